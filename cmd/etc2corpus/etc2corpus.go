@@ -0,0 +1,250 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// ----------------
+
+// etc2corpus downloads and verifies benchmark image corpora, for comparing
+// encoder quality settings on more (and larger) images than those in res.
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	allowUnpinnedFlag = flag.Bool("allow-unpinned", false, "whether to accept files with no pinned checksum")
+	corpusFlag        = flag.String("corpus", "", "which corpus to fetch")
+	dirFlag           = flag.String("dir", filepath.Join("res", "corpus"), "parent directory")
+	verifyFlag        = flag.Bool("verify", false, "whether to only verify, not download")
+)
+
+const usageStr = `etc2corpus downloads and verifies benchmark image corpora.
+
+Usage:
+
+    etc2corpus -corpus=NAME [-dir=DIR] [-verify] [-allow-unpinned]
+
+The files are saved in DIR/NAME, where DIR defaults to res/corpus (which git
+ignores). Files that are already present are not downloaded again.
+
+Each file's SHA-256 checksum is pinned in this program's source code. A
+download whose checksum does not match is rejected (and not saved), as is an
+already present file that does not match. -verify checks the files without
+downloading any missing ones.
+
+A file whose checksum is not pinned is rejected unless -allow-unpinned is
+given, in which case its checksum is recorded in DIR/NAME/SHA256SUMS when it
+is first downloaded and is checked on every later run. That only detects a
+later change, not a file that was already wrong when first fetched.
+
+The available corpora are:
+
+    kodak (the 24 Kodak Lossless True Color Image Suite images, 768×512 PNG)
+
+There is no game-texture corpus yet, as there is no freely redistributable
+set of game textures at stable URLs to fetch.
+`
+
+// corpora maps a corpus name to the URLs of its files.
+var corpora = map[string][]string{
+	"kodak": kodakURLs(),
+}
+
+func kodakURLs() (ret []string) {
+	for i := 1; i <= 24; i++ {
+		ret = append(ret, fmt.Sprintf("https://r0k.us/graphics/kodak/kodak/kodim%02d.png", i))
+	}
+	return ret
+}
+
+// pinnedSums maps a corpus name and then a file name to that file's SHA-256
+// checksum, in lower-case hexadecimal.
+//
+// Each checksum should be computed from a copy of the file obtained and
+// checked independently of the URLs above. A file that is not listed here is
+// only accepted with the -allow-unpinned flag.
+var pinnedSums = map[string]map[string]string{
+	"kodak": {
+		// The kodim01.png to kodim24.png checksums have not been pinned yet.
+	},
+}
+
+// httpClient bounds how long each download can take, so that a stalled
+// server does not hang the program.
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+func main() {
+	if err := main1(); err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(1)
+	}
+}
+
+func main1() error {
+	flag.Usage = func() { os.Stderr.WriteString(usageStr) }
+	flag.Parse()
+	if flag.NArg() != 0 {
+		return errors.New("too many arguments")
+	}
+
+	urls, ok := corpora[*corpusFlag]
+	if !ok {
+		return errors.New("bad -corpus flag")
+	}
+	dir := filepath.Join(*dirFlag, *corpusFlag)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	pinned := pinnedSums[*corpusFlag]
+	sumsFilename := filepath.Join(dir, "SHA256SUMS")
+	sums, err := readSums(sumsFilename)
+	if err != nil {
+		return err
+	}
+
+	numMissing := 0
+	for _, url := range urls {
+		name := url[strings.LastIndexByte(url, '/')+1:]
+		filename := filepath.Join(dir, name)
+
+		// want is the expected checksum, or "" if it is not yet known.
+		want, ok := pinned[name]
+		if !ok {
+			if !*allowUnpinnedFlag {
+				return fmt.Errorf("%s: no pinned checksum (see -allow-unpinned)", name)
+			}
+			want = sums[name]
+		}
+
+		if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+			if *verifyFlag {
+				numMissing++
+				continue
+			}
+			os.Stderr.WriteString("fetching " + url + "\n")
+			if err := download(filename, url, want); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		sum, err := hashFile(filename)
+		if err != nil {
+			return err
+		}
+		if want == "" {
+			sums[name] = sum
+		} else if sum != want {
+			return fmt.Errorf("%s: checksum mismatch: got %s, want %s", filename, sum, want)
+		}
+	}
+
+	if err := writeSums(sumsFilename, sums); err != nil {
+		return err
+	} else if numMissing > 0 {
+		return fmt.Errorf("%d file(s) missing", numMissing)
+	}
+	return nil
+}
+
+// download fetches url and saves it as filename. If wantSum is non-empty, the
+// file is only saved if its SHA-256 checksum matches.
+func download(filename string, url string, wantSum string) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	// Write to a temporary file first, so that an interrupted download does
+	// not leave a truncated file behind.
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	} else if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	} else if sum := hex.EncodeToString(h.Sum(nil)); (wantSum != "") && (sum != wantSum) {
+		os.Remove(tmp)
+		return fmt.Errorf("%s: checksum mismatch: got %s, want %s", url, sum, wantSum)
+	}
+	return os.Rename(tmp, filename)
+}
+
+func hashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readSums reads a file in the sha256sum program's format. A missing file is
+// treated as an empty one.
+func readSums(filename string) (map[string]string, error) {
+	sums := map[string]string{}
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return sums, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		sum, name, ok := strings.Cut(s.Text(), "  ")
+		if !ok {
+			return nil, errors.New(filename + ": bad line")
+		}
+		sums[name] = sum
+	}
+	return sums, s.Err()
+}
+
+func writeSums(filename string, sums map[string]string) error {
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := []byte(nil)
+	for _, name := range names {
+		buf = append(buf, sums[name]+"  "+name+"\n"...)
+	}
+	return os.WriteFile(filename, buf, 0644)
+}
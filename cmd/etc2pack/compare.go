@@ -0,0 +1,257 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/nigeltao/etc2/lib/container"
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/metrics"
+	"github.com/nigeltao/etc2/lib/pkm"
+)
+
+// compare decodes the encoded inFile and measures it against the original
+// image named by the -compare flag.
+func compare(inFile *os.File) error {
+	original, err := readImage(*compareFlag)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := io.ReadAll(inFile)
+	if err != nil {
+		return err
+	}
+	decoded := image.Image(nil)
+	err = container.Walk(bytes.NewReader(encoded), func(desc container.ImageDesc, decode func() (image.Image, error)) error {
+		// Only compare the first (largest) image.
+		m, err := decode()
+		if err != nil {
+			return err
+		}
+		decoded = m
+		return container.SkipAll
+	})
+	if err != nil {
+		return err
+	} else if decoded == nil {
+		return errors.New("-compare: no image in the encoded file")
+	}
+
+	mse, err := metrics.MSE(original, decoded)
+	if err != nil {
+		return err
+	}
+	rgb := (mse[0] + mse[1] + mse[2]) / 3
+	ssim, err := metrics.SSIM(original, decoded)
+	if err == metrics.ErrImageIsTooSmall {
+		ssim = math.NaN()
+	} else if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(os.Stdout, ""+
+		"channel  PSNR (dB)\n"+
+		"R        %9.3f\n"+
+		"G        %9.3f\n"+
+		"B        %9.3f\n"+
+		"A        %9.3f\n"+
+		"RGB      %9.3f\n"+
+		"SSIM (luma): %.5f\n",
+		metrics.PSNRFromMSE(mse[0]),
+		metrics.PSNRFromMSE(mse[1]),
+		metrics.PSNRFromMSE(mse[2]),
+		metrics.PSNRFromMSE(mse[3]),
+		metrics.PSNRFromMSE(rgb),
+		ssim)
+	return err
+}
+
+func readImage(filename string) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, _, err := image.Decode(f)
+	return m, err
+}
+
+// compareResult is one encoder's output quality, size and speed.
+type compareResult struct {
+	psnr     float64
+	size     int
+	duration time.Duration
+}
+
+// etc2compFormats maps an etc2.Format to the EtcTool program's -format
+// argument.
+var etc2compFormats = map[etc2.Format]string{
+	etc2.FormatETC1:             "ETC1",
+	etc2.FormatETC2RGB:          "RGB8",
+	etc2.FormatETC2RGBA1:        "RGB8A1",
+	etc2.FormatETC2RGBA8:        "RGBA8",
+	etc2.FormatETC2SRGB:         "SRGB8",
+	etc2.FormatETC2SRGBA1:       "SRGB8A1",
+	etc2.FormatETC2SRGBA8:       "SRGBA8",
+	etc2.FormatETC2R11Unsigned:  "R11",
+	etc2.FormatETC2R11Signed:    "SIGNED_R11",
+	etc2.FormatETC2RG11Unsigned: "RG11",
+	etc2.FormatETC2RG11Signed:   "SIGNED_RG11",
+}
+
+// etcpackFormats maps an etc2.Format to the etcpack program's -f argument.
+// FormatETC1 is instead selected by "-c etc1".
+var etcpackFormats = map[etc2.Format]string{
+	etc2.FormatETC2RGB:          "RGB",
+	etc2.FormatETC2RGBA1:        "RGBA1",
+	etc2.FormatETC2RGBA8:        "RGBA8",
+	etc2.FormatETC2SRGB:         "sRGB",
+	etc2.FormatETC2SRGBA1:       "sRGBA1",
+	etc2.FormatETC2SRGBA8:       "sRGBA8",
+	etc2.FormatETC2R11Unsigned:  "R",
+	etc2.FormatETC2R11Signed:    "R_signed",
+	etc2.FormatETC2RG11Unsigned: "RG",
+	etc2.FormatETC2RG11Signed:   "RG_signed",
+}
+
+func compareWith(inFile *os.File) error {
+	format, etc2Options, err := encodeOptions()
+	if err != nil {
+		return err
+	}
+	src, _, err := image.Decode(inFile)
+	if err != nil {
+		return err
+	}
+
+	// Run our encoder. Only the encoding is timed, as src is already decoded.
+	ourPKM := &bytes.Buffer{}
+	start := time.Now()
+	if err := pkm.Encode(ourPKM, src, &pkm.EncodeOptions{
+		Format:      format,
+		ETC2Options: etc2Options,
+	}); err != nil {
+		return err
+	}
+	ours, err := compareMeasure(src, ourPKM.Bytes(), time.Since(start))
+	if err != nil {
+		return err
+	}
+
+	// Run their encoder, via a temporary directory. Its time includes
+	// starting the process and decoding the PNG file.
+	tmpDir, err := os.MkdirTemp("", "etc2pack-compare-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	inPNG := filepath.Join(tmpDir, "in.png")
+	if err := writePNG(inPNG, src); err != nil {
+		return err
+	}
+
+	outPKM := ""
+	cmd := (*exec.Cmd)(nil)
+	switch *compareWithFlag {
+	case "etc2comp":
+		f, ok := etc2compFormats[format]
+		if !ok {
+			return fmt.Errorf("-compare-with=etc2comp does not support -format=%v", format)
+		}
+		outPKM = filepath.Join(tmpDir, "out.pkm")
+		cmd = exec.Command("EtcTool", inPNG, "-format", f, "-j", strconv.Itoa(*jobsFlag), "-output", outPKM)
+	case "etcpack":
+		args := []string{inPNG, tmpDir, "-c", "etc1"}
+		if format != etc2.FormatETC1 {
+			f, ok := etcpackFormats[format]
+			if !ok {
+				return fmt.Errorf("-compare-with=etcpack does not support -format=%v", format)
+			}
+			args = []string{inPNG, tmpDir, "-c", "etc2", "-f", f}
+		}
+		outPKM = filepath.Join(tmpDir, "in.pkm")
+		cmd = exec.Command("etcpack", args...)
+	default:
+		return errors.New("bad -compare-with flag")
+	}
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return fmt.Errorf("-compare-with=%s: %w", *compareWithFlag, err)
+	}
+	cmd.Dir = tmpDir
+	cmd.Stderr = os.Stderr
+	start = time.Now()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("-compare-with=%s: %w", *compareWithFlag, err)
+	}
+	duration := time.Since(start)
+	theirPKM, err := os.ReadFile(outPKM)
+	if err != nil {
+		return err
+	}
+	theirs, err := compareMeasure(src, theirPKM, duration)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(os.Stdout, ""+
+		"encoder      PSNR (dB)  size (bytes)  time\n"+
+		"etc2pack     %9.3f  %12d  %v\n"+
+		"%-11s  %9.3f  %12d  %v\n"+
+		"difference   %+9.3f  %+12d  %+.3fx\n"+
+		"format: %v. etc2pack's time is for encoding only. %s's time also\n"+
+		"includes starting its process and decoding its PNG input.\n",
+		ours.psnr, ours.size, ours.duration.Round(time.Microsecond),
+		*compareWithFlag, theirs.psnr, theirs.size, theirs.duration.Round(time.Microsecond),
+		ours.psnr-theirs.psnr, ours.size-theirs.size,
+		ours.duration.Seconds()/max(1e-9, theirs.duration.Seconds()),
+		format, *compareWithFlag)
+	return err
+}
+
+// compareMeasure decodes an encoder's PKM output and measures it against src.
+func compareMeasure(src image.Image, pkmBytes []byte, duration time.Duration) (compareResult, error) {
+	decoded, err := pkm.Decode(bytes.NewReader(pkmBytes))
+	if err != nil {
+		return compareResult{}, err
+	}
+	psnr, err := metrics.PSNR(src, decoded)
+	if err != nil {
+		return compareResult{}, err
+	}
+	return compareResult{
+		psnr:     psnr,
+		size:     len(pkmBytes),
+		duration: duration,
+	}, nil
+}
+
+func writePNG(filename string, m image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
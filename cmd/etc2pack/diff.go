@@ -0,0 +1,135 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/etc2util"
+)
+
+// diff compares the blocks of inFile, an encoded (KTX/PKM) file, with those of
+// the encoded file named by the -diff flag, printing the blocks that differ.
+// For KTX, it compares the first face and layer of the -level mipmap level.
+func diff(inFile *os.File) error {
+	if inFile == os.Stdin {
+		return errors.New("-diff: a path is required")
+	}
+	otherFile, err := os.Open(*diffFlag)
+	if err != nil {
+		return err
+	}
+	defer otherFile.Close()
+
+	a, err := newInspector(inFile)
+	if err != nil {
+		return err
+	}
+	b, err := newInspector(otherFile)
+	if err != nil {
+		return err
+	}
+	if (a.format != b.format) || (a.width != b.width) || (a.height != b.height) {
+		return fmt.Errorf("-diff: %s %d×%d and %s %d×%d images cannot be compared",
+			a.format, a.width, a.height, b.format, b.width, b.height)
+	}
+	widthInBlocks, heightInBlocks := etc2.BlocksWide(a.width), etc2.BlocksHigh(a.height)
+	readerA, err := a.payloadReader()
+	if err != nil {
+		return err
+	}
+	readerB, err := b.payloadReader()
+	if err != nil {
+		return err
+	}
+
+	heatmap := (*image.RGBA)(nil)
+	if *diffHeatmapFlag != "" {
+		heatmap = image.NewRGBA(image.Rect(0, 0, a.width, a.height))
+		for i := 3; i < len(heatmap.Pix); i += 4 {
+			heatmap.Pix[i] = 0xFF
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(w, "%s, %d×%d pixels, %d×%d blocks\n", a.format, a.width, a.height, widthInBlocks, heightInBlocks)
+	numDiffs, sumSquaredError := 0, 0.0
+	for d, err := range a.format.DiffBlocks(readerA, readerB, widthInBlocks, heightInBlocks) {
+		if err != nil {
+			return err
+		}
+		if numDiffs == 0 {
+			fmt.Fprintf(w, "block           modes                       PSNR (dB)  max diff\n")
+		}
+		numDiffs++
+		sumSquaredError += d.SumSquaredError
+		fmt.Fprintf(w, "(%4d, %4d)    %-12s  %-12s  %9.3f  %8d\n",
+			d.X, d.Y, d.ModeA, d.ModeB, d.PSNR(), d.MaxDifference)
+
+		if heatmap != nil {
+			c := diffHeatmapColor(d.SumSquaredError)
+			for y := 4 * d.Y; y < min(4*(d.Y+1), a.height); y++ {
+				for x := 4 * d.X; x < min(4*(d.X+1), a.width); x++ {
+					heatmap.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+
+	// The overall PSNR is over all blocks, including the identical ones.
+	psnr := math.Inf(+1)
+	if numBlocks := widthInBlocks * heightInBlocks; sumSquaredError > 0 {
+		psnr = 10 * math.Log10((255*255)/(sumSquaredError/float64(64*numBlocks)))
+	}
+	fmt.Fprintf(w, "%d of %d blocks differ; overall PSNR %.3f dB\n", numDiffs, widthInBlocks*heightInBlocks, psnr)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if heatmap != nil {
+		return etc2util.WriteFileAtomically(*diffHeatmapFlag, func(f *os.File) error {
+			return png.Encode(f, heatmap)
+		})
+	}
+	return nil
+}
+
+// diffHeatmapColor returns the -diff-heatmap color for a differing block. It
+// ranges from dark blue, for codes that decode to the same pixels, through red
+// to yellow, for a root mean square difference of 32 or more.
+func diffHeatmapColor(sumSquaredError float64) color.RGBA {
+	if sumSquaredError == 0 {
+		return color.RGBA{0x00, 0x00, 0x80, 0xFF}
+	}
+	t := min(1, math.Sqrt(sumSquaredError/64)/32)
+	if t < 0.5 {
+		return color.RGBA{uint8(0x40 + (0x17E * t)), 0x00, 0x00, 0xFF}
+	}
+	return color.RGBA{0xFF, uint8(0x1FE * (t - 0.5)), 0x00, 0xFF}
+}
+
+// payloadReader returns the encoded blocks of the inspected image, in
+// row-major order.
+func (ins *inspector) payloadReader() (io.Reader, error) {
+	offset, err := ins.blockOffset(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	size := ins.format.PayloadSize(etc2.BlocksWide(ins.width), etc2.BlocksHigh(ins.height))
+	return io.NewSectionReader(ins.r, offset, size), nil
+}
@@ -13,28 +13,84 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
+	"image"
+	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
+	"runtime"
+	"strings"
 
-	"github.com/nigeltao/etc2/internal/nie"
+	"github.com/nigeltao/etc2/lib/container"
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/etc2util"
+	"github.com/nigeltao/etc2/lib/ktx"
+	"github.com/nigeltao/etc2/lib/ktx2"
+	"github.com/nigeltao/etc2/lib/nie"
 	"github.com/nigeltao/etc2/lib/pkm"
 
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+
 	_ "image/gif"
-	_ "image/jpeg"
 
-	_ "golang.org/x/image/bmp"
-	_ "golang.org/x/image/tiff"
 	_ "golang.org/x/image/webp"
 )
 
 var (
 	decodeFlag = flag.Bool("decode", false, "whether to decode the input")
 	encodeFlag = flag.Bool("encode", false, "whether to encode the input")
+	formatFlag = flag.String("format", "", "encoded format")
+	jobsFlag   = flag.Int("jobs", runtime.NumCPU(), "maximum number of concurrent encoding goroutines")
+	levelFlag  = flag.Int("level", 0, "mipmap level to decode")
 	outputFlag = flag.String("output", "", "output format")
+
+	outputFileFlag = flag.String("o", "", "output file (instead of stdout)")
+
+	infoFlag          = flag.Bool("info", false, "whether to print the input's header fields")
+	inspectFlag       = flag.Bool("inspect", false, "whether to interactively inspect the input's blocks")
+	alphaTestFlag     = flag.Int("alpha-test", 0, "alpha-test threshold (1-255) whose coverage to preserve")
+	compatETCPACKFlag = flag.Bool("compat-etcpack", false, "whether to match the ETCPACK program's output exactly")
+	compareFlag       = flag.String("compare", "", "original image to compare with")
+	compareWithFlag   = flag.String("compare-with", "", "external encoder to compare with")
+	diffFlag          = flag.String("diff", "", "encoded file to compare block by block with")
+	diffHeatmapFlag   = flag.String("diff-heatmap", "", "PNG file to write -diff's per-block difference heatmap to")
+	profileFlag       = flag.String("profile", "", "target GPU profile to check against")
+	stripMetadataFlag = flag.Bool("strip-metadata", false, "whether to omit the default metadata")
+	visualizeFlag     = flag.Bool("visualize", false, "whether to write a PNG map of the encoder's chosen block modes")
+
+	mipmapsFlag            = flag.Bool("mipmaps", false, "whether to write a full mipmap chain")
+	mipmapsInSRGBSpaceFlag = flag.Bool("mipmaps-in-srgb-space", false, "whether to average sRGB values as is when downsampling")
+	normalMappingFlag      = flag.String("normal-mapping", "", "how to re-parameterize a normal map as two channels")
+
+	colorOnlyFlag    = flag.Bool("color-only", false, "whether to skip decoding the RGBA8 formats' alpha")
+	ditherFlag       = flag.Bool("dither", false, "whether to dither -linear-output")
+	linearOutputFlag = flag.Bool("linear-output", false, "whether to decode the sRGB formats to linear light")
+	partialFlag      = flag.Bool("partial", false, "whether to output what can be decoded from truncated input")
 )
 
+// setKeyValues holds the -set-key flag values, in order.
+var setKeyValues []ktx.KeyValue
+
+func init() {
+	flag.StringVar(outputFileFlag, "output-file", "", "output file (instead of stdout)")
+	flag.Func("set-key", "add key-value metadata (KEY=VALUE)", func(s string) error {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || (key == "") {
+			return errors.New("want KEY=VALUE")
+		}
+		setKeyValues = setKeyValue(setKeyValues, ktx.KeyValue{
+			Key:   key,
+			Value: append([]byte(value), 0x00),
+		})
+		return nil
+	})
+}
+
 const usageStr = `etc2pack decodes and encodes the ETC2 lossy image file format.
 
 Usage: choose one of
@@ -46,18 +102,188 @@ The path to the input image file is optional. If omitted, stdin is read.
 
 When decoding you can also pass one of these flags (before the path):
 
+    -output=bmp
+    -output=jpeg (quality 90; any alpha is discarded)
+    -output=nie-bn4
     -output=nie-bn8
     -output=png (this is the default)
+    -output=tiff
+
+When decoding you can also pass any of these flags (before the path):
+
+    -color-only    (skip the etc2-rgba8 formats' alpha; the output is opaque)
+    -linear-output (convert the etc2-srgb* formats' colors to linear light)
+    -dither        (dither -linear-output's 8-bit values, reducing banding)
+    -partial       (output what was received of a truncated file, with a
+                    warning printed to stderr, instead of failing)
 
 When encoding you can also pass one of these flags (before the path):
 
     -output=ktx
+    -output=ktx2
     -output=pkm (this is the default)
 
-The output image (in NIE/PNG or KTX/PKM format) is written to stdout.
+When encoding you can also pass the encoded format (before the path):
+
+    -format=etc1
+    -format=etc1s
+    -format=etc2-rgb (this is the default)
+    -format=etc2-rgba1
+    -format=etc2-rgba8
+    -format=etc2-srgb
+    -format=etc2-srgba1
+    -format=etc2-srgba8
+    -format=etc2-r11u
+    -format=etc2-r11s
+    -format=etc2-rg11u
+    -format=etc2-rg11s
+
+The etc1s format's blocks are encoded independently, as a KTX, KTX2 or PKM
+file of ETC1 codes that any ETC1 decoder accepts. There is no global codebook
+encoder yet (nor codebook size or quality knobs), so there is no .basis or
+BasisLZ-supercompressed KTX2 output.
+
+When encoding you can also pass -compat-etcpack (before the path) to produce
+exactly the same output as the ETCPACK program. The first difference is in
+converting color input to gray (for the R11 formats): ETCPACK uses the ITU-R
+BT.709 weights. The default is BT.601, like Go's image/color package. The
+second is that, by default, single-color blocks skip the full mode search.
+
+When encoding an alpha-tested texture (to etc2-rgba1 or etc2-rgba8), you can
+also pass the alpha test's threshold (before the path), such as:
+
+    -alpha-test=128
+
+The encoded alpha values are then scaled so that the fraction of pixels that
+pass the test is the same as in the input.
+
+When encoding you can also limit the encoder's parallelism (before the path):
+
+    -jobs=N (the default is the number of CPUs)
+
+When encoding to KTX or KTX2 you can also pass these flags (before the path):
+
+    -mipmaps
+    -mipmaps-in-srgb-space
+    -normal-mapping=octahedral
+    -normal-mapping=long-lat
+    -set-key=KEY=VALUE (this can be repeated)
+    -strip-metadata
+
+By default, KTX and KTX2 output has KTXorientation and KTXwriter keys.
+-strip-metadata omits them. -set-key adds the given key (or replaces its
+value, if already present).
+
+PKM output has no key-value metadata. Instead, -set-key (which then requires
+the -o flag) writes the keys and values to a JSON sidecar file, named after
+the output file with a ".json" suffix, such as "out.pkm.json".
+
+Passing -normal-mapping (with -format=etc2-rg11u or -format=etc2-rg11s)
+treats the input as a normal map and re-parameterizes each pixel's RGB (XYZ)
+normal as two channels, covering the whole sphere. The mapping is recorded
+under the etc2NormalMapping key, as it is needed to reconstruct the normals.
+
+Passing -mipmaps writes a full mipmap chain to the KTX output, each level
+downsampled from the one before. For the sRGB formats, downsampling averages
+colors in linear light, unless -mipmaps-in-srgb-space is also passed.
+
+When encoding you can also pass a target GPU profile, such as:
+
+    -profile=gles2
+    -profile=gles3
+    -profile=gles3,max-size=4096,pot
+
+A warning is printed (to stderr) if the output does not satisfy the profile.
+The output is still written.
+
+The output image (in BMP/JPEG/NIE/PNG/TIFF or KTX/KTX2/PKM format) is written
+to stdout, unless this flag (before the path) names an output file:
+
+    -o=path (or equivalently -output-file=path)
+
+The output file is written atomically: to a temporary file in the same
+directory that is then renamed.
+
+To compare this program's encoder with an external one, pass one of:
+
+    -compare-with=etc2comp (runs the EtcTool program)
+    -compare-with=etcpack  (runs the etcpack program)
+
+instead of -decode or -encode. The external program must be on the $PATH.
+Both encoders produce the -format output (etc2-rgb by default, but not etc1s)
+from the same input, honoring the -compat-etcpack and -jobs flags (etcpack
+ignores -jobs), and the PSNR, size and time taken for each (and the
+differences) are written to stdout. This program's time covers encoding only.
+The external program's time also covers starting it and decoding its PNG
+input.
+
+To print an encoded (KTX/KTX2/PKM) file's container, format, dimensions and
+payload size, without decoding its pixels:
+
+    etc2pack -info [path]
+
+To interactively inspect an encoded (KTX/PKM) file's blocks, such as when
+chasing a single-block artifact:
 
-Decode inputs KTX/PKM and outputs NIE/PNG.
-Encode inputs BMP, GIF, JPEG, PNG, TIFF or WEBP and outputs KTX/PKM.
+    etc2pack -inspect path
+
+Commands (read from stdin) print a block's mode, fields (base colors, tables,
+etc.), indexes and decoded pixels, or write a block's neighborhood, scaled up,
+to a PNG file. Type "help" for the list of commands. For KTX input, the -level
+flag selects the mipmap level.
+
+To see which mode the encoder chooses for each 4×4 block of an image:
+
+    etc2pack -visualize [path]
+
+This encodes the image (in the -format, if given, also honoring the
+-alpha-test, -compat-etcpack and -jobs flags) and writes a PNG image of the same
+size, instead of the encoded file, with each block colored by its mode:
+
+    red     individual
+    green   differential
+    blue    T
+    yellow  H
+    magenta planar
+    gray    none (the R11 and RG11 formats have no color modes)
+
+For the etc2-rgba1 and etc2-srgba1 formats, a block's transparent pixels are
+black.
+
+To measure how closely an encoded (KTX/KTX2/PKM) file matches its original
+image:
+
+    etc2pack -compare=original.png [path]
+
+The path is the encoded file (or stdin, if omitted). It is decoded and the
+per-channel PSNR, and the SSIM, are written to stdout.
+
+To compare two encoded (KTX/PKM) files of the same format and dimensions
+block by block, such as the outputs of two versions of an encoder:
+
+    etc2pack -diff=other.pkm path
+
+Each block whose encoded bytes differ is written to stdout, with its mode in
+each file, the PSNR between the two files' decoded pixels and the largest
+difference in any one channel (on a 0 to 65535 scale). A summary line
+follows. For KTX input, the -level flag selects the mipmap level. Also
+passing -diff-heatmap=heatmap.png writes a PNG image, the size of the files'
+images, that is black for identical blocks, dark blue for blocks whose
+codes differ but decode to the same pixels and red to yellow for increasingly
+different blocks.
+
+Decode inputs KTX/KTX2/PKM and outputs BMP, JPEG, NIE, PNG or TIFF. For KTX
+and KTX2 input, it decodes the first face and layer of mipmap level 0, unless
+a different level is passed:
+
+    etc2pack -decode -level=2 [path]
+
+KTX2 input must hold ETC2 or EAC data, either not supercompressed or
+Zstandard- or zlib-supercompressed, or BasisLZ (ETC1S) data, as produced by the
+basisu and toktx tools, which is transcoded to ETC2.
+
+Encode inputs BMP, GIF, JPEG, NIE, PNG, TIFF or WEBP and outputs
+KTX/KTX2/PKM.
 `
 
 var ErrBadOutputFlag = errors.New("main: bad -output flag")
@@ -88,38 +314,316 @@ func main1() error {
 		return errors.New("too many filenames; the maximum is one")
 	}
 
+	if *diffFlag != "" {
+		if *inspectFlag || *infoFlag || *decodeFlag || *encodeFlag || (*compareFlag != "") || (*compareWithFlag != "") {
+			return errors.New("-diff cannot be combined with -inspect, -info, -decode, -encode, -compare or -compare-with")
+		}
+		return diff(inFile)
+	} else if *diffHeatmapFlag != "" {
+		return errors.New("-diff-heatmap requires -diff")
+	}
+	if *inspectFlag {
+		if *infoFlag || *decodeFlag || *encodeFlag || (*compareFlag != "") || (*compareWithFlag != "") {
+			return errors.New("-inspect cannot be combined with -info, -decode, -encode, -compare or -compare-with")
+		}
+		return inspect(inFile)
+	}
+	if *infoFlag {
+		if *decodeFlag || *encodeFlag || (*compareFlag != "") || (*compareWithFlag != "") {
+			return errors.New("-info cannot be combined with -decode, -encode, -compare or -compare-with")
+		}
+		return info(inFile)
+	}
+	if *visualizeFlag {
+		if *decodeFlag || *encodeFlag || (*compareFlag != "") || (*compareWithFlag != "") {
+			return errors.New("-visualize cannot be combined with -decode, -encode, -compare or -compare-with")
+		}
+		return writeOutput(func(outFile *os.File) error { return visualize(outFile, inFile) })
+	}
+	if *compareFlag != "" {
+		if *decodeFlag || *encodeFlag || (*compareWithFlag != "") {
+			return errors.New("-compare cannot be combined with -decode, -encode or -compare-with")
+		}
+		return compare(inFile)
+	}
+	if *compareWithFlag != "" {
+		if *decodeFlag || *encodeFlag {
+			return errors.New("-compare-with cannot be combined with -decode or -encode")
+		}
+		return compareWith(inFile)
+	}
+	if !*decodeFlag && (*colorOnlyFlag || *ditherFlag || *linearOutputFlag || *partialFlag) {
+		return errors.New("-color-only, -dither, -linear-output and -partial require -decode")
+	}
 	if *decodeFlag && !*encodeFlag {
-		return decode(inFile)
+		return writeOutput(func(outFile *os.File) error { return decode(outFile, inFile) })
 	}
 	if !*decodeFlag && *encodeFlag {
-		return encode(inFile)
+		return writeOutput(func(outFile *os.File) error { return encode(outFile, inFile) })
 	}
 	return errors.New("must specify exactly one of -decode, -encode or -help")
 }
 
-func decode(inFile *os.File) error {
+// writeOutput calls write with the -o file, written atomically, or stdout.
+func writeOutput(write func(outFile *os.File) error) error {
+	if *outputFileFlag == "" {
+		return write(os.Stdout)
+	}
+	return etc2util.WriteFileAtomically(*outputFileFlag, write)
+}
+
+func decode(outFile *os.File, inFile *os.File) error {
 	switch *outputFlag {
-	case "", "nie-bn8", "png":
+	case "", "bmp", "jpeg", "nie-bn4", "nie-bn8", "png", "tiff":
 		// No-op.
 	default:
 		return ErrBadOutputFlag
 	}
 
-	src, err := pkm.Decode(inFile)
+	if *levelFlag < 0 {
+		return errors.New("bad -level flag")
+	}
+	encoded, err := io.ReadAll(inFile)
+	if err != nil {
+		return err
+	}
+	src := image.Image(nil)
+	err = container.WalkWithOptions(bytes.NewReader(encoded), decodeOptions(), func(desc container.ImageDesc, decode func() (image.Image, error)) error {
+		if (desc.Level != *levelFlag) || (desc.Face != 0) || (desc.Layer != 0) {
+			return nil
+		}
+		m, err := decode()
+		if m == nil {
+			return err
+		} else if err != nil {
+			// With -partial, a truncated image is still output.
+			os.Stderr.WriteString("warning: partial decode: " + err.Error() + "\n")
+		}
+		src = m
+		return container.SkipAll
+	})
 	if err != nil {
 		return err
+	} else if src == nil {
+		return errors.New("-decode: no image at the -level mipmap level")
 	}
-	if *outputFlag == "nie-bn8" {
-		dst, err := nie.EncodeBN8(src)
+
+	if strings.HasPrefix(*outputFlag, "nie-") {
+		encodeNIE := nie.EncodeBN8
+		if *outputFlag == "nie-bn4" {
+			encodeNIE = nie.EncodeBN4
+		}
+		dst, err := encodeNIE(src)
 		if err != nil {
 			return err
 		}
-		_, err = os.Stdout.Write(dst)
+		_, err = outFile.Write(dst)
 		return err
 	}
-	return png.Encode(os.Stdout, src)
+	switch *outputFlag {
+	case "bmp":
+		return bmp.Encode(outFile, src)
+	case "jpeg":
+		return jpeg.Encode(outFile, src, &jpeg.Options{Quality: 90})
+	case "tiff":
+		return tiff.Encode(outFile, src, nil)
+	}
+	return png.Encode(outFile, src)
+}
+
+// decodeOptions returns the options, from the command line flags, for every
+// decoding entry point.
+func decodeOptions() *container.DecodeOptions {
+	return &container.DecodeOptions{
+		ETC2Options: &etc2.DecodeOptions{
+			LinearOutput: *linearOutputFlag,
+			Dither:       *ditherFlag,
+			Partial:      *partialFlag,
+			ColorOnly:    *colorOnlyFlag,
+		},
+	}
 }
 
-func encode(inFile *os.File) error {
-	panic("TODO")
+func encode(outFile *os.File, inFile *os.File) error {
+	switch *outputFlag {
+	case "", "ktx", "ktx2", "pkm":
+		// No-op.
+	default:
+		return ErrBadOutputFlag
+	}
+
+	format, etc2Options, err := encodeOptions()
+	if err != nil {
+		return err
+	}
+
+	src, _, err := image.Decode(inFile)
+	if err != nil {
+		return err
+	}
+
+	if *profileFlag != "" {
+		profile, err := etc2.ParseProfile(*profileFlag)
+		if err != nil {
+			return errors.New("bad -profile flag")
+		}
+		b := src.Bounds()
+		numMipmapLevels := 1
+		if *mipmapsFlag {
+			numMipmapLevels = ktx.NumMipmapLevels(b.Dx(), b.Dy())
+		}
+		if err := profile.Check(format, b.Dx(), b.Dy(), numMipmapLevels); err != nil {
+			os.Stderr.WriteString("warning: " + err.Error() + "\n")
+		}
+	}
+
+	if (*outputFlag == "ktx") || (*outputFlag == "ktx2") {
+		keyValues := []ktx.KeyValue(nil)
+		if !*stripMetadataFlag {
+			// The ktx2 package converts the KTXorientation value to its KTX
+			// 2.0 form, "rd".
+			keyValues = append(keyValues, ktx.KeyValue{
+				Key:   "KTXorientation",
+				Value: []byte("S=r,T=d\x00"),
+			}, ktx.KeyValue{
+				Key:   "KTXwriter",
+				Value: []byte("etc2pack\x00"),
+			})
+		}
+		for _, kv := range setKeyValues {
+			keyValues = setKeyValue(keyValues, kv)
+		}
+		ktxOptions := &ktx.EncodeOptions{
+			Format:             format,
+			KeyValues:          keyValues,
+			ETC2Options:        etc2Options,
+			Mipmaps:            *mipmapsFlag,
+			MipmapsInSRGBSpace: *mipmapsInSRGBSpaceFlag,
+		}
+		if *outputFlag == "ktx2" {
+			return ktx2.Encode(outFile, src, &ktx2.EncodeOptions{KTXOptions: ktxOptions})
+		}
+		return ktx.Encode(outFile, src, ktxOptions)
+	}
+
+	if *normalMappingFlag != "" {
+		return errors.New("-normal-mapping requires -output=ktx or -output=ktx2")
+	} else if *mipmapsFlag || *mipmapsInSRGBSpaceFlag {
+		return errors.New("-mipmaps requires -output=ktx or -output=ktx2")
+	} else if (len(setKeyValues) > 0) && (*outputFileFlag == "") {
+		return errors.New("-set-key with -output=pkm requires -o")
+	}
+	if err := pkm.Encode(outFile, src, &pkm.EncodeOptions{
+		Format:      format,
+		ETC2Options: etc2Options,
+	}); err != nil {
+		return err
+	}
+	if len(setKeyValues) > 0 {
+		return writeSidecar(*outputFileFlag+".json", setKeyValues)
+	}
+	return nil
+}
+
+// writeSidecar writes keyValues, as a JSON object, to the file at path. Each
+// value's trailing NUL byte, if any, is dropped.
+func writeSidecar(path string, keyValues []ktx.KeyValue) error {
+	m := make(map[string]string, len(keyValues))
+	for _, kv := range keyValues {
+		m[kv.Key] = string(bytes.TrimSuffix(kv.Value, []byte{0x00}))
+	}
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return etc2util.WriteFileAtomically(path, func(f *os.File) error {
+		_, err := f.Write(append(data, '\n'))
+		return err
+	})
+}
+
+// encodeOptions returns the format and options, from the command line flags,
+// for every encoding entry point.
+func encodeOptions() (etc2.Format, *etc2.EncodeOptions, error) {
+	if *jobsFlag < 1 {
+		return 0, nil, errors.New("bad -jobs flag")
+	}
+	etc2Options := &etc2.EncodeOptions{
+		CompatETCPACK: *compatETCPACKFlag,
+		NumWorkers:    *jobsFlag,
+	}
+	if (*alphaTestFlag < 0) || (*alphaTestFlag > 255) {
+		return 0, nil, errors.New("bad -alpha-test flag")
+	} else if *alphaTestFlag > 0 {
+		etc2Options.AlphaCoverage = &etc2.AlphaCoverage{
+			Threshold: uint8(*alphaTestFlag),
+		}
+	}
+
+	format := etc2.FormatETC2RGB
+	if *formatFlag != "" {
+		format = parseFormat(*formatFlag)
+		if format == etc2.FormatInvalid {
+			return 0, nil, errors.New("bad -format flag")
+		}
+	}
+
+	if *normalMappingFlag != "" {
+		etc2Options.NormalMapping = parseNormalMapping(*normalMappingFlag)
+		if etc2Options.NormalMapping == etc2.NormalMappingNone {
+			return 0, nil, errors.New("bad -normal-mapping flag")
+		} else if (format != etc2.FormatETC2RG11Unsigned) && (format != etc2.FormatETC2RG11Signed) {
+			return 0, nil, errors.New("-normal-mapping requires -format=etc2-rg11u or -format=etc2-rg11s")
+		}
+	}
+	return format, etc2Options, nil
+}
+
+// parseNormalMapping returns the non-zero etc2.NormalMapping whose String is
+// s, or NormalMappingNone.
+func parseNormalMapping(s string) etc2.NormalMapping {
+	for _, nm := range []etc2.NormalMapping{etc2.NormalMappingOctahedral, etc2.NormalMappingLongLat} {
+		if nm.String() == s {
+			return nm
+		}
+	}
+	return etc2.NormalMappingNone
+}
+
+// allFormats lists every valid etc2.Format.
+var allFormats = [...]etc2.Format{
+	etc2.FormatETC1S,
+	etc2.FormatETC1,
+	etc2.FormatETC2RGB,
+	etc2.FormatETC2RGBA1,
+	etc2.FormatETC2RGBA8,
+	etc2.FormatETC2SRGB,
+	etc2.FormatETC2SRGBA1,
+	etc2.FormatETC2SRGBA8,
+	etc2.FormatETC2R11Unsigned,
+	etc2.FormatETC2R11Signed,
+	etc2.FormatETC2RG11Unsigned,
+	etc2.FormatETC2RG11Signed,
+}
+
+// parseFormat returns the etc2.Format whose String is s, or FormatInvalid.
+func parseFormat(s string) etc2.Format {
+	for _, f := range allFormats {
+		if f.String() == s {
+			return f
+		}
+	}
+	return etc2.FormatInvalid
+}
+
+// setKeyValue adds kv to keyValues, replacing any existing value for the same
+// key.
+func setKeyValue(keyValues []ktx.KeyValue, kv ktx.KeyValue) []ktx.KeyValue {
+	for i := range keyValues {
+		if keyValues[i].Key == kv.Key {
+			keyValues[i].Value = kv.Value
+			return keyValues
+		}
+	}
+	return append(keyValues, kv)
 }
@@ -0,0 +1,146 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/ktx"
+	"github.com/nigeltao/etc2/lib/ktx2"
+	"github.com/nigeltao/etc2/lib/pkm"
+	"github.com/nigeltao/etc2/lib/supercompress"
+)
+
+// info prints inFile's container header fields, without decoding pixels.
+func info(inFile *os.File) error {
+	header := [ktx2.HeaderSize]byte{}
+	n, err := io.ReadFull(inFile, header[:])
+	if (err != nil) && (err != io.EOF) && (err != io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	switch {
+	case (n >= pkm.HeaderSize) && strings.HasPrefix(string(header[:]), pkm.Magic):
+		return infoPKM(header[:n])
+	case (n >= ktx.HeaderSize) && strings.HasPrefix(string(header[:]), ktx.Magic):
+		return infoKTX(header[:n])
+	case (n >= ktx2.HeaderSize) && strings.HasPrefix(string(header[:]), ktx2.Magic):
+		return infoKTX2(io.MultiReader(bytes.NewReader(header[:n]), inFile))
+	}
+	return errors.New("-info: unsupported container")
+}
+
+func infoPKM(header []byte) error {
+	f, config, err := pkm.DecodeHeader(strings.NewReader(string(header)))
+	if err != nil {
+		return err
+	}
+	bW, bH := etc2.BlocksWide(config.Width), etc2.BlocksHigh(config.Height)
+	return printInfo([][2]string{
+		{"container", fmt.Sprintf("PKM (version %c.%c)", header[4], header[5])},
+		{"format", f.String()},
+		{"dimensions", fmt.Sprintf("%d×%d", config.Width, config.Height)},
+		{"rounded-up dimensions", fmt.Sprintf("%d×%d", 4*bW, 4*bH)},
+		{"payload size", fmt.Sprintf("%d bytes", f.PayloadSize(bW, bH))},
+		{"bytes per block", fmt.Sprint(f.BytesPerBlock())},
+	})
+}
+
+func infoKTX(header []byte) error {
+	if binary.LittleEndian.Uint32(header[12:]) != 0x0403_0201 {
+		return errors.New("-info: unsupported KTX endianness")
+	}
+	u32 := func(i int) int {
+		return int(binary.LittleEndian.Uint32(header[12+(4*i):]))
+	}
+	l := ktx.Layout{
+		Width:               u32(6),
+		Height:              u32(7),
+		Depth:               u32(8),
+		NumArrayElements:    u32(9),
+		NumFaces:            u32(10),
+		NumMipmapLevels:     max(1, u32(11)),
+		BytesOfKeyValueData: u32(12),
+	}
+	glInternalFormat := uint32(u32(4))
+	l.Format = etc2.FormatFromOpenGL(glInternalFormat)
+	if l.Format == etc2.FormatInvalid {
+		return fmt.Errorf("-info: unsupported KTX glInternalFormat 0x%04X", glInternalFormat)
+	}
+
+	payloadSize := int64(0)
+	for level := range l.NumMipmapLevels {
+		size := l.ImageSize(level)
+		if (l.NumFaces == 6) && (l.NumArrayElements == 0) {
+			size *= 6
+		}
+		payloadSize += size
+	}
+	bW, bH := etc2.BlocksWide(l.Width), etc2.BlocksHigh(l.Height)
+	return printInfo([][2]string{
+		{"container", "KTX (version 1)"},
+		{"format", l.Format.String()},
+		{"dimensions", fmt.Sprintf("%d×%d", l.Width, l.Height)},
+		{"rounded-up dimensions", fmt.Sprintf("%d×%d", 4*bW, 4*bH)},
+		{"depth", fmt.Sprint(l.Depth)},
+		{"array elements", fmt.Sprint(l.NumArrayElements)},
+		{"faces", fmt.Sprint(max(1, l.NumFaces))},
+		{"mipmap levels", fmt.Sprint(l.NumMipmapLevels)},
+		{"key-value data size", fmt.Sprintf("%d bytes", l.BytesOfKeyValueData)},
+		{"payload size", fmt.Sprintf("%d bytes", payloadSize)},
+		{"bytes per block", fmt.Sprint(l.Format.BytesPerBlock())},
+	})
+}
+
+func infoKTX2(r io.Reader) error {
+	l, err := ktx2.DecodeLayout(r)
+	if err != nil {
+		return err
+	}
+	scheme := fmt.Sprint(l.SupercompressionScheme)
+	if s, err := supercompress.Lookup(l.SupercompressionScheme); err == nil {
+		scheme = s.Name()
+	} else if l.SupercompressionScheme == supercompress.IDBasisLZ {
+		scheme = "BasisLZ"
+	}
+	payloadSize := uint64(0)
+	for _, level := range l.Levels {
+		payloadSize += level.ByteLength
+	}
+	bW, bH := etc2.BlocksWide(l.Width), etc2.BlocksHigh(l.Height)
+	return printInfo([][2]string{
+		{"container", "KTX (version 2)"},
+		{"format", l.Format.String()},
+		{"dimensions", fmt.Sprintf("%d×%d", l.Width, l.Height)},
+		{"rounded-up dimensions", fmt.Sprintf("%d×%d", 4*bW, 4*bH)},
+		{"depth", fmt.Sprint(l.Depth)},
+		{"array elements", fmt.Sprint(l.NumArrayElements)},
+		{"faces", fmt.Sprint(max(1, l.NumFaces))},
+		{"mipmap levels", fmt.Sprint(l.NumMipmapLevels)},
+		{"supercompression", scheme},
+		{"payload size", fmt.Sprintf("%d bytes", payloadSize)},
+		{"bytes per block", fmt.Sprint(l.Format.BytesPerBlock())},
+	})
+}
+
+func printInfo(rows [][2]string) error {
+	buf := []byte(nil)
+	for _, row := range rows {
+		buf = fmt.Appendf(buf, "%-22s %s\n", row[0]+":", row[1])
+	}
+	_, err := os.Stdout.Write(buf)
+	return err
+}
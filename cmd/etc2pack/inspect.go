@@ -0,0 +1,472 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/etc2util"
+	"github.com/nigeltao/etc2/lib/ktx"
+	"github.com/nigeltao/etc2/lib/pkm"
+)
+
+const inspectHelpStr = `Commands:
+
+    block X Y              print block (X, Y)'s mode, fields, indexes and pixels
+    pixel X Y              the same, for the block holding pixel (X, Y)
+    dump PATH X Y [R [S]]  write the blocks within R (default 1) blocks of
+                           block (X, Y) to a PNG file, scaled up S (default 8)
+                           times
+    help                   print this message
+    quit                   exit (as does end of input)
+`
+
+// errQuit is returned by inspector.run for the quit command.
+var errQuit = errors.New("quit")
+
+// inspector gives block-level access to one image (one mipmap level, face and
+// layer) of an encoded file, for -inspect.
+type inspector struct {
+	r      io.ReaderAt
+	format etc2.Format
+	width  int
+	height int
+
+	// blockOffset returns the file offset of the block at (blockX, blockY),
+	// which are measured in blocks and are in range.
+	blockOffset func(blockX int, blockY int) (int64, error)
+}
+
+// inspect reads commands from stdin that print details of the blocks in
+// inFile, an encoded (KTX/PKM) file. For KTX, it inspects the first face and
+// layer of the -level mipmap level.
+func inspect(inFile *os.File) error {
+	if inFile == os.Stdin {
+		return errors.New("-inspect: a path is required, as commands are read from stdin")
+	}
+	ins, err := newInspector(inFile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s, %d×%d pixels, %d×%d blocks. Type \"help\" for commands.\n",
+		ins.format, ins.width, ins.height,
+		etc2.BlocksWide(ins.width), etc2.BlocksHigh(ins.height))
+
+	s := bufio.NewScanner(os.Stdin)
+	for {
+		os.Stdout.WriteString("> ")
+		if !s.Scan() {
+			break
+		}
+		args := strings.Fields(s.Text())
+		if len(args) == 0 {
+			continue
+		}
+		buf, err := ins.run(nil, args)
+		if err == errQuit {
+			return nil
+		} else if err != nil {
+			buf = append(buf, "error: "+err.Error()+"\n"...)
+		}
+		if _, err := os.Stdout.Write(buf); err != nil {
+			return err
+		}
+	}
+	os.Stdout.WriteString("\n")
+	return s.Err()
+}
+
+func newInspector(r io.ReaderAt) (*inspector, error) {
+	header := [ktx.HeaderSize]byte{}
+	n, err := r.ReadAt(header[:], 0)
+	if (err != nil) && (err != io.EOF) {
+		return nil, err
+	}
+
+	switch {
+	case (n >= pkm.HeaderSize) && strings.HasPrefix(string(header[:]), pkm.Magic):
+		f, config, err := pkm.DecodeHeader(bytes.NewReader(header[:n]))
+		if err != nil {
+			return nil, err
+		}
+		widthInBlocks := etc2.BlocksWide(config.Width)
+		return &inspector{
+			r:      r,
+			format: f,
+			width:  config.Width,
+			height: config.Height,
+			blockOffset: func(blockX int, blockY int) (int64, error) {
+				return pkm.BlockOffset(f, widthInBlocks, blockX, blockY), nil
+			},
+		}, nil
+
+	case (n >= ktx.HeaderSize) && strings.HasPrefix(string(header[:]), ktx.Magic):
+		l, err := ktx.DecodeLayout(bytes.NewReader(header[:n]))
+		if err != nil {
+			return nil, err
+		}
+		level := *levelFlag
+		if (level < 0) || (level >= l.NumMipmapLevels) {
+			return nil, errors.New("-inspect: no image at the -level mipmap level")
+		}
+		return &inspector{
+			r:      r,
+			format: l.Format,
+			width:  max(1, l.Width>>level),
+			height: max(1, l.Height>>level),
+			blockOffset: func(blockX int, blockY int) (int64, error) {
+				return l.BlockOffset(level, 0, 0, blockX, blockY)
+			},
+		}, nil
+	}
+	return nil, errors.New("-inspect: unsupported container")
+}
+
+// run executes one command, appending its output to buf.
+func (ins *inspector) run(buf []byte, args []string) ([]byte, error) {
+	switch args[0] {
+	case "block", "pixel":
+		xy, err := parseInts(args[1:], 2, 2)
+		if err != nil {
+			return buf, err
+		}
+		if args[0] == "pixel" {
+			xy[0], xy[1] = xy[0]/4, xy[1]/4
+		}
+		b, err := ins.block(xy[0], xy[1])
+		if err != nil {
+			return buf, err
+		}
+		return ins.appendBlock(buf, &b), nil
+
+	case "dump":
+		if len(args) < 2 {
+			return buf, errors.New("missing PATH")
+		}
+		xyrs, err := parseInts(args[2:], 2, 4)
+		if err != nil {
+			return buf, err
+		}
+		xyrs = append(xyrs, 1, 8)[:4]
+		if (xyrs[2] < 0) || (xyrs[3] < 1) || (xyrs[3] > 64) {
+			return buf, errors.New("bad R or S")
+		}
+		m, err := ins.neighborhood(xyrs[0], xyrs[1], xyrs[2], xyrs[3])
+		if err != nil {
+			return buf, err
+		}
+		if err := etc2util.WriteFileAtomically(args[1], func(f *os.File) error {
+			return png.Encode(f, m)
+		}); err != nil {
+			return buf, err
+		}
+		b := m.Bounds()
+		return fmt.Appendf(buf, "wrote %s (%d×%d)\n", args[1], b.Dx(), b.Dy()), nil
+
+	case "help":
+		return append(buf, inspectHelpStr...), nil
+
+	case "quit", "exit":
+		return buf, errQuit
+	}
+	return buf, fmt.Errorf("unknown command %q (try \"help\")", args[0])
+}
+
+// parseInts parses between minN and maxN (inclusive) decimal integers.
+func parseInts(args []string, minN int, maxN int) ([]int, error) {
+	if (len(args) < minN) || (len(args) > maxN) {
+		return nil, errors.New("wrong number of arguments")
+	}
+	ret := make([]int, len(args))
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", arg)
+		}
+		ret[i] = n
+	}
+	return ret, nil
+}
+
+// block reads and decodes the block at (blockX, blockY).
+func (ins *inspector) block(blockX int, blockY int) (etc2.DecodedBlock, error) {
+	bW, bH := etc2.BlocksWide(ins.width), etc2.BlocksHigh(ins.height)
+	if (blockX < 0) || (blockX >= bW) || (blockY < 0) || (blockY >= bH) {
+		return etc2.DecodedBlock{}, fmt.Errorf("block (%d, %d) is outside the %d×%d blocks", blockX, blockY, bW, bH)
+	}
+	offset, err := ins.blockOffset(blockX, blockY)
+	if err != nil {
+		return etc2.DecodedBlock{}, err
+	}
+	code := make([]byte, ins.format.BytesPerBlock())
+	if n, err := ins.r.ReadAt(code, offset); n < len(code) {
+		if (err == nil) || (err == io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return etc2.DecodedBlock{}, err
+	}
+	for b, err := range ins.format.Blocks(bytes.NewReader(code), 1, 1) {
+		b.X, b.Y = blockX, blockY
+		return b, err
+	}
+	return etc2.DecodedBlock{}, io.ErrUnexpectedEOF
+}
+
+// neighborhood returns the blocks within radius blocks of (blockX, blockY),
+// clipped to the image, each pixel scaled up to a scale×scale square.
+func (ins *inspector) neighborhood(blockX int, blockY int, radius int, scale int) (*image.NRGBA64, error) {
+	bW, bH := etc2.BlocksWide(ins.width), etc2.BlocksHigh(ins.height)
+	r := image.Rect(blockX-radius, blockY-radius, blockX+radius+1, blockY+radius+1).
+		Intersect(image.Rect(0, 0, bW, bH))
+	if r.Empty() {
+		return nil, fmt.Errorf("block (%d, %d) is outside the %d×%d blocks", blockX, blockY, bW, bH)
+	}
+
+	m := image.NewNRGBA64(image.Rect(0, 0, 4*scale*r.Dx(), 4*scale*r.Dy()))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			b, err := ins.block(x, y)
+			if err != nil {
+				return nil, err
+			}
+			x0, y0 := 4*scale*(x-r.Min.X), 4*scale*(y-r.Min.Y)
+			for i, c := range b.Pixels {
+				for dy := range scale {
+					for dx := range scale {
+						m.SetNRGBA64(x0+(scale*(i&3))+dx, y0+(scale*(i>>2))+dy, c)
+					}
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// appendBlock appends a human-readable description of b.
+func (ins *inspector) appendBlock(buf []byte, b *etc2.DecodedBlock) []byte {
+	f := ins.format
+	n := f.BytesPerBlock()
+	buf = fmt.Appendf(buf, "block (%d, %d), pixels (%d, %d) to (%d, %d)\n",
+		b.X, b.Y, 4*b.X, 4*b.Y, (4*b.X)+3, (4*b.Y)+3)
+	buf = fmt.Appendf(buf, "code:   %X\n", b.Code[:n])
+
+	code0 := binary.BigEndian.Uint64(b.Code[0:])
+	code1 := binary.BigEndian.Uint64(b.Code[8:])
+	signed := (f == etc2.FormatETC2R11Signed) || (f == etc2.FormatETC2RG11Signed)
+	switch numEACChannels(f) {
+	case 1:
+		buf = appendEAC(buf, "red", code0, signed)
+	case 2:
+		buf = appendEAC(buf, "red", code0, signed)
+		buf = appendEAC(buf, "green", code1, signed)
+	default:
+		colorCode := code0
+		if n == 16 {
+			buf = appendEAC(buf, "alpha", code0, false)
+			colorCode = code1
+		}
+		buf = appendColor(buf, colorCode, f.AlphaModel() == etc2.AlphaModel1Bit, b)
+	}
+
+	buf = append(buf, "pixels:\n"...)
+	for y := range 4 {
+		buf = append(buf, "       "...)
+		for x := range 4 {
+			c := b.Pixels[(4*y)+x]
+			switch {
+			case numEACChannels(f) == 1:
+				buf = fmt.Appendf(buf, " %04X", c.R)
+			case numEACChannels(f) == 2:
+				buf = fmt.Appendf(buf, " %04X,%04X", c.R, c.G)
+			case f.AlphaModel() != etc2.AlphaModelOpaque:
+				buf = fmt.Appendf(buf, " #%02X%02X%02X%02X", c.R>>8, c.G>>8, c.B>>8, c.A>>8)
+			default:
+				buf = fmt.Appendf(buf, " #%02X%02X%02X", c.R>>8, c.G>>8, c.B>>8)
+			}
+		}
+		buf = append(buf, '\n')
+	}
+	return buf
+}
+
+// appendColor appends the fields, palette and indexes of an ETC1 / ETC2 color
+// (RGB) code.
+func appendColor(buf []byte, code uint64, oneBitAlpha bool, b *etc2.DecodedBlock) []byte {
+	buf = fmt.Appendf(buf, "mode:   %s\n", b.Mode)
+
+	flip := (code & 0x1_0000_0000) != 0
+	switch b.Mode {
+	case etc2.BlockModeIndividual:
+		buf = fmt.Appendf(buf, "base0:  %s, table %d\n", hex444(code>>0x3C, code>>0x34, code>>0x2C), 7&(code>>0x25))
+		buf = fmt.Appendf(buf, "base1:  %s, table %d\n", hex444(code>>0x38, code>>0x30, code>>0x28), 7&(code>>0x22))
+		buf = fmt.Appendf(buf, "flip:   %t\n", flip)
+
+	case etc2.BlockModeDifferential:
+		for i := range 2 {
+			rgb := [3]uint8{}
+			for j := range rgb {
+				shift := 0x3B - (8 * j)
+				c := int(0x1F & (code >> shift))
+				if i == 1 {
+					// The 3-bit delta is signed.
+					c += int(int8(uint8(code>>(shift-3))<<5) >> 5)
+				}
+				rgb[j] = uint8((c << 3) | (c >> 2))
+			}
+			buf = fmt.Appendf(buf, "base%d:  #%02X%02X%02X, table %d\n", i, rgb[0], rgb[1], rgb[2], 7&(code>>(0x25-(3*i))))
+		}
+		buf = fmt.Appendf(buf, "flip:   %t\n", flip)
+
+	case etc2.BlockModeT:
+		// The first base color's red bits straddle the overflow bits.
+		r0 := ((code >> 0x39) & 0x0C) | ((code >> 0x38) & 0x03)
+		buf = fmt.Appendf(buf, "base0:  %s\n", hex444(r0, code>>0x34, code>>0x30))
+		buf = fmt.Appendf(buf, "base1:  %s\n", hex444(code>>0x2C, code>>0x28, code>>0x24))
+		buf = fmt.Appendf(buf, "distance index: %d\n", ((code>>0x21)&6)|((code>>0x20)&1))
+
+	case etc2.BlockModeH:
+		g0 := ((code >> 0x37) & 0x0E) | ((code >> 0x34) & 0x01)
+		b0 := ((code >> 0x30) & 0x08) | ((code >> 0x2F) & 0x07)
+		base0 := hex444(code>>0x3B, g0, b0)
+		base1 := hex444(code>>0x2B, code>>0x27, code>>0x23)
+		buf = fmt.Appendf(buf, "base0:  %s\n", base0)
+		buf = fmt.Appendf(buf, "base1:  %s\n", base1)
+		// The lowest bit is implied by the order of the base colors, as
+		// 24-bit numbers. Their same-length hex strings sort the same way.
+		distance := ((code >> 0x20) & 4) | ((code >> 0x1F) & 2)
+		if base0 >= base1 {
+			distance |= 1
+		}
+		buf = fmt.Appendf(buf, "distance index: %d\n", distance)
+
+	case etc2.BlockModePlanar:
+		// The origin (O), horizontal (H) and vertical (V) colors are 6:7:6
+		// bits (red, green, blue).
+		o := [3]uint64{
+			(code >> 0x39) & 0x3F,
+			((code >> 0x32) & 0x40) | ((code >> 0x31) & 0x3F),
+			((code >> 0x2B) & 0x20) | ((code >> 0x28) & 0x18) | ((code >> 0x27) & 0x07),
+		}
+		h := [3]uint64{
+			((code >> 0x21) & 0x3E) | ((code >> 0x20) & 0x01),
+			(code >> 0x19) & 0x7F,
+			(code >> 0x13) & 0x3F,
+		}
+		v := [3]uint64{
+			(code >> 0x0D) & 0x3F,
+			(code >> 0x06) & 0x7F,
+			(code >> 0x00) & 0x3F,
+		}
+		for i, c := range [3]*[3]uint64{&o, &h, &v} {
+			buf = fmt.Appendf(buf, "%c:      #%02X%02X%02X\n", "OHV"[i],
+				(c[0]<<2)|(c[0]>>4), (c[1]<<1)|(c[1]>>6), (c[2]<<2)|(c[2]>>4))
+		}
+		return buf
+	}
+
+	// Each (sub-block, index) pair's palette color, as seen in the pixels.
+	// Individual and differential blocks have two sub-blocks. T and H blocks
+	// have one.
+	subBlock := func(x int, y int) int {
+		if (b.Mode == etc2.BlockModeT) || (b.Mode == etc2.BlockModeH) {
+			return 0
+		} else if flip {
+			return y >> 1
+		}
+		return x >> 1
+	}
+	indexes := [16]int{}
+	palette := [2][4]string{}
+	for i := range 16 {
+		x, y := i&3, i>>2
+		x4y := (4 * x) | y
+		index := int(((code >> x4y) & 1) | ((code >> (x4y + 15)) & 2))
+		indexes[i] = index
+		// RGBA1's transparent index is part of the color code. RGBA8's alpha
+		// is not, so it is not part of the palette.
+		c, p := b.Pixels[i], "transparent"
+		if !oneBitAlpha || (c.A != 0) {
+			p = fmt.Sprintf("#%02X%02X%02X", c.R>>8, c.G>>8, c.B>>8)
+		}
+		palette[subBlock(x, y)][index] = p
+	}
+	numSubBlocks := 1
+	if (b.Mode == etc2.BlockModeIndividual) || (b.Mode == etc2.BlockModeDifferential) {
+		numSubBlocks = 2
+	}
+	for s := range numSubBlocks {
+		buf = fmt.Appendf(buf, "palette%d (as used):", s)
+		for _, p := range palette[s] {
+			if p == "" {
+				p = "unused"
+			}
+			buf = append(buf, ' ')
+			buf = append(buf, p...)
+		}
+		buf = append(buf, '\n')
+	}
+	return appendIndexes(buf, "color indexes", &indexes)
+}
+
+// appendEAC appends the fields and indexes of an EAC (alpha, R11 or G11) code.
+// The signed R11 and RG11 formats' base is a two's complement byte.
+func appendEAC(buf []byte, name string, code uint64, signed bool) []byte {
+	base := int(code >> 56)
+	if signed {
+		base = int(int8(code >> 56))
+	}
+	buf = fmt.Appendf(buf, "%s: base %d, multiplier %d, table %d\n",
+		name, base, 0x0F&(code>>52), 0x0F&(code>>48))
+	indexes := [16]int{}
+	for i := range 16 {
+		x, y := i&3, i>>2
+		shift := (((x ^ 3) * 4) | (y ^ 3)) * 3
+		indexes[i] = int(7 & (code >> shift))
+	}
+	return appendIndexes(buf, name+" indexes", &indexes)
+}
+
+func appendIndexes(buf []byte, name string, indexes *[16]int) []byte {
+	buf = append(buf, name...)
+	buf = append(buf, ":\n"...)
+	for y := range 4 {
+		buf = fmt.Appendf(buf, "        %d %d %d %d\n",
+			indexes[(4*y)+0], indexes[(4*y)+1], indexes[(4*y)+2], indexes[(4*y)+3])
+	}
+	return buf
+}
+
+// numEACChannels returns the number of R11 / RG11 channels in f, or 0 for the
+// color (RGB) formats.
+func numEACChannels(f etc2.Format) int {
+	switch f {
+	case etc2.FormatETC2R11Unsigned, etc2.FormatETC2R11Signed:
+		return 1
+	case etc2.FormatETC2RG11Unsigned, etc2.FormatETC2RG11Signed:
+		return 2
+	}
+	return 0
+}
+
+// hex444 formats three 4-bit values (the low bits of r, g and b) as an 8-bit
+// per channel color.
+func hex444(r uint64, g uint64, b uint64) string {
+	return fmt.Sprintf("#%02X%02X%02X", 0x11*(r&0x0F), 0x11*(g&0x0F), 0x11*(b&0x0F))
+}
@@ -0,0 +1,64 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+// modeColors maps each etc2.BlockMode to its -visualize color.
+var modeColors = [...]color.RGBA{
+	etc2.BlockModeNone:         {0x80, 0x80, 0x80, 0xFF},
+	etc2.BlockModeIndividual:   {0xFF, 0x00, 0x00, 0xFF},
+	etc2.BlockModeDifferential: {0x00, 0xFF, 0x00, 0xFF},
+	etc2.BlockModeT:            {0x00, 0x00, 0xFF, 0xFF},
+	etc2.BlockModeH:            {0xFF, 0xFF, 0x00, 0xFF},
+	etc2.BlockModePlanar:       {0xFF, 0x00, 0xFF, 0xFF},
+}
+
+// visualize encodes inFile and writes a PNG image to outFile, coloring each
+// block by the mode that the encoder chose.
+func visualize(outFile *os.File, inFile *os.File) error {
+	format, etc2Options, err := encodeOptions()
+	if err != nil {
+		return err
+	}
+	src, _, err := image.Decode(inFile)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err := etc2.Encode(buf, src, format, etc2Options); err != nil {
+		return err
+	}
+
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	oneBitAlpha := format.AlphaModel() == etc2.AlphaModel1Bit
+	for block, err := range format.Blocks(buf, etc2.BlocksWide(b.Dx()), etc2.BlocksHigh(b.Dy())) {
+		if err != nil {
+			return err
+		}
+		for i, c := range block.Pixels {
+			x, y := (4*block.X)+(i&3), (4*block.Y)+(i>>2)
+			if oneBitAlpha && (c.A == 0) {
+				dst.SetRGBA(x, y, color.RGBA{0x00, 0x00, 0x00, 0xFF})
+			} else {
+				dst.SetRGBA(x, y, modeColors[block.Mode])
+			}
+		}
+	}
+	return png.Encode(outFile, dst)
+}
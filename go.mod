@@ -2,4 +2,7 @@ module github.com/nigeltao/etc2
 
 go 1.24
 
-require golang.org/x/image v0.24.0
+require (
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/image v0.24.0
+)
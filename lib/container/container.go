@@ -0,0 +1,209 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// ----------------
+
+// Package container provides container-agnostic access to the ETC images held
+// in file formats such as PKM, KTX and KTX 2.0.
+//
+// Some container formats hold more than one image (mipmap levels, cube map
+// faces or array layers). Walk visits them all, so that tools don't need to
+// special-case each container format.
+package container
+
+import (
+	"errors"
+	"image"
+	"io"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/ktx"
+	"github.com/nigeltao/etc2/lib/ktx2"
+	"github.com/nigeltao/etc2/lib/pkm"
+)
+
+var (
+	ErrBadArgument          = errors.New("container: bad argument")
+	ErrUnsupportedContainer = errors.New("container: unsupported container")
+)
+
+// SkipAll can be returned by a Walk callback to stop the walk early. Walk
+// will then return a nil error.
+var SkipAll = errors.New("container: skip all")
+
+// ImageDesc describes one of the images in a container.
+type ImageDesc struct {
+	// Container is the container format's name, such as "ktx", "ktx2" or
+	// "pkm".
+	Container string
+
+	// Config is the image's color model and (not rounded up) dimensions.
+	Config image.Config
+
+	// Level is the mipmap level, where 0 is the largest image.
+	Level int
+
+	// Face is the cube map face, in the range [0, 6), or 0 for non-cube maps.
+	Face int
+
+	// Layer is the texture array layer, or 0 for non-array textures. For 3D
+	// textures, it also counts depth slices, as per ktx.Layout.BlockOffset.
+	Layer int
+}
+
+// DecodeOptions are optional arguments to WalkWithOptions. The zero value is
+// valid and means to use the default configuration.
+type DecodeOptions struct {
+	// ETC2Options, if non-nil, are passed on to etc2.Format.DecodeWithOptions
+	// by the decode functions passed to the Walk callback. With its Partial
+	// option, those functions can return both an image and an error.
+	ETC2Options *etc2.DecodeOptions
+}
+
+// Walk calls fn for each image in the container file r, in the container's
+// natural order.
+//
+// fn's second argument is a function that decodes that image. The decoding is
+// lazy: callers that are only interested in the ImageDesc metadata do not pay
+// the cost of decoding pixels. It is only valid to call during the fn call.
+//
+// If fn returns a non-nil error then Walk stops and returns that error, unless
+// it is SkipAll, in which case Walk stops and returns nil.
+func Walk(r io.ReaderAt, fn func(desc ImageDesc, decode func() (image.Image, error)) error) error {
+	return WalkWithOptions(r, nil, fn)
+}
+
+// WalkWithOptions is like Walk but with optional arguments.
+//
+// options may be nil, which means to use the default configuration.
+func WalkWithOptions(r io.ReaderAt, options *DecodeOptions, fn func(desc ImageDesc, decode func() (image.Image, error)) error) error {
+	if (r == nil) || (fn == nil) {
+		return ErrBadArgument
+	}
+
+	magic := [len(ktx.Magic)]byte{}
+	n, err := r.ReadAt(magic[:], 0)
+	if (err != nil) && (err != io.EOF) {
+		return err
+	} else if n < len(pkm.Magic) {
+		return io.ErrUnexpectedEOF
+	}
+
+	etc2Options := (*etc2.DecodeOptions)(nil)
+	if options != nil {
+		etc2Options = options.ETC2Options
+	}
+
+	err = ErrUnsupportedContainer
+	switch {
+	case string(magic[:len(pkm.Magic)]) == pkm.Magic:
+		err = walkPKM(r, etc2Options, fn)
+	case string(magic[:n]) == ktx.Magic:
+		err = walkKTX(r, etc2Options, fn)
+	case string(magic[:n]) == ktx2.Magic:
+		err = walkKTX2(r, etc2Options, fn)
+	}
+
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkPKM(r io.ReaderAt, etc2Options *etc2.DecodeOptions, fn func(desc ImageDesc, decode func() (image.Image, error)) error) error {
+	config, err := pkm.DecodeConfig(newReader(r, 0))
+	if err != nil {
+		return err
+	}
+	return fn(ImageDesc{
+		Container: "pkm",
+		Config:    config,
+	}, func() (image.Image, error) {
+		return pkm.DecodeWithOptions(newReader(r, 0), &pkm.DecodeOptions{
+			ETC2Options: etc2Options,
+		})
+	})
+}
+
+func walkKTX(r io.ReaderAt, etc2Options *etc2.DecodeOptions, fn func(desc ImageDesc, decode func() (image.Image, error)) error) error {
+	ktxOptions := &ktx.DecodeOptions{ETC2Options: etc2Options}
+	l, err := ktx.DecodeLayout(newReader(r, 0))
+	if err != nil {
+		return err
+	}
+	for level := range l.NumMipmapLevels {
+		config := image.Config{
+			ColorModel: l.Format.ColorModel(),
+			Width:      max(1, l.Width>>level),
+			Height:     max(1, l.Height>>level),
+		}
+		for layer := range l.NumLayers(level) {
+			for face := range max(1, l.NumFaces) {
+				if err := fn(ImageDesc{
+					Container: "ktx",
+					Config:    config,
+					Level:     level,
+					Face:      face,
+					Layer:     layer,
+				}, func() (image.Image, error) {
+					return ktx.DecodeImageWithOptions(r, &l, level, face, layer, ktxOptions)
+				}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func walkKTX2(r io.ReaderAt, etc2Options *etc2.DecodeOptions, fn func(desc ImageDesc, decode func() (image.Image, error)) error) error {
+	ktx2Options := &ktx2.DecodeOptions{ETC2Options: etc2Options}
+	l, err := ktx2.DecodeLayout(newReader(r, 0))
+	if err != nil {
+		return err
+	}
+	for level := range l.NumMipmapLevels {
+		config := image.Config{
+			ColorModel: l.Format.ColorModel(),
+			Width:      max(1, l.Width>>level),
+			Height:     max(1, l.Height>>level),
+		}
+		// A level's faces and layers share one (possibly supercompressed)
+		// byte range. Read it at most once, and only if fn decodes any of
+		// them.
+		data, dataErr, dataRead := []byte(nil), error(nil), false
+		for layer := range l.NumLayers(level) {
+			for face := range max(1, l.NumFaces) {
+				if err := fn(ImageDesc{
+					Container: "ktx2",
+					Config:    config,
+					Level:     level,
+					Face:      face,
+					Layer:     layer,
+				}, func() (image.Image, error) {
+					if !dataRead {
+						data, dataErr = ktx2.ReadLevel(r, &l, level)
+						dataRead = true
+					}
+					if dataErr != nil {
+						return nil, dataErr
+					}
+					return ktx2.DecodeLevelImage(data, &l, level, face, layer, ktx2Options)
+				}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func newReader(r io.ReaderAt, offset int64) io.Reader {
+	const maxInt64 = 0x7FFF_FFFF_FFFF_FFFF
+	return io.NewSectionReader(r, offset, maxInt64-offset)
+}
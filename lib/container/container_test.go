@@ -0,0 +1,190 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package container
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/ktx"
+	"github.com/nigeltao/etc2/lib/ktx2"
+	"github.com/nigeltao/etc2/lib/supercompress"
+)
+
+func TestWalkPKM(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/mona-lisa.21x32.etc2-rgb.pkm")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+
+	numImages := 0
+	err = Walk(bytes.NewReader(srcBytes), func(desc ImageDesc, decode func() (image.Image, error)) error {
+		numImages++
+		if got, want := desc.Container, "pkm"; got != want {
+			tt.Errorf("Container: got %q, want %q", got, want)
+		}
+		if (desc.Config.Width != 21) || (desc.Config.Height != 32) {
+			tt.Errorf("Config: got %dx%d, want 21x32", desc.Config.Width, desc.Config.Height)
+		}
+		m, err := decode()
+		if err != nil {
+			return err
+		}
+		if got, want := m.Bounds(), image.Rect(0, 0, 21, 32); got != want {
+			tt.Errorf("Bounds: got %v, want %v", got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		tt.Fatalf("Walk: %v", err)
+	}
+	if numImages != 1 {
+		tt.Fatalf("numImages: got %d, want 1", numImages)
+	}
+
+	err = Walk(bytes.NewReader(srcBytes), func(desc ImageDesc, decode func() (image.Image, error)) error {
+		return SkipAll
+	})
+	if err != nil {
+		tt.Fatalf("Walk(SkipAll): %v", err)
+	}
+
+	err = Walk(bytes.NewReader([]byte("not a texture")), func(desc ImageDesc, decode func() (image.Image, error)) error {
+		return nil
+	})
+	if err != ErrUnsupportedContainer {
+		tt.Fatalf("Walk(junk): got %v, want %v", err, ErrUnsupportedContainer)
+	}
+}
+
+// makeLayers returns n distinct width×height gray images.
+func makeLayers(width int, height int, n int) []image.Image {
+	layers := make([]image.Image, n)
+	for i := range layers {
+		m := image.NewGray(image.Rect(0, 0, width, height))
+		for y := range height {
+			for x := range width {
+				m.SetGray(x, y, color.Gray{uint8((20 * x) + (10 * y) + (70 * i))})
+			}
+		}
+		layers[i] = m
+	}
+	return layers
+}
+
+// walkAll walks r and checks that it visits, in order, every level, layer
+// and face of a width×height texture with numLevels mipmap levels and
+// numLayers layers, decoding each image with decode.
+func walkAll(tt *testing.T, r io.ReaderAt, container string, width int, height int, numLevels int, numLayers int, decode func(level int, layer int) (image.Image, error)) {
+	tt.Helper()
+	n := 0
+	err := Walk(r, func(desc ImageDesc, decodeImage func() (image.Image, error)) error {
+		level, layer := n/numLayers, n%numLayers
+		n++
+		w, h := max(1, width>>level), max(1, height>>level)
+		if want := (ImageDesc{
+			Container: container,
+			Config:    image.Config{ColorModel: desc.Config.ColorModel, Width: w, Height: h},
+			Level:     level,
+			Layer:     layer,
+		}); desc != want {
+			tt.Fatalf("got %+v, want %+v", desc, want)
+		}
+		got, err := decodeImage()
+		if err != nil {
+			return err
+		}
+		want, err := decode(level, layer)
+		if err != nil {
+			tt.Fatalf("level=%d, layer=%d: decode: %v", level, layer, err)
+		}
+		if got.Bounds() != want.Bounds() {
+			tt.Fatalf("level=%d, layer=%d: got bounds %v, want %v", level, layer, got.Bounds(), want.Bounds())
+		}
+		b := want.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if g, w := got.At(x, y), want.At(x, y); g != w {
+					tt.Fatalf("level=%d, layer=%d: At(%d, %d): got %v, want %v", level, layer, x, y, g, w)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		tt.Fatalf("Walk: %v", err)
+	}
+	if want := numLevels * numLayers; n != want {
+		tt.Fatalf("numImages: got %d, want %d", n, want)
+	}
+}
+
+func TestWalkKTX(tt *testing.T) {
+	const width, height, numLayers = 12, 8, 3
+	buf := &bytes.Buffer{}
+	options := &ktx.EncodeOptions{Format: etc2.FormatETC2R11Unsigned, Mipmaps: true}
+	if err := ktx.EncodeArray(buf, makeLayers(width, height, numLayers), options); err != nil {
+		tt.Fatalf("EncodeArray: %v", err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+	l, err := ktx.DecodeLayout(r)
+	if err != nil {
+		tt.Fatalf("DecodeLayout: %v", err)
+	}
+	walkAll(tt, r, "ktx", width, height, l.NumMipmapLevels, numLayers, func(level int, layer int) (image.Image, error) {
+		return ktx.DecodeImage(r, &l, level, 0, layer)
+	})
+}
+
+// countingReaderAt counts the ReadAt calls at each offset.
+type countingReaderAt struct {
+	r      io.ReaderAt
+	counts map[int64]int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.counts[off]++
+	return c.r.ReadAt(p, off)
+}
+
+func TestWalkKTX2(tt *testing.T) {
+	const width, height, numLayers = 12, 8, 3
+	for _, scheme := range []uint32{supercompress.IDNone, supercompress.IDZLIB} {
+		buf := &bytes.Buffer{}
+		options := &ktx2.EncodeOptions{
+			KTXOptions:             &ktx.EncodeOptions{Format: etc2.FormatETC2R11Unsigned, Mipmaps: true},
+			SupercompressionScheme: scheme,
+		}
+		if err := ktx2.EncodeArray(buf, makeLayers(width, height, numLayers), options); err != nil {
+			tt.Fatalf("scheme=%d: EncodeArray: %v", scheme, err)
+		}
+		l, err := ktx2.DecodeLayout(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			tt.Fatalf("scheme=%d: DecodeLayout: %v", scheme, err)
+		}
+
+		r := &countingReaderAt{bytes.NewReader(buf.Bytes()), map[int64]int{}}
+		walkAll(tt, r, "ktx2", width, height, l.NumMipmapLevels, numLayers, func(level int, layer int) (image.Image, error) {
+			return ktx2.DecodeImage(bytes.NewReader(buf.Bytes()), &l, level, 0, layer)
+		})
+
+		// Each level's (supercompressed) data is read once, not once per
+		// layer.
+		for level, lv := range l.Levels {
+			if got := r.counts[int64(lv.ByteOffset)]; got != 1 {
+				tt.Errorf("scheme=%d, level=%d: reads: got %d, want 1", scheme, level, got)
+			}
+		}
+	}
+}
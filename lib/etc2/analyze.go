@@ -0,0 +1,161 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"math"
+)
+
+// Analysis is the result of AnalyzeImage.
+type Analysis struct {
+	// Recommended is the suggested Format for the image.
+	Recommended Format
+
+	// Candidates are the Formats considered, with their estimated quality.
+	Candidates []Candidate
+
+	// NumSampledBlocks is the number of 4×4 blocks that were trial-encoded to
+	// estimate each Candidate's PSNR.
+	NumSampledBlocks int
+
+	// NumOpaquePixels, NumTransparentPixels and NumTranslucentPixels count
+	// the image's pixels whose 8-bit alpha is 0xFF, 0x00 or neither. Unlike
+	// the PSNR estimates, these count every pixel, not just sampled ones.
+	NumOpaquePixels      int
+	NumTransparentPixels int
+	NumTranslucentPixels int
+}
+
+// Candidate is a Format and its estimated quality for an analyzed image.
+type Candidate struct {
+	Format Format
+
+	// PSNR is the estimated peak signal-to-noise ratio, in decibels, over
+	// the premultiplied RGBA channels. It is +Inf if the sampled blocks were
+	// encoded losslessly.
+	PSNR float64
+}
+
+// analyzeMaxSampledBlocks is the maximum number of blocks that AnalyzeImage
+// trial-encodes.
+const analyzeMaxSampledBlocks = 256
+
+// AnalyzeImage examines src and suggests which color Format to encode it with.
+// It is the engine behind automatic format selection, such as an editor's
+// "import settings" dialog.
+//
+// The per-Format quality estimates come from trial-encoding a sample of
+// blocks, spread evenly across the image, which is much faster than encoding
+// the whole image in every Format. The alpha statistics are exact.
+//
+// Images with translucent pixels are recommended FormatETC2RGBA8. Otherwise,
+// images with transparent pixels are recommended FormatETC2RGBA1. Opaque
+// images are recommended FormatETC1, which is more widely supported, if its
+// estimated PSNR is close to FormatETC2RGB's, and FormatETC2RGB otherwise.
+func AnalyzeImage(src image.Image) (*Analysis, error) {
+	if src == nil {
+		return nil, ErrBadArgument
+	}
+	b := src.Bounds()
+	if (b.Dx() > 65532) || (b.Dy() > 65532) {
+		return nil, ErrImageIsTooLarge
+	}
+
+	formats := [...]Format{
+		FormatETC1,
+		FormatETC2RGB,
+		FormatETC2RGBA1,
+		FormatETC2RGBA8,
+	}
+	sumSquaredErrors := [len(formats)]float64{}
+	numSampledBlocks := 0
+
+	ret := &Analysis{}
+	widthInBlocks := BlocksWide(b.Dx())
+	heightInBlocks := BlocksHigh(b.Dy())
+	numBlocks := widthInBlocks * heightInBlocks
+	stride := max(1, (numBlocks+analyzeMaxSampledBlocks-1)/analyzeMaxSampledBlocks)
+
+	e := newEncoder(0, nil)
+	extract := FormatETC2RGBA8.makeExtract(&e.pixels, src, b, nil)
+	for blockIndex := range numBlocks {
+		blockX := b.Min.X + (4 * (blockIndex % widthInBlocks))
+		blockY := b.Min.Y + (4 * (blockIndex / widthInBlocks))
+		extract(blockX, blockY)
+
+		// Count alpha, ignoring the right and bottom edges' padding.
+		for y := range min(4, b.Max.Y-blockY) {
+			for x := range min(4, b.Max.X-blockX) {
+				switch e.pixels[(16*y)+(4*x)+3] {
+				case 0xFF:
+					ret.NumOpaquePixels++
+				case 0x00:
+					ret.NumTransparentPixels++
+				default:
+					ret.NumTranslucentPixels++
+				}
+			}
+		}
+
+		if (blockIndex % stride) != 0 {
+			continue
+		}
+		numSampledBlocks++
+		for i, f := range formats {
+			switch f {
+			case FormatETC2RGBA8:
+				alphaCode := e.encodeAlpha()
+				colorCode := e.encodeColor(f)
+				decodeColor(&e.work, colorCode, false)
+				decodeAlpha(&e.work, alphaCode)
+			default:
+				decodeColor(&e.work, e.encodeColor(f), f == FormatETC2RGBA1)
+			}
+			sumSquaredErrors[i] += premulSquaredError(&e.pixels, &e.work)
+		}
+	}
+
+	ret.NumSampledBlocks = numSampledBlocks
+	ret.Candidates = make([]Candidate, len(formats))
+	for i, f := range formats {
+		psnr := math.Inf(+1)
+		if mse := sumSquaredErrors[i] / float64(64*max(1, numSampledBlocks)); mse > 0 {
+			psnr = 10 * math.Log10((255*255)/mse)
+		}
+		ret.Candidates[i] = Candidate{Format: f, PSNR: psnr}
+	}
+
+	const etc1Tolerance = 0.25 // In decibels.
+	if ret.NumTranslucentPixels > 0 {
+		ret.Recommended = FormatETC2RGBA8
+	} else if ret.NumTransparentPixels > 0 {
+		ret.Recommended = FormatETC2RGBA1
+	} else if ret.Candidates[0].PSNR >= (ret.Candidates[1].PSNR - etc1Tolerance) {
+		ret.Recommended = FormatETC1
+	} else {
+		ret.Recommended = FormatETC2RGB
+	}
+	return ret, nil
+}
+
+// premulSquaredError returns the sum of squared differences between two
+// blocks' premultiplied RGBA values. Decoders output opaque alpha (0xFF) for
+// the opaque formats, so those formats' error includes any discarded alpha.
+func premulSquaredError(original *[64]byte, decoded *[64]byte) (ret float64) {
+	for i := 0; i < 64; i += 4 {
+		oa, da := int32(original[i+3]), int32(decoded[i+3])
+		for c := range 3 {
+			d := ((int32(original[i+c]) * oa) - (int32(decoded[i+c]) * da)) / 0xFF
+			ret += float64(d * d)
+		}
+		ret += float64((oa - da) * (oa - da))
+	}
+	return ret
+}
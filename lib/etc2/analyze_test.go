@@ -0,0 +1,135 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestAnalyzeImage(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/mona-lisa.21x32.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	pngImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+
+	// opaque is block-aligned (5×8 blocks), so that every block is sampled
+	// and no block has padding. Each candidate's estimate should then be the
+	// exact PSNR of encoding and decoding the whole image.
+	opaque := image.NewRGBA(image.Rect(0, 0, 20, 32))
+	draw.Draw(opaque, opaque.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(opaque, opaque.Bounds(), pngImage, image.Point{}, draw.Over)
+
+	a, err := etc2.AnalyzeImage(opaque)
+	if err != nil {
+		tt.Fatalf("AnalyzeImage: %v", err)
+	} else if a.NumSampledBlocks != 40 {
+		tt.Fatalf("NumSampledBlocks: got %d, want 40", a.NumSampledBlocks)
+	} else if (a.NumOpaquePixels != 640) || (a.NumTransparentPixels != 0) || (a.NumTranslucentPixels != 0) {
+		tt.Fatalf("alpha counts: got %d, %d, %d, want 640, 0, 0",
+			a.NumOpaquePixels, a.NumTransparentPixels, a.NumTranslucentPixels)
+	}
+	psnrs := map[etc2.Format]float64{}
+	for _, c := range a.Candidates {
+		psnrs[c.Format] = c.PSNR
+		encoded := &bytes.Buffer{}
+		if err := etc2.Encode(encoded, opaque, c.Format, nil); err != nil {
+			tt.Fatalf("f=%v: Encode: %v", c.Format, err)
+		}
+		decoded, err := etc2.DecodeRaw(encoded.Bytes(), c.Format, 20, 32)
+		if err != nil {
+			tt.Fatalf("f=%v: DecodeRaw: %v", c.Format, err)
+		}
+		sse := 0.0
+		for y := range 32 {
+			for x := range 20 {
+				r0, g0, b0, a0 := opaque.At(x, y).RGBA()
+				r1, g1, b1, a1 := decoded.At(x, y).RGBA()
+				for _, d := range [4]float64{
+					float64(r0>>8) - float64(r1>>8),
+					float64(g0>>8) - float64(g1>>8),
+					float64(b0>>8) - float64(b1>>8),
+					float64(a0>>8) - float64(a1>>8),
+				} {
+					sse += d * d
+				}
+			}
+		}
+		want := 10 * math.Log10((255*255)/(sse/(4*640)))
+		if math.Abs(c.PSNR-want) > 1e-9 {
+			tt.Errorf("f=%v: PSNR: got %.6f, want %.6f", c.Format, c.PSNR, want)
+		}
+	}
+	if len(psnrs) != 4 {
+		tt.Fatalf("Candidates: got %v, want 4 distinct formats", a.Candidates)
+	}
+	wantRecommended := etc2.FormatETC2RGB
+	if psnrs[etc2.FormatETC1] >= (psnrs[etc2.FormatETC2RGB] - 0.25) {
+		wantRecommended = etc2.FormatETC1
+	}
+	if a.Recommended != wantRecommended {
+		tt.Fatalf("Recommended: got %v, want %v", a.Recommended, wantRecommended)
+	}
+
+	// Transparent pixels recommend FormatETC2RGBA1 and translucent ones
+	// FormatETC2RGBA8. The image is not block-aligned: padding pixels are
+	// not counted.
+	for _, tc := range []struct {
+		alpha uint8
+		want  etc2.Format
+	}{
+		{0x00, etc2.FormatETC2RGBA1},
+		{0x80, etc2.FormatETC2RGBA8},
+	} {
+		m := image.NewNRGBA(image.Rect(3, 5, 3+21, 5+10))
+		draw.Draw(m, m.Bounds(), image.White, image.Point{}, draw.Src)
+		m.SetNRGBA(4, 6, color.NRGBA{0x40, 0x80, 0xC0, tc.alpha})
+		m.SetNRGBA(23, 14, color.NRGBA{0x40, 0x80, 0xC0, tc.alpha})
+		a, err := etc2.AnalyzeImage(m)
+		if err != nil {
+			tt.Fatalf("alpha=%#02x: AnalyzeImage: %v", tc.alpha, err)
+		} else if a.Recommended != tc.want {
+			tt.Fatalf("alpha=%#02x: Recommended: got %v, want %v", tc.alpha, a.Recommended, tc.want)
+		}
+		numTransparent, numTranslucent := 2, 0
+		if tc.alpha != 0x00 {
+			numTransparent, numTranslucent = 0, 2
+		}
+		if (a.NumOpaquePixels != 208) || (a.NumTransparentPixels != numTransparent) || (a.NumTranslucentPixels != numTranslucent) {
+			tt.Fatalf("alpha=%#02x: alpha counts: got %d, %d, %d, want 208, %d, %d", tc.alpha,
+				a.NumOpaquePixels, a.NumTransparentPixels, a.NumTranslucentPixels, numTransparent, numTranslucent)
+		}
+	}
+
+	// Large images are sampled, but their alpha counts are still exact.
+	large := image.NewGray(image.Rect(0, 0, 1024, 512))
+	if a, err := etc2.AnalyzeImage(large); err != nil {
+		tt.Fatalf("large: AnalyzeImage: %v", err)
+	} else if a.NumSampledBlocks != 256 {
+		tt.Fatalf("large: NumSampledBlocks: got %d, want 256", a.NumSampledBlocks)
+	} else if a.NumOpaquePixels != (1024 * 512) {
+		tt.Fatalf("large: NumOpaquePixels: got %d, want %d", a.NumOpaquePixels, 1024*512)
+	}
+
+	if _, err := etc2.AnalyzeImage(nil); err != etc2.ErrBadArgument {
+		tt.Fatalf("nil: got %v, want %v", err, etc2.ErrBadArgument)
+	}
+}
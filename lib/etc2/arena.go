@@ -0,0 +1,49 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+// Arena is a bump allocator for decoded images' pixel data. Its Alloc method
+// can be passed to NewImageUsing (or a container package's decode options).
+//
+// Allocating from an Arena is cheap and, once the Arena has grown to fit a
+// typical workload, makes no garbage. The trade-off is that memory is only
+// reclaimed all at once, by Reset, after which all images whose pixels came
+// from the Arena must no longer be used.
+//
+// An Arena is not safe for concurrent use by multiple goroutines.
+type Arena struct {
+	buf []byte
+	off int
+}
+
+// NewArena returns an Arena whose initial capacity is size bytes.
+func NewArena(size int) *Arena {
+	return &Arena{buf: make([]byte, max(0, size))}
+}
+
+// Alloc returns a zeroed slice of length n.
+//
+// If the Arena is full then a new buffer is allocated, at least twice as large
+// as the previous one. The previous buffer is kept alive only by the slices
+// already returned from it.
+func (a *Arena) Alloc(n int) []byte {
+	if n > (len(a.buf) - a.off) {
+		a.buf = make([]byte, max(n, 2*len(a.buf)))
+		a.off = 0
+	}
+	ret := a.buf[a.off : a.off+n : a.off+n]
+	a.off += n
+	clear(ret)
+	return ret
+}
+
+// Reset makes the Arena's memory available for re-use by Alloc.
+func (a *Arena) Reset() {
+	a.off = 0
+}
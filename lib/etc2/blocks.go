@@ -0,0 +1,183 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"bufio"
+	"image/color"
+	"io"
+	"iter"
+)
+
+// BlockMode is the mode of a block's color (RGB) code.
+type BlockMode uint8
+
+const (
+	// BlockModeNone means that the block has no color code, as for the R11
+	// and RG11 formats.
+	BlockModeNone = BlockMode(0)
+
+	BlockModeIndividual   = BlockMode(1)
+	BlockModeDifferential = BlockMode(2)
+	BlockModeT            = BlockMode(3)
+	BlockModeH            = BlockMode(4)
+	BlockModePlanar       = BlockMode(5)
+)
+
+// String returns a short name for m, such as "planar".
+func (m BlockMode) String() string {
+	switch m {
+	case BlockModeNone:
+		return "none"
+	case BlockModeIndividual:
+		return "individual"
+	case BlockModeDifferential:
+		return "differential"
+	case BlockModeT:
+		return "t"
+	case BlockModeH:
+		return "h"
+	case BlockModePlanar:
+		return "planar"
+	}
+	return "invalid"
+}
+
+// DecodedBlock is one decoded 4×4 block of an ETC-compressed image.
+type DecodedBlock struct {
+	// X and Y are the block's coordinates, measured in blocks.
+	X, Y int
+
+	// Pixels are the block's non-premultiplied colors, in row-major order.
+	// Single-channel (R11) values are replicated to red, green and blue, like
+	// color.Gray16. Two-channel (RG11) blocks have zero blue. The signed R11
+	// and RG11 formats use the same 0x8000 offset as Format.Decode.
+	Pixels [16]color.NRGBA64
+
+	// Mode is the mode of the block's color code.
+	Mode BlockMode
+
+	// Code holds the block's encoded bytes. Only the first f.BytesPerBlock()
+	// bytes are used.
+	Code [16]byte
+}
+
+// Blocks returns an iterator over the decoded blocks of the ETC-compressed
+// image in src, in row-major order. Unlike Decode, it never holds the whole
+// image in memory, which suits computing statistics or extracting data sets.
+//
+// If reading src fails, the iterator yields a zero DecodedBlock and the error,
+// and then stops. As for Decode, src ending early is a *TruncatedError.
+func (f Format) Blocks(src io.Reader, widthInBlocks int, heightInBlocks int) iter.Seq2[DecodedBlock, error] {
+	return func(yield func(DecodedBlock, error) bool) {
+		if (src == nil) || (f.ETCVersion() == 0) ||
+			(widthInBlocks < 0) || (widthInBlocks > 16384) ||
+			(heightInBlocks < 0) || (heightInBlocks > 16384) {
+			yield(DecodedBlock{}, ErrBadArgument)
+			return
+		}
+		// Strip the sRGB bit, which does not affect decoding.
+		f &^= formatBitSRGBColorSpace
+
+		br := bufio.NewReader(src)
+		n := f.BytesPerBlock()
+		work := [64]byte{}
+		for blockY := range heightInBlocks {
+			for blockX := range widthInBlocks {
+				b := DecodedBlock{X: blockX, Y: blockY}
+				if _, err := readFull(br, b.Code[:n]); err != nil {
+					yield(DecodedBlock{}, truncated(err, f, (blockY*widthInBlocks)+blockX))
+					return
+				}
+				f.decodeBlock(&b, &work)
+				if !yield(b, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// decodeBlock decodes b.Code into b.Pixels and b.Mode, using work as scratch
+// space. f must not have its sRGB bit set.
+func (f Format) decodeBlock(b *DecodedBlock, work *[64]byte) {
+	code0 := readU64BE(b.Code[0:])
+	switch f {
+	case FormatETC1S, FormatETC1, FormatETC2RGB, FormatETC2RGBA1:
+		oneBitAlpha := f == FormatETC2RGBA1
+		b.Mode = colorBlockMode(code0, oneBitAlpha)
+		decodeColor(work, code0, oneBitAlpha)
+		fromRGBA8(&b.Pixels, work)
+
+	case FormatETC2RGBA8:
+		code1 := readU64BE(b.Code[8:])
+		b.Mode = colorBlockMode(code1, false)
+		decodeColor(work, code1, false)
+		decodeAlpha(work, code0)
+		fromRGBA8(&b.Pixels, work)
+
+	case FormatETC2R11Unsigned, FormatETC2R11Signed:
+		if f == FormatETC2R11Signed {
+			decode11s(work, 0x00, code0)
+		} else {
+			decode11u(work, 0x00, code0)
+		}
+		for i := range b.Pixels {
+			y := (uint16(work[(2*i)+0]) << 8) | uint16(work[(2*i)+1])
+			b.Pixels[i] = color.NRGBA64{R: y, G: y, B: y, A: 0xFFFF}
+		}
+
+	case FormatETC2RG11Unsigned, FormatETC2RG11Signed:
+		code1 := readU64BE(b.Code[8:])
+		if f == FormatETC2RG11Signed {
+			decode11s(work, 0x00, code0)
+			decode11s(work, 0x20, code1)
+		} else {
+			decode11u(work, 0x00, code0)
+			decode11u(work, 0x20, code1)
+		}
+		for i := range b.Pixels {
+			b.Pixels[i] = color.NRGBA64{
+				R: (uint16(work[(2*i)+0x00]) << 8) | uint16(work[(2*i)+0x01]),
+				G: (uint16(work[(2*i)+0x20]) << 8) | uint16(work[(2*i)+0x21]),
+				B: 0x0000,
+				A: 0xFFFF,
+			}
+		}
+	}
+}
+
+// fromRGBA8 converts a decoded color block's 8-bit RGBA values. Transparent
+// pixels are zero in both the premultiplied and non-premultiplied forms.
+func fromRGBA8(pixels *[16]color.NRGBA64, work *[64]byte) {
+	for i := range pixels {
+		pixels[i] = color.NRGBA64{
+			R: 0x101 * uint16(work[(4*i)+0]),
+			G: 0x101 * uint16(work[(4*i)+1]),
+			B: 0x101 * uint16(work[(4*i)+2]),
+			A: 0x101 * uint16(work[(4*i)+3]),
+		}
+	}
+}
+
+// colorBlockMode returns the mode of a color code, following the same logic as
+// decodeColor.
+func colorBlockMode(code uint64, oneBitAlpha bool) BlockMode {
+	diff := (code & 0x2_0000_0000) != 0
+	if !oneBitAlpha && !diff {
+		return BlockModeIndividual
+	} else if r := (0x1F & uint32(code>>0x3B)) + diffs[7&(code>>0x38)]; (r >> 5) != 0 {
+		return BlockModeT
+	} else if g := (0x1F & uint32(code>>0x33)) + diffs[7&(code>>0x30)]; (g >> 5) != 0 {
+		return BlockModeH
+	} else if b := (0x1F & uint32(code>>0x2B)) + diffs[7&(code>>0x28)]; (b >> 5) != 0 {
+		return BlockModePlanar
+	}
+	return BlockModeDifferential
+}
@@ -0,0 +1,166 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"image/color"
+	"sync"
+)
+
+// compressedImageCacheSize is the number of decoded blocks that a
+// CompressedImage caches. 16 blocks covers a 64 pixel wide span of one row of
+// blocks, which suits scanning across (or sampling near) a few pixels.
+const compressedImageCacheSize = 16
+
+// cachedBlock is one entry of a CompressedImage's block cache.
+type cachedBlock struct {
+	// blockIndex is the row-major index of the block, or -1 if the entry is
+	// unused.
+	blockIndex int
+	// lastUsed is the CompressedImage's clock when the entry was last used.
+	lastUsed uint64
+	pixels   [16]color.NRGBA64
+}
+
+// CompressedImage is an image.Image backed by an ETC payload, which it
+// decodes a 4×4 block at a time, as the At and RGBA64At methods need them. It
+// caches the most recently used blocks, so that looking up nearby pixels does
+// not decode the same block again.
+//
+// This suits programs, such as texture viewers, that only sample a few pixels
+// of a large texture: decoding all of it up front, with DecodeRaw, would take
+// more time and memory.
+//
+// Its colors are those that DecodeRaw (with no DecodeOptions) would give, and
+// its ColorModel is the Format's. It is safe for concurrent use.
+type CompressedImage struct {
+	data          []byte
+	f             Format
+	rect          image.Rectangle
+	widthInBlocks int
+
+	mu    sync.Mutex
+	clock uint64
+	cache [compressedImageCacheSize]cachedBlock
+	code  DecodedBlock
+	work  [64]byte
+}
+
+// NewCompressedImage returns a CompressedImage for the headerless ETC payload
+// data, like DecodeRaw does for an in-memory image. The image's bounds are
+// exactly width by height, even if they are not multiples of 4.
+//
+// It does not copy data, which the caller should not modify afterwards. If
+// data is too short, it returns a *TruncatedError.
+func NewCompressedImage(data []byte, f Format, width int, height int) (*CompressedImage, error) {
+	if (f.ETCVersion() == 0) ||
+		(width < 0) || (width >= 65536) ||
+		(height < 0) || (height >= 65536) {
+		return nil, ErrBadArgument
+	}
+	widthInBlocks, heightInBlocks := BlocksWide(width), BlocksHigh(height)
+	if int64(len(data)) < f.PayloadSize(widthInBlocks, heightInBlocks) {
+		return nil, newTruncatedError(f, len(data)/f.BytesPerBlock())
+	}
+	m := &CompressedImage{
+		data:          data,
+		f:             f,
+		rect:          image.Rect(0, 0, width, height),
+		widthInBlocks: widthInBlocks,
+	}
+	for i := range m.cache {
+		m.cache[i].blockIndex = -1
+	}
+	return m, nil
+}
+
+// Format returns the image's ETC format.
+func (m *CompressedImage) Format() Format { return m.f }
+
+func (m *CompressedImage) ColorModel() color.Model { return m.f.ColorModel() }
+
+func (m *CompressedImage) Bounds() image.Rectangle { return m.rect }
+
+func (m *CompressedImage) At(x int, y int) color.Color {
+	if !(image.Point{x, y}.In(m.rect)) {
+		return m.f.ColorModel().Convert(color.Transparent)
+	}
+	c := m.nrgba64At(x, y)
+	switch {
+	case 0 != (m.f & formatBit8BitAlpha):
+		return color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+	case 0 == (m.f & formatBitDepth11):
+		// Transparent (FormatETC2RGBA1) pixels decode to all zeroes, so these
+		// values are already premultiplied.
+		return color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+	case 0 != (m.f & formatBitDepth11TwoChannel):
+		return color.RGBA64{c.R, c.G, 0x0000, 0xFFFF}
+	}
+	return color.Gray16{c.R}
+}
+
+func (m *CompressedImage) RGBA64At(x int, y int) color.RGBA64 {
+	if !(image.Point{x, y}.In(m.rect)) {
+		return color.RGBA64{}
+	}
+	c := m.nrgba64At(x, y)
+	if (0 != (m.f & formatBit8BitAlpha)) && (c.A != 0xFFFF) {
+		r, g, b, a := c.RGBA()
+		return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+	}
+	return color.RGBA64(c)
+}
+
+// Opaque reports whether the image is fully opaque. It only decodes blocks
+// for the formats with an alpha channel.
+func (m *CompressedImage) Opaque() bool {
+	if m.f.AlphaModel() == AlphaModelOpaque {
+		return true
+	}
+	for y := m.rect.Min.Y; y < m.rect.Max.Y; y++ {
+		for x := m.rect.Min.X; x < m.rect.Max.X; x++ {
+			if m.nrgba64At(x, y).A != 0xFFFF {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nrgba64At returns the decoded color at (x, y), which must be within m.rect,
+// decoding its block if it is not cached.
+func (m *CompressedImage) nrgba64At(x int, y int) color.NRGBA64 {
+	blockIndex := ((y / 4) * m.widthInBlocks) + (x / 4)
+	pixelIndex := (4 * (y & 3)) + (x & 3)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock++
+
+	lru := 0
+	for i := range m.cache {
+		if m.cache[i].blockIndex == blockIndex {
+			m.cache[i].lastUsed = m.clock
+			return m.cache[i].pixels[pixelIndex]
+		} else if m.cache[i].lastUsed < m.cache[lru].lastUsed {
+			lru = i
+		}
+	}
+
+	n := m.f.BytesPerBlock()
+	copy(m.code.Code[:n], m.data[blockIndex*n:])
+	(m.f &^ formatBitSRGBColorSpace).decodeBlock(&m.code, &m.work)
+	m.cache[lru] = cachedBlock{
+		blockIndex: blockIndex,
+		lastUsed:   m.clock,
+		pixels:     m.code.Pixels,
+	}
+	return m.code.Pixels[pixelIndex]
+}
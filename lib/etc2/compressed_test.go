@@ -0,0 +1,70 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestCompressedImage(tt *testing.T) {
+	testCases := []string{
+		"36.etc2-r11s",
+		"36.etc2-rg11u",
+		"49.etc2-rgba1",
+		"49.etc2-srgba8",
+		"dice.80x60.etc2-rgba8",
+		"mona-lisa.21x32.etc1",
+		"water-lillies.64x62.etc2-rgb",
+	}
+
+	for _, tc := range testCases {
+		f, config, payload := readPKM(tt, tc)
+		want, err := etc2.DecodeRaw(payload, f, config.Width, config.Height)
+		if err != nil {
+			tt.Errorf("tc=%q: DecodeRaw: %v", tc, err)
+			continue
+		}
+		got, err := etc2.NewCompressedImage(payload, f, config.Width, config.Height)
+		if err != nil {
+			tt.Errorf("tc=%q: NewCompressedImage: %v", tc, err)
+			continue
+		} else if got.Bounds() != want.Bounds() {
+			tt.Errorf("tc=%q: got bounds %v, want %v", tc, got.Bounds(), want.Bounds())
+			continue
+		} else if got.ColorModel() != want.ColorModel() {
+			tt.Errorf("tc=%q: ColorModel differs", tc)
+			continue
+		}
+
+		// Visit the pixels in column-major order, so that the block cache
+		// misses (and evicts) as well as hits.
+		b := want.Bounds()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				if g, w := got.At(x, y), want.At(x, y); g != w {
+					tt.Fatalf("tc=%q: At(%d, %d): got %v, want %v", tc, x, y, g, w)
+				}
+				if g, w := got.RGBA64At(x, y), want.(image.RGBA64Image).RGBA64At(x, y); g != w {
+					tt.Fatalf("tc=%q: RGBA64At(%d, %d): got %v, want %v", tc, x, y, g, w)
+				}
+			}
+		}
+		if g, w := got.Opaque(), want.(interface{ Opaque() bool }).Opaque(); g != w {
+			tt.Errorf("tc=%q: Opaque: got %t, want %t", tc, g, w)
+		}
+
+		if _, err := etc2.NewCompressedImage(payload[:len(payload)-1], f, config.Width, config.Height); !errors.As(err, new(*etc2.TruncatedError)) {
+			tt.Errorf("tc=%q: truncated: got %v, want an *etc2.TruncatedError", tc, err)
+		}
+	}
+}
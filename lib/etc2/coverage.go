@@ -0,0 +1,186 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"math"
+)
+
+// AlphaCoverage configures EncodeOptions.AlphaCoverage.
+//
+// An alpha-tested texture (such as foliage or a chain-link fence) is drawn
+// where its alpha is at least some threshold. Compression, and especially
+// downsampling to smaller mipmap levels, changes how many pixels pass that
+// test, so that the drawn shapes thin out (or thicken). Scaling the alpha
+// values before encoding can restore the original fraction of passing pixels.
+type AlphaCoverage struct {
+	// Threshold is the alpha test's reference value, on the 8-bit scale: a
+	// pixel passes if its alpha is at least Threshold. It should be positive.
+	Threshold uint8
+
+	// Target is the fraction (from 0 to 1) of pixels that should pass, after
+	// encoding. Zero means to use the source image's own (pre-encoding)
+	// fraction.
+	//
+	// When encoding a mipmap chain, each level's Target should typically be
+	// the MeasureAlphaCoverage of the base (largest) level.
+	Target float64
+}
+
+// MeasureAlphaCoverage returns the fraction of src's pixels whose
+// (non-premultiplied, 8-bit) alpha is at least threshold. It returns zero if
+// src is nil or empty.
+func MeasureAlphaCoverage(src image.Image, threshold uint8) float64 {
+	if src == nil {
+		return 0
+	}
+	b := src.Bounds()
+	if b.Empty() {
+		return 0
+	}
+	numPassing := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			_, _, _, a := src.At(x, y).RGBA()
+			if uint8(a>>8) >= threshold {
+				numPassing++
+			}
+		}
+	}
+	return float64(numPassing) / float64(b.Dx()*b.Dy())
+}
+
+// alphaScaleLUT returns the table that maps each 8-bit alpha value v to
+// min(255, round(v * scale)).
+func alphaScaleLUT(scale float64) *[256]uint8 {
+	lut := &[256]uint8{}
+	for v := range 256 {
+		lut[v] = uint8(min(255, (float64(v)*scale)+0.5))
+	}
+	return lut
+}
+
+// applyAlphaLUT returns extract, modified to then map the pixels' alpha
+// values through lut. It returns extract unchanged if lut is nil.
+func applyAlphaLUT(extract func(blockX int, blockY int), pixels *[64]byte, lut *[256]uint8) func(blockX int, blockY int) {
+	if lut == nil {
+		return extract
+	}
+	return func(blockX int, blockY int) {
+		extract(blockX, blockY)
+		for i := 3; i < 64; i += 4 {
+			pixels[i] = lut[pixels[i]]
+		}
+	}
+}
+
+// alphaCoverageLUT returns the alpha scaling table for options.AlphaCoverage,
+// when encoding the r sub-rectangle of src in the format f (which must not
+// have its sRGB bit set). It returns nil if there is no AlphaCoverage or f
+// has no alpha channel, or if the best scale is one (no change).
+//
+// The scale is found by bisection, measuring the fraction of passing pixels
+// as decoded (for FormatETC2RGBA8, after an alpha-only encode) at each step.
+func (options *EncodeOptions) alphaCoverageLUT(src image.Image, r image.Rectangle, f Format) *[256]uint8 {
+	if (options == nil) || (options.AlphaCoverage == nil) || (f.AlphaModel() == AlphaModelOpaque) {
+		return nil
+	}
+	threshold := options.AlphaCoverage.Threshold
+
+	// Extract every in-bounds alpha value once, block by block.
+	e := newEncoder(0, options)
+	extract := f.makeExtract(&e.pixels, src, r, options)
+	blocks := make([][16]byte, 0, BlocksWide(r.Dx())*BlocksHigh(r.Dy()))
+	numPixels, numPassing := 0, 0
+	for blockY := r.Min.Y; blockY < r.Max.Y; blockY += 4 {
+		for blockX := r.Min.X; blockX < r.Max.X; blockX += 4 {
+			extract(blockX, blockY)
+			alphas := [16]byte{}
+			for i := range 16 {
+				alphas[i] = e.pixels[(4*i)+3]
+				if inBlockBounds(r, blockX, blockY, i) {
+					numPixels++
+					if alphas[i] >= threshold {
+						numPassing++
+					}
+				}
+			}
+			blocks = append(blocks, alphas)
+		}
+	}
+	if numPixels == 0 {
+		return nil
+	}
+	target := options.AlphaCoverage.Target
+	if target == 0 {
+		target = float64(numPassing) / float64(numPixels)
+	}
+
+	// coverage returns the decoded fraction of passing pixels, after scaling
+	// the alpha values by lut.
+	coverage := func(lut *[256]uint8) float64 {
+		n, blockX, blockY := 0, r.Min.X, r.Min.Y
+		for _, alphas := range blocks {
+			for i, a := range alphas {
+				e.pixels[(4*i)+3] = lut[a]
+			}
+			if f == FormatETC2RGBA8 {
+				decodeAlpha(&e.work, e.encodeAlpha())
+			} else {
+				// FormatETC2RGBA1 decodes every pixel as fully opaque or
+				// fully transparent, depending only on its source alpha.
+				for i := 3; i < 64; i += 4 {
+					e.work[i] = uint8(int8(e.pixels[i]) >> 7)
+				}
+			}
+			for i := range 16 {
+				if inBlockBounds(r, blockX, blockY, i) && (e.work[(4*i)+3] >= threshold) {
+					n++
+				}
+			}
+			if blockX += 4; blockX >= r.Max.X {
+				blockX, blockY = r.Min.X, blockY+4
+			}
+		}
+		return float64(n) / float64(numPixels)
+	}
+
+	// Coverage (roughly) increases with the scale. At 256, every non-zero
+	// alpha value becomes 255.
+	bestScale, bestLUT := 1.0, (*[256]uint8)(nil)
+	bestDist := math.Abs(coverage(alphaScaleLUT(1)) - target)
+	lo, hi := 0.0, 256.0
+	for range 24 {
+		if bestDist == 0 {
+			break
+		}
+		mid := (lo + hi) / 2
+		lut := alphaScaleLUT(mid)
+		c := coverage(lut)
+		if dist := math.Abs(c - target); bestDist > dist {
+			bestScale, bestLUT, bestDist = mid, lut, dist
+		}
+		if c < target {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	if bestScale == 1 {
+		return nil
+	}
+	return bestLUT
+}
+
+// inBlockBounds returns whether the i'th pixel of the block whose top-left
+// pixel is at (blockX, blockY) is within r, as opposed to being padding.
+func inBlockBounds(r image.Rectangle, blockX int, blockY int, i int) bool {
+	return ((blockX + (i & 3)) < r.Max.X) && ((blockY + (i >> 2)) < r.Max.Y)
+}
@@ -0,0 +1,57 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestEncodeAlphaCoverage(tt *testing.T) {
+	// A soft-edged (blurred) shape, as a downsampled mipmap level would be.
+	src := image.NewNRGBA(image.Rect(0, 0, 30, 30))
+	for y := range 30 {
+		for x := range 30 {
+			dx, dy := x-15, y-15
+			a := max(0, 255-(((dx*dx)+(dy*dy))*255/200))
+			src.SetNRGBA(x, y, color.NRGBA{R: 0x40, G: 0x80, B: 0x20, A: uint8(a)})
+		}
+	}
+	const threshold = 0x80
+	srcCoverage := etc2.MeasureAlphaCoverage(src, threshold)
+
+	for _, f := range []etc2.Format{etc2.FormatETC2RGBA1, etc2.FormatETC2RGBA8} {
+		for _, target := range []float64{0, 0.5} {
+			want := target
+			if want == 0 {
+				want = srcCoverage
+			}
+			buf := &bytes.Buffer{}
+			if err := etc2.Encode(buf, src, f, &etc2.EncodeOptions{
+				AlphaCoverage: &etc2.AlphaCoverage{
+					Threshold: threshold,
+					Target:    target,
+				},
+			}); err != nil {
+				tt.Fatalf("f=%v, target=%g: Encode: %v", f, target, err)
+			}
+			m, err := etc2.DecodeRaw(buf.Bytes(), f, 30, 30)
+			if err != nil {
+				tt.Fatalf("f=%v, target=%g: DecodeRaw: %v", f, target, err)
+			}
+			if got := etc2.MeasureAlphaCoverage(m, threshold); (got < want-0.01) || (want+0.01 < got) {
+				tt.Fatalf("f=%v, target=%g: got coverage %g, want %g", f, target, got, want)
+			}
+		}
+	}
+}
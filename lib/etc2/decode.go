@@ -11,22 +11,342 @@ package etc2
 import (
 	"image"
 	"io"
+	"math"
+	"sync"
 )
 
+// DecodeOptions are optional arguments to DecodeWithOptions and the other
+// "WithOptions" decoding functions. The zero value is valid and means to use
+// the default configuration.
+//
+// New fields may be added in the future. Their zero values will always mean
+// the existing behavior.
+type DecodeOptions struct {
+	// LinearOutput is whether to convert the sRGB formats' decoded colors from
+	// the sRGB transfer function to linear light, rounded to the nearest 8-bit
+	// value. It is ignored for non-sRGB formats, and never affects alpha.
+	//
+	// Linear 8-bit output has far fewer distinct dark values than sRGB 8-bit
+	// output, so dark gradients can show banding. Dither reduces this.
+	LinearOutput bool
+
+	// Dither is whether, when LinearOutput requantizes to 8 bits, to apply an
+	// ordered (4×4 Bayer matrix) dither instead of rounding to nearest.
+	Dither bool
+
+	// Partial is whether, if src ends early (or fails in some other way), to
+	// still decode every complete block that was read before returning the
+	// error. Blocks are decoded in row-major order. dst's pixels for the
+	// remaining blocks are left unchanged.
+	//
+	// The pkm and ktx packages then return the partially decoded image along
+	// with the error, so that viewers can show what was received from, for
+	// example, a broken download.
+	Partial bool
+
+	// ColorOnly is whether to skip decoding the alpha half of the
+	// FormatETC2RGBA8 and FormatETC2SRGBA8 formats' blocks, decoding only
+	// their RGB content as if opaque. dst must then be an *image.RGBA (as for
+	// FormatETC2RGB), not an *image.NRGBA. It is ignored for other formats.
+	ColorOnly bool
+
+	// OneBitAlphaNRGBA is whether NewImageWithOptions returns an *image.NRGBA
+	// (as for FormatETC2RGBA8), instead of an *image.RGBA, for the
+	// FormatETC2RGBA1 and FormatETC2SRGBA1 formats. Punch-through alpha is
+	// still alpha, and callers that process non-premultiplied colors can then
+	// handle every alpha format alike. It is ignored for other formats.
+	//
+	// A decoded texel is either opaque or transparent black, whose
+	// premultiplied and non-premultiplied forms are the same bytes. Decoding
+	// into either image type therefore gives the same pixels.
+	OneBitAlphaNRGBA bool
+
+	// Gray8 is whether NewImageWithOptions returns an *image.Gray, instead of
+	// an *image.Gray16, for the FormatETC2R11Unsigned and FormatETC2R11Signed
+	// formats. This halves the memory used, for callers (such as those
+	// decoding masks) that only want 8 bits. Each 16-bit value v is rounded to
+	// the nearest 8-bit value, v*255/65535. It is ignored for other formats.
+	Gray8 bool
+
+	// RG16 is whether NewImageWithOptions returns an *ImageRG16, instead of an
+	// *image.RGBA64, for the FormatETC2RG11Unsigned and FormatETC2RG11Signed
+	// formats. This halves the memory used, for callers (such as normal map
+	// pipelines) that only want the red and green channels. It is ignored for
+	// other formats.
+	RG16 bool
+}
+
+// DecodeRaw decodes a headerless ETC payload, such as one extracted from a
+// game archive, whose format and image dimensions are known out of band.
+//
+// The width and height are in pixels, not blocks. The returned image's bounds
+// are exactly that width and height, even if they are not multiples of 4.
+func DecodeRaw(data []byte, f Format, width int, height int) (image.Image, error) {
+	return DecodeRawWithOptions(data, f, width, height, nil)
+}
+
+// DecodeRawWithOptions is like DecodeRaw but with optional arguments.
+//
+// If data is too short, it returns a *TruncatedError. With the Partial
+// option, it also returns the image, with the complete blocks in data
+// decoded.
+//
+// options may be nil, which means to use the default configuration.
+func DecodeRawWithOptions(data []byte, f Format, width int, height int, options *DecodeOptions) (image.Image, error) {
+	m, err := f.NewImageWithOptions(width, height, nil, options)
+	if err != nil {
+		return nil, err
+	}
+	b := m.Bounds()
+	err = f.DecodeBytes(m, data, b.Dx()/4, b.Dy()/4, options)
+	if (err != nil) && ((options == nil) || !options.Partial) {
+		return nil, err
+	}
+	return m.SubImage(image.Rect(0, 0, width, height)), err
+}
+
 // Decode decodes the ETC-compressed image in src into dst, given the image
 // dimensions as measured in 4×4 pixel blocks.
 //
-// dst should be the result of calling f.NewImage.
+// dst should be the result of calling f.NewImage (or, for DecodeWithOptions,
+// f.NewImageWithOptions).
+//
+// src may deliver its bytes in arbitrarily small pieces, as network or pipe
+// readers do. If src ends early, Decode returns a *TruncatedError, which wraps
+// io.ErrUnexpectedEOF and identifies the first incomplete block. Any other
+// error from src, such as a context-aware reader's context.Canceled, stops
+// decoding and is returned as is.
 func (f Format) Decode(dst image.Image, src io.Reader, widthInBlocks int, heightInBlocks int) error {
+	return f.DecodeWithOptions(dst, src, widthInBlocks, heightInBlocks, nil)
+}
+
+// DecodeWithOptions is like Decode but with optional arguments.
+//
+// options may be nil, which means to use the default configuration.
+func (f Format) DecodeWithOptions(dst image.Image, src io.Reader, widthInBlocks int, heightInBlocks int, options *DecodeOptions) error {
+	if dst == nil {
+		return ErrBadArgument
+	} else if b := dst.Bounds(); (b.Dx() < (widthInBlocks * 4)) || (b.Dy() < (heightInBlocks * 4)) {
+		return ErrBadArgument
+	}
+	return f.decodeAt(dst, dst.Bounds().Min, src, nil, widthInBlocks, heightInBlocks, options)
+}
+
+// DecodeBytes is like DecodeWithOptions except that the ETC-compressed image
+// is the in-memory data instead of an io.Reader, such as a memory-mapped file.
+// The blocks are decoded in place, without copying data.
+//
+// If data is too short, it returns a *TruncatedError. With the Partial
+// option, the complete blocks in data are decoded first.
+//
+// options may be nil, which means to use the default configuration.
+func (f Format) DecodeBytes(dst image.Image, data []byte, widthInBlocks int, heightInBlocks int, options *DecodeOptions) error {
+	if dst == nil {
+		return ErrBadArgument
+	} else if b := dst.Bounds(); (b.Dx() < (widthInBlocks * 4)) || (b.Dy() < (heightInBlocks * 4)) {
+		return ErrBadArgument
+	} else if data == nil {
+		data = []byte{}
+	}
+	return f.decodeAt(dst, dst.Bounds().Min, nil, data, widthInBlocks, heightInBlocks, options)
+}
+
+// DecodeAt is like Decode except that the top-left of the decoded image is
+// placed at p in dst, instead of at dst.Bounds().Min. This can assemble an
+// atlas from multiple ETC-compressed tiles.
+//
+// Decoded pixels outside of dst.Bounds() are discarded. Passing a SubImage of
+// a larger image as dst can therefore clip a tile whose dimensions are not a
+// multiple of 4, without overwriting its neighbors.
+//
+// dst's concrete type should match that returned by f.NewImage.
+func (f Format) DecodeAt(dst image.Image, p image.Point, src io.Reader, widthInBlocks int, heightInBlocks int) error {
+	return f.decodeAt(dst, p, src, nil, widthInBlocks, heightInBlocks, nil)
+}
+
+// DecodeAtWithOptions is like DecodeAt but with optional arguments.
+//
+// options may be nil, which means to use the default configuration.
+func (f Format) DecodeAtWithOptions(dst image.Image, p image.Point, src io.Reader, widthInBlocks int, heightInBlocks int, options *DecodeOptions) error {
+	return f.decodeAt(dst, p, src, nil, widthInBlocks, heightInBlocks, options)
+}
+
+// DecodeAlpha decodes only the alpha channel of the ETC-compressed image in
+// src into dst, given the image dimensions as measured in 4×4 pixel blocks.
+// The color half of each block is skipped, not decoded, which is faster than
+// Decode for tools that only need a mask, such as for hit-testing or alpha
+// coverage statistics.
+//
+// f must be FormatETC2RGBA8 or FormatETC2SRGBA8. The decoded image's top-left
+// is placed at dst.Bounds().Min and decoded pixels outside of dst.Bounds()
+// (such as the padding of images whose dimensions are not multiples of 4) are
+// discarded.
+func (f Format) DecodeAlpha(dst *image.Alpha, src io.Reader, widthInBlocks int, heightInBlocks int) error {
 	if (dst == nil) || (src == nil) ||
+		((f != FormatETC2RGBA8) && (f != FormatETC2SRGBA8)) ||
 		(widthInBlocks < 0) || (widthInBlocks > 16384) ||
 		(heightInBlocks < 0) || (heightInBlocks > 16384) {
 		return ErrBadArgument
-	} else if b := dst.Bounds(); (b.Dx() < (widthInBlocks * 4)) || (b.Dy() < (heightInBlocks * 4)) {
+	}
+
+	b := dst.Bounds()
+	rowSize := widthInBlocks * 16
+	row := make([]byte, rowSize)
+	work := [64]byte{}
+	tile := [16]byte{}
+
+	for by := range heightInBlocks {
+		if numRead, err := readFull(src, row); err != nil {
+			return truncated(err, f, (by*widthInBlocks)+(numRead/16))
+		}
+		y0 := b.Min.Y + (4 * by)
+		for bx := range widthInBlocks {
+			decodeAlpha(&work, readU64BE(row[16*bx:]))
+			for i := range tile {
+				tile[i] = work[(4*i)+3]
+			}
+			storeBlock(dst.Pix, dst.Stride, b, b.Min.X+(4*bx), y0, 1, tile[:])
+		}
+	}
+	return nil
+}
+
+// DecodeFloat32 decodes the ETC-compressed image in src into dst, given the
+// image dimensions as measured in 4×4 pixel blocks. Each value is
+// reconstructed as per the OpenGL specification, without the bias or
+// rounding of Decode's 16-bit image types: the signed formats' values are in
+// the range [-1, +1] and the unsigned formats' values are in the range [0, 1].
+//
+// f must be one of the R11 or RG11 formats. dst holds the pixels in row-major
+// order, with the RG11 formats' two channels interleaved. Its length must be
+// at least (4 * widthInBlocks) * (4 * heightInBlocks) * numChannels, where
+// numChannels is 2 for the RG11 formats and 1 for the R11 formats.
+func (f Format) DecodeFloat32(dst []float32, src io.Reader, widthInBlocks int, heightInBlocks int) error {
+	scale := float32(1) / 2047
+	if (f == FormatETC2R11Signed) || (f == FormatETC2RG11Signed) {
+		scale = float32(1) / 1023
+	}
+	return f.decodeValues11(len(dst), src, widthInBlocks, heightInBlocks, func(i int, value11 int32) {
+		dst[i] = float32(value11) * scale
+	})
+}
+
+// Decode11Bit is like DecodeFloat32 except that it gives the exact 11-bit
+// values reconstructed by the OpenGL specification's formulas, before any
+// conversion to floating point or expansion to 16 bits. This is useful for
+// conformance testing against other decoders.
+//
+// The signed formats' values are in the range [-1023, +1023] and the unsigned
+// formats' values are in the range [0, 2047].
+func (f Format) Decode11Bit(dst []int16, src io.Reader, widthInBlocks int, heightInBlocks int) error {
+	return f.decodeValues11(len(dst), src, widthInBlocks, heightInBlocks, func(i int, value11 int32) {
+		dst[i] = int16(value11)
+	})
+}
+
+// decodeValues11 calls fn for each of the 11-bit values of the R11 or RG11
+// image in src, where i is the value's index in a row-major, channel
+// interleaved slice. dstLen is that slice's length.
+func (f Format) decodeValues11(dstLen int, src io.Reader, widthInBlocks int, heightInBlocks int, fn func(i int, value11 int32)) error {
+	if (src == nil) ||
+		(widthInBlocks < 0) || (widthInBlocks > 16384) ||
+		(heightInBlocks < 0) || (heightInBlocks > 16384) {
 		return ErrBadArgument
 	}
 
-	dstPix, dstStride := []byte(nil), 0
+	numChannels, unpack := 0, unpack11u
+	switch f {
+	case FormatETC2R11Unsigned:
+		numChannels, unpack = 1, unpack11u
+	case FormatETC2R11Signed:
+		numChannels, unpack = 1, unpack11s
+	case FormatETC2RG11Unsigned:
+		numChannels, unpack = 2, unpack11u
+	case FormatETC2RG11Signed:
+		numChannels, unpack = 2, unpack11s
+	default:
+		return ErrBadArgument
+	}
+	width := 4 * widthInBlocks
+	if int64(dstLen) < (int64(width) * int64(4*heightInBlocks) * int64(numChannels)) {
+		return ErrBadArgument
+	}
+
+	bytesPerBlock := f.BytesPerBlock()
+	row := make([]byte, widthInBlocks*bytesPerBlock)
+	values := [16]int32{}
+
+	for by := range heightInBlocks {
+		if numRead, err := readFull(src, row); err != nil {
+			return truncated(err, f, (by*widthInBlocks)+(numRead/bytesPerBlock))
+		}
+		for bx := range widthInBlocks {
+			for c := range numChannels {
+				unpack(&values, readU64BE(row[(bytesPerBlock*bx)+(8*c):]))
+				for j, v := range values {
+					x := (4 * bx) + (j & 3)
+					y := (4 * by) + (j >> 2)
+					fn((((y*width)+x)*numChannels)+c, v)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// maxConsecutiveEmptyReads is how many times in a row an io.Reader can return
+// no data and no error before readFull gives up, like bufio.Reader does.
+const maxConsecutiveEmptyReads = 100
+
+// readFull is like io.ReadFull, except that it returns io.ErrUnexpectedEOF
+// (not io.EOF) if r ends before filling buf, even if no bytes were read, and
+// io.ErrNoProgress if r keeps returning (0, nil).
+func readFull(r io.Reader, buf []byte) (numRead int, retErr error) {
+	for numEmptyReads := 0; numRead < len(buf); {
+		n, err := r.Read(buf[numRead:])
+		numRead += n
+		if numRead == len(buf) {
+			return numRead, nil
+		} else if err == io.EOF {
+			return numRead, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return numRead, err
+		} else if n > 0 {
+			numEmptyReads = 0
+		} else if numEmptyReads++; numEmptyReads >= maxConsecutiveEmptyReads {
+			return numRead, io.ErrNoProgress
+		}
+	}
+	return numRead, nil
+}
+
+// truncated converts a readFull error of io.ErrUnexpectedEOF to a
+// *TruncatedError for the block at blockIndex. Other errors are returned as
+// is.
+func truncated(err error, f Format, blockIndex int) error {
+	if err == io.ErrUnexpectedEOF {
+		return newTruncatedError(f, blockIndex)
+	}
+	return err
+}
+
+// decodeAt decodes from src or, if src is nil, from data. The latter decodes
+// in place, without copying data into a buffer.
+func (f Format) decodeAt(dst image.Image, p image.Point, src io.Reader, data []byte, widthInBlocks int, heightInBlocks int, options *DecodeOptions) error {
+	if (dst == nil) || ((src == nil) && (data == nil)) ||
+		(widthInBlocks < 0) || (widthInBlocks > 16384) ||
+		(heightInBlocks < 0) || (heightInBlocks > 16384) {
+		return ErrBadArgument
+	}
+
+	linearize, dither := false, false
+	if (options != nil) && options.LinearOutput && ((f & formatBitSRGBColorSpace) != 0) {
+		linearize, dither = true, options.Dither
+		srgbToLinearOnce.Do(initSRGBToLinear)
+	}
+
+	partial, colorOnly := (options != nil) && options.Partial, false
+	dstPix, dstStride, bytesPerPixel := []byte(nil), 0, 0
 	switch f {
 	case FormatETC1S,
 		FormatETC1,
@@ -35,108 +355,139 @@ func (f Format) Decode(dst image.Image, src io.Reader, widthInBlocks int, height
 		if m, ok := dst.(*image.RGBA); !ok {
 			return ErrBadImageType
 		} else {
-			dstPix, dstStride = m.Pix, m.Stride
+			dstPix, dstStride, bytesPerPixel = m.Pix, m.Stride, 4
 		}
 		f = FormatETC1
 
 	case FormatETC2RGBA1,
 		FormatETC2SRGBA1:
-		if m, ok := dst.(*image.RGBA); !ok {
-			return ErrBadImageType
+		// Opaque and transparent black texels are the same in both forms.
+		if m, ok := dst.(*image.RGBA); ok {
+			dstPix, dstStride, bytesPerPixel = m.Pix, m.Stride, 4
+		} else if m, ok := dst.(*image.NRGBA); ok {
+			dstPix, dstStride, bytesPerPixel = m.Pix, m.Stride, 4
 		} else {
-			dstPix, dstStride = m.Pix, m.Stride
+			return ErrBadImageType
 		}
 		f = FormatETC2RGBA1
 
 	case FormatETC2RGBA8,
 		FormatETC2SRGBA8:
-		if m, ok := dst.(*image.NRGBA); !ok {
+		if colorOnly = (options != nil) && options.ColorOnly; colorOnly {
+			if m, ok := dst.(*image.RGBA); !ok {
+				return ErrBadImageType
+			} else {
+				dstPix, dstStride, bytesPerPixel = m.Pix, m.Stride, 4
+			}
+		} else if m, ok := dst.(*image.NRGBA); !ok {
 			return ErrBadImageType
 		} else {
-			dstPix, dstStride = m.Pix, m.Stride
+			dstPix, dstStride, bytesPerPixel = m.Pix, m.Stride, 4
 		}
 		f = FormatETC2RGBA8
 
 	case FormatETC2R11Unsigned,
 		FormatETC2R11Signed:
-		if m, ok := dst.(*image.Gray16); !ok {
-			return ErrBadImageType
+		if m, ok := dst.(*image.Gray16); ok {
+			dstPix, dstStride, bytesPerPixel = m.Pix, m.Stride, 2
+		} else if m, ok := dst.(*image.Gray); ok {
+			dstPix, dstStride, bytesPerPixel = m.Pix, m.Stride, 1
 		} else {
-			dstPix, dstStride = m.Pix, m.Stride
+			return ErrBadImageType
 		}
 
 	case FormatETC2RG11Unsigned,
 		FormatETC2RG11Signed:
-		if m, ok := dst.(*image.RGBA64); !ok {
-			return ErrBadImageType
+		if m, ok := dst.(*image.RGBA64); ok {
+			dstPix, dstStride, bytesPerPixel = m.Pix, m.Stride, 8
+		} else if m, ok := dst.(*ImageRG16); ok {
+			dstPix, dstStride, bytesPerPixel = m.Pix, m.Stride, 4
 		} else {
-			dstPix, dstStride = m.Pix, m.Stride
+			return ErrBadImageType
 		}
 
 	default:
 		return ErrBadArgument
 	}
 
+	b := dst.Bounds()
 	numBytesRemaining := int64(widthInBlocks*heightInBlocks) * int64(f.BytesPerBlock())
-	const decoderBufferSize = 4096
-	buf, bufI := &[decoderBufferSize]byte{}, decoderBufferSize
+	// Small images (such as icons) get a smaller buffer. Both
+	// numBytesRemaining and 4096 are multiples of f.BytesPerBlock().
+	bufSize := int(min(numBytesRemaining, 4096))
+	buf, bufI := []byte(nil), bufSize
 	work := [64]byte{}
+	tile := [128]byte{}
+	truncatedErr := error(nil)
+
+	if src != nil {
+		buf = make([]byte, bufSize)
+	} else {
+		n := int(min(numBytesRemaining, int64(len(data))))
+		if int64(n) < numBytesRemaining {
+			if !partial {
+				return newTruncatedError(f, n/f.BytesPerBlock())
+			}
+			n -= n % f.BytesPerBlock()
+			truncatedErr = io.ErrUnexpectedEOF
+		}
+		buf, bufI, bufSize = data[:n], 0, n
+		numBytesRemaining = 0
+	}
 
 	for by := 0; by < heightInBlocks; by++ {
-		rowPix := dstPix[4*by*dstStride:]
+		y0 := p.Y + (4 * by)
 
 		for bx := 0; bx < widthInBlocks; bx++ {
-			if bufI >= decoderBufferSize {
-				n := int(min(numBytesRemaining, decoderBufferSize))
-				if _, err := io.ReadFull(src, buf[decoderBufferSize-n:]); err != nil {
-					return err
+			x0 := p.X + (4 * bx)
+
+			if bufI >= bufSize {
+				if truncatedErr != nil {
+					return truncated(truncatedErr, f, (by*widthInBlocks)+bx)
+				}
+				n := int(min(numBytesRemaining, int64(bufSize)))
+				if numRead, err := readFull(src, buf[bufSize-n:]); err != nil {
+					if !partial {
+						return truncated(err, f, (by*widthInBlocks)+bx+(numRead/f.BytesPerBlock()))
+					}
+					// Move the complete blocks that were read to the end of
+					// buf, decode them and then return err.
+					m := numRead - (numRead % f.BytesPerBlock())
+					if m == 0 {
+						return truncated(err, f, (by*widthInBlocks)+bx)
+					}
+					copy(buf[bufSize-m:], buf[bufSize-n:][:m])
+					n, truncatedErr = m, err
 				}
-				bufI = decoderBufferSize - n
+				bufI = bufSize - n
 				numBytesRemaining -= int64(n)
 			}
 
+			pixels := work[:]
 			switch f {
 			case FormatETC1, FormatETC2RGBA1:
 				colorCode := readU64BE(buf[bufI+0:])
 				bufI += 8
 				decodeColor(&work, colorCode, f != FormatETC1)
-				copy(rowPix[0*dstStride:], work[0x00:0x10])
-				copy(rowPix[1*dstStride:], work[0x10:0x20])
-				copy(rowPix[2*dstStride:], work[0x20:0x30])
-				copy(rowPix[3*dstStride:], work[0x30:0x40])
-				rowPix = rowPix[16:]
 
 			case FormatETC2RGBA8:
 				alphaCode := readU64BE(buf[bufI+0:])
 				colorCode := readU64BE(buf[bufI+8:])
 				bufI += 16
 				decodeColor(&work, colorCode, false)
-				decodeAlpha(&work, alphaCode)
-				copy(rowPix[0*dstStride:], work[0x00:0x10])
-				copy(rowPix[1*dstStride:], work[0x10:0x20])
-				copy(rowPix[2*dstStride:], work[0x20:0x30])
-				copy(rowPix[3*dstStride:], work[0x30:0x40])
-				rowPix = rowPix[16:]
+				if !colorOnly {
+					decodeAlpha(&work, alphaCode)
+				}
 
 			case FormatETC2R11Unsigned:
 				rCode := readU64BE(buf[bufI+0:])
 				bufI += 8
 				decode11u(&work, 0x00, rCode)
-				copy(rowPix[0*dstStride:], work[0x00:0x08])
-				copy(rowPix[1*dstStride:], work[0x08:0x10])
-				copy(rowPix[2*dstStride:], work[0x10:0x18])
-				copy(rowPix[3*dstStride:], work[0x18:0x20])
-				rowPix = rowPix[8:]
 
 			case FormatETC2R11Signed:
 				rCode := readU64BE(buf[bufI+0:])
 				bufI += 8
 				decode11s(&work, 0x00, rCode)
-				copy(rowPix[0*dstStride:], work[0x00:0x08])
-				copy(rowPix[1*dstStride:], work[0x08:0x10])
-				copy(rowPix[2*dstStride:], work[0x10:0x18])
-				copy(rowPix[3*dstStride:], work[0x18:0x20])
-				rowPix = rowPix[8:]
 
 			case FormatETC2RG11Unsigned:
 				rCode := readU64BE(buf[bufI+0:])
@@ -144,8 +495,8 @@ func (f Format) Decode(dst image.Image, src io.Reader, widthInBlocks int, height
 				bufI += 16
 				decode11u(&work, 0x00, rCode)
 				decode11u(&work, 0x20, gCode)
-				weaveRG11(rowPix, dstStride, &work)
-				rowPix = rowPix[32:]
+				weaveRG11(tile[:], bytesPerPixel, &work)
+				pixels = tile[:]
 
 			case FormatETC2RG11Signed:
 				rCode := readU64BE(buf[bufI+0:])
@@ -153,15 +504,96 @@ func (f Format) Decode(dst image.Image, src io.Reader, widthInBlocks int, height
 				bufI += 16
 				decode11s(&work, 0x00, rCode)
 				decode11s(&work, 0x20, gCode)
-				weaveRG11(rowPix, dstStride, &work)
-				rowPix = rowPix[32:]
+				weaveRG11(tile[:], bytesPerPixel, &work)
+				pixels = tile[:]
+			}
+
+			if linearize {
+				linearizeBlock(&work, x0, y0, dither)
+			} else if bytesPerPixel == 1 {
+				narrowGray16(tile[:16], &work)
+				pixels = tile[:]
 			}
+			storeBlock(dstPix, dstStride, b, x0, y0, bytesPerPixel, pixels)
 		}
 	}
 
 	return nil
 }
 
+// srgbToLinear maps an 8-bit sRGB value to its linear light value, on the
+// 8-bit scale, in fixed point with 5 fractional bits.
+var (
+	srgbToLinear     [256]uint16
+	srgbToLinearOnce sync.Once
+)
+
+func initSRGBToLinear() {
+	for i := range srgbToLinear {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		srgbToLinear[i] = uint16(math.Round(v * 255 * 32))
+	}
+}
+
+// bayer4x4 is the 4×4 ordered dither matrix. Each element e gives a rounding
+// threshold of (2*e + 1) / 32.
+var bayer4x4 = [4][4]uint8{
+	{0x0, 0x8, 0x2, 0xA},
+	{0xC, 0x4, 0xE, 0x6},
+	{0x3, 0xB, 0x1, 0x9},
+	{0xF, 0x7, 0xD, 0x5},
+}
+
+// linearizeBlock converts a decoded block's RGB values from sRGB to linear.
+// The block's top-left corner is at (x0, y0), which positions the dither.
+func linearizeBlock(work *[64]byte, x0 int, y0 int, dither bool) {
+	for y := range 4 {
+		for x := range 4 {
+			bias := uint16(16)
+			if dither {
+				bias = 1 + (2 * uint16(bayer4x4[(y0+y)&3][(x0+x)&3]))
+			}
+			i := (16 * y) + (4 * x)
+			for c := range 3 {
+				work[i+c] = uint8((srgbToLinear[work[i+c]] + bias) >> 5)
+			}
+		}
+	}
+}
+
+// narrowGray16 converts a decoded R11 block's 16 big-endian 16-bit values to
+// 8-bit values, rounding to nearest.
+func narrowGray16(dst []byte, work *[64]byte) {
+	for i := range dst {
+		v := (uint32(work[(2*i)+0]) << 8) | uint32(work[(2*i)+1])
+		dst[i] = uint8(((v * 255) + 32767) / 65535)
+	}
+}
+
+// storeBlock copies a 4×4 block of pixels, whose top-left corner is at (x0,
+// y0), to dstPix. The copy is clipped to the b bounds.
+func storeBlock(dstPix []byte, dstStride int, b image.Rectangle, x0 int, y0 int, bytesPerPixel int, pixels []byte) {
+	xLo, xHi := max(x0, b.Min.X), min(x0+4, b.Max.X)
+	yLo, yHi := max(y0, b.Min.Y), min(y0+4, b.Max.Y)
+	if (xLo >= xHi) || (yLo >= yHi) {
+		return
+	}
+
+	n := (xHi - xLo) * bytesPerPixel
+	s := ((yLo - y0) * 4 * bytesPerPixel) + ((xLo - x0) * bytesPerPixel)
+	d := ((yLo - b.Min.Y) * dstStride) + ((xLo - b.Min.X) * bytesPerPixel)
+	for y := yLo; y < yHi; y++ {
+		copy(dstPix[d:d+n], pixels[s:s+n])
+		s += 4 * bytesPerPixel
+		d += dstStride
+	}
+}
+
 func readU64BE(buf []byte) uint64 {
 	buf = buf[:8]
 	return (uint64(buf[0]) << 56) |
@@ -498,12 +930,45 @@ func decodeAlpha(work *[64]byte, code uint64) {
 }
 
 func decode11u(work *[64]byte, workOffset int, code uint64) {
+	values := [16]int32{}
+	unpack11u(&values, code)
+	for i, v := range values {
+		value11 := uint32(v)
+		value16 := (value11 << 5) | (value11 >> 6)
+
+		work[workOffset+(2*i)+0] = uint8(value16 >> 8)
+		work[workOffset+(2*i)+1] = uint8(value16 >> 0)
+	}
+}
+
+func decode11s(work *[64]byte, workOffset int, code uint64) {
+	values := [16]int32{}
+	unpack11s(&values, code)
+	for i, value11 := range values {
+		value16 := int32(0)
+		if value11 >= 0 {
+			value16 = (value11 << 5) | (value11 >> 5)
+		} else {
+			value11 = -value11
+			value16 = (value11 << 5) | (value11 >> 5)
+			value16 = -value16
+		}
+		value16 += 0x8000
+
+		work[workOffset+(2*i)+0] = uint8(value16 >> 8)
+		work[workOffset+(2*i)+1] = uint8(value16 >> 0)
+	}
+}
+
+// unpack11u sets values to the 11-bit values, in the range [0, 2047], of an
+// unsigned R11 block's 16 pixels, in row-major order.
+func unpack11u(values *[16]int32, code uint64) {
 	base := (8 * int32(code>>56)) + 4
 
 	multiplier := max(1, 8*int32((code>>52)&0x0F))
 	which := int((code >> 48) & 0x0F)
 
-	for i := range 16 {
+	for i := range values {
 		x := uint32(i & 3)
 		y := uint32(i >> 2)
 
@@ -511,21 +976,19 @@ func decode11u(work *[64]byte, workOffset int, code uint64) {
 		index := (code >> shift) & 7
 		delta := multiplier * int32(alphaModifiers[which][index])
 
-		value11 := uint32(max(0, min(2047, base+delta)))
-		value16 := (value11 << 5) | (value11 >> 6)
-
-		work[workOffset+(2*i)+0] = uint8(value16 >> 8)
-		work[workOffset+(2*i)+1] = uint8(value16 >> 0)
+		values[i] = max(0, min(2047, base+delta))
 	}
 }
 
-func decode11s(work *[64]byte, workOffset int, code uint64) {
+// unpack11s sets values to the 11-bit values, in the range [-1023, +1023], of
+// a signed R11 block's 16 pixels, in row-major order.
+func unpack11s(values *[16]int32, code uint64) {
 	base := 8 * max(int32(int8(code>>56)), -127)
 
 	multiplier := max(1, 8*int32((code>>52)&0x0F))
 	which := int((code >> 48) & 0x0F)
 
-	for i := range 16 {
+	for i := range values {
 		x := uint32(i & 3)
 		y := uint32(i >> 2)
 
@@ -533,37 +996,29 @@ func decode11s(work *[64]byte, workOffset int, code uint64) {
 		index := (code >> shift) & 7
 		delta := multiplier * int32(alphaModifiers[which][index])
 
-		value11 := int32(max(-1023, min(1023, base+delta)))
-		value16 := int32(0)
-		if value11 >= 0 {
-			value16 = (value11 << 5) | (value11 >> 5)
-		} else {
-			value11 = -value11
-			value16 = (value11 << 5) | (value11 >> 5)
-			value16 = -value16
-		}
-		value16 += 0x8000
-
-		work[workOffset+(2*i)+0] = uint8(value16 >> 8)
-		work[workOffset+(2*i)+1] = uint8(value16 >> 0)
+		values[i] = max(-1023, min(1023, base+delta))
 	}
 }
 
-func weaveRG11(dst []byte, dstStride int, work *[64]byte) {
+// weaveRG11 interleaves the red and green halves of work into a 4×4 tile of
+// either RGBA64 (8 bytes per pixel) or ImageRG16 (4 bytes per pixel) pixels.
+func weaveRG11(dst []byte, bytesPerPixel int, work *[64]byte) {
 	for i := range 16 {
 		x := i & 3
 		y := i >> 2
 
-		d := (dstStride * y) + (8 * x)
-		rgba := dst[d : d+8]
+		d := (4 * bytesPerPixel * y) + (bytesPerPixel * x)
+		rgba := dst[d : d+bytesPerPixel]
 		rgba[0] = work[(2*i)+0x00]
 		rgba[1] = work[(2*i)+0x01]
 		rgba[2] = work[(2*i)+0x20]
 		rgba[3] = work[(2*i)+0x21]
-		rgba[4] = 0x00
-		rgba[5] = 0x00
-		rgba[6] = 0xFF
-		rgba[7] = 0xFF
+		if bytesPerPixel == 8 {
+			rgba[4] = 0x00
+			rgba[5] = 0x00
+			rgba[6] = 0xFF
+			rgba[7] = 0xFF
+		}
 	}
 }
 
@@ -0,0 +1,385 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/pkm"
+)
+
+// readPKM reads the named file from the res/1-encoded-pkm directory,
+// returning its format, its size and its payload (the encoded blocks).
+func readPKM(tt *testing.T, name string) (etc2.Format, image.Config, []byte) {
+	srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + name + ".pkm")
+	if err != nil {
+		tt.Fatalf("%s: os.ReadFile: %v", name, err)
+	}
+	f, config, err := pkm.DecodeHeader(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("%s: DecodeHeader: %v", name, err)
+	}
+	return f, config, srcBytes[pkm.HeaderSize:]
+}
+
+func TestDecodeRawPartial(tt *testing.T) {
+	const tc = "mona-lisa.21x32.etc2-rgb"
+	f, config, payload := readPKM(tt, tc)
+	want, err := etc2.DecodeRaw(payload, f, config.Width, config.Height)
+	if err != nil {
+		tt.Fatalf("DecodeRaw: %v", err)
+	}
+
+	// Keep 13 whole blocks (2 rows of 6 and 1 more) and half of the next.
+	const numBlocks = 13
+	truncated := payload[:(numBlocks*f.BytesPerBlock())+(f.BytesPerBlock()/2)]
+
+	if m, err := etc2.DecodeRaw(truncated, f, config.Width, config.Height); m != nil {
+		tt.Fatalf("non-partial: got a non-nil image")
+	} else if !errors.As(err, new(*etc2.TruncatedError)) {
+		tt.Fatalf("non-partial: got %v, want an *etc2.TruncatedError", err)
+	}
+
+	got, err := etc2.DecodeRawWithOptions(truncated, f, config.Width, config.Height, &etc2.DecodeOptions{Partial: true})
+	truncatedErr := (*etc2.TruncatedError)(nil)
+	if !errors.As(err, &truncatedErr) {
+		tt.Fatalf("partial: got %v, want an *etc2.TruncatedError", err)
+	} else if truncatedErr.BlockIndex != numBlocks {
+		tt.Fatalf("partial: BlockIndex: got %d, want %d", truncatedErr.BlockIndex, numBlocks)
+	} else if got == nil {
+		tt.Fatalf("partial: got a nil image")
+	} else if got.Bounds() != want.Bounds() {
+		tt.Fatalf("partial: got bounds %v, want %v", got.Bounds(), want.Bounds())
+	}
+
+	widthInBlocks := etc2.BlocksWide(config.Width)
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			w := want.At(x, y)
+			if ((y/4)*widthInBlocks)+(x/4) >= numBlocks {
+				w = color.RGBA{}
+			}
+			if g := got.At(x, y); g != w {
+				tt.Fatalf("partial: At(%d, %d): got %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestDecodeOneBitAlphaNRGBA(tt *testing.T) {
+	for _, tc := range []string{"49.etc2-rgba1", "49.etc2-srgba1", "dice.80x60.etc2-rgba1"} {
+		f, config, payload := readPKM(tt, tc)
+		full, err := etc2.DecodeRaw(payload, f, config.Width, config.Height)
+		if err != nil {
+			tt.Fatalf("tc=%q: DecodeRaw: %v", tc, err)
+		}
+		want, ok := full.(*image.RGBA)
+		if !ok {
+			tt.Fatalf("tc=%q: DecodeRaw: got %T, want *image.RGBA", tc, full)
+		}
+
+		m, err := etc2.DecodeRawWithOptions(payload, f, config.Width, config.Height, &etc2.DecodeOptions{OneBitAlphaNRGBA: true})
+		if err != nil {
+			tt.Fatalf("tc=%q: DecodeRawWithOptions: %v", tc, err)
+		}
+		got, ok := m.(*image.NRGBA)
+		if !ok {
+			tt.Fatalf("tc=%q: DecodeRawWithOptions: got %T, want *image.NRGBA", tc, m)
+		} else if got.Bounds() != want.Bounds() {
+			tt.Fatalf("tc=%q: bounds: got %v, want %v", tc, got.Bounds(), want.Bounds())
+		}
+
+		numTransparent := 0
+		b := want.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				g, w := got.NRGBAAt(x, y), want.RGBAAt(x, y)
+				if (g.R != w.R) || (g.G != w.G) || (g.B != w.B) || (g.A != w.A) {
+					tt.Fatalf("tc=%q: (%d, %d): got %v, want %v", tc, x, y, g, w)
+				} else if g.A == 0 {
+					numTransparent++
+				}
+			}
+		}
+		if numTransparent == 0 {
+			tt.Fatalf("tc=%q: got no transparent pixels", tc)
+		}
+	}
+}
+
+func TestDecodeGray8(tt *testing.T) {
+	for _, tc := range []string{"36.etc2-r11s", "36.etc2-r11u", "lincoln.24x32.etc2-r11u"} {
+		f, config, payload := readPKM(tt, tc)
+		full, err := etc2.DecodeRaw(payload, f, config.Width, config.Height)
+		if err != nil {
+			tt.Fatalf("tc=%q: DecodeRaw: %v", tc, err)
+		}
+		want, ok := full.(*image.Gray16)
+		if !ok {
+			tt.Fatalf("tc=%q: DecodeRaw: got %T, want *image.Gray16", tc, full)
+		}
+
+		m, err := etc2.DecodeRawWithOptions(payload, f, config.Width, config.Height, &etc2.DecodeOptions{Gray8: true})
+		if err != nil {
+			tt.Fatalf("tc=%q: DecodeRawWithOptions: %v", tc, err)
+		}
+		got, ok := m.(*image.Gray)
+		if !ok {
+			tt.Fatalf("tc=%q: DecodeRawWithOptions: got %T, want *image.Gray", tc, m)
+		} else if got.Bounds() != want.Bounds() {
+			tt.Fatalf("tc=%q: bounds: got %v, want %v", tc, got.Bounds(), want.Bounds())
+		}
+
+		b := want.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				v := want.Gray16At(x, y).Y
+				if g, w := got.GrayAt(x, y).Y, uint8(math.Round(float64(v)*255/65535)); g != w {
+					tt.Fatalf("tc=%q: (%d, %d): got %d, want %d (from 0x%04X)", tc, x, y, g, w, v)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeRG16(tt *testing.T) {
+	for _, tc := range []string{"36.etc2-rg11s", "36.etc2-rg11u"} {
+		f, config, payload := readPKM(tt, tc)
+		full, err := etc2.DecodeRaw(payload, f, config.Width, config.Height)
+		if err != nil {
+			tt.Fatalf("tc=%q: DecodeRaw: %v", tc, err)
+		}
+		want, ok := full.(*image.RGBA64)
+		if !ok {
+			tt.Fatalf("tc=%q: DecodeRaw: got %T, want *image.RGBA64", tc, full)
+		}
+
+		m, err := etc2.DecodeRawWithOptions(payload, f, config.Width, config.Height, &etc2.DecodeOptions{RG16: true})
+		if err != nil {
+			tt.Fatalf("tc=%q: DecodeRawWithOptions: %v", tc, err)
+		}
+		got, ok := m.(*etc2.ImageRG16)
+		if !ok {
+			tt.Fatalf("tc=%q: DecodeRawWithOptions: got %T, want *etc2.ImageRG16", tc, m)
+		} else if got.Bounds() != want.Bounds() {
+			tt.Fatalf("tc=%q: bounds: got %v, want %v", tc, got.Bounds(), want.Bounds())
+		} else if n := len(got.Pix); n != (len(want.Pix) / 2) {
+			tt.Fatalf("tc=%q: len(Pix): got %d, want %d", tc, n, len(want.Pix)/2)
+		}
+
+		b := want.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if g, w := got.At(x, y), want.At(x, y); g != w {
+					tt.Fatalf("tc=%q: (%d, %d): got %v, want %v", tc, x, y, g, w)
+				}
+			}
+		}
+
+		// A SubImage shares pixels with the original image.
+		sub := got.SubImage(image.Rect(1, 2, 5, 6)).(*etc2.ImageRG16)
+		sub.SetRG16(3, 4, 0x1234, 0x5678)
+		if g, w := got.RGBA64At(3, 4), (color.RGBA64{0x1234, 0x5678, 0x0000, 0xFFFF}); g != w {
+			tt.Fatalf("tc=%q: SetRG16: got %v, want %v", tc, g, w)
+		}
+	}
+}
+
+func TestDecodeFloat32(tt *testing.T) {
+	for _, tc := range []string{"36.etc2-r11s", "36.etc2-r11u", "36.etc2-rg11s", "36.etc2-rg11u"} {
+		f, config, payload := readPKM(tt, tc)
+		m, err := etc2.DecodeRaw(payload, f, config.Width, config.Height)
+		if err != nil {
+			tt.Fatalf("tc=%q: DecodeRaw: %v", tc, err)
+		}
+
+		signed := (f == etc2.FormatETC2R11Signed) || (f == etc2.FormatETC2RG11Signed)
+		numChannels := 1
+		if (f == etc2.FormatETC2RG11Unsigned) || (f == etc2.FormatETC2RG11Signed) {
+			numChannels = 2
+		}
+		w, h := etc2.PaddedSize(config.Width, config.Height)
+		dst := make([]float32, w*h*numChannels)
+		if err := f.DecodeFloat32(dst[:len(dst)-1], bytes.NewReader(payload), w/4, h/4); err != etc2.ErrBadArgument {
+			tt.Fatalf("tc=%q: short dst: got %v, want %v", tc, err, etc2.ErrBadArgument)
+		} else if err := f.DecodeFloat32(dst, bytes.NewReader(payload), w/4, h/4); err != nil {
+			tt.Fatalf("tc=%q: DecodeFloat32: %v", tc, err)
+		}
+
+		for y := range config.Height {
+			for x := range config.Width {
+				r, g, _, _ := m.At(x, y).RGBA()
+				for c, v16 := range []uint32{r, g}[:numChannels] {
+					got := float64(dst[(((y*w)+x)*numChannels)+c])
+					want := float64(v16) / 0xFFFF
+					if signed {
+						want = (float64(v16) - 0x8000) / 0x7FFF
+					}
+					if (got < -1) || (got > 1) || (math.Abs(got-want) > (1.0 / 1023)) {
+						tt.Fatalf("tc=%q: (%d, %d, %d): got %g, want %g", tc, x, y, c, got, want)
+					}
+				}
+			}
+		}
+	}
+
+	if err := etc2.FormatETC2RGB.DecodeFloat32(make([]float32, 16), bytes.NewReader(make([]byte, 8)), 1, 1); err != etc2.ErrBadArgument {
+		tt.Fatalf("etc2-rgb: got %v, want %v", err, etc2.ErrBadArgument)
+	}
+}
+
+func TestDecode11Bit(tt *testing.T) {
+	for _, tc := range []string{"36.etc2-r11s", "36.etc2-r11u", "36.etc2-rg11s", "36.etc2-rg11u"} {
+		f, config, payload := readPKM(tt, tc)
+
+		signed := (f == etc2.FormatETC2R11Signed) || (f == etc2.FormatETC2RG11Signed)
+		numChannels := 1
+		if (f == etc2.FormatETC2RG11Unsigned) || (f == etc2.FormatETC2RG11Signed) {
+			numChannels = 2
+		}
+		w, h := etc2.PaddedSize(config.Width, config.Height)
+		raw := make([]int16, w*h*numChannels)
+		if err := f.Decode11Bit(raw, bytes.NewReader(payload), w/4, h/4); err != nil {
+			tt.Fatalf("tc=%q: Decode11Bit: %v", tc, err)
+		}
+		floats := make([]float32, len(raw))
+		if err := f.DecodeFloat32(floats, bytes.NewReader(payload), w/4, h/4); err != nil {
+			tt.Fatalf("tc=%q: DecodeFloat32: %v", tc, err)
+		}
+
+		// The float32 values are the 11-bit values, divided by 1023 (signed)
+		// or 2047 (unsigned).
+		for i, v := range raw {
+			lo, hi, scale := int16(0), int16(2047), float32(2047)
+			if signed {
+				lo, hi, scale = -1023, +1023, 1023
+			}
+			if (v < lo) || (v > hi) {
+				tt.Fatalf("tc=%q: i=%d: got %d, want in [%d, %d]", tc, i, v, lo, hi)
+			} else if got, want := floats[i], float32(v)*(1/scale); got != want {
+				tt.Fatalf("tc=%q: i=%d: got %g, want %g", tc, i, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeLinearOutput(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	srgbToLinear := func(v uint8) float64 {
+		x := float64(v) / 255
+		if x <= 0.04045 {
+			return 255 * x / 12.92
+		}
+		return 255 * math.Pow((x+0.055)/1.055, 2.4)
+	}
+
+	for _, f := range []etc2.Format{etc2.FormatETC2SRGB, etc2.FormatETC2SRGBA8, etc2.FormatETC2RGB} {
+		encoded := &bytes.Buffer{}
+		if err := etc2.Encode(encoded, srcImage, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode: %v", f, err)
+		}
+		decode := func(options *etc2.DecodeOptions) *image.NRGBA {
+			m, err := etc2.DecodeRawWithOptions(encoded.Bytes(), f, 80, 60, options)
+			if err != nil {
+				tt.Fatalf("f=%v: DecodeRawWithOptions: %v", f, err)
+			}
+			n := image.NewNRGBA(m.Bounds())
+			for y := range 60 {
+				for x := range 80 {
+					n.Set(x, y, m.At(x, y))
+				}
+			}
+			return n
+		}
+		isSRGB := f != etc2.FormatETC2RGB
+		plain := decode(nil)
+		linear := decode(&etc2.DecodeOptions{LinearOutput: true})
+		dithered := decode(&etc2.DecodeOptions{LinearOutput: true, Dither: true})
+
+		for i := 0; i < len(plain.Pix); i += 4 {
+			if plain.Pix[i+3] != 0xFF {
+				// Un-premultiplying translucent pixels, for the RGBA8
+				// formats, loses precision.
+				continue
+			}
+			for c := range 4 {
+				p, l, d := plain.Pix[i+c], linear.Pix[i+c], dithered.Pix[i+c]
+				if !isSRGB || (c == 3) {
+					// LinearOutput is ignored for non-sRGB formats and for
+					// alpha.
+					if (l != p) || (d != p) {
+						tt.Fatalf("f=%v, i=%d, c=%d: got %d and %d, want %d", f, i, c, l, d, p)
+					}
+					continue
+				}
+				want := srgbToLinear(p)
+				if math.Abs(float64(l)-want) > 0.5+(1.0/32) {
+					tt.Fatalf("f=%v, i=%d, c=%d: linear: got %d, want %.3f", f, i, c, l, want)
+				} else if math.Abs(float64(d)-want) >= 1 {
+					tt.Fatalf("f=%v, i=%d, c=%d: dithered: got %d, want %.3f", f, i, c, d, want)
+				}
+			}
+		}
+	}
+
+	// Over a flat area, the dithered values should average close to the
+	// exact linear value, which rounding to nearest cannot do. sRGB 0x24
+	// (36) is linear 4.499 (out of 255), almost halfway between two 8-bit
+	// values.
+	const width, height = 16, 16
+	flat := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.RGBA{0x24, 0x24, 0x24, 0xFF}), image.Point{}, draw.Src)
+	encoded := &bytes.Buffer{}
+	if err := etc2.Encode(encoded, flat, etc2.FormatETC2SRGB, nil); err != nil {
+		tt.Fatalf("flat: Encode: %v", err)
+	}
+	plain, err := etc2.DecodeRaw(encoded.Bytes(), etc2.FormatETC2SRGB, width, height)
+	if err != nil {
+		tt.Fatalf("flat: DecodeRaw: %v", err)
+	}
+	v := plain.(*image.RGBA).Pix[0]
+	if v != 0x24 {
+		tt.Fatalf("flat: got %#02x, want 0x24", v)
+	}
+	want := srgbToLinear(v)
+	for _, dither := range []bool{false, true} {
+		m, err := etc2.DecodeRawWithOptions(encoded.Bytes(), etc2.FormatETC2SRGB, width, height,
+			&etc2.DecodeOptions{LinearOutput: true, Dither: dither})
+		if err != nil {
+			tt.Fatalf("flat, dither=%t: DecodeRawWithOptions: %v", dither, err)
+		}
+		sum := 0
+		for y := range height {
+			for x := range width {
+				sum += int(m.(*image.RGBA).RGBAAt(x, y).G)
+			}
+		}
+		mean := float64(sum) / (width * height)
+		if gotClose := math.Abs(mean-want) < 0.1; gotClose != dither {
+			tt.Fatalf("flat, dither=%t: mean: got %.3f, exact %.3f", dither, mean, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"iter"
+	"math"
+)
+
+// BlockDiff describes one block that differs between two ETC payloads, as
+// found by Format.DiffBlocks.
+type BlockDiff struct {
+	// X and Y are the block's coordinates, measured in blocks.
+	X, Y int
+
+	// ModeA and ModeB are the modes of the block's color codes in the first
+	// and second payloads.
+	ModeA, ModeB BlockMode
+
+	// SumSquaredError is the sum of the squared differences between the two
+	// payloads' decoded red, green, blue and alpha values (as per
+	// DecodedBlock.Pixels), each scaled to the range [0, 255]. It is zero if
+	// the codes differ but decode to the same pixels.
+	SumSquaredError float64
+
+	// MaxDifference is the largest absolute difference between any one of
+	// the decoded channel values, in the range [0, 0xFFFF].
+	MaxDifference uint16
+}
+
+// PSNR returns the peak signal-to-noise ratio, in decibels, between the two
+// payloads' decoded block. It is +Inf if they decode to the same pixels.
+func (d *BlockDiff) PSNR() float64 {
+	if d.SumSquaredError == 0 {
+		return math.Inf(+1)
+	}
+	return 10 * math.Log10((255*255)/(d.SumSquaredError/64))
+}
+
+// DiffBlocks returns an iterator over the blocks whose encoded bytes differ
+// between two ETC-compressed images, a and b, of the same format and
+// dimensions, in row-major order. Identical blocks are skipped. This suits
+// debugging encoder changes or validating an encoder's port to another
+// language against this one.
+//
+// Like Blocks, it never holds either whole image in memory. If reading a or b
+// fails, the iterator yields a zero BlockDiff and the error, and then stops.
+// As for Decode, either ending early is a *TruncatedError.
+func (f Format) DiffBlocks(a io.Reader, b io.Reader, widthInBlocks int, heightInBlocks int) iter.Seq2[BlockDiff, error] {
+	return func(yield func(BlockDiff, error) bool) {
+		if (a == nil) || (b == nil) || (f.ETCVersion() == 0) ||
+			(widthInBlocks < 0) || (widthInBlocks > 16384) ||
+			(heightInBlocks < 0) || (heightInBlocks > 16384) {
+			yield(BlockDiff{}, ErrBadArgument)
+			return
+		}
+		// Strip the sRGB bit, which does not affect decoding.
+		f &^= formatBitSRGBColorSpace
+
+		brA, brB := bufio.NewReader(a), bufio.NewReader(b)
+		n := f.BytesPerBlock()
+		blockA, blockB, work := DecodedBlock{}, DecodedBlock{}, [64]byte{}
+		for blockY := range heightInBlocks {
+			for blockX := range widthInBlocks {
+				if _, err := readFull(brA, blockA.Code[:n]); err != nil {
+					yield(BlockDiff{}, truncated(err, f, (blockY*widthInBlocks)+blockX))
+					return
+				} else if _, err := readFull(brB, blockB.Code[:n]); err != nil {
+					yield(BlockDiff{}, truncated(err, f, (blockY*widthInBlocks)+blockX))
+					return
+				} else if bytes.Equal(blockA.Code[:n], blockB.Code[:n]) {
+					continue
+				}
+				f.decodeBlock(&blockA, &work)
+				f.decodeBlock(&blockB, &work)
+				d := BlockDiff{X: blockX, Y: blockY, ModeA: blockA.Mode, ModeB: blockB.Mode}
+				for i := range blockA.Pixels {
+					pa, pb := &blockA.Pixels[i], &blockB.Pixels[i]
+					for _, c := range [4][2]uint16{{pa.R, pb.R}, {pa.G, pb.G}, {pa.B, pb.B}, {pa.A, pb.A}} {
+						delta := max(c[0], c[1]) - min(c[0], c[1])
+						d.MaxDifference = max(d.MaxDifference, delta)
+						scaled := float64(delta) / 0x101
+						d.SumSquaredError += scaled * scaled
+					}
+				}
+				if !yield(d, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestDiffBlocks(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	src, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	const widthInBlocks, heightInBlocks = 20, 15
+
+	// Paint over part of the image. Blocks are encoded independently, so
+	// only the blocks that overlap the painted rectangle should differ.
+	modified := image.NewNRGBA(src.Bounds())
+	draw.Draw(modified, modified.Bounds(), src, image.Point{}, draw.Src)
+	draw.Draw(modified, image.Rect(22, 17, 31, 26), image.NewUniform(color.NRGBA{0x20, 0xC0, 0x60, 0x80}), image.Point{}, draw.Src)
+
+	for _, f := range []etc2.Format{etc2.FormatETC2RGBA8, etc2.FormatETC2R11Unsigned} {
+		a, err := etc2.AppendEncoded(nil, src, f, nil)
+		if err != nil {
+			tt.Fatalf("f=%v: AppendEncoded: %v", f, err)
+		}
+		b, err := etc2.AppendEncoded(nil, modified, f, nil)
+		if err != nil {
+			tt.Fatalf("f=%v: AppendEncoded: %v", f, err)
+		}
+		blocksA, blocksB := []etc2.DecodedBlock{}, []etc2.DecodedBlock{}
+		for block, err := range f.Blocks(bytes.NewReader(a), widthInBlocks, heightInBlocks) {
+			if err != nil {
+				tt.Fatalf("f=%v: Blocks: %v", f, err)
+			}
+			blocksA = append(blocksA, block)
+		}
+		for block, err := range f.Blocks(bytes.NewReader(b), widthInBlocks, heightInBlocks) {
+			if err != nil {
+				tt.Fatalf("f=%v: Blocks: %v", f, err)
+			}
+			blocksB = append(blocksB, block)
+		}
+
+		wantIndexes, gotIndexes := []int{}, []int{}
+		for i := range blocksA {
+			if blocksA[i].Code != blocksB[i].Code {
+				wantIndexes = append(wantIndexes, i)
+			}
+		}
+		for d, err := range f.DiffBlocks(bytes.NewReader(a), bytes.NewReader(b), widthInBlocks, heightInBlocks) {
+			if err != nil {
+				tt.Fatalf("f=%v: DiffBlocks: %v", f, err)
+			}
+			i := (d.Y * widthInBlocks) + d.X
+			gotIndexes = append(gotIndexes, i)
+			wantSSE, wantMax := 0.0, uint16(0)
+			for j, pa := range blocksA[i].Pixels {
+				pb := blocksB[i].Pixels[j]
+				for _, c := range [4][2]uint16{{pa.R, pb.R}, {pa.G, pb.G}, {pa.B, pb.B}, {pa.A, pb.A}} {
+					delta := math.Abs(float64(c[0]) - float64(c[1]))
+					wantSSE += (delta / 0x101) * (delta / 0x101)
+					wantMax = max(wantMax, uint16(delta))
+				}
+			}
+			if (d.ModeA != blocksA[i].Mode) || (d.ModeB != blocksB[i].Mode) {
+				tt.Fatalf("f=%v: block (%d, %d): got modes %v, %v", f, d.X, d.Y, d.ModeA, d.ModeB)
+			} else if (math.Abs(d.SumSquaredError-wantSSE) > 1e-6) || (d.MaxDifference != wantMax) {
+				tt.Fatalf("f=%v: block (%d, %d): got %v, %d, want %v, %d",
+					f, d.X, d.Y, d.SumSquaredError, d.MaxDifference, wantSSE, wantMax)
+			}
+		}
+		for _, i := range wantIndexes {
+			if x, y := i%widthInBlocks, i/widthInBlocks; (x < 5) || (x > 7) || (y < 4) || (y > 6) {
+				tt.Fatalf("f=%v: block (%d, %d) differs", f, x, y)
+			}
+		}
+		if len(wantIndexes) == 0 {
+			tt.Fatalf("f=%v: the two encodings are identical", f)
+		} else if !slices.Equal(gotIndexes, wantIndexes) {
+			tt.Fatalf("f=%v: got differing blocks %v, want %v", f, gotIndexes, wantIndexes)
+		}
+
+		// Identical payloads have no differing blocks, and a truncated
+		// payload is a *TruncatedError.
+		for d, err := range f.DiffBlocks(bytes.NewReader(a), bytes.NewReader(a), widthInBlocks, heightInBlocks) {
+			tt.Fatalf("f=%v: identical: got %+v, %v", f, d, err)
+		}
+		truncatedErr := (*etc2.TruncatedError)(nil)
+		for _, err := range f.DiffBlocks(bytes.NewReader(a), bytes.NewReader(a[:100]), widthInBlocks, heightInBlocks) {
+			if err == nil {
+				continue
+			} else if !errors.As(err, &truncatedErr) || (truncatedErr.Offset != 96) {
+				tt.Fatalf("f=%v: truncated: got %v", f, err)
+			}
+		}
+		if truncatedErr == nil {
+			tt.Fatalf("f=%v: truncated: got no error", f)
+		}
+	}
+}
@@ -10,67 +10,605 @@ package etc2
 
 import (
 	"image"
+	"image/color"
+	"image/draw"
 	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
 )
 
+// GrayWeights are the red, green and blue weights, as fractions of Sum, for
+// converting color to gray.
+type GrayWeights struct {
+	R, G, B, Sum uint64
+}
+
+// GrayWeightsBT601 returns the ITU-R BT.601 (also used by JFIF) weights: 0.299,
+// 0.587 and 0.114. These are the same weights, and give the same results, as
+// the Go standard library's color.Gray16Model.
+func GrayWeightsBT601() GrayWeights {
+	return GrayWeights{R: 19595, G: 38470, B: 7471, Sum: 65536}
+}
+
+// GrayWeightsBT709 returns the ITU-R BT.709 weights: 0.212656, 0.715158 and
+// 0.072186. These match the ImageMagick "convert" program (and ImageMagick's
+// MagickCore/colorspace.c), which the ETCPACK program shells out to, as used
+// by https://github.com/nigeltao/ETCPACK.git
+func GrayWeightsBT709() GrayWeights {
+	return GrayWeights{R: 212656, G: 715158, B: 72186, Sum: 1000000}
+}
+
 // EncodeOptions are optional arguments to Encode. The zero value is valid and
 // means to use the default configuration.
-//
-// There are no fields for now, but there may be some in the future.
 type EncodeOptions struct {
+	// NormalMapping, if non-zero, treats the source image as a normal map
+	// and re-parameterizes each pixel's RGB (XYZ) normal as two channels
+	// before encoding. It is only valid for the RG11 formats.
+	//
+	// The same NormalMapping should be used, after decoding, to reconstruct
+	// the normals. Its String method gives a name suitable for recording in
+	// container metadata.
+	NormalMapping NormalMapping
+
+	// ValueRange, if non-nil, is the range of source values that is stretched
+	// to the full range before encoding, for the R11 formats. Source values
+	// outside of it are clamped. It is ignored for the other formats.
+	//
+	// MeasureValueRange gives a source image's range. The same ValueRange
+	// should be used, after decoding, to reconstruct the values: see
+	// ValueRange.DenormalizeGray16. Its String method gives a form suitable
+	// for recording in container metadata.
+	ValueRange *ValueRange
+
+	// SourceIsPremultiplied is whether to use the source pixels' red, green
+	// and blue values as is, instead of un-premultiplying them by alpha (when
+	// src is not an *image.NRGBA or *image.NRGBA64, which are already
+	// non-premultiplied). This suits pipelines whose RGBA data is meant to be
+	// premultiplied when sampled, where un-premultiplying (and its rounding)
+	// would introduce fringes around semi-transparent edges.
+	SourceIsPremultiplied bool
+
+	// Transform, if non-nil, is applied to every source pixel (after
+	// un-premultiplying alpha) as the pixels are read, before any other
+	// conversion such as to gray or via NormalMapping. This lets pipelines
+	// apply color grading (e.g. a 3D lookup table) without an extra pass over
+	// the image or an intermediate image allocation.
+	//
+	// It may be called more than once per pixel, as blocks on the right and
+	// bottom edges are padded by repeating pixels. If NumWorkers is more than
+	// one, it may be called concurrently.
+	Transform func(c color.NRGBA64) color.NRGBA64
+
+	// Background, if non-nil, is the color that source pixels are composited
+	// over when encoding to an opaque format (one whose AlphaModel is
+	// AlphaModelOpaque), instead of ignoring their alpha. Background's own
+	// alpha is ignored: it is treated as opaque.
+	//
+	// Compositing happens after any Transform. With SourceIsPremultiplied,
+	// the source colors are already multiplied by alpha, so each composited
+	// color is c + Background×(1-alpha) instead of c×alpha +
+	// Background×(1-alpha).
+	Background color.Color
+
+	// OnAlphaDiscarded, if non-nil, is called when encoding to an opaque
+	// format with a nil Background and a source pixel is not fully opaque,
+	// meaning that its alpha is being ignored. It is called at most once per
+	// encode, or once per goroutine if NumWorkers is more than one.
+	OnAlphaDiscarded func()
+
+	// AlphaCoverage, if non-nil, scales the source pixels' alpha values (after
+	// any Transform) so that the fraction of encoded pixels that pass an alpha
+	// test matches a target. This keeps alpha-tested foliage, fences and the
+	// like from thinning out after compression and mipmapping. It applies to
+	// the formats with alpha (FormatETC2RGBA1 and FormatETC2RGBA8) and is
+	// ignored for the others.
+	//
+	// Finding the scale takes an extra pass over the source pixels and, for
+	// FormatETC2RGBA8, a number of alpha-only encodes. The whole of the
+	// encoded rectangle is measured, even if SubRect is non-empty.
+	AlphaCoverage *AlphaCoverage
+
+	// SubRect, if non-empty, restricts the output to only those blocks that
+	// cover SubRect. This supports partial texture updates, such as via the
+	// glCompressedTexSubImage2D function.
+	//
+	// SubRect must be contained by the encoded rectangle (src.Bounds() for
+	// Encode) and be block-aligned: its Min must be a multiple of 4 pixels
+	// from the encoded rectangle's Min and likewise for its Max, unless that
+	// coincides with the encoded rectangle's Max.
+	//
+	// Blocks on the encoded rectangle's right and bottom edges are padded the
+	// same way regardless of SubRect, so that the emitted blocks match the
+	// corresponding blocks of a full encoding.
+	SubRect image.Rectangle
+
+	// CompatETCPACK is whether to match the ETCPACK program's output exactly,
+	// where it differs from the conventions of Go's standard library.
+	//
+	// Currently, there are two differences. The first is how the
+	// single-channel (R11) formats convert color source pixels to gray.
+	// ETCPACK uses GrayWeightsBT709. Otherwise, GrayWeightsBT601 is used, like
+	// the image/color package's color.GrayModel and color.Gray16Model.
+	//
+	// The second is that ETCPACK runs its full mode search on every block.
+	// Otherwise, blocks whose pixels all have the same color (or, for
+	// FormatETC2RGBA1, are all transparent) take a shortcut, whose codes can
+	// differ from (but are never worse than) ETCPACK's.
+	CompatETCPACK bool
+
+	// Compatibility, if not CompatibilityDefault, overrides the CompatETCPACK
+	// and Quality fields, picking either bit-exact ETCPACK output or an
+	// encoder that is free to make better decisions.
+	Compatibility Compatibility
+
+	// Quality is the encoder's effort level. Higher levels search more
+	// candidate codes, which is slower but usually lowers the total error.
+	// The zero value, QualityDefault, matches ETCPACK's (fast mode) search.
+	// Other levels do not match ETCPACK's output, even with CompatETCPACK.
+	// See also Compatibility.
+	Quality Quality
+
+	// Metric is how the encoder measures each candidate code's error, when
+	// choosing between the modes' best codes for a block. Each mode's own
+	// search still uses MetricRGB, as it is much faster. MetricOklab picks
+	// visually better modes for photographic content, but the output's PSNR
+	// (an RGB measure) can be lower. CompatibilityETCPACK implies MetricRGB.
+	//
+	// It does not apply to alpha or to the R11 and RG11 formats.
+	Metric Metric
+
+	// Refinement, if non-nil and its Fraction is positive, adds a second pass
+	// that re-encodes the worst-looking blocks with more effort. The whole
+	// output is then held in memory until the second pass completes, before
+	// anything is written to dst, although the Preview, OnBlock,
+	// FlushEveryBlockRow and OnCheckpoint options still apply as it is
+	// written. With Resume, both passes still cover every block, since which
+	// blocks are the worst depends on all of them, but only the remaining
+	// rows are written.
+	//
+	// It does not apply to the R11 and RG11 formats, whose encoder is already
+	// exhaustive, or with CompatibilityETCPACK.
+	Refinement *Refinement
+
+	// CandidateGenerators, if non-empty, propose extra color codes for every
+	// block, which the encoder considers after its own modes' codes. If
+	// NumWorkers is more than one, their AppendCandidates methods may be
+	// called concurrently.
+	//
+	// The solid-color shortcut (see CompatETCPACK) is not affected: such
+	// blocks skip the generators. They do not apply to alpha or to the R11
+	// and RG11 formats.
+	CandidateGenerators []CandidateGenerator
+
+	// ForceMode, if not BlockModeNone, restricts every block's color code to
+	// that mode, even where another mode would have a lower error. This is
+	// for codec development, such as comparing modes on given content, not
+	// for production use. The solid-color shortcut (see CompatETCPACK) is not
+	// taken.
+	//
+	// The mode must be available in the encoded format: the individual mode
+	// is unavailable for FormatETC1S and the RGBA1 formats, and the T, H and
+	// planar modes are unavailable for FormatETC1 and FormatETC1S. The R11 and
+	// RG11 formats have no color codes, so ForceMode must be BlockModeNone.
+	// Otherwise, encoding returns ErrBadArgument.
+	ForceMode BlockMode
+
+	// MaxPerPixelError, if positive, makes the color mode search prefer the
+	// candidate codes that keep every pixel's red, green and blue error (on
+	// the 8-bit scale) at or below this bound, even if another candidate has
+	// a lower total error. If no candidate meets the bound, the one with the
+	// lowest total error is used, as usual.
+	//
+	// This suits data textures, such as ID maps or gradients that drive
+	// shaders, where a single large error matters more than many small ones.
+	// It does not apply to alpha or to the R11 and RG11 formats.
+	MaxPerPixelError int
+
+	// BucketBoundaries, if non-empty, partitions each channel's 8-bit values
+	// into buckets: each element is the smallest value of a new bucket, so
+	// that {64, 128, 192} gives four buckets. The encoder then prefers codes
+	// whose decoded values stay in the same bucket as the source values. This
+	// suits textures whose exact values drive branching in shaders, such as
+	// terrain splat indices. The elements must be in increasing order.
+	//
+	// For the R11 and RG11 formats, each pixel is mapped to the closest
+	// decodable value in its bucket, so every pixel keeps its bucket whenever
+	// that is possible at all. 16-bit values are bucketed by their high byte.
+	// For the color formats, it applies to red, green and blue (not alpha),
+	// like MaxPerPixelError: candidate codes that move a value to another
+	// bucket are avoided, but if every candidate does, the one with the
+	// lowest total error is used. The Preview option can check the result.
+	BucketBoundaries []uint8
+
+	// Preview, if non-nil, receives the decoded pixels of the emitted blocks,
+	// as Format.Decode would decode them, during the same pass. This gives an
+	// exact preview of the encoded quality without a separate decode.
+	//
+	// Preview uses the same coordinate space as src: the encoded pixel for
+	// src's (x, y) is set at Preview's (x, y). Padding pixels (outside the
+	// encoded rectangle) and pixels outside Preview.Bounds() are not set. If
+	// NumWorkers is more than one, distinct pixels may be set concurrently.
+	Preview draw.Image
+
+	// OnBlock, if non-nil, is called after each block is encoded, with that
+	// block's mode and error. This suits error heatmaps and automated quality
+	// regression tracking. If NumWorkers is more than one, it may be called
+	// concurrently and out of order.
+	OnBlock func(s BlockStats)
+
+	// NumWorkers is the maximum number of goroutines to use. Zero or one
+	// means to encode on the calling goroutine only.
+	//
+	// If dst is an io.WriterAt and io.Seeker (such as an *os.File that is not
+	// a pipe), and neither FlushEveryBlockRow nor OnCheckpoint is set, then
+	// rows of blocks are encoded concurrently and each is written at its
+	// final offset. Otherwise, encoding is pipelined: one goroutine extracts
+	// the source pixels, NumWorkers goroutines encode rows of blocks and the
+	// calling goroutine writes the encoded rows to dst, in order.
+	//
+	// For FormatETC2RGBA8, if there are at most NumWorkers/2 rows of blocks,
+	// then each row's alpha and color codes are also searched for
+	// concurrently, so that short images still use the spare goroutines.
+	//
+	// Either way, the output is deterministic: byte-for-byte identical to the
+	// one-worker output, for every NumWorkers value. Content-addressed caches
+	// can therefore rely on it. Only the order of OnBlock calls (and of
+	// Preview writes) can vary.
+	NumWorkers int
+
+	// WriteBufferSize is the size, in bytes, of the buffer that holds encoded
+	// blocks before they are written to dst. Zero means to use a default
+	// size (slightly under 4 KiB). Values less than 16 (the largest number of
+	// bytes per block) are rounded up to 16.
+	//
+	// Each dst.Write call, other than the final one, will be passed the
+	// largest multiple of the format's BytesPerBlock that fits in the buffer.
+	// A power of two, such as 65536, therefore gives writes of exactly that
+	// size, as needed for O_DIRECT files. To write the whole payload at once,
+	// use EncodePayload instead.
+	WriteBufferSize int
+
+	// FlushEveryBlockRow is whether to write any buffered data at the end of
+	// every row of blocks, instead of waiting for the buffer to fill. If dst
+	// also has a "Flush() error" method (like a *bufio.Writer) then that is
+	// called too.
+	FlushEveryBlockRow bool
+
+	// OnCheckpoint, if non-nil, is called at the end of every row of blocks,
+	// after that row's data has been written (and flushed, as for
+	// FlushEveryBlockRow) to dst. Returning a non-nil error stops the encode
+	// and that error is returned.
+	//
+	// A caller can persist the Checkpoint so that an interrupted encode can
+	// later be resumed, via the Resume field, without re-encoding the rows
+	// already written.
+	OnCheckpoint func(c Checkpoint) error
+
+	// Resume, if non-zero, skips the first Resume.BlockRow rows of blocks.
+	// It is typically a Checkpoint previously passed to OnCheckpoint, for
+	// the same source, format and options. The caller is responsible for
+	// positioning dst at Resume.Offset (e.g. by truncating and seeking a
+	// file) before calling Encode.
+	Resume Checkpoint
+}
+
+// Checkpoint records the progress of a partially complete encode.
+type Checkpoint struct {
+	// BlockRow is the number of rows of blocks completed, counting from the
+	// top of the encoded rectangle (or SubRect, if non-empty).
+	BlockRow int
+
+	// Offset is the number of bytes of encoded output for those rows.
+	Offset int64
 }
 
+// Quality is an EncodeOptions.Quality effort level.
+type Quality uint8
+
+const (
+	// QualityDefault searches each mode's most likely codes, like ETCPACK.
+	QualityDefault Quality = 0
+
+	// QualityHigh also tries both the individual and differential modes (not
+	// just whichever suits the half blocks' average colors), tries their
+	// neighboring (±1 per channel) base colors, and refines the planar mode's
+	// quantized colors.
+	QualityHigh Quality = 1
+
+	// QualityBest does everything that QualityHigh does, and also tries the
+	// T and H modes' neighboring (±1 per channel) base colors.
+	QualityBest Quality = 2
+
+	// QualityMax does everything that QualityBest does, but searches wider
+	// (±2 per channel) base color neighborhoods, chooses the differential
+	// mode's two base colors jointly instead of one after the other, and
+	// repeats the planar mode's refinement until it converges. It is
+	// typically 10 to 50 times slower than QualityBest, for a modest
+	// reduction in error.
+	QualityMax Quality = 3
+)
+
+// Compatibility is whether the encoder's output must match ETCPACK's.
+type Compatibility uint8
+
+const (
+	// CompatibilityDefault means to use the EncodeOptions' CompatETCPACK and
+	// Quality fields as they are.
+	CompatibilityDefault Compatibility = 0
+
+	// CompatibilityETCPACK means to match the ETCPACK program's output bit for
+	// bit, for regression parity. It implies CompatETCPACK and QualityDefault.
+	CompatibilityETCPACK Compatibility = 1
+
+	// CompatibilityUnconstrained means that the encoder is free to make better
+	// decisions than ETCPACK's. It ignores CompatETCPACK and raises Quality to
+	// at least QualityHigh.
+	CompatibilityUnconstrained Compatibility = 2
+)
+
 // Encode writes src to dst in the ETC format f.
 //
 // options may be nil, which means to use the default configuration.
 func Encode(dst io.Writer, src image.Image, f Format, options *EncodeOptions) error {
-	if (dst == nil) || (src == nil) || (f.ETCVersion() == 0) {
+	if src == nil {
+		return ErrBadArgument
+	}
+	return EncodeRect(dst, src, src.Bounds(), f, options)
+}
+
+// AppendEncoded is like Encode except that it appends the ETC payload to dst,
+// growing it as needed, and returns the extended slice. This helps callers
+// that embed the payload in their own container format.
+//
+// On error, it returns dst (with its original length) and the error.
+//
+// options may be nil, which means to use the default configuration.
+func AppendEncoded(dst []byte, src image.Image, f Format, options *EncodeOptions) ([]byte, error) {
+	return (&Encoder{options: options}).AppendEncoded(dst, src, f)
+}
+
+// EncodeRect is like Encode but only encodes the r sub-rectangle of src, as if
+// src was cropped to r. Unlike calling SubImage, src does not need to have a
+// SubImage method and no pixels are copied.
+//
+// r must be contained by src.Bounds(). If r's dimensions are not multiples of
+// 4 then the blocks on its right and bottom edges are padded by repeating the
+// right-most column and bottom-most row of r.
+func EncodeRect(dst io.Writer, src image.Image, r image.Rectangle, f Format, options *EncodeOptions) error {
+	return (&Encoder{options: options}).EncodeRect(dst, src, r, f)
+}
+
+// Encoder is a reusable ETC encoder. Servers that encode many images can keep
+// an Encoder to avoid re-allocating its working memory for every image.
+//
+// An Encoder is not safe for concurrent use: use one per goroutine. Its
+// options' NumWorkers still applies, but the extra workers' working memory is
+// not re-used.
+//
+// The zero value is ready to use, with the default configuration.
+type Encoder struct {
+	options *EncodeOptions
+	e       encoder
+}
+
+// NewEncoder returns an Encoder with the given options.
+//
+// options may be nil, which means to use the default configuration.
+func NewEncoder(options *EncodeOptions) *Encoder {
+	return &Encoder{options: options}
+}
+
+// Reset sets the options for subsequent Encode and EncodeRect calls, keeping
+// the Encoder's working memory.
+//
+// options may be nil, which means to use the default configuration.
+func (enc *Encoder) Reset(options *EncodeOptions) {
+	enc.options = options
+}
+
+// Encode is like the Encode function, using enc's options.
+func (enc *Encoder) Encode(dst io.Writer, src image.Image, f Format) error {
+	if src == nil {
+		return ErrBadArgument
+	}
+	return enc.EncodeRect(dst, src, src.Bounds(), f)
+}
+
+// AppendEncoded is like the AppendEncoded function, using enc's options.
+func (enc *Encoder) AppendEncoded(dst []byte, src image.Image, f Format) ([]byte, error) {
+	if src == nil {
+		return dst, ErrBadArgument
+	}
+	b := src.Bounds()
+	if (b.Dx() > 65532) || (b.Dy() > 65532) {
+		return dst, ErrImageIsTooLarge
+	}
+	w := appendWriter{slices.Grow(dst, int(f.PayloadSize(BlocksWide(b.Dx()), BlocksHigh(b.Dy()))))}
+	if err := enc.EncodeRect(&w, src, b, f); err != nil {
+		return dst, err
+	}
+	return w.b, nil
+}
+
+// Payload is an encoded ETC payload, held in memory.
+//
+// It implements io.WriterTo, writing all of Data in a single Write call. This
+// suits high-throughput pipelines that prefer a few large writes, such as to
+// network sockets.
+type Payload struct {
+	// Format is the ETC format that Data is encoded in.
+	Format Format
+
+	// Width and Height are the encoded image's dimensions, in pixels (not
+	// rounded up to a multiple of 4).
+	Width  int
+	Height int
+
+	// Data holds the encoded blocks, in row-major order.
+	Data []byte
+}
+
+// EncodePayload is like Encode except that it returns the encoded ETC payload
+// instead of writing it to an io.Writer.
+//
+// options may be nil, which means to use the default configuration.
+func EncodePayload(src image.Image, f Format, options *EncodeOptions) (*Payload, error) {
+	if src == nil {
+		return nil, ErrBadArgument
+	}
+	r, err := options.subRect(src.Bounds())
+	if err != nil {
+		return nil, err
+	}
+	data, err := AppendEncoded(nil, src, f, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Payload{
+		Format: f,
+		Width:  r.Dx(),
+		Height: r.Dy(),
+		Data:   data,
+	}, nil
+}
+
+// WriteTo implements the io.WriterTo interface.
+func (p *Payload) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(p.Data)
+	if (err == nil) && (n != len(p.Data)) {
+		err = io.ErrShortWrite
+	}
+	return int64(n), err
+}
+
+// appendWriter is an io.Writer that appends to a byte slice.
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+// EncodeRect is like the EncodeRect function, using enc's options.
+func (enc *Encoder) EncodeRect(dst io.Writer, src image.Image, r image.Rectangle, f Format) error {
+	options := enc.options
+	if (dst == nil) || (src == nil) || (f.ETCVersion() == 0) || !r.In(src.Bounds()) {
+		return ErrBadArgument
+	} else if !options.normalMappingIsValid(f) {
 		return ErrBadArgument
 	}
 
 	// Strip the sRGB bit. This encoder treats RGB and sRGB equally.
 	f &^= formatBitSRGBColorSpace
 
-	b := src.Bounds()
-	bW, bH := b.Dx(), b.Dy()
-	if (bW > 65532) || (bH > 65532) {
+	if !options.forceModeIsValid(f) {
+		return ErrBadArgument
+	} else if (r.Dx() > 65532) || (r.Dy() > 65532) {
 		return ErrImageIsTooLarge
 	}
 
-	e, bufJ := &encoder{}, 0
-	extract := f.makeExtract(&e.pixels, src)
+	sr, err := options.subRect(r)
+	if err != nil {
+		return err
+	}
+
+	bytesPerBlock := f.BytesPerBlock()
+	bytesPerBlockRow := int64(BlocksWide(sr.Dx()) * bytesPerBlock)
 
-	for blockY := 0; blockY < bH; blockY += 4 {
-		for blockX := 0; blockX < bW; blockX += 4 {
-			extract(blockX, blockY)
+	writeBufferSize := defaultWriteBufferSize
+	flushEveryBlockRow := false
+	onCheckpoint := (func(Checkpoint) error)(nil)
+	resume := Checkpoint{}
+	if options != nil {
+		if options.WriteBufferSize != 0 {
+			writeBufferSize = max(16, options.WriteBufferSize)
+		}
+		onCheckpoint = options.OnCheckpoint
+		flushEveryBlockRow = options.FlushEveryBlockRow || (onCheckpoint != nil)
+		resume = options.Resume
+		if (resume.BlockRow < 0) || (resume.BlockRow > BlocksHigh(sr.Dy())) ||
+			(resume.Offset != (int64(resume.BlockRow) * bytesPerBlockRow)) {
+			return ErrBadArgument
+		}
+	}
 
-			if (f & formatBitDepth11) != 0 {
-				signed := (f & formatBitDepth11Signed) != 0
-				if (f & formatBitDepth11TwoChannel) != 0 {
-					writeU64BE(e.buf[bufJ+0:], e.encode11(0x00, signed))
-					writeU64BE(e.buf[bufJ+8:], e.encode11(0x20, signed))
-					bufJ += 16
-				} else {
-					writeU64BE(e.buf[bufJ+0:], e.encode11(0x00, signed))
-					bufJ += 8
+	alphaLUT := options.alphaCoverageLUT(src, r, f)
+
+	if options.refinement(f) != nil {
+		return encodeRefined(&enc.e, dst, src, r, sr, f, options, alphaLUT, writeBufferSize)
+	}
+
+	numWorkers := 1
+	if options != nil {
+		numWorkers = max(1, options.NumWorkers)
+	}
+	if (numWorkers > 1) && !flushEveryBlockRow {
+		// Seek fails for an *os.File that is a pipe, and WriteAt fails (even
+		// for an empty slice) for one opened with O_APPEND. In either case, we
+		// fall back to sequential writes.
+		if dst, ok := dst.(writerAtSeeker); ok {
+			if base, err := dst.Seek(0, io.SeekCurrent); err == nil {
+				if _, err := dst.WriteAt(nil, base); err == nil {
+					return encodeRowsAt(dst, base, src, r, sr, f, options, alphaLUT, numWorkers)
 				}
+			}
+		}
+	}
+	if numWorkers > 1 {
+		return encodeRowsPipelined(&enc.e, dst, src, r, sr, f, options, alphaLUT, numWorkers, writeBufferSize)
+	}
 
-			} else if f == FormatETC2RGBA8 {
-				writeU64BE(e.buf[bufJ+0:], e.encodeAlpha())
-				writeU64BE(e.buf[bufJ+8:], e.encodeColor(f))
-				bufJ += 16
+	e, bufJ := &enc.e, 0
+	e.reset(writeBufferSize, options)
+	extract := applyAlphaLUT(f.makeExtract(&e.pixels, src, r, options), &e.pixels, alphaLUT)
+	preview, onBlock := options.preview(), options.onBlock()
 
-			} else {
-				writeU64BE(e.buf[bufJ:], e.encodeColor(f))
-				bufJ += 8
+	for blockY := sr.Min.Y + (4 * resume.BlockRow); blockY < sr.Max.Y; blockY += 4 {
+		for blockX := sr.Min.X; blockX < sr.Max.X; blockX += 4 {
+			extract(blockX, blockY)
+
+			e.encodeBlock(e.buf[bufJ:], f)
+			if preview != nil {
+				e.writePreview(preview, e.buf[bufJ:bufJ+bytesPerBlock], f, blockX, blockY, r)
+			}
+			if onBlock != nil {
+				onBlock(e.blockStats(e.buf[bufJ:bufJ+bytesPerBlock], f, (blockX-r.Min.X)/4, (blockY-r.Min.Y)/4))
 			}
+			bufJ += bytesPerBlock
+
+			if (bufJ + bytesPerBlock) > len(e.buf) {
+				if _, err := dst.Write(e.buf[:bufJ]); err != nil {
+					return err
+				}
+				bufJ = 0
+			}
+		}
 
-			if bufJ >= encoderBufferSize {
-				if _, err := dst.Write(e.buf[:]); err != nil {
+		if flushEveryBlockRow {
+			if bufJ > 0 {
+				if _, err := dst.Write(e.buf[:bufJ]); err != nil {
 					return err
 				}
 				bufJ = 0
 			}
+			if flusher, ok := dst.(interface{ Flush() error }); ok {
+				if err := flusher.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if onCheckpoint != nil {
+			blockRow := ((blockY - sr.Min.Y) / 4) + 1
+			if err := onCheckpoint(Checkpoint{
+				BlockRow: blockRow,
+				Offset:   int64(blockRow) * bytesPerBlockRow,
+			}); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -82,12 +620,341 @@ func Encode(dst io.Writer, src image.Image, f Format, options *EncodeOptions) er
 	return nil
 }
 
-const encoderBufferSize = 4096 - 64 - 64
+const defaultWriteBufferSize = 4096 - 64 - 64
+
+// subRect returns the rectangle of r to emit blocks for: the validated
+// options.SubRect, or r itself if that is empty. options may be nil.
+func (options *EncodeOptions) subRect(r image.Rectangle) (image.Rectangle, error) {
+	if (options == nil) || options.SubRect.Empty() {
+		return r, nil
+	}
+	sr := options.SubRect
+	if !sr.In(r) ||
+		(((sr.Min.X - r.Min.X) & 3) != 0) ||
+		(((sr.Min.Y - r.Min.Y) & 3) != 0) ||
+		((sr.Max.X != r.Max.X) && (((sr.Max.X - r.Min.X) & 3) != 0)) ||
+		((sr.Max.Y != r.Max.Y) && (((sr.Max.Y - r.Min.Y) & 3) != 0)) {
+		return image.Rectangle{}, ErrBadArgument
+	}
+	return sr, nil
+}
+
+// forceModeIsValid returns whether options.ForceMode is available in the
+// format f, which must not have its sRGB bit set. options may be nil.
+func (options *EncodeOptions) forceModeIsValid(f Format) bool {
+	if (options == nil) || (options.ForceMode == BlockModeNone) {
+		return true
+	} else if (f & formatBitDepth11) != 0 {
+		return false
+	}
+	switch options.ForceMode {
+	case BlockModeIndividual:
+		return (f != FormatETC1S) && (f != FormatETC2RGBA1)
+	case BlockModeDifferential:
+		return true
+	case BlockModeT, BlockModeH, BlockModePlanar:
+		return (f & formatBitsETC2) == formatBitsETC2
+	}
+	return false
+}
+
+// compatETCPACK returns options.CompatETCPACK, as overridden by
+// options.Compatibility. options may be nil.
+func (options *EncodeOptions) compatETCPACK() bool {
+	if options == nil {
+		return false
+	}
+	switch options.Compatibility {
+	case CompatibilityETCPACK:
+		return true
+	case CompatibilityUnconstrained:
+		return false
+	}
+	return options.CompatETCPACK
+}
+
+// quality returns options.Quality, as overridden by options.Compatibility.
+// options may be nil.
+func (options *EncodeOptions) quality() Quality {
+	if options == nil {
+		return QualityDefault
+	}
+	switch options.Compatibility {
+	case CompatibilityETCPACK:
+		return QualityDefault
+	case CompatibilityUnconstrained:
+		return max(QualityHigh, options.Quality)
+	}
+	return options.Quality
+}
+
+// metric returns options.Metric, as overridden by options.Compatibility.
+// options may be nil.
+func (options *EncodeOptions) metric() Metric {
+	if (options == nil) || (options.Compatibility == CompatibilityETCPACK) {
+		return MetricRGB
+	}
+	return options.Metric
+}
+
+// preview returns options.Preview. options may be nil.
+func (options *EncodeOptions) preview() draw.Image {
+	if options == nil {
+		return nil
+	}
+	return options.Preview
+}
+
+// onBlock returns options.OnBlock. options may be nil.
+func (options *EncodeOptions) onBlock() func(s BlockStats) {
+	if options == nil {
+		return nil
+	}
+	return options.OnBlock
+}
+
+// writerAtSeeker is an io.WriterAt whose current (io.Writer) position can be
+// queried and set, such as an *os.File.
+type writerAtSeeker interface {
+	io.WriterAt
+	io.Seeker
+}
+
+// encodeRowsAt is like the loop at the end of EncodeRect, but numWorkers
+// goroutines each encode whole rows of blocks, writing them to dst at their
+// final offsets (relative to base, dst's current position). Afterwards, dst's
+// position is just after the final row, as if the rows had been written
+// sequentially.
+func encodeRowsAt(dst writerAtSeeker, base int64, src image.Image, r image.Rectangle, sr image.Rectangle, f Format, options *EncodeOptions, alphaLUT *[256]uint8, numWorkers int) error {
+	bytesPerBlock := f.BytesPerBlock()
+	bytesPerBlockRow := BlocksWide(sr.Dx()) * bytesPerBlock
+	firstBlockRow := options.Resume.BlockRow
+	numBlockRows := BlocksHigh(sr.Dy()) - firstBlockRow
+
+	split := splitRGBA8Rows(f, numBlockRows, numWorkers)
+	nextBlockRow := atomic.Int64{}
+	errs := make([]error, numWorkers)
+	wg := sync.WaitGroup{}
+	for w := range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := newEncoder(bytesPerBlockRow, options)
+			extract := applyAlphaLUT(f.makeExtract(&e.pixels, src, r, options), &e.pixels, alphaLUT)
+			preview, onBlock := options.preview(), options.onBlock()
+			a, rowPixels := (*encoder)(nil), []byte(nil)
+			if split {
+				a, rowPixels = newEncoder(0, options), make([]byte, 64*BlocksWide(sr.Dx()))
+			}
+			for {
+				i := int(nextBlockRow.Add(1) - 1)
+				if i >= numBlockRows {
+					return
+				}
+				blockY := sr.Min.Y + (4 * (firstBlockRow + i))
+				if split {
+					for j, blockX := 0, sr.Min.X; blockX < sr.Max.X; j, blockX = j+1, blockX+4 {
+						extract(blockX, blockY)
+						copy(rowPixels[64*j:], e.pixels[:])
+					}
+					encodeRGBA8Row(e, a, e.buf, rowPixels)
+				}
+				bufJ := 0
+				for j, blockX := 0, sr.Min.X; blockX < sr.Max.X; j, blockX = j+1, blockX+4 {
+					if split {
+						copy(e.pixels[:], rowPixels[64*j:])
+					} else {
+						extract(blockX, blockY)
+						e.encodeBlock(e.buf[bufJ:], f)
+					}
+					if preview != nil {
+						e.writePreview(preview, e.buf[bufJ:bufJ+bytesPerBlock], f, blockX, blockY, r)
+					}
+					if onBlock != nil {
+						onBlock(e.blockStats(e.buf[bufJ:bufJ+bytesPerBlock], f, (blockX-r.Min.X)/4, (blockY-r.Min.Y)/4))
+					}
+					bufJ += bytesPerBlock
+				}
+				if _, err := dst.WriteAt(e.buf, base+int64(i*bytesPerBlockRow)); err != nil {
+					errs[w] = err
+					// Make the other workers stop early.
+					nextBlockRow.Store(int64(numBlockRows))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	_, err := dst.Seek(base+int64(numBlockRows*bytesPerBlockRow), io.SeekStart)
+	return err
+}
 
 type encoder struct {
 	pixels [64]byte
 	work   [64]byte
-	buf    [encoderBufferSize]byte
+	buf    []byte
+
+	// maxPerPixelError is EncodeOptions.MaxPerPixelError, or zero for no
+	// bound.
+	maxPerPixelError int32
+
+	// buckets maps 8-bit values to their EncodeOptions.BucketBoundaries
+	// bucket, or is nil if there are no boundaries. If non-nil, it points to
+	// bucketsArray.
+	buckets      *[256]uint8
+	bucketsArray [256]uint8
+
+	// solidFastPath is whether to use the encodeSolidColor and
+	// encodeSolidAlpha shortcuts. They are disabled by CompatETCPACK.
+	solidFastPath bool
+
+	// quality is EncodeOptions.Quality.
+	quality Quality
+
+	// forceMode is EncodeOptions.ForceMode.
+	forceMode BlockMode
+
+	// metric is EncodeOptions.Metric. If it is MetricOklab, pixelsOklab holds
+	// e.pixels converted to Oklab, once per block.
+	metric      Metric
+	pixelsOklab [16][3]float32
+
+	// goHarderAlways is whether encodeColor runs both the T and H modes'
+	// fuller searches, regardless of which mode the first searches picked.
+	goHarderAlways bool
+
+	// generators is EncodeOptions.CandidateGenerators. generated is scratch
+	// space for their candidate codes.
+	generators []CandidateGenerator
+	generated  []uint64
+}
+
+// newEncoder returns an encoder with a bufSize byte buffer. options may be
+// nil.
+func newEncoder(bufSize int, options *EncodeOptions) *encoder {
+	e := &encoder{}
+	e.reset(bufSize, options)
+	return e
+}
+
+// reset prepares e for a new encode, re-using e.buf if it is large enough.
+// options may be nil.
+func (e *encoder) reset(bufSize int, options *EncodeOptions) {
+	halfBlockTablesOnce.Do(initHalfBlockTables)
+
+	if cap(e.buf) < bufSize {
+		e.buf = make([]byte, bufSize)
+	}
+	e.buf = e.buf[:bufSize]
+
+	e.solidFastPath = !options.compatETCPACK()
+
+	e.quality, e.forceMode = options.quality(), BlockModeNone
+	e.metric = options.metric()
+	e.goHarderAlways = false
+	e.generators = nil
+	if options != nil {
+		e.generators = options.CandidateGenerators
+	}
+	if e.metric == MetricOklab {
+		srgbToLinearF32Once.Do(initSRGBToLinearF32)
+	}
+	if options != nil {
+		e.forceMode = options.ForceMode
+	}
+	if e.forceMode != BlockModeNone {
+		e.solidFastPath = false
+	}
+
+	e.maxPerPixelError = 0
+	if (options != nil) && (options.MaxPerPixelError > 0) {
+		e.maxPerPixelError = int32(min(options.MaxPerPixelError, 255))
+	}
+
+	e.buckets = nil
+	if (options != nil) && (len(options.BucketBoundaries) > 0) {
+		e.buckets = &e.bucketsArray
+		bucket, boundaries := uint8(0), options.BucketBoundaries
+		for v := range 256 {
+			for (len(boundaries) > 0) && (int(boundaries[0]) <= v) {
+				bucket, boundaries = bucket+1, boundaries[1:]
+			}
+			e.buckets[v] = bucket
+		}
+	}
+}
+
+// encodeBlock encodes e.pixels to b, which must be at least f.BytesPerBlock()
+// bytes long.
+func (e *encoder) encodeBlock(b []byte, f Format) {
+	if (f & formatBitDepth11) != 0 {
+		signed := (f & formatBitDepth11Signed) != 0
+		writeU64BE(b[0:], e.encode11(0x00, signed))
+		if (f & formatBitDepth11TwoChannel) != 0 {
+			writeU64BE(b[8:], e.encode11(0x20, signed))
+		}
+
+	} else if f == FormatETC2RGBA8 {
+		writeU64BE(b[0:], e.encodeAlpha())
+		writeU64BE(b[8:], e.encodeColor(f))
+
+	} else {
+		writeU64BE(b[0:], e.encodeColor(f))
+	}
+}
+
+// splitRGBA8Rows returns whether to search for each row of blocks' alpha and
+// color codes concurrently, as encodeRGBA8Row does. Encoding whole rows
+// concurrently already keeps the numWorkers goroutines busy, unless there are
+// too few rows, such as for short images or small mipmap levels.
+func splitRGBA8Rows(f Format, numBlockRows int, numWorkers int) bool {
+	return (f == FormatETC2RGBA8) && ((2 * numBlockRows) <= numWorkers)
+}
+
+// encodeRGBA8Row encodes a row of FormatETC2RGBA8 blocks, whose pixels are
+// packed 64 bytes per block, to code. The alpha and color searches are
+// independent, so a helper goroutine (using a) searches for the alpha codes
+// while the calling goroutine (using e) searches for the color codes. Either
+// way, code ends up the same as if each block went through e.encodeBlock.
+//
+// Afterwards, e.pixels holds the row's final block.
+func encodeRGBA8Row(e *encoder, a *encoder, code []byte, pixels []byte) {
+	numBlocks := len(pixels) / 64
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := range numBlocks {
+			copy(a.pixels[:], pixels[64*j:])
+			writeU64BE(code[(16*j)+0:], a.encodeAlpha())
+		}
+	}()
+	for j := range numBlocks {
+		copy(e.pixels[:], pixels[64*j:])
+		writeU64BE(code[(16*j)+8:], e.encodeColor(FormatETC2RGBA8))
+	}
+	wg.Wait()
+}
+
+// writePreview decodes the block code, whose top-left pixel is at (blockX,
+// blockY), into preview, clipped to r and to preview's bounds. f must not have
+// its sRGB bit set.
+func (e *encoder) writePreview(preview draw.Image, code []byte, f Format, blockX int, blockY int, r image.Rectangle) {
+	b := DecodedBlock{}
+	copy(b.Code[:], code)
+	f.decodeBlock(&b, &e.work)
+	clip := r.Intersect(preview.Bounds())
+	for i, c := range b.Pixels {
+		if p := (image.Point{X: blockX + (i & 3), Y: blockY + (i >> 2)}); p.In(clip) {
+			preview.Set(p.X, p.Y, c)
+		}
+	}
 }
 
 func (e *encoder) hasTransparentPixelsWhenUsingOneBitAlpha() bool {
@@ -100,6 +967,9 @@ func (e *encoder) hasTransparentPixelsWhenUsingOneBitAlpha() bool {
 }
 
 func (e *encoder) calculateBlockLoss(formatIsOneBitAlpha bool) (loss int32) {
+	if !formatIsOneBitAlpha {
+		return blockLoss(&e.pixels, &e.work)
+	}
 	for x := range 4 {
 		for y := range 4 {
 			i := (16 * y) + (4 * x)
@@ -118,108 +988,135 @@ func (e *encoder) calculateBlockLoss(formatIsOneBitAlpha bool) (loss int32) {
 	return loss
 }
 
-func (e *encoder) encodeColor(f Format) uint64 {
-	bestCode, bestLoss := uint64(0), maxInt32
+// colorCandidate is the best color code found so far by encodeColor.
+type colorCandidate struct {
+	code uint64
+	loss int32
+	// acceptable is whether every pixel's error is within the encoder's
+	// maxPerPixelError and every pixel stays in its bucket. It is always true
+	// if there is no bound and there are no buckets.
+	acceptable bool
+}
 
-	formatIsOneBitAlpha := f == FormatETC2RGBA1
-	if formatIsOneBitAlpha {
-		codeA := e.encodeRGBWithAlpha(true)
-		decodeColor(&e.work, codeA, true)
-		lossA := e.calculateBlockLoss(formatIsOneBitAlpha)
-		bestCode, bestLoss = codeA, lossA
+// consider decodes code into e.work and replaces *best with it if it is
+// better: if it is acceptable and *best is not, or else if it has a lower
+// loss. It returns whether *best was replaced.
+func (e *encoder) consider(best *colorCandidate, code uint64, formatIsOneBitAlpha bool) bool {
+	decodeColor(&e.work, code, formatIsOneBitAlpha)
+	c := colorCandidate{
+		code: code,
+		acceptable: ((e.maxPerPixelError == 0) || (e.calculateBlockMaxError(formatIsOneBitAlpha) <= e.maxPerPixelError)) &&
+			((e.buckets == nil) || e.keepsBuckets(formatIsOneBitAlpha)),
+	}
+	if e.metric == MetricOklab {
+		c.loss = e.calculateBlockLossOklab(formatIsOneBitAlpha)
+	} else {
+		c.loss = e.calculateBlockLoss(formatIsOneBitAlpha)
+	}
+	if (c.acceptable && !best.acceptable) ||
+		((c.acceptable == best.acceptable) && (best.loss > c.loss)) {
+		*best = c
+		return true
+	}
+	return false
+}
 
-		codeT := e.encodeT(true, false)
-		decodeColor(&e.work, codeT, true)
-		lossT := e.calculateBlockLoss(formatIsOneBitAlpha)
-		if bestLoss > lossT {
-			bestCode, bestLoss = codeT, lossT
+// calculateBlockMaxError is like calculateBlockLoss but returns the largest
+// absolute error of any pixel's red, green or blue channel.
+func (e *encoder) calculateBlockMaxError(formatIsOneBitAlpha bool) (maxErr int32) {
+	for i := 0; i < 64; i += 4 {
+		if formatIsOneBitAlpha && (e.pixels[i+3] < 0x80) {
+			continue
 		}
-
-		codeH := e.encodeH(true, false)
-		decodeColor(&e.work, codeH, true)
-		lossH := e.calculateBlockLoss(formatIsOneBitAlpha)
-		if bestLoss > lossH {
-			bestCode, bestLoss = codeH, lossH
+		for c := range 3 {
+			d := int32(e.pixels[i+c]) - int32(e.work[i+c])
+			maxErr = max(maxErr, d, -d)
 		}
+	}
+	return maxErr
+}
 
-		if e.hasTransparentPixelsWhenUsingOneBitAlpha() {
-			return bestCode
+// keepsBuckets returns whether every pixel's decoded red, green and blue
+// values are in the same bucket as the source values. e.buckets must not be
+// nil.
+func (e *encoder) keepsBuckets(formatIsOneBitAlpha bool) bool {
+	for i := 0; i < 64; i += 4 {
+		if formatIsOneBitAlpha && (e.pixels[i+3] < 0x80) {
+			continue
+		}
+		for c := range 3 {
+			if e.buckets[e.pixels[i+c]] != e.buckets[e.work[i+c]] {
+				return false
+			}
 		}
+	}
+	return true
+}
 
-		codeB := e.encodeRGBWithAlpha(false)
-		decodeColor(&e.work, codeB, true)
-		lossB := e.calculateBlockLoss(formatIsOneBitAlpha)
-		if bestLoss > lossB {
-			bestCode, bestLoss = codeB, lossB
+func (e *encoder) encodeColor(f Format) uint64 {
+	if e.forceMode != BlockModeNone {
+		return e.encodeColorForced(f)
+	} else if e.solidFastPath {
+		if code, ok := e.encodeSolidColor(f); ok {
+			return code
 		}
+	}
 
-	} else {
-		codeA := e.encodeRGBSansAlpha(reduceAverage, f == FormatETC1S)
-		decodeColor(&e.work, codeA, false)
-		lossA := e.calculateBlockLoss(formatIsOneBitAlpha)
-		bestCode, bestLoss = codeA, lossA
+	best := colorCandidate{loss: maxInt32}
+	if e.metric == MetricOklab {
+		e.prepareOklab()
+	}
 
-		if f == FormatETC1S {
-			return bestCode
+	phases := colorModePhases(f)
+	for i, phase := range phases {
+		winner := colorMode(nil)
+		for _, m := range phase {
+			if e.consider(&best, m.search(e, false), m.oneBitAlpha()) {
+				winner = m
+			}
 		}
 
-		codeQ := e.encodeRGBSansAlpha(reduceQuantize, false)
-		decodeColor(&e.work, codeQ, false)
-		lossQ := e.calculateBlockLoss(formatIsOneBitAlpha)
-		if bestLoss > lossQ {
-			bestCode, bestLoss = codeQ, lossQ
+		if i < (len(phases) - 1) {
+			if e.hasTransparentPixelsWhenUsingOneBitAlpha() {
+				break
+			}
+			continue
 		}
 
-		if (f & formatBitsETC2) != formatBitsETC2 {
-			return bestCode
+		// Like ETCPACK, search harder with the final phase's mode (if any)
+		// that last improved on the best code, as it looks promising.
+		if e.goHarderAlways {
+			for _, m := range phase {
+				if m.hasHarderSearch() {
+					e.consider(&best, m.search(e, true), m.oneBitAlpha())
+				}
+			}
+		} else if (winner != nil) && winner.hasHarderSearch() {
+			e.consider(&best, winner.search(e, true), winner.oneBitAlpha())
 		}
 	}
 
-	codeP := e.encodePlanar()
-	decodeColor(&e.work, codeP, false)
-	lossP := e.calculateBlockLoss(formatIsOneBitAlpha)
-	if bestLoss > lossP {
-		bestCode, bestLoss = codeP, lossP
-	}
-
-	const goHarderT, goHarderH = 1, 2
-	goHarder := 0
-
-	codeT := e.encodeT(false, false)
-	decodeColor(&e.work, codeT, false)
-	lossT := e.calculateBlockLoss(formatIsOneBitAlpha)
-	if bestLoss > lossT {
-		bestCode, bestLoss = codeT, lossT
-		goHarder = goHarderT
-	}
+	e.considerGenerated(&best, f)
+	return best.code
+}
 
-	codeH := e.encodeH(false, false)
-	decodeColor(&e.work, codeH, false)
-	lossH := e.calculateBlockLoss(formatIsOneBitAlpha)
-	if bestLoss > lossH {
-		bestCode, bestLoss = codeH, lossH
-		goHarder = goHarderH
+// encodeColorForced is like encodeColor but only considers codes in
+// e.forceMode, which must be valid for f.
+func (e *encoder) encodeColorForced(f Format) uint64 {
+	best := colorCandidate{loss: maxInt32}
+	if e.metric == MetricOklab {
+		e.prepareOklab()
 	}
 
-	switch goHarder {
-	case goHarderT:
-		codeU := e.encodeT(false, true)
-		decodeColor(&e.work, codeU, false)
-		lossU := e.calculateBlockLoss(formatIsOneBitAlpha)
-		if bestLoss > lossU {
-			bestCode, bestLoss = codeU, lossU
-		}
-
-	case goHarderH:
-		codeI := e.encodeH(false, true)
-		decodeColor(&e.work, codeI, false)
-		lossI := e.calculateBlockLoss(formatIsOneBitAlpha)
-		if bestLoss > lossI {
-			bestCode, bestLoss = codeI, lossI
+	for _, m := range forcedColorModes(f, e.forceMode) {
+		e.consider(&best, m.search(e, false), m.oneBitAlpha())
+		if m.hasHarderSearch() {
+			e.consider(&best, m.search(e, true), m.oneBitAlpha())
 		}
 	}
 
-	return bestCode
+	e.considerGenerated(&best, f)
+	return best.code
 }
 
 func (e *encoder) encodeRGBWithAlpha(isTransparent bool) uint64 {
@@ -425,13 +1322,53 @@ func (e *encoder) encodeRGBSansAlpha(reduce reduceFunc, formatIsETC1S bool) uint
 		diff1 := (base1[1] >> 3) - (base0[1] >> 3)
 		diff2 := (base1[2] >> 3) - (base0[2] >> 3)
 
-		if (-4 <= diff0) && (diff0 <= +3) &&
+		// By default, the differential mode is used if the two bases are
+		// close enough and the individual mode is used otherwise. Higher
+		// quality levels try both, pulling the second base within range for
+		// the differential mode.
+		diffFits := (-4 <= diff0) && (diff0 <= +3) &&
 			(-4 <= diff1) && (diff1 <= +3) &&
-			(-4 <= diff2) && (diff2 <= +3) {
+			(-4 <= diff2) && (diff2 <= +3)
+		tryBoth := (e.quality >= QualityHigh) && !formatIsETC1S
+		tryDiff := (diffFits || tryBoth) && (e.forceMode != BlockModeIndividual)
+		tryIndiv := (!diffFits || tryBoth) && (e.forceMode != BlockModeDifferential)
+		switch e.forceMode {
+		case BlockModeIndividual:
+			tryIndiv = true
+		case BlockModeDifferential:
+			tryDiff = true
+		}
+
+		if tryDiff {
 			const diffBit = 1
 
+			center0, center1 := base0, base1
+			if !diffFits {
+				for c, d := range [3]int32{diff0, diff1, diff2} {
+					q := (base0[c] >> 3) + max(-4, min(+3, d))
+					base1[c] = (q << 3) | (q >> 2)
+				}
+				diff0 = (base1[0] >> 3) - (base0[0] >> 3)
+				diff1 = (base1[1] >> 3) - (base0[1] >> 3)
+				diff2 = (base1[2] >> 3) - (base0[2] >> 3)
+			}
+
 			table0, indexes0, loss0 := e.encodeHalfBlock((2*flipBit)+0, &base0)
 			table1, indexes1, loss1 := e.encodeHalfBlock((2*flipBit)+1, &base1)
+			if (e.quality >= QualityHigh) && !formatIsETC1S {
+				radius := e.neighborhoodRadius()
+				table0, indexes0, loss0 = e.searchBaseNeighborhood((2*flipBit)+0, &base0, &base1, true, radius, table0, indexes0, loss0)
+				table1, indexes1, loss1 = e.searchBaseNeighborhood((2*flipBit)+1, &base1, &base0, true, radius, table1, indexes1, loss1)
+				if e.quality >= QualityMax {
+					if h0, h1, ok := e.searchDiffPairs(flipBit, center0, center1); ok && ((loss0 + loss1) > (h0.loss + h1.loss)) {
+						base0, table0, indexes0, loss0 = h0.base, h0.table, h0.indexes, h0.loss
+						base1, table1, indexes1, loss1 = h1.base, h1.table, h1.indexes, h1.loss
+					}
+				}
+				diff0 = (base1[0] >> 3) - (base0[0] >> 3)
+				diff1 = (base1[1] >> 3) - (base0[1] >> 3)
+				diff2 = (base1[2] >> 3) - (base0[2] >> 3)
+			}
 			loss := loss0 + loss1
 
 			if bestLoss > loss {
@@ -450,8 +1387,9 @@ func (e *encoder) encodeRGBSansAlpha(reduce reduceFunc, formatIsETC1S bool) uint
 					uint64(indexes1) |
 					uint64(indexes0)
 			}
+		}
 
-		} else {
+		if tryIndiv {
 			const diffBit = 0
 
 			base0 = reduce(rgbAvgs0, false)
@@ -459,6 +1397,11 @@ func (e *encoder) encodeRGBSansAlpha(reduce reduceFunc, formatIsETC1S bool) uint
 
 			table0, indexes0, loss0 := e.encodeHalfBlock((2*flipBit)+0, &base0)
 			table1, indexes1, loss1 := e.encodeHalfBlock((2*flipBit)+1, &base1)
+			if e.quality >= QualityHigh {
+				radius := e.neighborhoodRadius()
+				table0, indexes0, loss0 = e.searchBaseNeighborhood((2*flipBit)+0, &base0, nil, false, radius, table0, indexes0, loss0)
+				table1, indexes1, loss1 = e.searchBaseNeighborhood((2*flipBit)+1, &base1, nil, false, radius, table1, indexes1, loss1)
+			}
 			loss := loss0 + loss1
 
 			if bestLoss > loss {
@@ -482,6 +1425,124 @@ func (e *encoder) encodeRGBSansAlpha(reduce reduceFunc, formatIsETC1S bool) uint
 	return bestCode
 }
 
+// neighborhoodRadius returns how far, per channel, searchBaseNeighborhood
+// strays from the half blocks' rounded average colors.
+func (e *encoder) neighborhoodRadius() int32 {
+	if e.quality >= QualityMax {
+		return 2
+	}
+	return 1
+}
+
+// searchBaseNeighborhood tries the base colors that neighbor base (by up to
+// ±radius in each 5-bit channel if diff, or 4-bit channel if not) for the half
+// block in the given orientation. If diff, the candidates must also stay within
+// the differential mode's delta range of the other half block's base. If one
+// has a lower loss than the given table, indexes and loss, base is updated and
+// that candidate's table, indexes and loss are returned. Otherwise, the given
+// table, indexes and loss are returned unchanged.
+//
+// Like ETCPACK's slow mode, this recovers some of the error from rounding the
+// half block's average color.
+func (e *encoder) searchBaseNeighborhood(orientation int, base *[3]int32, other *[3]int32, diff bool, radius int32, table uint32, indexes uint32, loss int32) (uint32, uint32, int32) {
+	shift, maxQ := int32(4), int32(15)
+	if diff {
+		shift, maxQ = 3, 31
+	}
+	width := (2 * radius) + 1
+	center, bestBase := *base, *base
+	for n := range width * width * width {
+		candidate, ok := [3]int32{}, true
+		for c, m := 0, n; c < 3; c, m = c+1, m/width {
+			q := (center[c] >> shift) + (m % width) - radius
+			if (q < 0) || (maxQ < q) {
+				ok = false
+				break
+			} else if diff {
+				d := (other[c] >> 3) - q
+				if (orientation & 1) != 0 {
+					d = -d
+				}
+				if (d < -4) || (+3 < d) {
+					ok = false
+					break
+				}
+				candidate[c] = (q << 3) | (q >> 2)
+			} else {
+				candidate[c] = (q << 4) | q
+			}
+		}
+		if !ok || (candidate == center) {
+			continue
+		}
+		if t, i, l := e.encodeHalfBlock(orientation, &candidate); loss > l {
+			table, indexes, loss = t, i, l
+			bestBase = candidate
+		}
+	}
+	*base = bestBase
+	return table, indexes, loss
+}
+
+// halfBlockCandidate is a base color and its best table, indexes and loss for
+// one half block.
+type halfBlockCandidate struct {
+	base    [3]int32
+	table   uint32
+	indexes uint32
+	loss    int32
+}
+
+// searchDiffPairs chooses the differential mode's two base colors jointly,
+// from the ±2 per (5-bit) channel neighborhoods of center0 and center1, for
+// the given flip bit. searchBaseNeighborhood instead fixes one half block's
+// base before searching the other's, which misses pairs where both move. It
+// returns false if no pair is within the differential mode's delta range.
+func (e *encoder) searchDiffPairs(flipBit int, center0 [3]int32, center1 [3]int32) (halfBlockCandidate, halfBlockCandidate, bool) {
+	const radius, width = 2, 5
+	candidates := [2][width * width * width]halfBlockCandidate{}
+	counts := [2]int{}
+	for h, center := range [2][3]int32{center0, center1} {
+		for n := range width * width * width {
+			candidate, ok := [3]int32{}, true
+			for c, m := 0, n; c < 3; c, m = c+1, m/width {
+				q := (center[c] >> 3) + int32(m%width) - radius
+				if (q < 0) || (31 < q) {
+					ok = false
+					break
+				}
+				candidate[c] = (q << 3) | (q >> 2)
+			}
+			if !ok {
+				continue
+			}
+			t, i, l := e.encodeHalfBlock((2*flipBit)+h, &candidate)
+			candidates[h][counts[h]] = halfBlockCandidate{candidate, t, i, l}
+			counts[h]++
+		}
+	}
+
+	best0, best1, bestLoss := halfBlockCandidate{}, halfBlockCandidate{}, maxInt32
+	for _, h0 := range candidates[0][:counts[0]] {
+		for _, h1 := range candidates[1][:counts[1]] {
+			if bestLoss <= (h0.loss + h1.loss) {
+				continue
+			}
+			ok := true
+			for c := range 3 {
+				if d := (h1.base[c] >> 3) - (h0.base[c] >> 3); (d < -4) || (+3 < d) {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				best0, best1, bestLoss = h0, h1, h0.loss+h1.loss
+			}
+		}
+	}
+	return best0, best1, bestLoss < maxInt32
+}
+
 func (e *encoder) calculateRGBAverages(orientation int) [3]float64 {
 	sums := [3]int32{}
 	for i := range 8 {
@@ -498,9 +1559,17 @@ func (e *encoder) calculateRGBAverages(orientation int) [3]float64 {
 }
 
 func (e *encoder) encodeHalfBlock(orientation int, base *[3]int32) (table uint32, indexes uint32, loss int32) {
+	h := halfBlock{}
+	for i := range 8 {
+		offset := perOrientationPixelsOffsets[orientation][i]
+		h.orig[0][i] = int32(e.pixels[offset+0])
+		h.orig[1][i] = int32(e.pixels[offset+1])
+		h.orig[2][i] = int32(e.pixels[offset+2])
+	}
+
 	loss = maxInt32
 	for t := range uint32(8) {
-		indexes0, loss0 := e.encodeHalfBlock1(orientation, base, t)
+		indexes0, loss0 := h.encode1(orientation, base, t)
 		if loss > loss0 {
 			table, indexes, loss = t, indexes0, loss0
 		}
@@ -508,32 +1577,25 @@ func (e *encoder) encodeHalfBlock(orientation int, base *[3]int32) (table uint32
 	return table, indexes, loss
 }
 
-func (e *encoder) encodeHalfBlock1(orientation int, base *[3]int32, table uint32) (indexes uint32, loss int32) {
-	for i := range 8 {
-		offset := perOrientationPixelsOffsets[orientation][i]
-		orig0 := int32(e.pixels[offset+0])
-		orig1 := int32(e.pixels[offset+1])
-		orig2 := int32(e.pixels[offset+2])
-
-		bestOneLoss := maxInt32
-		bestJ := uint8(0)
-		for _, j := range scramble {
-			delta0 := int32(clamp[1023&(uint32(base[0])+modifiers[table][j])]) - orig0
-			delta1 := int32(clamp[1023&(uint32(base[1])+modifiers[table][j])]) - orig1
-			delta2 := int32(clamp[1023&(uint32(base[2])+modifiers[table][j])]) - orig2
-			oneLoss := 0 +
-				(weightValuesI32[0] * delta0 * delta0) +
-				(weightValuesI32[1] * delta1 * delta1) +
-				(weightValuesI32[2] * delta2 * delta2)
-			if bestOneLoss > oneLoss {
-				bestJ, bestOneLoss = j, oneLoss
-			}
-		}
+// encode1 finds the best pixel indexes, and their loss, for one table.
+func (h *halfBlock) encode1(orientation int, base *[3]int32, table uint32) (indexes uint32, loss int32) {
+	// The four candidate colors do not depend on the pixel.
+	c0 := &halfBlockCandidates[table][base[0]]
+	c1 := &halfBlockCandidates[table][base[1]]
+	c2 := &halfBlockCandidates[table][base[2]]
+	for k := range h.cand {
+		h.cand[k][0] = c0[k]
+		h.cand[k][1] = c1[k]
+		h.cand[k][2] = c2[k]
+	}
+	halfBlockLosses(h)
 
+	for i := range 8 {
+		bestJ := scramble[h.bestK[i]]
 		shift := perOrientationShifts[orientation][i]
 		indexes |= uint32(bestJ&2) << (shift + 0x0F)
 		indexes |= uint32(bestJ&1) << (shift + 0x00)
-		loss += bestOneLoss
+		loss += h.bestLoss[i]
 	}
 	return indexes, loss
 }
@@ -729,7 +1791,84 @@ func (e *encoder) encodePlanar() uint64 {
 	colorVG7 := int32(((colorV[1] * 0x7F) / 0xFF) + 0.5)
 	colorVB6 := int32(((colorV[2] * 0x3F) / 0xFF) + 0.5)
 
-	// Pack using Planar mode's idiosyncratic bit pattern.
+	colorO6 := [3]int32{colorOR6, colorOG7, colorOB6}
+	colorH6 := [3]int32{colorHR6, colorHG7, colorHB6}
+	colorV6 := [3]int32{colorVR6, colorVG7, colorVB6}
+	if e.quality >= QualityHigh {
+		e.refinePlanar(&colorO6, &colorH6, &colorV6, e.quality >= QualityMax)
+	}
+	return packPlanar(colorO6, colorH6, colorV6)
+}
+
+// refinePlanar adjusts the quantized (6:7:6 bits) O, H and V colors by up to
+// ±1 each, keeping the combination with the lowest decoded error. Rounding
+// the least squares fit's colors independently is not necessarily optimal,
+// especially for smooth gradients.
+//
+// Planar mode's channels are decoded independently, so each channel is a
+// separate search over 3×3×3 candidates. If exhaustive, the search is over
+// 5×5×5 (±2) candidates instead, and is repeated around each improved fit
+// until none of its neighbors improve on it. The least squares fit ignores
+// the decoder's clamping to [0x00, 0xFF], so for blocks that saturate, the
+// best fit can be further away than rounding error.
+func (e *encoder) refinePlanar(colorO *[3]int32, colorH *[3]int32, colorV *[3]int32, exhaustive bool) {
+	radius := int32(1)
+	if exhaustive {
+		radius = 2
+	}
+	for c := range 3 {
+		maxQ := int32(0x3F)
+		if c == 1 {
+			maxQ = 0x7F
+		}
+		bestO, bestH, bestV := colorO[c], colorH[c], colorV[c]
+		bestLoss := e.planarChannelLoss(c, bestO, bestH, bestV)
+		for {
+			centerO, centerH, centerV := bestO, bestH, bestV
+			for o := centerO - radius; o <= centerO+radius; o++ {
+				for h := centerH - radius; h <= centerH+radius; h++ {
+					for v := centerV - radius; v <= centerV+radius; v++ {
+						if (o < 0) || (o > maxQ) || (h < 0) || (h > maxQ) || (v < 0) || (v > maxQ) {
+							continue
+						} else if loss := e.planarChannelLoss(c, o, h, v); bestLoss > loss {
+							bestO, bestH, bestV, bestLoss = o, h, v, loss
+						}
+					}
+				}
+			}
+			if !exhaustive || (bestLoss == 0) ||
+				((centerO == bestO) && (centerH == bestH) && (centerV == bestV)) {
+				break
+			}
+		}
+		colorO[c], colorH[c], colorV[c] = bestO, bestH, bestV
+	}
+}
+
+// planarChannelLoss returns the sum of the squared errors, over e.pixels'
+// channel c, of the planar mode's decoding of the quantized o, h and v values.
+// The arithmetic matches decodePlanar's.
+func (e *encoder) planarChannelLoss(c int, o int32, h int32, v int32) (loss int32) {
+	if c == 1 {
+		o, h, v = (o<<1)|(o>>6), (h<<1)|(h>>6), (v<<1)|(v>>6)
+	} else {
+		o, h, v = (o<<2)|(o>>4), (h<<2)|(h>>4), (v<<2)|(v>>4)
+	}
+	for i := range 16 {
+		x, y := int32(i&3), int32(i>>2)
+		p := ((x * (h - o)) + (y * (v - o)) + (4 * o) + 2) >> 2
+		d := max(0x00, min(0xFF, p)) - int32(e.pixels[(4*i)+c])
+		loss += d * d
+	}
+	return loss
+}
+
+// packPlanar packs the O, H and V colors (each 6:7:6 bits) using Planar mode's
+// idiosyncratic bit pattern.
+func packPlanar(colorO [3]int32, colorH [3]int32, colorV [3]int32) uint64 {
+	colorOR6, colorOG7, colorOB6 := colorO[0], colorO[1], colorO[2]
+	colorHR6, colorHG7, colorHB6 := colorH[0], colorH[1], colorH[2]
+	colorVR6, colorVG7, colorVB6 := colorV[0], colorV[1], colorV[2]
 
 	code := 0 |
 		(uint64(colorOR6) << (63 - (6 + 0))) |
@@ -777,14 +1916,14 @@ func (e *encoder) encodeT(formatIsOneBitAlpha bool, goHarder bool) uint64 {
 		{
 			cluster00 := clusterfy(&e.pixels, 0.0)
 			convert8BitTo4Bit(&cluster00)
-			bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = e.calculateError59T(cluster00, formatIsOneBitAlpha)
+			bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = e.calculateError59T(cluster00, formatIsOneBitAlpha, maxInt32)
 			bestCluster = &cluster00
 		}
 
 		{
 			cluster05 := clusterfy(&e.pixels, 0.5)
 			convert8BitTo4Bit(&cluster05)
-			swap05, which05, pixelIndexes05, blockLoss05 := e.calculateError59T(cluster05, formatIsOneBitAlpha)
+			swap05, which05, pixelIndexes05, blockLoss05 := e.calculateError59T(cluster05, formatIsOneBitAlpha, maxInt32)
 			if bestBlockLoss > blockLoss05 {
 				bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = swap05, which05, pixelIndexes05, blockLoss05
 				bestCluster = &cluster05
@@ -794,7 +1933,7 @@ func (e *encoder) encodeT(formatIsOneBitAlpha bool, goHarder bool) uint64 {
 		{
 			cluster10 := clusterfy(&e.pixels, 1.0)
 			convert8BitTo4Bit(&cluster10)
-			swap10, which10, pixelIndexes10, blockLoss10 := e.calculateError59T(cluster10, formatIsOneBitAlpha)
+			swap10, which10, pixelIndexes10, blockLoss10 := e.calculateError59T(cluster10, formatIsOneBitAlpha, maxInt32)
 			if bestBlockLoss > blockLoss10 {
 				bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = swap10, which10, pixelIndexes10, blockLoss10
 				bestCluster = &cluster10
@@ -804,29 +1943,88 @@ func (e *encoder) encodeT(formatIsOneBitAlpha bool, goHarder bool) uint64 {
 	} else {
 		cluster05 := clusterfy(&e.pixels, 0.5)
 		convert8BitTo4Bit(&cluster05)
-		bestSwap, bestWhich, bestPixelIndexes, _ = e.calculateError59T(cluster05, formatIsOneBitAlpha)
+		bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = e.calculateError59T(cluster05, formatIsOneBitAlpha, maxInt32)
 		bestCluster = &cluster05
 	}
 
+	if e.quality >= QualityBest {
+		bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = searchTHNeighborhood(
+			bestCluster, bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss, false,
+			func(rgb444 [2][3]uint8, limit int32) (uint32, uint32, uint32, int32) {
+				return e.calculateError59T(rgb444, formatIsOneBitAlpha, limit)
+			})
+	}
+
 	if bestSwap > 0 {
 		bestCluster[0][0], bestCluster[1][0] = bestCluster[1][0], bestCluster[0][0]
 		bestCluster[0][1], bestCluster[1][1] = bestCluster[1][1], bestCluster[0][1]
 		bestCluster[0][2], bestCluster[1][2] = bestCluster[1][2], bestCluster[0][2]
 	}
 
-	// Pack using T mode's idiosyncratic bit pattern.
+	return packT(bestCluster, bestWhich, bestPixelIndexes, formatIsOneBitAlpha)
+}
+
+// searchTHNeighborhood tries adjusting each channel of the two 4:4:4 bit
+// colors by -1, 0 or +1: up to 3⁶ combinations, evaluated by calculate (which
+// is calculateError59T or calculateError58H). If one has a lower loss than
+// bestLoss, colors is updated and that combination's swap, distance index,
+// pixel indexes and loss are returned. Otherwise, the best arguments are
+// returned unchanged. If skipEqual, combinations where the two colors are
+// equal are not tried.
+func searchTHNeighborhood(
+	colors *[2][3]uint8,
+	bestSwap uint32,
+	bestWhich uint32,
+	bestPixelIndexes uint32,
+	bestLoss int32,
+	skipEqual bool,
+	calculate func(rgb444 [2][3]uint8, limit int32) (uint32, uint32, uint32, int32)) (uint32, uint32, uint32, int32) {
+
+	// 364 is 111111 in base 3: no adjustments.
+	const numCombinations, unadjusted = 729, 364
+
+	center, bestColors := *colors, *colors
+	for n := range numCombinations {
+		if n == unadjusted {
+			continue
+		}
+		candidate, ok := center, true
+		for k, m := 0, n; k < 6; k, m = k+1, m/3 {
+			v := int(center[k/3][k%3]) + (m % 3) - 1
+			if (v < 0) || (15 < v) {
+				ok = false
+				break
+			}
+			candidate[k/3][k%3] = uint8(v)
+		}
+		if !ok || (skipEqual && (candidate[0] == candidate[1])) {
+			continue
+		}
+		swap, which, pixelIndexes, loss := calculate(candidate, bestLoss)
+		if bestLoss > loss {
+			bestSwap, bestWhich, bestPixelIndexes, bestLoss = swap, which, pixelIndexes, loss
+			bestColors = candidate
+		}
+	}
+
+	*colors = bestColors
+	return bestSwap, bestWhich, bestPixelIndexes, bestLoss
+}
 
+// packT packs the two 4:4:4 bit colors, the distance index and the pixel
+// indexes using T mode's idiosyncratic bit pattern.
+func packT(colors *[2][3]uint8, which uint32, pixelIndexes uint32, formatIsOneBitAlpha bool) uint64 {
 	code := 0 |
-		(uint64(bestCluster[0][0]&0x0C) << 57) |
-		(uint64(bestCluster[0][0]&0x03) << 56) |
-		(uint64(bestCluster[0][1]) << 52) |
-		(uint64(bestCluster[0][2]) << 48) |
-		(uint64(bestCluster[1][0]) << 44) |
-		(uint64(bestCluster[1][1]) << 40) |
-		(uint64(bestCluster[1][2]) << 36) |
-		(uint64(bestWhich&0x06) << 33) |
-		(uint64(bestWhich&0x01) << 32) |
-		uint64(bestPixelIndexes)
+		(uint64(colors[0][0]&0x0C) << 57) |
+		(uint64(colors[0][0]&0x03) << 56) |
+		(uint64(colors[0][1]) << 52) |
+		(uint64(colors[0][2]) << 48) |
+		(uint64(colors[1][0]) << 44) |
+		(uint64(colors[1][1]) << 40) |
+		(uint64(colors[1][2]) << 36) |
+		(uint64(which&0x06) << 33) |
+		(uint64(which&0x01) << 32) |
+		uint64(pixelIndexes)
 	if !formatIsOneBitAlpha {
 		code |= (1 << 33) // Diff bit.
 	}
@@ -847,7 +2045,11 @@ func (e *encoder) encodeT(formatIsOneBitAlpha bool, goHarder bool) uint64 {
 	return code
 }
 
-func (e *encoder) calculateError59T(rgb444 [2][3]uint8, formatIsOneBitAlpha bool) (
+// calculateError59T returns the best T mode swap, distance index and pixel
+// indexes for the two 4:4:4 bit colors, and their loss. Candidates whose loss
+// exceeds limit are abandoned part way through, so if the returned loss
+// exceeds limit then the other return values are meaningless.
+func (e *encoder) calculateError59T(rgb444 [2][3]uint8, formatIsOneBitAlpha bool, limit int32) (
 	bestSwap uint32,
 	bestWhich uint32,
 	bestPixelIndexes uint32,
@@ -909,6 +2111,9 @@ func (e *encoder) calculateError59T(rgb444 [2][3]uint8, formatIsOneBitAlpha bool
 				pixelIndexes |= uint32(bestJ&2) << (shift + 0x0F)
 				pixelIndexes |= uint32(bestJ&1) << (shift + 0x00)
 				blockLoss += bestOneLoss
+				if blockLoss > limit {
+					break
+				}
 			}
 
 			if bestBlockLoss > blockLoss {
@@ -930,7 +2135,7 @@ func (e *encoder) encodeH(formatIsOneBitAlpha bool, goHarder bool) uint64 {
 			cluster00 := clusterfy(&e.pixels, 0.0)
 			convert8BitTo4Bit(&cluster00)
 			sort4BitColors(&cluster00)
-			bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = e.calculateError58H(cluster00, formatIsOneBitAlpha)
+			bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = e.calculateError58H(cluster00, formatIsOneBitAlpha, maxInt32)
 			bestCluster = &cluster00
 		}
 
@@ -938,7 +2143,7 @@ func (e *encoder) encodeH(formatIsOneBitAlpha bool, goHarder bool) uint64 {
 			cluster05 := clusterfy(&e.pixels, 0.5)
 			convert8BitTo4Bit(&cluster05)
 			sort4BitColors(&cluster05)
-			swap05, which05, pixelIndexes05, blockLoss05 := e.calculateError58H(cluster05, formatIsOneBitAlpha)
+			swap05, which05, pixelIndexes05, blockLoss05 := e.calculateError58H(cluster05, formatIsOneBitAlpha, maxInt32)
 			if bestBlockLoss > blockLoss05 {
 				bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = swap05, which05, pixelIndexes05, blockLoss05
 				bestCluster = &cluster05
@@ -949,7 +2154,7 @@ func (e *encoder) encodeH(formatIsOneBitAlpha bool, goHarder bool) uint64 {
 			cluster10 := clusterfy(&e.pixels, 1.0)
 			convert8BitTo4Bit(&cluster10)
 			sort4BitColors(&cluster10)
-			swap10, which10, pixelIndexes10, blockLoss10 := e.calculateError58H(cluster10, formatIsOneBitAlpha)
+			swap10, which10, pixelIndexes10, blockLoss10 := e.calculateError58H(cluster10, formatIsOneBitAlpha, maxInt32)
 			if bestBlockLoss > blockLoss10 {
 				bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = swap10, which10, pixelIndexes10, blockLoss10
 				bestCluster = &cluster10
@@ -960,10 +2165,20 @@ func (e *encoder) encodeH(formatIsOneBitAlpha bool, goHarder bool) uint64 {
 		cluster05 := clusterfy(&e.pixels, 0.5)
 		convert8BitTo4Bit(&cluster05)
 		sort4BitColors(&cluster05)
-		bestSwap, bestWhich, bestPixelIndexes, _ = e.calculateError58H(cluster05, formatIsOneBitAlpha)
+		bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = e.calculateError58H(cluster05, formatIsOneBitAlpha, maxInt32)
 		bestCluster = &cluster05
 	}
 
+	if e.quality >= QualityBest {
+		// H mode's distance index's low bit is implied by the order of the two
+		// colors, so they cannot be equal.
+		bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss = searchTHNeighborhood(
+			bestCluster, bestSwap, bestWhich, bestPixelIndexes, bestBlockLoss, true,
+			func(rgb444 [2][3]uint8, limit int32) (uint32, uint32, uint32, int32) {
+				return e.calculateError58H(rgb444, formatIsOneBitAlpha, limit)
+			})
+	}
+
 	if bestSwap > 0 {
 		bestCluster[0][0], bestCluster[1][0] = bestCluster[1][0], bestCluster[0][0]
 		bestCluster[0][1], bestCluster[1][1] = bestCluster[1][1], bestCluster[0][1]
@@ -1009,7 +2224,9 @@ func (e *encoder) encodeH(formatIsOneBitAlpha bool, goHarder bool) uint64 {
 	return code
 }
 
-func (e *encoder) calculateError58H(rgb444 [2][3]uint8, formatIsOneBitAlpha bool) (
+// calculateError58H is like calculateError59T but for H mode. The returned
+// swap is always zero.
+func (e *encoder) calculateError58H(rgb444 [2][3]uint8, formatIsOneBitAlpha bool, limit int32) (
 	bestSwap uint32,
 	bestWhich uint32,
 	bestPixelIndexes uint32,
@@ -1096,6 +2313,9 @@ func (e *encoder) calculateError58H(rgb444 [2][3]uint8, formatIsOneBitAlpha bool
 			pixelIndexes |= uint32(bestJ&2) << (shift + 0x0F)
 			pixelIndexes |= uint32(bestJ&1) << (shift + 0x00)
 			blockLoss += bestOneLoss
+			if blockLoss > limit {
+				break
+			}
 		}
 
 		if bestBlockLoss > blockLoss {
@@ -1317,7 +2537,12 @@ func (e *encoder) encode11(pixOffset int, signed bool) uint64 {
 		for mult := range 16 {
 			for table := range 16 {
 				h.fill(base, mult, table, signed)
-				loss := h.calculate11BlockLoss(&e.pixels, pixOffset, bestLoss)
+				loss := uint64(0)
+				if e.buckets == nil {
+					loss = h.calculate11BlockLoss(&e.pixels, pixOffset, bestLoss)
+				} else {
+					loss = h.calculate11BlockLossBuckets(&e.pixels, pixOffset, bestLoss, e.buckets)
+				}
 				if bestLoss > loss {
 					bestLoss = loss
 					bestBase, bestTable, bestMult = base, table, mult
@@ -1337,11 +2562,15 @@ func (e *encoder) encode11(pixOffset int, signed bool) uint64 {
 			(uint32(e.pixels[pixOffset+(2*i)+0]) << 8) +
 			(uint32(e.pixels[pixOffset+(2*i)+1]) << 0)
 		bestJ, bestDelta2 := 0, maxUint64
-		for j, helperValue := range h {
-			delta := int64(value) - int64(helperValue)
-			delta2 := uint64(delta * delta)
-			if bestDelta2 > delta2 {
-				bestJ, bestDelta2 = j, delta2
+		if e.buckets != nil {
+			bestJ, bestDelta2 = h.nearestInBucket(value, e.buckets)
+		} else {
+			for j, helperValue := range h {
+				delta := int64(value) - int64(helperValue)
+				delta2 := uint64(delta * delta)
+				if bestDelta2 > delta2 {
+					bestJ, bestDelta2 = j, delta2
+				}
 			}
 		}
 
@@ -1377,6 +2606,45 @@ func (h *encode11Helper) calculate11BlockLoss(pixels *[64]byte, pixOffset int, b
 	return loss
 }
 
+// bucketPenalty is added to the loss of a pixel that cannot keep its bucket.
+// It exceeds any block's loss from keeping buckets (16 * 0xFFFF * 0xFFFF).
+const bucketPenalty = 1 << 40
+
+// calculate11BlockLossBuckets is like calculate11BlockLoss but maps each
+// pixel to the nearest helper value in the same bucket (by high byte).
+func (h *encode11Helper) calculate11BlockLossBuckets(pixels *[64]byte, pixOffset int, bestLossSoFar uint64, buckets *[256]uint8) (loss uint64) {
+	for i := range 16 {
+		value := 0 +
+			(uint32(pixels[pixOffset+(2*i)+0]) << 8) +
+			(uint32(pixels[pixOffset+(2*i)+1]) << 0)
+		_, delta2 := h.nearestInBucket(value, buckets)
+		loss += delta2
+		if loss >= bestLossSoFar {
+			return loss
+		}
+	}
+	return loss
+}
+
+// nearestInBucket returns the index of the helper value nearest to value that
+// is in the same bucket, and the squared difference. If there is no such
+// helper value, it returns the nearest one and adds bucketPenalty.
+func (h *encode11Helper) nearestInBucket(value uint32, buckets *[256]uint8) (bestJ int, bestDelta2 uint64) {
+	bestDelta2 = maxUint64
+	bucket := buckets[value>>8]
+	for j, helperValue := range h {
+		delta := int64(value) - int64(helperValue)
+		delta2 := uint64(delta * delta)
+		if buckets[helperValue>>8] != bucket {
+			delta2 += bucketPenalty
+		}
+		if bestDelta2 > delta2 {
+			bestJ, bestDelta2 = j, delta2
+		}
+	}
+	return bestJ, bestDelta2
+}
+
 func (h *encode11Helper) fill(rawBase int, rawMultiplier int, table int, signed bool) {
 	multiplier := max(1, 8*int32(rawMultiplier))
 
@@ -1413,6 +2681,12 @@ func (h *encode11Helper) fill(rawBase int, rawMultiplier int, table int, signed
 }
 
 func (e *encoder) encodeAlpha() uint64 {
+	if e.solidFastPath {
+		if code, ok := e.encodeSolidAlpha(); ok {
+			return code
+		}
+	}
+
 	alphaSum := int32(0)
 	for i := range 16 {
 		a := int32(e.pixels[(4*i)+3])
@@ -0,0 +1,1073 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/pkm"
+)
+
+func TestGrayWeights(tt *testing.T) {
+	for _, gw := range []etc2.GrayWeights{etc2.GrayWeightsBT601(), etc2.GrayWeightsBT709()} {
+		if sum := gw.R + gw.G + gw.B; sum != gw.Sum {
+			tt.Fatalf("gw=%v: R+G+B: got %d, want %d", gw, sum, gw.Sum)
+		}
+	}
+
+	// Mutating a returned value does not change later ones.
+	gw := etc2.GrayWeightsBT709()
+	gw.R = 0
+	if got := etc2.GrayWeightsBT709(); got.R == 0 {
+		tt.Fatalf("GrayWeightsBT709 was mutated: %v", got)
+	}
+
+	gw = etc2.GrayWeightsBT601()
+	for _, c := range []color.RGBA64{
+		{0x0000, 0x0000, 0x0000, 0xFFFF},
+		{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF},
+		{0xFFFF, 0x0000, 0x0000, 0xFFFF},
+		{0x1234, 0x5678, 0x9ABC, 0xFFFF},
+		{0x8000, 0x4000, 0xC000, 0xFFFF},
+	} {
+		want := color.Gray16Model.Convert(c).(color.Gray16).Y
+		got := uint16(((gw.R * uint64(c.R)) + (gw.G * uint64(c.G)) + (gw.B * uint64(c.B)) + (gw.Sum / 2)) / gw.Sum)
+		if got != want {
+			tt.Fatalf("c=%v: got 0x%04X, want 0x%04X", c, got, want)
+		}
+	}
+}
+
+func TestEncodeOffsetSubImage(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/mona-lisa.21x32.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+
+	// Each source is a copy of the PNG, in a different image type, so that
+	// each of makeExtract's code paths is exercised.
+	newImages := map[string]func(r image.Rectangle) draw.Image{
+		"Gray":   func(r image.Rectangle) draw.Image { return image.NewGray(r) },
+		"Gray16": func(r image.Rectangle) draw.Image { return image.NewGray16(r) },
+		"NRGBA":  func(r image.Rectangle) draw.Image { return image.NewNRGBA(r) },
+		"RGBA":   func(r image.Rectangle) draw.Image { return image.NewRGBA(r) },
+		"RGBA64": func(r image.Rectangle) draw.Image { return image.NewRGBA64(r) },
+	}
+	formats := []etc2.Format{
+		etc2.FormatETC1,
+		etc2.FormatETC2RGB,
+		etc2.FormatETC2RGBA1,
+		etc2.FormatETC2RGBA8,
+		etc2.FormatETC2R11Unsigned,
+		etc2.FormatETC2RG11Signed,
+	}
+
+	// The sub-image's Min is not a multiple of 4 and its size is not a
+	// multiple of 4, so that its blocks straddle the source's blocks and its
+	// right and bottom edges are padded.
+	r := image.Rect(5, 3, 5+13, 3+18)
+	for name, newImage := range newImages {
+		src := newImage(srcImage.Bounds())
+		draw.Draw(src, src.Bounds(), srcImage, image.Point{}, draw.Src)
+		sub := src.(interface {
+			SubImage(r image.Rectangle) image.Image
+		}).SubImage(r)
+		if got := sub.Bounds(); got != r {
+			tt.Fatalf("%s: SubImage bounds: got %v, want %v", name, got, r)
+		}
+
+		// zeroed is a copy of sub, of the same type, with a zero
+		// Bounds().Min.
+		zeroed := newImage(image.Rect(0, 0, r.Dx(), r.Dy()))
+		for y := range r.Dy() {
+			for x := range r.Dx() {
+				zeroed.Set(x, y, sub.At(r.Min.X+x, r.Min.Y+y))
+			}
+		}
+
+		for _, f := range formats {
+			want := &bytes.Buffer{}
+			if err := etc2.Encode(want, zeroed, f, nil); err != nil {
+				tt.Fatalf("%s, f=%v: Encode(zeroed): %v", name, f, err)
+			}
+
+			got := &bytes.Buffer{}
+			if err := etc2.Encode(got, sub, f, nil); err != nil {
+				tt.Fatalf("%s, f=%v: Encode: %v", name, f, err)
+			} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				tt.Fatalf("%s, f=%v: Encode: encodings differ", name, f)
+			}
+
+			got.Reset()
+			if err := etc2.Encode(got, sub, f, &etc2.EncodeOptions{NumWorkers: 4}); err != nil {
+				tt.Fatalf("%s, f=%v: Encode(NumWorkers=4): %v", name, f, err)
+			} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				tt.Fatalf("%s, f=%v: Encode(NumWorkers=4): encodings differ", name, f)
+			}
+
+			got.Reset()
+			if _, err := etc2.EncodeWithStats(got, sub, f, nil); err != nil {
+				tt.Fatalf("%s, f=%v: EncodeWithStats: %v", name, f, err)
+			} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				tt.Fatalf("%s, f=%v: EncodeWithStats: encodings differ", name, f)
+			}
+
+			got.Reset()
+			if err := etc2.EncodeMulti([]io.Writer{got}, sub, []etc2.Format{f}, nil); err != nil {
+				tt.Fatalf("%s, f=%v: EncodeMulti: %v", name, f, err)
+			} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				tt.Fatalf("%s, f=%v: EncodeMulti: encodings differ", name, f)
+			}
+
+			extracted, err := etc2.NewExtractedImage(sub, f, nil)
+			if err != nil {
+				tt.Fatalf("%s, f=%v: NewExtractedImage: %v", name, f, err)
+			}
+			got.Reset()
+			if err := etc2.Encode(got, extracted, f, nil); err != nil {
+				tt.Fatalf("%s, f=%v: Encode(extracted): %v", name, f, err)
+			} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				tt.Fatalf("%s, f=%v: Encode(extracted): encodings differ", name, f)
+			}
+		}
+	}
+}
+
+func TestEncodeRect(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/mona-lisa.21x32.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	src := image.NewNRGBA(srcImage.Bounds())
+	draw.Draw(src, src.Bounds(), srcImage, image.Point{}, draw.Src)
+
+	// r is not block-aligned, relative to src, and its size (13×18) is not a
+	// multiple of 4. Wrapping src hides its SubImage method.
+	r := image.Rect(5, 3, 5+13, 3+18)
+	wrapped := struct{ image.Image }{src}
+
+	for _, f := range []etc2.Format{etc2.FormatETC2RGB, etc2.FormatETC2RGBA8, etc2.FormatETC2R11Unsigned} {
+		want := &bytes.Buffer{}
+		if err := etc2.Encode(want, src.SubImage(r), f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode(SubImage): %v", f, err)
+		}
+		for _, numWorkers := range []int{1, 4} {
+			got := &bytes.Buffer{}
+			if err := etc2.EncodeRect(got, wrapped, r, f, &etc2.EncodeOptions{NumWorkers: numWorkers}); err != nil {
+				tt.Fatalf("f=%v, numWorkers=%d: EncodeRect: %v", f, numWorkers, err)
+			} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				tt.Fatalf("f=%v, numWorkers=%d: EncodeRect: encodings differ", f, numWorkers)
+			}
+		}
+	}
+
+	// r must be inside src's bounds. Overlapping them is not enough.
+	for _, badR := range []image.Rectangle{
+		image.Rect(-1, 0, 8, 8),
+		image.Rect(16, 28, 24, 36),
+		image.Rect(100, 100, 104, 104),
+	} {
+		if err := etc2.EncodeRect(io.Discard, src, badR, etc2.FormatETC1, nil); err != etc2.ErrBadArgument {
+			tt.Fatalf("r=%v: got %v, want %v", badR, err, etc2.ErrBadArgument)
+		}
+	}
+}
+
+func TestEncodeSubRect(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/mona-lisa.21x32.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	src := image.NewNRGBA(srcImage.Bounds())
+	draw.Draw(src, src.Bounds(), srcImage, image.Point{}, draw.Src)
+
+	// r (13×18, or 4×5 blocks) is not block-aligned relative to src.
+	r := image.Rect(5, 3, 5+13, 3+18)
+	const blocksWide = 4
+
+	for _, f := range []etc2.Format{etc2.FormatETC2RGB, etc2.FormatETC2RGBA8, etc2.FormatETC2R11Unsigned} {
+		bytesPerBlock := f.BytesPerBlock()
+		full := &bytes.Buffer{}
+		if err := etc2.EncodeRect(full, src, r, f, nil); err != nil {
+			tt.Fatalf("f=%v: EncodeRect: %v", f, err)
+		}
+
+		// Each SubRect's blocks should match the corresponding blocks of the
+		// full encoding, including those padded on r's right and bottom
+		// edges.
+		for _, subRect := range []image.Rectangle{
+			r,
+			image.Rect(5+4, 3+4, 5+13, 3+18),
+			image.Rect(5, 3, 5+8, 3+12),
+			image.Rect(5+12, 3+16, 5+13, 3+18),
+		} {
+			got := &bytes.Buffer{}
+			if err := etc2.EncodeRect(got, src, r, f, &etc2.EncodeOptions{SubRect: subRect}); err != nil {
+				tt.Fatalf("f=%v, subRect=%v: EncodeRect: %v", f, subRect, err)
+			}
+			want := []byte(nil)
+			for by := (subRect.Min.Y - r.Min.Y) / 4; by < etc2.BlocksHigh(subRect.Max.Y-r.Min.Y); by++ {
+				for bx := (subRect.Min.X - r.Min.X) / 4; bx < etc2.BlocksWide(subRect.Max.X-r.Min.X); bx++ {
+					i := ((by * blocksWide) + bx) * bytesPerBlock
+					want = append(want, full.Bytes()[i:i+bytesPerBlock]...)
+				}
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				tt.Fatalf("f=%v, subRect=%v: encodings differ", f, subRect)
+			}
+		}
+	}
+
+	// SubRect must be inside r and block-aligned relative to r.
+	for _, badSubRect := range []image.Rectangle{
+		image.Rect(5+4, 3+4, 5+16, 3+20),
+		image.Rect(0, 0, 8, 8),
+		image.Rect(5+1, 3, 5+8, 3+8),
+		image.Rect(5, 3, 5+7, 3+8),
+	} {
+		if err := etc2.EncodeRect(io.Discard, src, r, etc2.FormatETC1, &etc2.EncodeOptions{SubRect: badSubRect}); err != etc2.ErrBadArgument {
+			tt.Fatalf("subRect=%v: got %v, want %v", badSubRect, err, etc2.ErrBadArgument)
+		}
+	}
+}
+
+// colorLoss returns the luma-weighted squared difference between a's and b's
+// red, green and blue values.
+func colorLoss(a color.NRGBA, b color.NRGBA) int {
+	dr := int(a.R) - int(b.R)
+	dg := int(a.G) - int(b.G)
+	db := int(a.B) - int(b.B)
+	return (299 * dr * dr) + (587 * dg * dg) + (114 * db * db)
+}
+
+func TestEncodeQuality(tt *testing.T) {
+	// Smooth gradients suit the planar mode.
+	gradient := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := range 64 {
+		for x := range 64 {
+			gradient.SetNRGBA(x, y, color.NRGBA{uint8(3*x + y), uint8(200 - (2 * y)), uint8(x + (x * y / 32)), 0xFF})
+		}
+	}
+	// Hard edges suit the T and H modes.
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	dice, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+
+	// Each level should be no worse than the one before, for both images.
+	for _, f := range []etc2.Format{etc2.FormatETC1, etc2.FormatETC2RGB} {
+		for _, src := range []image.Image{gradient, dice} {
+			b := src.Bounds()
+			losses := [4]int{}
+			for i, quality := range []etc2.Quality{etc2.QualityDefault, etc2.QualityHigh, etc2.QualityBest, etc2.QualityMax} {
+				buf := &bytes.Buffer{}
+				if err := etc2.Encode(buf, src, f, &etc2.EncodeOptions{Quality: quality}); err != nil {
+					tt.Fatalf("f=%v, quality=%d: Encode: %v", f, quality, err)
+				}
+				m, err := etc2.DecodeRaw(buf.Bytes(), f, b.Dx(), b.Dy())
+				if err != nil {
+					tt.Fatalf("f=%v, quality=%d: DecodeRaw: %v", f, quality, err)
+				}
+				for y := b.Min.Y; y < b.Max.Y; y++ {
+					for x := b.Min.X; x < b.Max.X; x++ {
+						got := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+						want := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+						losses[i] += colorLoss(got, want)
+					}
+				}
+			}
+			if (losses[1] > losses[0]) || (losses[2] > losses[1]) || (losses[3] > losses[2]) || (losses[2] >= losses[0]) {
+				tt.Errorf("f=%v, %v: losses (default, high, best, max): got %v, want decreasing", f, b, losses)
+			}
+		}
+	}
+}
+
+// oklabLoss is like colorLoss but returns the squared Oklab distance, as
+// etc2.MetricOklab measures it.
+func oklabLoss(a color.NRGBA, b color.NRGBA) float64 {
+	toOklab := func(c color.NRGBA) (l float64, m float64, s float64) {
+		linear := func(u uint8) float64 {
+			if v := float64(u) / 255; v > 0.04045 {
+				return math.Pow((v+0.055)/1.055, 2.4)
+			}
+			return float64(u) / (255 * 12.92)
+		}
+		r, g, b := linear(c.R), linear(c.G), linear(c.B)
+		l = math.Cbrt((0.4122214708 * r) + (0.5363325363 * g) + (0.0514459929 * b))
+		m = math.Cbrt((0.2119034982 * r) + (0.6806995451 * g) + (0.1073969566 * b))
+		s = math.Cbrt((0.0883024619 * r) + (0.2817188376 * g) + (0.6299787005 * b))
+		return (0.2104542553 * l) + (0.7936177850 * m) - (0.0040720468 * s),
+			(1.9779984951 * l) - (2.4285922050 * m) + (0.4505937099 * s),
+			(0.0259040371 * l) + (0.7827717662 * m) - (0.8086757660 * s)
+	}
+	aL, aA, aB := toOklab(a)
+	bL, bA, bB := toOklab(b)
+	return ((aL - bL) * (aL - bL)) + ((aA - bA) * (aA - bA)) + ((aB - bB) * (aB - bB))
+}
+
+func TestEncodeMetric(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	src, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	b := src.Bounds()
+
+	// Each metric should do better than the other, by its own measure.
+	rgbLosses, oklabLosses := [2]int{}, [2]float64{}
+	encoded := [2][]byte{}
+	for i, metric := range []etc2.Metric{etc2.MetricRGB, etc2.MetricOklab} {
+		buf := &bytes.Buffer{}
+		if err := etc2.Encode(buf, src, etc2.FormatETC2RGB, &etc2.EncodeOptions{Metric: metric}); err != nil {
+			tt.Fatalf("metric=%d: Encode: %v", metric, err)
+		}
+		encoded[i] = bytes.Clone(buf.Bytes())
+		m, err := etc2.DecodeRaw(buf.Bytes(), etc2.FormatETC2RGB, b.Dx(), b.Dy())
+		if err != nil {
+			tt.Fatalf("metric=%d: DecodeRaw: %v", metric, err)
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				got := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+				want := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+				rgbLosses[i] += colorLoss(got, want)
+				oklabLosses[i] += oklabLoss(got, want)
+			}
+		}
+	}
+	if bytes.Equal(encoded[0], encoded[1]) {
+		tt.Fatalf("MetricRGB and MetricOklab: outputs are identical")
+	} else if rgbLosses[0] > rgbLosses[1] {
+		tt.Errorf("RGB losses (MetricRGB, MetricOklab): got %v, want increasing", rgbLosses)
+	} else if oklabLosses[1] > oklabLosses[0] {
+		tt.Errorf("Oklab losses (MetricRGB, MetricOklab): got %v, want decreasing", oklabLosses)
+	}
+
+	// CompatibilityETCPACK implies MetricRGB.
+	got, want := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := etc2.Encode(got, src, etc2.FormatETC2RGB, &etc2.EncodeOptions{
+		Compatibility: etc2.CompatibilityETCPACK,
+		Metric:        etc2.MetricOklab,
+	}); err != nil {
+		tt.Fatalf("CompatibilityETCPACK: Encode: %v", err)
+	} else if err := etc2.Encode(want, src, etc2.FormatETC2RGB, &etc2.EncodeOptions{
+		Compatibility: etc2.CompatibilityETCPACK,
+	}); err != nil {
+		tt.Fatalf("CompatibilityETCPACK: Encode: %v", err)
+	} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		tt.Fatalf("CompatibilityETCPACK: MetricOklab changed the output")
+	}
+}
+
+// fixedCandidateGenerator is an etc2.CandidateGenerator that always proposes
+// the same code.
+type fixedCandidateGenerator struct {
+	code     uint64
+	numCalls int
+}
+
+func (g *fixedCandidateGenerator) AppendCandidates(dst []uint64, pixels *[64]byte, f etc2.Format) []uint64 {
+	g.numCalls++
+	return append(dst, g.code)
+}
+
+func TestEncodeCandidateGenerators(tt *testing.T) {
+	// A T mode block (its red base and delta overflow), repeated twice.
+	const tCode = 0xFB3C_5A96_1234_5678
+	payload := binary.BigEndian.AppendUint64(nil, tCode)
+	payload = binary.BigEndian.AppendUint64(payload, tCode)
+	src, err := etc2.DecodeRaw(payload, etc2.FormatETC2RGB, 8, 4)
+	if err != nil {
+		tt.Fatalf("DecodeRaw: %v", err)
+	}
+
+	testCases := []struct {
+		f         etc2.Format
+		forceMode etc2.BlockMode
+		wantCode  bool
+	}{
+		{etc2.FormatETC2RGB, etc2.BlockModeNone, true},
+		{etc2.FormatETC2RGB, etc2.BlockModeT, true},
+		{etc2.FormatETC2RGB, etc2.BlockModePlanar, false},
+		{etc2.FormatETC1, etc2.BlockModeNone, false},
+	}
+	for _, tc := range testCases {
+		g := &fixedCandidateGenerator{code: tCode}
+		options := &etc2.EncodeOptions{
+			ForceMode:           tc.forceMode,
+			CandidateGenerators: []etc2.CandidateGenerator{g},
+		}
+		got, err := etc2.AppendEncoded(nil, src, tc.f, options)
+		if err != nil {
+			tt.Fatalf("f=%v, forceMode=%d: AppendEncoded: %v", tc.f, tc.forceMode, err)
+		} else if g.numCalls != 2 {
+			tt.Fatalf("f=%v, forceMode=%d: numCalls: got %d, want 2", tc.f, tc.forceMode, g.numCalls)
+		}
+
+		if tc.wantCode {
+			if !bytes.Equal(got, payload) {
+				tt.Fatalf("f=%v, forceMode=%d: got % x, want % x", tc.f, tc.forceMode, got, payload)
+			}
+			continue
+		}
+		// An invalid code (for the format or the forced mode) is ignored.
+		options.CandidateGenerators = nil
+		want, err := etc2.AppendEncoded(nil, src, tc.f, options)
+		if err != nil {
+			tt.Fatalf("f=%v, forceMode=%d: AppendEncoded: %v", tc.f, tc.forceMode, err)
+		} else if !bytes.Equal(got, want) {
+			tt.Fatalf("f=%v, forceMode=%d: got % x, want % x", tc.f, tc.forceMode, got, want)
+		}
+	}
+}
+
+func TestEncodeForceMode(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	src, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	const widthInBlocks, heightInBlocks = 20, 15
+
+	for _, f := range []etc2.Format{
+		etc2.FormatETC1S,
+		etc2.FormatETC1,
+		etc2.FormatETC2RGB,
+		etc2.FormatETC2RGBA1,
+		etc2.FormatETC2RGBA8,
+		etc2.FormatETC2R11Unsigned,
+	} {
+		for _, mode := range []etc2.BlockMode{
+			etc2.BlockModeIndividual,
+			etc2.BlockModeDifferential,
+			etc2.BlockModeT,
+			etc2.BlockModeH,
+			etc2.BlockModePlanar,
+		} {
+			valid := true
+			switch {
+			case f == etc2.FormatETC2R11Unsigned:
+				valid = false
+			case mode == etc2.BlockModeIndividual:
+				valid = (f != etc2.FormatETC1S) && (f != etc2.FormatETC2RGBA1)
+			case mode != etc2.BlockModeDifferential:
+				valid = f.ETCVersion() == 2
+			}
+
+			buf := &bytes.Buffer{}
+			err := etc2.Encode(buf, src, f, &etc2.EncodeOptions{ForceMode: mode})
+			if !valid {
+				if err != etc2.ErrBadArgument {
+					tt.Errorf("f=%v, mode=%v: got %v, want %v", f, mode, err, etc2.ErrBadArgument)
+				}
+				continue
+			} else if err != nil {
+				tt.Errorf("f=%v, mode=%v: Encode: %v", f, mode, err)
+				continue
+			}
+
+			for b, err := range f.Blocks(buf, widthInBlocks, heightInBlocks) {
+				if err != nil {
+					tt.Fatalf("f=%v, mode=%v: Blocks: %v", f, mode, err)
+				} else if b.Mode != mode {
+					tt.Errorf("f=%v, mode=%v: block (%d, %d): got %v", f, mode, b.X, b.Y, b.Mode)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeOnBlock(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	src, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	const widthInBlocks, heightInBlocks = 20, 15
+
+	for _, f := range []etc2.Format{etc2.FormatETC2RGB, etc2.FormatETC2RGBA8} {
+		stats := make([]etc2.BlockStats, widthInBlocks*heightInBlocks)
+		buf := &bytes.Buffer{}
+		if err := etc2.Encode(buf, src, f, &etc2.EncodeOptions{
+			OnBlock: func(s etc2.BlockStats) {
+				stats[(widthInBlocks*s.Y)+s.X] = s
+			},
+		}); err != nil {
+			tt.Fatalf("f=%v: Encode: %v", f, err)
+		}
+		encoded := buf.Bytes()
+
+		for b, err := range f.Blocks(bytes.NewReader(encoded), widthInBlocks, heightInBlocks) {
+			if err != nil {
+				tt.Fatalf("f=%v: Blocks: %v", f, err)
+			}
+			s := stats[(widthInBlocks*b.Y)+b.X]
+			if (s.X != b.X) || (s.Y != b.Y) || (s.Mode != b.Mode) {
+				tt.Fatalf("f=%v: block (%d, %d): got %+v", f, b.X, b.Y, s)
+			}
+
+			wantLoss, wantAlphaLoss := int64(0), int64(0)
+			for i, c := range b.Pixels {
+				got := color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+				want := color.NRGBAModel.Convert(src.At((4*b.X)+(i&3), (4*b.Y)+(i>>2))).(color.NRGBA)
+				wantLoss += int64(colorLoss(got, want))
+				if f == etc2.FormatETC2RGBA8 {
+					d := int64(got.A) - int64(want.A)
+					wantAlphaLoss += d * d
+				}
+			}
+			if (s.Loss != wantLoss) || (s.AlphaLoss != wantAlphaLoss) {
+				tt.Fatalf("f=%v: block (%d, %d): losses: got %d, %d, want %d, %d",
+					f, b.X, b.Y, s.Loss, s.AlphaLoss, wantLoss, wantAlphaLoss)
+			}
+		}
+	}
+}
+
+func TestEncodeResume(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+
+	// The 80×60 source has 15 rows of blocks. Interrupting the encode after
+	// stopRow rows, then resuming from the last Checkpoint into the same
+	// (truncated and re-positioned) file, should give the same bytes as a
+	// one-shot encode.
+	errStop := errors.New("stop")
+	for _, f := range []etc2.Format{etc2.FormatETC1, etc2.FormatETC2RGBA1} {
+		for _, refinement := range []*etc2.Refinement{nil, {Fraction: 0.25, Quality: etc2.QualityHigh}} {
+			for _, numWorkers := range []int{1, 4} {
+				newOptions := func() *etc2.EncodeOptions {
+					return &etc2.EncodeOptions{NumWorkers: numWorkers, Refinement: refinement}
+				}
+				want := &bytes.Buffer{}
+				if err := etc2.Encode(want, srcImage, f, newOptions()); err != nil {
+					tt.Fatalf("f=%v: one-shot Encode: %v", f, err)
+				}
+
+				for _, stopRow := range []int{1, 7, 14} {
+					name := fmt.Sprintf("f=%v, refinement=%t, numWorkers=%d, stopRow=%d",
+						f, refinement != nil, numWorkers, stopRow)
+					file, err := os.CreateTemp(tt.TempDir(), "resume.etc")
+					if err != nil {
+						tt.Fatalf("%s: os.CreateTemp: %v", name, err)
+					}
+					defer file.Close()
+
+					checkpoint := etc2.Checkpoint{}
+					options := newOptions()
+					options.OnCheckpoint = func(c etc2.Checkpoint) error {
+						checkpoint = c
+						if c.BlockRow == stopRow {
+							return errStop
+						}
+						return nil
+					}
+					if err := etc2.Encode(file, srcImage, f, options); err != errStop {
+						tt.Fatalf("%s: interrupted Encode: got %v, want %v", name, err, errStop)
+					} else if checkpoint.BlockRow != stopRow {
+						tt.Fatalf("%s: BlockRow: got %d, want %d", name, checkpoint.BlockRow, stopRow)
+					}
+
+					// Simulate a crash that lost a partially written row: the
+					// file may hold more than checkpoint.Offset bytes.
+					if _, err := file.Write([]byte("garbage")); err != nil {
+						tt.Fatalf("%s: Write: %v", name, err)
+					} else if err := file.Truncate(checkpoint.Offset); err != nil {
+						tt.Fatalf("%s: Truncate: %v", name, err)
+					} else if _, err := file.Seek(checkpoint.Offset, io.SeekStart); err != nil {
+						tt.Fatalf("%s: Seek: %v", name, err)
+					}
+
+					options = newOptions()
+					options.Resume = checkpoint
+					if err := etc2.Encode(file, srcImage, f, options); err != nil {
+						tt.Fatalf("%s: resumed Encode: %v", name, err)
+					}
+					got, err := os.ReadFile(file.Name())
+					if err != nil {
+						tt.Fatalf("%s: os.ReadFile: %v", name, err)
+					} else if !bytes.Equal(got, want.Bytes()) {
+						tt.Fatalf("%s: resumed output differs from the one-shot output", name)
+					}
+				}
+			}
+		}
+	}
+
+	// A Resume that does not match the format's row size is rejected.
+	if err := etc2.Encode(io.Discard, srcImage, etc2.FormatETC1, &etc2.EncodeOptions{
+		Resume: etc2.Checkpoint{BlockRow: 1, Offset: 1},
+	}); err != etc2.ErrBadArgument {
+		tt.Fatalf("bad Resume: got %v, want %v", err, etc2.ErrBadArgument)
+	}
+}
+
+func TestEncodeShortRGBA8(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+
+	// With 1 or 2 rows of blocks and 4 workers, each row's alpha and color
+	// codes are searched for concurrently. The output should still match the
+	// one-worker output, whether dst is seekable or not.
+	for _, height := range []int{3, 8} {
+		src := srcImage.(interface {
+			SubImage(r image.Rectangle) image.Image
+		}).SubImage(image.Rect(0, 20, 80, 20+height))
+		want := &bytes.Buffer{}
+		if err := etc2.Encode(want, src, etc2.FormatETC2RGBA8, nil); err != nil {
+			tt.Fatalf("height=%d: Encode: %v", height, err)
+		}
+
+		numOnBlockCalls := atomic.Int32{}
+		options := &etc2.EncodeOptions{
+			NumWorkers: 4,
+			OnBlock:    func(s etc2.BlockStats) { numOnBlockCalls.Add(1) },
+		}
+
+		got := &bytes.Buffer{}
+		if err := etc2.Encode(got, src, etc2.FormatETC2RGBA8, options); err != nil {
+			tt.Fatalf("height=%d: Encode(pipelined): %v", height, err)
+		} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			tt.Fatalf("height=%d: Encode(pipelined): encodings differ", height)
+		}
+
+		file, err := os.CreateTemp(tt.TempDir(), "short.etc")
+		if err != nil {
+			tt.Fatalf("os.CreateTemp: %v", err)
+		}
+		defer file.Close()
+		if err := etc2.Encode(file, src, etc2.FormatETC2RGBA8, options); err != nil {
+			tt.Fatalf("height=%d: Encode(file): %v", height, err)
+		} else if gotBytes, err := os.ReadFile(file.Name()); err != nil {
+			tt.Fatalf("height=%d: os.ReadFile: %v", height, err)
+		} else if !bytes.Equal(gotBytes, want.Bytes()) {
+			tt.Fatalf("height=%d: Encode(file): encodings differ", height)
+		}
+
+		numBlocks := int32(20 * etc2.BlocksHigh(height))
+		if got, want := numOnBlockCalls.Load(), 2*numBlocks; got != want {
+			tt.Fatalf("height=%d: numOnBlockCalls: got %d, want %d", height, got, want)
+		}
+	}
+}
+
+func TestEncodeDeterministic(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	// Crop the image, to keep the test fast. Its dimensions are not
+	// multiples of 4.
+	srcImage = srcImage.(etc2.SubsettableImage).SubImage(image.Rect(10, 7, 31, 26))
+
+	formats := []etc2.Format{
+		etc2.FormatETC1,
+		etc2.FormatETC2RGB,
+		etc2.FormatETC2SRGBA1,
+		etc2.FormatETC2RGBA8,
+		etc2.FormatETC2R11Unsigned,
+		etc2.FormatETC2RG11Signed,
+	}
+	for _, f := range formats {
+		qualities := []etc2.Quality{etc2.QualityDefault, etc2.QualityHigh}
+		if (f == etc2.FormatETC2R11Unsigned) || (f == etc2.FormatETC2RG11Signed) {
+			// Quality does not affect the (exhaustive) 11-bit encoder.
+			qualities = qualities[:1]
+		}
+		for _, quality := range qualities {
+			want := []byte(nil)
+			for _, numWorkers := range []int{1, 3, 64} {
+				// A bytes.Buffer exercises the pipelined encoder and an
+				// *os.File exercises writing rows of blocks at their offsets.
+				buf := &bytes.Buffer{}
+				file, err := os.CreateTemp(tt.TempDir(), "deterministic.etc")
+				if err != nil {
+					tt.Fatalf("os.CreateTemp: %v", err)
+				}
+				for _, dst := range []io.Writer{buf, file} {
+					if err := etc2.Encode(dst, srcImage, f, &etc2.EncodeOptions{
+						NumWorkers: numWorkers,
+						Quality:    quality,
+					}); err != nil {
+						tt.Fatalf("f=%v, numWorkers=%d: Encode: %v", f, numWorkers, err)
+					}
+				}
+				fileBytes, err := os.ReadFile(file.Name())
+				file.Close()
+				if err != nil {
+					tt.Fatalf("os.ReadFile: %v", err)
+				}
+
+				if want == nil {
+					want = buf.Bytes()
+				}
+				if !bytes.Equal(buf.Bytes(), want) {
+					tt.Fatalf("f=%v, quality=%d, numWorkers=%d: bytes.Buffer output differs", f, quality, numWorkers)
+				} else if !bytes.Equal(fileBytes, want) {
+					tt.Fatalf("f=%v, quality=%d, numWorkers=%d: *os.File output differs", f, quality, numWorkers)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeMaxPerPixelError(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/water-lillies.64x62.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	b := srcImage.Bounds()
+
+	// numOverBound returns how many pixels have a red, green or blue error
+	// greater than bound.
+	const bound = 12
+	numOverBound := func(maxPerPixelError int) (n int) {
+		buf := &bytes.Buffer{}
+		if err := etc2.Encode(buf, srcImage, etc2.FormatETC2RGB, &etc2.EncodeOptions{
+			MaxPerPixelError: maxPerPixelError,
+		}); err != nil {
+			tt.Fatalf("Encode: %v", err)
+		}
+		decoded, err := etc2.DecodeRaw(buf.Bytes(), etc2.FormatETC2RGB, b.Dx(), b.Dy())
+		if err != nil {
+			tt.Fatalf("DecodeRaw: %v", err)
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c0 := color.NRGBAModel.Convert(srcImage.At(x, y)).(color.NRGBA)
+				c1 := color.NRGBAModel.Convert(decoded.At(x-b.Min.X, y-b.Min.Y)).(color.NRGBA)
+				for _, d := range []int{
+					int(c0.R) - int(c1.R),
+					int(c0.G) - int(c1.G),
+					int(c0.B) - int(c1.B),
+				} {
+					if (d > bound) || (d < -bound) {
+						n++
+						break
+					}
+				}
+			}
+		}
+		return n
+	}
+
+	without, with := numOverBound(0), numOverBound(bound)
+	if with >= without {
+		tt.Fatalf("pixels over the bound: got %d with MaxPerPixelError, %d without, want fewer with", with, without)
+	}
+}
+
+func TestEncodeBucketBoundaries(tt *testing.T) {
+	// A noisy ramp, with many values just either side of the boundaries.
+	boundaries := []uint8{64, 128, 192}
+	src := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := range 32 {
+		for x := range 32 {
+			v := uint8((8 * x) + (((x * 7) ^ (y * 13)) % 9) - 4)
+			src.SetNRGBA(x, y, color.NRGBA{v, v ^ 0x40, 255 - v, 0xFF})
+		}
+	}
+	bucket := func(v uint8) (b int) {
+		for _, boundary := range boundaries {
+			if v >= boundary {
+				b++
+			}
+		}
+		return b
+	}
+
+	for _, f := range []etc2.Format{etc2.FormatETC2RGB, etc2.FormatETC2R11Unsigned} {
+		// numMoved returns how many pixels have a channel value that moved to
+		// another bucket.
+		numMoved := func(boundaries []uint8) (n int) {
+			buf := &bytes.Buffer{}
+			if err := etc2.Encode(buf, src, f, &etc2.EncodeOptions{BucketBoundaries: boundaries}); err != nil {
+				tt.Fatalf("f=%v: Encode: %v", f, err)
+			}
+			decoded, err := etc2.DecodeRaw(buf.Bytes(), f, 32, 32)
+			if err != nil {
+				tt.Fatalf("f=%v: DecodeRaw: %v", f, err)
+			}
+			for y := range 32 {
+				for x := range 32 {
+					c0 := src.NRGBAAt(x, y)
+					if f == etc2.FormatETC2R11Unsigned {
+						y0 := color.Gray16Model.Convert(c0).(color.Gray16).Y
+						y1 := decoded.(*image.Gray16).Gray16At(x, y).Y
+						if bucket(uint8(y0>>8)) != bucket(uint8(y1>>8)) {
+							n++
+						}
+						continue
+					}
+					c1 := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+					if (bucket(c0.R) != bucket(c1.R)) ||
+						(bucket(c0.G) != bucket(c1.G)) ||
+						(bucket(c0.B) != bucket(c1.B)) {
+						n++
+					}
+				}
+			}
+			return n
+		}
+
+		without, with := numMoved(nil), numMoved(boundaries)
+		if with >= without {
+			tt.Errorf("f=%v: pixels that moved bucket: got %d with BucketBoundaries, %d without, want fewer with",
+				f, with, without)
+		}
+		if (f == etc2.FormatETC2R11Unsigned) && (with != 0) {
+			tt.Errorf("f=%v: pixels that moved bucket: got %d, want 0", f, with)
+		}
+	}
+}
+
+func TestETC2EncoderReuse(tt *testing.T) {
+	srcImages := []image.Image(nil)
+	for _, name := range []string{"lincoln.24x32", "mona-lisa.21x32"} {
+		srcBytes, err := os.ReadFile("../../res/0-original-png/" + name + ".png")
+		if err != nil {
+			tt.Fatalf("os.ReadFile: %v", err)
+		}
+		srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+		if err != nil {
+			tt.Fatalf("png.Decode: %v", err)
+		}
+		srcImages = append(srcImages, srcImage)
+	}
+
+	enc := etc2.NewEncoder(nil)
+	for i, options := range []*etc2.EncodeOptions{nil, {MaxPerPixelError: 20}, nil} {
+		enc.Reset(options)
+		for _, srcImage := range srcImages {
+			got, want := &bytes.Buffer{}, &bytes.Buffer{}
+			if err := enc.Encode(got, srcImage, etc2.FormatETC1); err != nil {
+				tt.Fatalf("i=%d: Encoder.Encode: %v", i, err)
+			}
+			if err := etc2.Encode(want, srcImage, etc2.FormatETC1, options); err != nil {
+				tt.Fatalf("i=%d: etc2.Encode: %v", i, err)
+			}
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				tt.Errorf("i=%d: Encoder.Encode and etc2.Encode differ", i)
+			}
+		}
+	}
+
+	// Re-using an Encoder should allocate less than the Encode function.
+	dst := &bytes.Buffer{}
+	reused := testing.AllocsPerRun(10, func() {
+		dst.Reset()
+		enc.Encode(dst, srcImages[0], etc2.FormatETC1)
+	})
+	fresh := testing.AllocsPerRun(10, func() {
+		dst.Reset()
+		etc2.Encode(dst, srcImages[0], etc2.FormatETC1, nil)
+	})
+	if reused >= fresh {
+		tt.Errorf("allocations: got %v reused, %v fresh, want fewer reused", reused, fresh)
+	}
+}
+
+func TestAppendEncoded(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	dice, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	// Crop the image, as encoding the 11-bit formats is slow.
+	src := dice.(etc2.SubsettableImage).SubImage(image.Rect(8, 4, 30, 23))
+	prefix := []byte("prefix")
+	for _, f := range []etc2.Format{etc2.FormatETC1, etc2.FormatETC2RGBA8, etc2.FormatETC2R11Signed} {
+		want := &bytes.Buffer{}
+		want.Write(prefix)
+		if err := etc2.Encode(want, src, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode: %v", f, err)
+		}
+		got, err := etc2.AppendEncoded(prefix[:len(prefix):len(prefix)], src, f, nil)
+		if err != nil {
+			tt.Fatalf("f=%v: AppendEncoded: %v", f, err)
+		} else if !bytes.Equal(got, want.Bytes()) {
+			tt.Fatalf("f=%v: AppendEncoded and Encode differ", f)
+		}
+	}
+
+	if got, err := etc2.AppendEncoded(prefix, src, etc2.FormatInvalid, nil); err != etc2.ErrBadArgument {
+		tt.Fatalf("FormatInvalid: got %v, want %v", err, etc2.ErrBadArgument)
+	} else if string(got) != "prefix" {
+		tt.Fatalf("FormatInvalid: got %q, want %q", got, "prefix")
+	}
+}
+
+// writeCounter is a bytes.Buffer that counts its Write calls.
+type writeCounter struct {
+	bytes.Buffer
+	numWrites int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.numWrites++
+	return w.Buffer.Write(p)
+}
+
+func TestEncodePayload(tt *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 37, 21))
+	for y := range 21 {
+		for x := range 37 {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(7 * x), uint8(11 * y), 0x40, 0xFF})
+		}
+	}
+	want := &bytes.Buffer{}
+	if err := etc2.Encode(want, src, etc2.FormatETC2RGB, nil); err != nil {
+		tt.Fatalf("Encode: %v", err)
+	}
+
+	p, err := etc2.EncodePayload(src, etc2.FormatETC2RGB, nil)
+	if err != nil {
+		tt.Fatalf("EncodePayload: %v", err)
+	} else if (p.Format != etc2.FormatETC2RGB) || (p.Width != 37) || (p.Height != 21) {
+		tt.Fatalf("EncodePayload: got %v %d×%d, want %v 37×21", p.Format, p.Width, p.Height, etc2.FormatETC2RGB)
+	}
+
+	// WriteTo writes everything in a single Write call.
+	got := &writeCounter{}
+	if n, err := io.WriterTo(p).WriteTo(got); err != nil {
+		tt.Fatalf("WriteTo: %v", err)
+	} else if n != int64(want.Len()) {
+		tt.Fatalf("WriteTo: got %d bytes, want %d", n, want.Len())
+	} else if got.numWrites != 1 {
+		tt.Fatalf("numWrites: got %d, want 1", got.numWrites)
+	} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		tt.Fatalf("EncodePayload and Encode differ")
+	}
+}
+
+func TestEncodeCompatibility(tt *testing.T) {
+	testCases := []struct {
+		filename string
+		format   etc2.Format
+	}{
+		{"49", etc2.FormatETC2RGBA1},
+		{"lincoln.24x32", etc2.FormatETC2R11Unsigned},
+		{"mona-lisa.21x32", etc2.FormatETC1},
+		{"mona-lisa.21x32", etc2.FormatETC2RGB},
+	}
+
+	for _, tc := range testCases {
+		tcString := tc.filename + "." + tc.format.String()
+		srcBytes, err := os.ReadFile("../../res/0-original-png/" + tc.filename + ".png")
+		if err != nil {
+			tt.Fatalf("tc=%q: os.ReadFile(png): %v", tcString, err)
+		}
+		srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+		if err != nil {
+			tt.Fatalf("tc=%q: Decode: %v", tcString, err)
+		}
+		encode := func(o etc2.EncodeOptions) []byte {
+			buf := &bytes.Buffer{}
+			if err := etc2.Encode(buf, srcImage, tc.format, &o); err != nil {
+				tt.Fatalf("tc=%q: Encode: %v", tcString, err)
+			}
+			return buf.Bytes()
+		}
+
+		// CompatibilityETCPACK overrides Quality, matching the golden files
+		// (produced by the ETCPACK program).
+		want, err := os.ReadFile("../../res/1-encoded-pkm/" + tcString + ".pkm")
+		if err != nil {
+			tt.Fatalf("tc=%q: os.ReadFile(pkm): %v", tcString, err)
+		}
+		if got := encode(etc2.EncodeOptions{
+			Compatibility: etc2.CompatibilityETCPACK,
+			Quality:       etc2.QualityBest,
+		}); !bytes.Equal(got, want[pkm.HeaderSize:]) {
+			tt.Fatalf("tc=%q: CompatibilityETCPACK: output differs from golden", tcString)
+		}
+
+		// CompatibilityUnconstrained overrides CompatETCPACK and raises
+		// Quality to at least QualityHigh.
+		if got, want := encode(etc2.EncodeOptions{
+			Compatibility: etc2.CompatibilityUnconstrained,
+			CompatETCPACK: true,
+		}), encode(etc2.EncodeOptions{
+			Quality: etc2.QualityHigh,
+		}); !bytes.Equal(got, want) {
+			tt.Fatalf("tc=%q: CompatibilityUnconstrained: output differs from QualityHigh", tcString)
+		}
+		if got, want := encode(etc2.EncodeOptions{
+			Compatibility: etc2.CompatibilityUnconstrained,
+			Quality:       etc2.QualityBest,
+		}), encode(etc2.EncodeOptions{
+			Quality: etc2.QualityBest,
+		}); !bytes.Equal(got, want) {
+			tt.Fatalf("tc=%q: CompatibilityUnconstrained: output differs from QualityBest", tcString)
+		}
+	}
+}
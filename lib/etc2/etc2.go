@@ -21,8 +21,10 @@ package etc2
 
 import (
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
+	"io"
 )
 
 var (
@@ -31,6 +33,58 @@ var (
 	ErrImageIsTooLarge = errors.New("etc2: image is too large")
 )
 
+// TruncatedError is the error for an ETC payload that ends early. It wraps
+// io.ErrUnexpectedEOF, so that errors.Is(err, io.ErrUnexpectedEOF) reports
+// whether err is a TruncatedError.
+type TruncatedError struct {
+	// BlockIndex is the row-major index of the first incomplete block.
+	BlockIndex int
+
+	// Offset is the byte offset, from the start of the payload (not of any
+	// container file), of that block.
+	Offset int64
+}
+
+// newTruncatedError returns a *TruncatedError for the block of the format f
+// at blockIndex.
+func newTruncatedError(f Format, blockIndex int) *TruncatedError {
+	return &TruncatedError{
+		BlockIndex: blockIndex,
+		Offset:     int64(blockIndex) * int64(f.BytesPerBlock()),
+	}
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("etc2: payload is truncated at block %d (byte offset %d)", e.BlockIndex, e.Offset)
+}
+
+func (e *TruncatedError) Unwrap() error { return io.ErrUnexpectedEOF }
+
+// BlockSize is the width and height, in pixels, of every ETC block.
+const BlockSize = 4
+
+// BlocksWide returns the number of block columns for an image w pixels wide.
+func BlocksWide(w int) int {
+	return (w + 3) / 4
+}
+
+// BlocksHigh returns the number of block rows for an image h pixels high.
+func BlocksHigh(h int) int {
+	return (h + 3) / 4
+}
+
+// PaddedSize returns an image's width and height rounded up to multiples of
+// BlockSize, which are the dimensions that its encoded blocks cover.
+func PaddedSize(w int, h int) (paddedW int, paddedH int) {
+	return (w + 3) &^ 3, (h + 3) &^ 3
+}
+
+// BlockAt returns the block coordinates of the block containing the pixel (x,
+// y), where both are relative to the image's top-left corner.
+func BlockAt(x int, y int) (blockX int, blockY int) {
+	return x >> 2, y >> 2
+}
+
 // SubsettableImage is an image.Image that also has a SubImage method, like all
 // of the Go standard library's image types.
 type SubsettableImage interface {
@@ -91,6 +145,41 @@ const (
 	formatBitsETC2  = Format(0xC0)
 )
 
+// String returns a short name for f, such as "etc2-rgba8". These names match
+// the suffixes of this repository's test data filenames.
+func (f Format) String() string {
+	switch f {
+	case FormatETC1S:
+		return "etc1s"
+	case FormatETC1:
+		return "etc1"
+
+	case FormatETC2RGB:
+		return "etc2-rgb"
+	case FormatETC2RGBA1:
+		return "etc2-rgba1"
+	case FormatETC2RGBA8:
+		return "etc2-rgba8"
+
+	case FormatETC2SRGB:
+		return "etc2-srgb"
+	case FormatETC2SRGBA1:
+		return "etc2-srgba1"
+	case FormatETC2SRGBA8:
+		return "etc2-srgba8"
+
+	case FormatETC2R11Unsigned:
+		return "etc2-r11u"
+	case FormatETC2R11Signed:
+		return "etc2-r11s"
+	case FormatETC2RG11Unsigned:
+		return "etc2-rg11u"
+	case FormatETC2RG11Signed:
+		return "etc2-rg11s"
+	}
+	return "invalid"
+}
+
 // AlphaModel returns the Format's transparency model.
 func (f Format) AlphaModel() AlphaModel {
 	switch f & (formatBit1BitAlpha | formatBit8BitAlpha) {
@@ -113,6 +202,19 @@ func (f Format) BytesPerBlock() int {
 	return 8
 }
 
+// PayloadSize returns the number of bytes of a raw (container-less) payload
+// of the given dimensions, measured in blocks.
+func (f Format) PayloadSize(widthInBlocks int, heightInBlocks int) int64 {
+	return int64(widthInBlocks) * int64(heightInBlocks) * int64(f.BytesPerBlock())
+}
+
+// BlockOffset returns the byte offset of the block at (blockX, blockY) within
+// a raw (container-less) payload that is widthInBlocks blocks wide. Blocks
+// are stored in row-major order. All arguments are measured in blocks.
+func (f Format) BlockOffset(widthInBlocks int, blockX int, blockY int) int64 {
+	return ((int64(blockY) * int64(widthInBlocks)) + int64(blockX)) * int64(f.BytesPerBlock())
+}
+
 // ETCVersion returns 0, 1 or 2 depending on whether the Format is invalid,
 // from ETC1 or from ETC2.
 func (f Format) ETCVersion() int {
@@ -146,22 +248,94 @@ func (f Format) ColorModel() color.Model {
 //
 // It returns an error if the width or height is negative or above 65536.
 func (f Format) NewImage(width int, height int) (SubsettableImage, error) {
+	return f.NewImageUsing(width, height, nil)
+}
+
+// NewImageUsing is like NewImage except that, if alloc is non-nil, the image's
+// Pix slice is the result of calling alloc with the required length, such as
+// Arena.Alloc. alloc must return a slice of exactly that length.
+//
+// This lets programs that decode many small images re-use memory, reducing
+// garbage collection pressure.
+func (f Format) NewImageUsing(width int, height int, alloc func(n int) []byte) (SubsettableImage, error) {
+	return f.newImage(width, height, alloc, false)
+}
+
+// newImage is like NewImageUsing except that, if narrow is set, the 11-bit
+// formats' images use half as many bytes per pixel: the R11 formats' image is
+// an *image.Gray instead of an *image.Gray16 and the RG11 formats' image is an
+// *ImageRG16 instead of an *image.RGBA64.
+func (f Format) newImage(width int, height int, alloc func(n int) []byte, narrow bool) (SubsettableImage, error) {
 	if (width < 0) || (width >= 65536) ||
 		(height < 0) || (height >= 65536) {
 		return nil, ErrBadArgument
 	}
-	r := image.Rect(0, 0, (width+3)&^3, (height+3)&^3)
+	paddedW, paddedH := PaddedSize(width, height)
+	r := image.Rect(0, 0, paddedW, paddedH)
 
+	bytesPerPixel := 0
 	if f == FormatInvalid {
 		return nil, ErrBadArgument
-	} else if 0 != (f & formatBit8BitAlpha) {
-		return image.NewNRGBA(r), nil
 	} else if 0 == (f & formatBitDepth11) {
-		return image.NewRGBA(r), nil
+		bytesPerPixel = 4
+	} else if 0 != (f & formatBitDepth11TwoChannel) {
+		bytesPerPixel = 8
+	} else {
+		bytesPerPixel = 2
+	}
+	if narrow && (0 != (f & formatBitDepth11)) {
+		bytesPerPixel /= 2
+	}
+
+	stride := bytesPerPixel * paddedW
+	pix := []byte(nil)
+	if alloc == nil {
+		pix = make([]byte, stride*paddedH)
+	} else if pix = alloc(stride * paddedH); len(pix) != (stride * paddedH) {
+		return nil, ErrBadArgument
+	}
+
+	if 0 != (f & formatBit8BitAlpha) {
+		return &image.NRGBA{Pix: pix, Stride: stride, Rect: r}, nil
+	} else if 0 == (f & formatBitDepth11) {
+		return &image.RGBA{Pix: pix, Stride: stride, Rect: r}, nil
 	} else if 0 != (f & formatBitDepth11TwoChannel) {
-		return image.NewRGBA64(r), nil
+		if narrow {
+			return &ImageRG16{Pix: pix, Stride: stride, Rect: r}, nil
+		}
+		return &image.RGBA64{Pix: pix, Stride: stride, Rect: r}, nil
+	} else if narrow {
+		return &image.Gray{Pix: pix, Stride: stride, Rect: r}, nil
+	}
+	return &image.Gray16{Pix: pix, Stride: stride, Rect: r}, nil
+}
+
+// NewImageWithOptions is like NewImageUsing except that it returns the image
+// type that DecodeWithOptions requires for the given options. This differs
+// from NewImage's for the RGBA8 formats when options.ColorOnly is set, for the
+// RGBA1 formats when options.OneBitAlphaNRGBA is set, for the R11 formats
+// when options.Gray8 is set and for the RG11 formats when options.RG16 is set.
+//
+// options may be nil, which means to use the default configuration.
+func (f Format) NewImageWithOptions(width int, height int, alloc func(n int) []byte, options *DecodeOptions) (SubsettableImage, error) {
+	narrow := false
+	if options != nil {
+		switch f {
+		case FormatETC2RGBA8, FormatETC2SRGBA8:
+			if options.ColorOnly {
+				f = FormatETC2RGB
+			}
+		case FormatETC2RGBA1, FormatETC2SRGBA1:
+			if options.OneBitAlphaNRGBA {
+				f = FormatETC2RGBA8
+			}
+		case FormatETC2R11Unsigned, FormatETC2R11Signed:
+			narrow = options.Gray8
+		case FormatETC2RG11Unsigned, FormatETC2RG11Signed:
+			narrow = options.RG16
+		}
 	}
-	return image.NewGray16(r), nil
+	return f.newImage(width, height, alloc, narrow)
 }
 
 // OpenGLInternalFormat returns the OpenGL internalFormat enum value for f, suitable
@@ -198,6 +372,41 @@ func (f Format) OpenGLInternalFormat() uint32 {
 	return 0
 }
 
+// FormatFromOpenGL returns the Format whose OpenGLInternalFormat is
+// internalFormat, or FormatInvalid if there is no such Format. GL_ETC1_RGB8_OES
+// maps to FormatETC1, not FormatETC1S.
+func FormatFromOpenGL(internalFormat uint32) Format {
+	switch internalFormat {
+	case 0x8D64: // GL_ETC1_RGB8_OES
+		return FormatETC1
+
+	case 0x9274: // GL_COMPRESSED_RGB8_ETC2
+		return FormatETC2RGB
+	case 0x9278: // GL_COMPRESSED_RGBA8_ETC2_EAC
+		return FormatETC2RGBA8
+	case 0x9276: // GL_COMPRESSED_RGB8_PUNCHTHROUGH_ALPHA1_ETC2
+		return FormatETC2RGBA1
+
+	case 0x9275: // GL_COMPRESSED_SRGB8_ETC2
+		return FormatETC2SRGB
+	case 0x9279: // GL_COMPRESSED_SRGB8_ALPHA8_ETC2_EAC
+		return FormatETC2SRGBA8
+	case 0x9277: // GL_COMPRESSED_SRGB8_PUNCHTHROUGH_ALPHA1_ETC2
+		return FormatETC2SRGBA1
+
+	case 0x9270: // GL_COMPRESSED_R11_EAC
+		return FormatETC2R11Unsigned
+	case 0x9271: // GL_COMPRESSED_SIGNED_R11_EAC
+		return FormatETC2R11Signed
+	case 0x9272: // GL_COMPRESSED_RG11_EAC
+		return FormatETC2RG11Unsigned
+	case 0x9273: // GL_COMPRESSED_SIGNED_RG11_EAC
+		return FormatETC2RG11Signed
+	}
+
+	return FormatInvalid
+}
+
 // PKMFormat returns the PKM file format's enum value for f.
 func (f Format) PKMFormat() uint8 {
 	switch f {
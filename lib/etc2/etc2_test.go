@@ -0,0 +1,45 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestFormatFromOpenGL(tt *testing.T) {
+	for _, f := range []etc2.Format{
+		etc2.FormatETC1,
+		etc2.FormatETC2RGB,
+		etc2.FormatETC2RGBA8,
+		etc2.FormatETC2RGBA1,
+		etc2.FormatETC2SRGB,
+		etc2.FormatETC2SRGBA8,
+		etc2.FormatETC2SRGBA1,
+		etc2.FormatETC2R11Unsigned,
+		etc2.FormatETC2R11Signed,
+		etc2.FormatETC2RG11Unsigned,
+		etc2.FormatETC2RG11Signed,
+	} {
+		if got := etc2.FormatFromOpenGL(f.OpenGLInternalFormat()); got != f {
+			tt.Errorf("%v: got %v", f, got)
+		}
+	}
+
+	// FormatETC1S is a subset of FormatETC1 and shares its enum value.
+	if got := etc2.FormatFromOpenGL(etc2.FormatETC1S.OpenGLInternalFormat()); got != etc2.FormatETC1 {
+		tt.Errorf("ETC1S: got %v, want %v", got, etc2.FormatETC1)
+	}
+	for _, v := range []uint32{0, 0x1908, 0x8D63, 0x927A} {
+		if got := etc2.FormatFromOpenGL(v); got != etc2.FormatInvalid {
+			tt.Errorf("0x%04X: got %v, want %v", v, got, etc2.FormatInvalid)
+		}
+	}
+}
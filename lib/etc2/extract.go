@@ -10,35 +10,86 @@ package etc2
 
 import (
 	"image"
+	"image/color"
 )
 
 // makeExtract returns a closure that extracts the 4×4 block from src with the
 // given top-left corner, writing the data to pixels.
 //
-// Out-of-bound pixels right of and below the image are substituted with the
-// nearest in-bound pixel from the right and bottom edges.
-func (f Format) makeExtract(pixels *[64]byte, src image.Image) func(blockX int, blockY int) {
-	// We use the ITU-R BT.709 constants for conversion from color to gray,
-	// which matches the ImageMagick "convert" program (and ImageMagick's
-	// MagickCore/colorspace.c) used by
-	// https://github.com/nigeltao/ETCPACK.git
-	//
-	// These RGB-to-gray constants are different from that used by the Go
-	// standard library's image/color package (which follows ITU-R BT.601, the
-	// same as JFIF): 0.299 0.587 0.114
-	//
-	// Using BT.709 means that this package's encoder produces exactly the same
-	// output as the ETCPACK C++ program (which shells out to "convert").
-	const grayR, grayG, grayB, graySum = 212656, 715158, 72186, 1000000
-
-	maxPoint := src.Bounds().Max
-	mX1 := maxPoint.X - 1
-	mY1 := maxPoint.Y - 1
+// Out-of-bound pixels right of and below bounds are substituted with the
+// nearest in-bound pixel from the bounds' right and bottom edges.
+//
+// options may be nil, which means to use the default configuration.
+func (f Format) makeExtract(pixels *[64]byte, src image.Image, bounds image.Rectangle, options *EncodeOptions) func(blockX int, blockY int) {
+	return applyValueRange(f.makeRawExtract(pixels, src, bounds, options), pixels, options.valueRange(f&^formatBitSRGBColorSpace))
+}
+
+// makeRawExtract is like makeExtract but ignores options.ValueRange.
+func (f Format) makeRawExtract(pixels *[64]byte, src image.Image, bounds image.Rectangle, options *EncodeOptions) func(blockX int, blockY int) {
+	gw := GrayWeightsBT601()
+	if options.compatETCPACK() {
+		gw = GrayWeightsBT709()
+	}
+	grayR, grayG, grayB, graySum := gw.R, gw.G, gw.B, gw.Sum
+
+	if m, ok := src.(*ExtractedImage); ok && m.canExtract(f, bounds, options) {
+		return func(blockX int, blockY int) {
+			copy(pixels[:], m.block(blockX, blockY))
+		}
+	} else if s, ok := src.(SignedImage); ok && ((f & formatBitDepth11Signed) != 0) {
+		mX1, mY1 := bounds.Max.X-1, bounds.Max.Y-1
+		return func(blockX int, blockY int) {
+			for y := range 4 {
+				for x := range 4 {
+					i := (8 * y) + (2 * x)
+					r, g := s.SignedAt(min(mX1, blockX+x), min(mY1, blockY+y))
+					u, v := biasSigned16(r), biasSigned16(g)
+					pixels[i+0x00] = uint8(u >> 8)
+					pixels[i+0x01] = uint8(u >> 0)
+					pixels[i+0x20] = uint8(v >> 8)
+					pixels[i+0x21] = uint8(v >> 0)
+				}
+			}
+		}
+	} else if (options != nil) && options.SourceIsPremultiplied {
+		src = newPremultipliedSource(src)
+	}
+	if transform := options.transform(f); transform != nil {
+		src = &transformedImage{src, transform}
+	}
+
+	mX1 := bounds.Max.X - 1
+	mY1 := bounds.Max.Y - 1
 
 	if (f & formatBitDepth11) != 0 {
 		twoChannel := (f & formatBitDepth11TwoChannel) != 0
 
-		if srcNRGBA, ok := src.(*image.NRGBA); ok {
+		nm := NormalMappingNone
+		if options != nil {
+			nm = options.NormalMapping
+		}
+
+		if twoChannel && (nm != NormalMappingNone) {
+			return func(blockX int, blockY int) {
+				for y := range 4 {
+					for x := range 4 {
+						i := (8 * y) + (2 * x)
+						r, g, b, a := src.At(min(mX1, blockX+x), min(mY1, blockY+y)).RGBA()
+						if (a != 0x0000) && (a != 0xFFFF) {
+							r = (uint32(r) * 0xFFFF) / uint32(a)
+							g = (uint32(g) * 0xFFFF) / uint32(a)
+							b = (uint32(b) * 0xFFFF) / uint32(a)
+						}
+						u, v := nm.mapNormal(r, g, b)
+						pixels[i+0x00] = uint8(u >> 8)
+						pixels[i+0x01] = uint8(u >> 0)
+						pixels[i+0x20] = uint8(v >> 8)
+						pixels[i+0x21] = uint8(v >> 0)
+					}
+				}
+			}
+
+		} else if srcNRGBA, ok := src.(*image.NRGBA); ok {
 			return func(blockX int, blockY int) {
 				for y := range 4 {
 					for x := range 4 {
@@ -61,7 +112,7 @@ func (f Format) makeExtract(pixels *[64]byte, src image.Image) func(blockX int,
 				}
 			}
 
-		} else if srcNRGBA64, ok := src.(*image.NRGBA64); ok {
+		} else if srcNRGBA64, ok := src.(nrgba64Image); ok {
 			return func(blockX int, blockY int) {
 				for y := range 4 {
 					for x := range 4 {
@@ -156,7 +207,7 @@ func (f Format) makeExtract(pixels *[64]byte, src image.Image) func(blockX int,
 				}
 			}
 
-		} else if srcNRGBA64, ok := src.(*image.NRGBA64); ok {
+		} else if srcNRGBA64, ok := src.(nrgba64Image); ok {
 			return func(blockX int, blockY int) {
 				for y := range 4 {
 					for x := range 4 {
@@ -210,3 +261,131 @@ func (f Format) makeExtract(pixels *[64]byte, src image.Image) func(blockX int,
 		}
 	}
 }
+
+// nrgba64Image is an image.Image whose pixels can be read, without allocation
+// or premultiplication, as color.NRGBA64 values. *image.NRGBA64,
+// *premultipliedSource and *transformedImage implement it.
+type nrgba64Image interface {
+	image.Image
+	NRGBA64At(x int, y int) color.NRGBA64
+}
+
+// premultipliedSource presents a premultiplied source image's colors, as is,
+// as non-premultiplied colors, for EncodeOptions.SourceIsPremultiplied.
+type premultipliedSource struct {
+	src    image.Image
+	rgba64 image.RGBA64Image
+}
+
+// newPremultipliedSource returns src, wrapped if needed so that its colors are
+// read without un-premultiplying them.
+func newPremultipliedSource(src image.Image) image.Image {
+	switch src.(type) {
+	case *image.NRGBA, nrgba64Image:
+		return src
+	}
+	rgba64, _ := src.(image.RGBA64Image)
+	return &premultipliedSource{src, rgba64}
+}
+
+func (m *premultipliedSource) ColorModel() color.Model     { return color.NRGBA64Model }
+func (m *premultipliedSource) Bounds() image.Rectangle     { return m.src.Bounds() }
+func (m *premultipliedSource) At(x int, y int) color.Color { return m.NRGBA64At(x, y) }
+
+func (m *premultipliedSource) NRGBA64At(x int, y int) color.NRGBA64 {
+	if m.rgba64 != nil {
+		return color.NRGBA64(m.rgba64.RGBA64At(x, y))
+	}
+	r, g, b, a := m.src.At(x, y).RGBA()
+	return color.NRGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: uint16(a)}
+}
+
+// transform returns the per-pixel function, combining the Transform,
+// Background and OnAlphaDiscarded options, to apply when extracting pixels for
+// the format f. It returns nil if there is nothing to apply. options may be
+// nil.
+func (options *EncodeOptions) transform(f Format) func(c color.NRGBA64) color.NRGBA64 {
+	if options == nil {
+		return nil
+	}
+	t := options.Transform
+	if f.AlphaModel() != AlphaModelOpaque {
+		return t
+
+	} else if options.Background != nil {
+		bgR, bgG, bgB, bgA := options.Background.RGBA()
+		if (bgA != 0x0000) && (bgA != 0xFFFF) {
+			bgR = (bgR * 0xFFFF) / bgA
+			bgG = (bgG * 0xFFFF) / bgA
+			bgB = (bgB * 0xFFFF) / bgA
+		}
+		premultiplied := options.SourceIsPremultiplied
+		return func(c color.NRGBA64) color.NRGBA64 {
+			if t != nil {
+				c = t(c)
+			}
+			a, z := uint32(c.A), 0xFFFF-uint32(c.A)
+			if premultiplied {
+				// The source colors are already weighted by their alpha.
+				// Invalid premultiplied colors (greater than alpha) could
+				// overflow, so clamp.
+				return color.NRGBA64{
+					R: uint16(min(0xFFFF, uint32(c.R)+(((bgR*z)+0x7FFF)/0xFFFF))),
+					G: uint16(min(0xFFFF, uint32(c.G)+(((bgG*z)+0x7FFF)/0xFFFF))),
+					B: uint16(min(0xFFFF, uint32(c.B)+(((bgB*z)+0x7FFF)/0xFFFF))),
+					A: 0xFFFF,
+				}
+			}
+			return color.NRGBA64{
+				R: uint16(((uint32(c.R) * a) + (bgR * z) + 0x7FFF) / 0xFFFF),
+				G: uint16(((uint32(c.G) * a) + (bgG * z) + 0x7FFF) / 0xFFFF),
+				B: uint16(((uint32(c.B) * a) + (bgB * z) + 0x7FFF) / 0xFFFF),
+				A: 0xFFFF,
+			}
+		}
+
+	} else if onAlphaDiscarded := options.OnAlphaDiscarded; onAlphaDiscarded != nil {
+		called := false
+		return func(c color.NRGBA64) color.NRGBA64 {
+			if t != nil {
+				c = t(c)
+			}
+			if !called && (c.A != 0xFFFF) {
+				called = true
+				onAlphaDiscarded()
+			}
+			return c
+		}
+	}
+	return t
+}
+
+// transformedImage applies EncodeOptions.Transform (and related options) to
+// src's pixels.
+type transformedImage struct {
+	src       image.Image
+	transform func(c color.NRGBA64) color.NRGBA64
+}
+
+func (m *transformedImage) ColorModel() color.Model     { return color.NRGBA64Model }
+func (m *transformedImage) Bounds() image.Rectangle     { return m.src.Bounds() }
+func (m *transformedImage) At(x int, y int) color.Color { return m.NRGBA64At(x, y) }
+
+func (m *transformedImage) NRGBA64At(x int, y int) color.NRGBA64 {
+	c := color.NRGBA64{}
+	switch src := m.src.(type) {
+	case *image.NRGBA:
+		c8 := src.NRGBAAt(x, y)
+		c = color.NRGBA64{
+			R: uint16(c8.R) * 0x101,
+			G: uint16(c8.G) * 0x101,
+			B: uint16(c8.B) * 0x101,
+			A: uint16(c8.A) * 0x101,
+		}
+	case nrgba64Image:
+		c = src.NRGBA64At(x, y)
+	default:
+		c = color.NRGBA64Model.Convert(src.At(x, y)).(color.NRGBA64)
+	}
+	return m.transform(c)
+}
@@ -0,0 +1,248 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestEncodeGrayConversion(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/36.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+
+	// By default, encoding a color image to R11 should be the same as first
+	// converting it with the standard library's color.Gray16Model.
+	b := srcImage.Bounds()
+	grayImage := image.NewGray16(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			grayImage.Set(x, y, srcImage.At(x, y))
+		}
+	}
+
+	for _, f := range []etc2.Format{etc2.FormatETC2R11Unsigned, etc2.FormatETC2R11Signed} {
+		got, want := &bytes.Buffer{}, &bytes.Buffer{}
+		if err := etc2.Encode(got, srcImage, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode(color): %v", f, err)
+		}
+		if err := etc2.Encode(want, grayImage, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode(gray): %v", f, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			tt.Fatalf("f=%v: color and pre-converted gray encodings differ", f)
+		}
+	}
+}
+
+func TestEncodeSourceIsPremultiplied(tt *testing.T) {
+	// A semi-transparent gradient, as an *image.RGBA whose (premultiplied)
+	// bytes are also viewed as an *image.NRGBA.
+	rgba := image.NewRGBA(image.Rect(0, 0, 16, 12))
+	for y := range 12 {
+		for x := range 16 {
+			a := uint8(0x40 + (8 * y))
+			rgba.SetRGBA(x, y, color.RGBA{R: a, G: uint8(x * 4), B: a / 3, A: a})
+		}
+	}
+	nrgba := &image.NRGBA{Pix: rgba.Pix, Stride: rgba.Stride, Rect: rgba.Rect}
+
+	for _, f := range []etc2.Format{etc2.FormatETC2RGBA8, etc2.FormatETC2RG11Unsigned} {
+		got, want, plain := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+		if err := etc2.Encode(got, rgba, f, &etc2.EncodeOptions{SourceIsPremultiplied: true}); err != nil {
+			tt.Fatalf("f=%v: Encode(premultiplied): %v", f, err)
+		}
+		if err := etc2.Encode(want, nrgba, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode(nrgba): %v", f, err)
+		}
+		if err := etc2.Encode(plain, rgba, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode(rgba): %v", f, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			tt.Fatalf("f=%v: premultiplied and as-is encodings differ", f)
+		} else if bytes.Equal(got.Bytes(), plain.Bytes()) {
+			tt.Fatalf("f=%v: SourceIsPremultiplied had no effect", f)
+		}
+	}
+}
+
+func TestEncodeTransform(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	src := image.NewNRGBA(srcImage.Bounds())
+	draw.Draw(src, src.Bounds(), srcImage, image.Point{}, draw.Src)
+
+	// inverted is src with its red, green and blue inverted, which is exactly
+	// what invert does to the 8-bit values widened to 16 bits.
+	inverted := image.NewNRGBA(src.Bounds())
+	for i := 0; i < len(src.Pix); i += 4 {
+		inverted.Pix[i+0] = 0xFF - src.Pix[i+0]
+		inverted.Pix[i+1] = 0xFF - src.Pix[i+1]
+		inverted.Pix[i+2] = 0xFF - src.Pix[i+2]
+		inverted.Pix[i+3] = src.Pix[i+3]
+	}
+	invert := func(c color.NRGBA64) color.NRGBA64 {
+		return color.NRGBA64{R: 0xFFFF - c.R, G: 0xFFFF - c.G, B: 0xFFFF - c.B, A: c.A}
+	}
+
+	// The R11 format checks that Transform runs before the conversion to
+	// gray.
+	for _, f := range []etc2.Format{etc2.FormatETC2RGB, etc2.FormatETC2RGBA8, etc2.FormatETC2R11Unsigned} {
+		want := &bytes.Buffer{}
+		if err := etc2.Encode(want, inverted, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode(inverted): %v", f, err)
+		}
+		for _, numWorkers := range []int{1, 4} {
+			got := &bytes.Buffer{}
+			if err := etc2.Encode(got, src, f, &etc2.EncodeOptions{
+				Transform:  invert,
+				NumWorkers: numWorkers,
+			}); err != nil {
+				tt.Fatalf("f=%v, numWorkers=%d: Encode: %v", f, numWorkers, err)
+			} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				tt.Fatalf("f=%v, numWorkers=%d: encodings differ", f, numWorkers)
+			}
+		}
+	}
+}
+
+func TestEncodeOnAlphaDiscarded(tt *testing.T) {
+	// src's left half is opaque and its right half is fully transparent
+	// (with non-zero color), so that compositing over a background is exact.
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for y := range 8 {
+		for x := range 16 {
+			c := color.NRGBA{uint8(16 * x), uint8(32 * y), 0x80, 0xFF}
+			if x >= 8 {
+				c.A = 0x00
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+	opaque := image.NewNRGBA(src.Bounds())
+	copy(opaque.Pix, src.Pix)
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xFF
+	}
+	background := color.RGBA{0x00, 0x00, 0xFF, 0xFF}
+	composited := image.NewNRGBA(src.Bounds())
+	draw.Draw(composited, composited.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(composited, composited.Bounds(), src, image.Point{}, draw.Over)
+
+	testCases := []struct {
+		src       image.Image
+		f         etc2.Format
+		wantCalls int
+	}{
+		{src, etc2.FormatETC2RGB, 1},
+		{src, etc2.FormatETC1, 1},
+		{src, etc2.FormatETC2R11Unsigned, 1},
+		{opaque, etc2.FormatETC2RGB, 0},
+		{src, etc2.FormatETC2RGBA8, 0},
+		{src, etc2.FormatETC2RGBA1, 0},
+	}
+	for i, tc := range testCases {
+		numCalls := 0
+		got := &bytes.Buffer{}
+		if err := etc2.Encode(got, tc.src, tc.f, &etc2.EncodeOptions{
+			OnAlphaDiscarded: func() { numCalls++ },
+		}); err != nil {
+			tt.Fatalf("i=%d: Encode: %v", i, err)
+		} else if numCalls != tc.wantCalls {
+			tt.Fatalf("i=%d: numCalls: got %d, want %d", i, numCalls, tc.wantCalls)
+		}
+
+		// OnAlphaDiscarded does not change the output.
+		want := &bytes.Buffer{}
+		if err := etc2.Encode(want, tc.src, tc.f, nil); err != nil {
+			tt.Fatalf("i=%d: Encode(nil options): %v", i, err)
+		} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			tt.Fatalf("i=%d: encodings differ", i)
+		}
+	}
+
+	// With a Background, alpha is composited instead of discarded, so
+	// OnAlphaDiscarded is not called.
+	for _, f := range []etc2.Format{etc2.FormatETC2RGB, etc2.FormatETC2R11Unsigned} {
+		numCalls := 0
+		got := &bytes.Buffer{}
+		if err := etc2.Encode(got, src, f, &etc2.EncodeOptions{
+			Background:       background,
+			OnAlphaDiscarded: func() { numCalls++ },
+		}); err != nil {
+			tt.Fatalf("f=%v: Encode(Background): %v", f, err)
+		} else if numCalls != 0 {
+			tt.Fatalf("f=%v: numCalls: got %d, want 0", f, numCalls)
+		}
+		want := &bytes.Buffer{}
+		if err := etc2.Encode(want, composited, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode(composited): %v", f, err)
+		} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			tt.Fatalf("f=%v: Background: encodings differ", f)
+		}
+	}
+}
+
+func TestEncodeBackgroundPremultiplied(tt *testing.T) {
+	// src is premultiplied, with opaque, half-transparent and fully
+	// transparent columns. Over an opaque blue background, each composited
+	// color is c + bg*(1-a), which is exact at 8 bits for these alphas.
+	src := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	want := image.NewNRGBA(src.Bounds())
+	for y := range 8 {
+		for x := range 16 {
+			switch x % 3 {
+			case 0:
+				src.SetRGBA(x, y, color.RGBA{uint8(16 * x), uint8(32 * y), 0x80, 0xFF})
+				want.SetNRGBA(x, y, color.NRGBA{uint8(16 * x), uint8(32 * y), 0x80, 0xFF})
+			case 1:
+				src.SetRGBA(x, y, color.RGBA{uint8(8 * x), uint8(16 * y), 0x40, 0x80})
+				want.SetNRGBA(x, y, color.NRGBA{uint8(8 * x), uint8(16 * y), 0x40 + 0x7F, 0xFF})
+			case 2:
+				want.SetNRGBA(x, y, color.NRGBA{0x00, 0x00, 0xFF, 0xFF})
+			}
+		}
+	}
+	background := color.RGBA{0x00, 0x00, 0xFF, 0xFF}
+
+	for _, f := range []etc2.Format{etc2.FormatETC2RGB, etc2.FormatETC1} {
+		got := &bytes.Buffer{}
+		if err := etc2.Encode(got, src, f, &etc2.EncodeOptions{
+			Background:            background,
+			SourceIsPremultiplied: true,
+		}); err != nil {
+			tt.Fatalf("f=%v: Encode(Background, SourceIsPremultiplied): %v", f, err)
+		}
+		wantBuf := &bytes.Buffer{}
+		if err := etc2.Encode(wantBuf, want, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode(composited): %v", f, err)
+		} else if !bytes.Equal(got.Bytes(), wantBuf.Bytes()) {
+			tt.Fatalf("f=%v: encodings differ", f)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"image/color"
+)
+
+// ExtractedImage is a source image that has been converted, once, to the
+// block-planar representation that the encoder works on: un-premultiplied,
+// converted to the format's channels and split into 4×4 blocks (padded at the
+// right and bottom edges).
+//
+// Passing an ExtractedImage to Encode (or EncodeRect, with r equal to its
+// Bounds) skips that conversion, which helps when the same image is encoded
+// several times, such as with different formats or options. It is only
+// skipped for formats with the same pixel layout (color, R11 or RG11) and the
+// same NormalMapping as when the ExtractedImage was created, and no further
+// Transform. Otherwise, it is treated like any other image.Image.
+type ExtractedImage struct {
+	bounds        image.Rectangle
+	layout        Format
+	normalMapping NormalMapping
+	widthInBlocks int
+	blocks        []byte
+}
+
+// NewExtractedImage extracts src's pixels for encoding in the format f.
+//
+// options may be nil, which means to use the default configuration. Only its
+// NormalMapping, SourceIsPremultiplied, Transform, Background and
+// OnAlphaDiscarded fields are used. Their effects are baked into the
+// ExtractedImage: encoding it later ignores SourceIsPremultiplied.
+func NewExtractedImage(src image.Image, f Format, options *EncodeOptions) (*ExtractedImage, error) {
+	if (src == nil) || (f.ETCVersion() == 0) || !options.normalMappingIsValid(f) {
+		return nil, ErrBadArgument
+	}
+	nm := NormalMappingNone
+	if options != nil {
+		nm = options.NormalMapping
+	}
+
+	b := src.Bounds()
+	if (b.Dx() > 65532) || (b.Dy() > 65532) {
+		return nil, ErrImageIsTooLarge
+	}
+	widthInBlocks := BlocksWide(b.Dx())
+	heightInBlocks := BlocksHigh(b.Dy())
+
+	m := &ExtractedImage{
+		bounds:        b,
+		layout:        f.pixelLayout(),
+		normalMapping: nm,
+		widthInBlocks: widthInBlocks,
+		blocks:        make([]byte, 64*widthInBlocks*heightInBlocks),
+	}
+	pixels := [64]byte{}
+	extract := f.makeRawExtract(&pixels, src, b, options)
+	i := 0
+	for blockY := b.Min.Y; blockY < b.Max.Y; blockY += 4 {
+		for blockX := b.Min.X; blockX < b.Max.X; blockX += 4 {
+			extract(blockX, blockY)
+			i += copy(m.blocks[i:], pixels[:])
+		}
+	}
+	return m, nil
+}
+
+// pixelLayout returns the Format bits that determine how makeExtract lays out
+// a block's pixels.
+func (f Format) pixelLayout() Format {
+	return f & (formatBitDepth11 | formatBitDepth11TwoChannel)
+}
+
+// canExtract returns whether makeExtract can copy m's blocks instead of
+// converting its pixels.
+func (m *ExtractedImage) canExtract(f Format, bounds image.Rectangle, options *EncodeOptions) bool {
+	nm := NormalMappingNone
+	if options != nil {
+		if options.transform(f) != nil {
+			return false
+		}
+		nm = options.NormalMapping
+	}
+	return (m.layout == f.pixelLayout()) && (m.normalMapping == nm) && (m.bounds == bounds)
+}
+
+// block returns the 64 bytes of the block containing the pixel (x, y).
+func (m *ExtractedImage) block(x int, y int) []byte {
+	i := 64 * ((((y - m.bounds.Min.Y) / 4) * m.widthInBlocks) + ((x - m.bounds.Min.X) / 4))
+	return m.blocks[i : i+64]
+}
+
+// ColorModel implements the image.Image interface.
+func (m *ExtractedImage) ColorModel() color.Model {
+	switch m.layout {
+	case formatBitDepth11:
+		return color.Gray16Model
+	case formatBitDepth11 | formatBitDepth11TwoChannel:
+		return color.RGBA64Model
+	}
+	return color.NRGBAModel
+}
+
+// Bounds implements the image.Image interface.
+func (m *ExtractedImage) Bounds() image.Rectangle {
+	return m.bounds
+}
+
+// At implements the image.Image interface.
+func (m *ExtractedImage) At(x int, y int) color.Color {
+	if !(image.Point{X: x, Y: y}.In(m.bounds)) {
+		return color.Transparent
+	}
+	block := m.block(x, y)
+	x = (x - m.bounds.Min.X) & 3
+	y = (y - m.bounds.Min.Y) & 3
+
+	switch m.layout {
+	case formatBitDepth11:
+		i := (8 * y) + (2 * x)
+		return color.Gray16{
+			Y: (uint16(block[i+0]) << 8) | uint16(block[i+1]),
+		}
+	case formatBitDepth11 | formatBitDepth11TwoChannel:
+		i := (8 * y) + (2 * x)
+		return color.RGBA64{
+			R: (uint16(block[i+0x00]) << 8) | uint16(block[i+0x01]),
+			G: (uint16(block[i+0x20]) << 8) | uint16(block[i+0x21]),
+			B: 0x0000,
+			A: 0xFFFF,
+		}
+	}
+	i := (16 * y) + (4 * x)
+	return color.NRGBA{
+		R: block[i+0],
+		G: block[i+1],
+		B: block[i+2],
+		A: block[i+3],
+	}
+}
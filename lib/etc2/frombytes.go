@@ -0,0 +1,109 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+// PixelLayout is the layout of the raw pixels passed to EncodeFromBytes.
+type PixelLayout uint8
+
+const (
+	// PixelLayoutRGBA8 is 4 bytes per pixel: red, green, blue and
+	// non-premultiplied alpha.
+	PixelLayoutRGBA8 = PixelLayout(0)
+
+	// PixelLayoutRG8 is 2 bytes per pixel: red and green. Blue is 0x00 and
+	// alpha is 0xFF.
+	PixelLayoutRG8 = PixelLayout(1)
+
+	// PixelLayoutGray8 is 1 byte per pixel: gray.
+	PixelLayoutGray8 = PixelLayout(2)
+)
+
+// BytesPerPixel returns the number of bytes per pixel, or 0 if pl is invalid.
+func (pl PixelLayout) BytesPerPixel() int {
+	switch pl {
+	case PixelLayoutRGBA8:
+		return 4
+	case PixelLayoutRG8:
+		return 2
+	case PixelLayoutGray8:
+		return 1
+	}
+	return 0
+}
+
+// EncodeFromBytes is like Encode except that the source pixels are given as a
+// raw byte slice instead of an image.Image. Texture bakers that already hold
+// such buffers can then skip converting them, or reading them pixel by pixel
+// through the image.Image interface's At method.
+//
+// The pixel at (x, y), where 0 <= x < width and 0 <= y < height, starts at
+// pix[(y * stride) + (x * layout.BytesPerPixel())]. stride must be at least
+// width * layout.BytesPerPixel().
+//
+// options may be nil, which means to use the default configuration.
+func EncodeFromBytes(dst io.Writer, pix []byte, layout PixelLayout, width int, height int, stride int, f Format, options *EncodeOptions) error {
+	src, err := newBytesImage(pix, layout, width, height, stride)
+	if err != nil {
+		return err
+	}
+	return Encode(dst, src, f, options)
+}
+
+// newBytesImage returns an image.Image that shares pix, using one of the
+// types that makeExtract reads without allocation.
+func newBytesImage(pix []byte, layout PixelLayout, width int, height int, stride int) (image.Image, error) {
+	bpp := layout.BytesPerPixel()
+	if (bpp == 0) || (width < 0) || (height < 0) || (stride < (width * bpp)) {
+		return nil, ErrBadArgument
+	} else if (width > 0) && (height > 0) {
+		if n := (int64(height-1) * int64(stride)) + int64(width*bpp); int64(len(pix)) < n {
+			return nil, ErrBadArgument
+		}
+	}
+
+	r := image.Rect(0, 0, width, height)
+	switch layout {
+	case PixelLayoutRGBA8:
+		return &image.NRGBA{Pix: pix, Stride: stride, Rect: r}, nil
+	case PixelLayoutRG8:
+		return &rg8Image{pix: pix, stride: stride, rect: r}, nil
+	}
+	return &image.Gray{Pix: pix, Stride: stride, Rect: r}, nil
+}
+
+// rg8Image is an image.RGBA64Image backed by PixelLayoutRG8 pixels.
+type rg8Image struct {
+	pix    []byte
+	stride int
+	rect   image.Rectangle
+}
+
+func (m *rg8Image) ColorModel() color.Model     { return color.RGBA64Model }
+func (m *rg8Image) Bounds() image.Rectangle     { return m.rect }
+func (m *rg8Image) At(x int, y int) color.Color { return m.RGBA64At(x, y) }
+
+func (m *rg8Image) RGBA64At(x int, y int) color.RGBA64 {
+	if !(image.Point{x, y}.In(m.rect)) {
+		return color.RGBA64{}
+	}
+	i := ((y - m.rect.Min.Y) * m.stride) + ((x - m.rect.Min.X) * 2)
+	s := m.pix[i : i+2 : i+2]
+	return color.RGBA64{
+		R: uint16(s[0]) * 0x101,
+		G: uint16(s[1]) * 0x101,
+		B: 0x0000,
+		A: 0xFFFF,
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestEncodeFromBytes(tt *testing.T) {
+	const width, height = 13, 9
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	rg := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			c := color.NRGBA{uint8(19 * x), uint8(27 * y), uint8(x * y), uint8(255 - (5 * x))}
+			nrgba.SetNRGBA(x, y, c)
+			gray.SetGray(x, y, color.Gray{c.R ^ c.G})
+			rg.SetNRGBA(x, y, color.NRGBA{c.R, c.G, 0x00, 0xFF})
+		}
+	}
+
+	testCases := []struct {
+		layout etc2.PixelLayout
+		src    image.Image
+		pixels func(x int, y int) []byte
+	}{{
+		etc2.PixelLayoutRGBA8, nrgba,
+		func(x int, y int) []byte { c := nrgba.NRGBAAt(x, y); return []byte{c.R, c.G, c.B, c.A} },
+	}, {
+		etc2.PixelLayoutRG8, rg,
+		func(x int, y int) []byte { c := rg.NRGBAAt(x, y); return []byte{c.R, c.G} },
+	}, {
+		etc2.PixelLayoutGray8, gray,
+		func(x int, y int) []byte { return []byte{gray.GrayAt(x, y).Y} },
+	}}
+
+	for _, tc := range testCases {
+		// Pad each row with 3 junk bytes, to check that stride is honored.
+		stride := (width * tc.layout.BytesPerPixel()) + 3
+		pix := []byte(nil)
+		for y := range height {
+			for x := range width {
+				pix = append(pix, tc.pixels(x, y)...)
+			}
+			if y < (height - 1) {
+				pix = append(pix, 0xAA, 0xBB, 0xCC)
+			}
+		}
+
+		for _, f := range []etc2.Format{etc2.FormatETC2RGBA8, etc2.FormatETC2RGB, etc2.FormatETC2RG11Unsigned, etc2.FormatETC2R11Unsigned} {
+			want := &bytes.Buffer{}
+			if err := etc2.Encode(want, tc.src, f, nil); err != nil {
+				tt.Fatalf("layout=%d, f=%v: Encode: %v", tc.layout, f, err)
+			}
+			got := &bytes.Buffer{}
+			if err := etc2.EncodeFromBytes(got, pix, tc.layout, width, height, stride, f, nil); err != nil {
+				tt.Fatalf("layout=%d, f=%v: EncodeFromBytes: %v", tc.layout, f, err)
+			} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				tt.Fatalf("layout=%d, f=%v: EncodeFromBytes and Encode differ", tc.layout, f)
+			}
+		}
+
+		if err := etc2.EncodeFromBytes(io.Discard, pix[:len(pix)-1], tc.layout, width, height, stride, etc2.FormatETC2RGB, nil); err != etc2.ErrBadArgument {
+			tt.Fatalf("layout=%d: short pix: got %v, want %v", tc.layout, err, etc2.ErrBadArgument)
+		}
+	}
+}
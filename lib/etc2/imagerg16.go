@@ -0,0 +1,108 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"image/color"
+)
+
+// ImageRG16 is an in-memory image whose At method returns color.RGBA64
+// values, like an *image.RGBA64, but that only stores the red and green
+// channels. Blue is always 0x0000 and alpha is always 0xFFFF, as the RG11
+// formats decode to. It uses half the memory of an *image.RGBA64.
+//
+// It is what NewImageWithOptions returns for the RG11 formats when
+// DecodeOptions.RG16 is set.
+type ImageRG16 struct {
+	// Pix holds the image's pixels, as interleaved red and green values in
+	// big-endian order. The pixel at (x, y) starts at Pix[(y-Rect.Min.Y)*Stride
+	// + (x-Rect.Min.X)*4].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// NewImageRG16 returns a new ImageRG16 with the given bounds.
+func NewImageRG16(r image.Rectangle) *ImageRG16 {
+	w, h := r.Dx(), r.Dy()
+	if (w <= 0) || (h <= 0) {
+		return &ImageRG16{Rect: r}
+	}
+	return &ImageRG16{
+		Pix:    make([]uint8, 4*w*h),
+		Stride: 4 * w,
+		Rect:   r,
+	}
+}
+
+func (m *ImageRG16) ColorModel() color.Model { return color.RGBA64Model }
+
+func (m *ImageRG16) Bounds() image.Rectangle { return m.Rect }
+
+func (m *ImageRG16) At(x int, y int) color.Color {
+	return m.RGBA64At(x, y)
+}
+
+func (m *ImageRG16) RGBA64At(x int, y int) color.RGBA64 {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return color.RGBA64{}
+	}
+	i := m.PixOffset(x, y)
+	s := m.Pix[i : i+4 : i+4]
+	return color.RGBA64{
+		R: (uint16(s[0]) << 8) | uint16(s[1]),
+		G: (uint16(s[2]) << 8) | uint16(s[3]),
+		B: 0x0000,
+		A: 0xFFFF,
+	}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (m *ImageRG16) PixOffset(x int, y int) int {
+	return ((y - m.Rect.Min.Y) * m.Stride) + ((x - m.Rect.Min.X) * 4)
+}
+
+// SetRG16 sets the pixel at (x, y) to the red and green values r and g.
+func (m *ImageRG16) SetRG16(x int, y int, r uint16, g uint16) {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return
+	}
+	i := m.PixOffset(x, y)
+	s := m.Pix[i : i+4 : i+4]
+	s[0] = uint8(r >> 8)
+	s[1] = uint8(r >> 0)
+	s[2] = uint8(g >> 8)
+	s[3] = uint8(g >> 0)
+}
+
+// SubImage returns an image representing the portion of the image m visible
+// through r. The returned value shares pixels with the original image.
+func (m *ImageRG16) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(m.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be
+	// inside either r1 or r2 if the intersection is empty. Without explicitly
+	// checking for this, the Pix[i:] expression below can panic.
+	if r.Empty() {
+		return &ImageRG16{}
+	}
+	i := m.PixOffset(r.Min.X, r.Min.Y)
+	return &ImageRG16{
+		Pix:    m.Pix[i:],
+		Stride: m.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque, which
+// an ImageRG16 always is.
+func (m *ImageRG16) Opaque() bool { return true }
@@ -0,0 +1,101 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"sync"
+)
+
+// This file holds the encoder's hottest loops, which compute weighted squared
+// errors. Each has a pure Go implementation (the "Generic" suffix) and, on
+// some architectures, an assembly one. The "purego" build tag forces the pure
+// Go versions.
+
+// halfBlock holds one half block's pixels and one table's four candidate
+// colors, laid out for halfBlockLosses. The assembly code depends on the
+// field offsets.
+type halfBlock struct {
+	// orig holds the 8 source pixels' red, green and blue values.
+	orig [3][8]int32
+
+	// cand holds the 4 candidate colors, in scramble order. Each is red,
+	// green, blue and then padding.
+	cand [4][4]int32
+
+	// bestK and bestLoss are outputs: each pixel's best candidate (an index
+	// into cand) and that candidate's weighted squared error. Ties pick the
+	// lowest index.
+	bestK    [8]int32
+	bestLoss [8]int32
+}
+
+// halfBlockCandidates[table][base] holds the four values (in scramble order)
+// that an 8-bit base value and a table's modifiers produce, after clamping.
+// Each is the same for every channel.
+//
+// weightedSquares[channel][255+d] is weightValuesI32[channel] * d * d.
+//
+// Both are initialized by halfBlockTablesOnce, so that the half-block search
+// does no clamping or multiplying per candidate.
+var (
+	halfBlockCandidates [8][256][4]int32
+	weightedSquares     [3][511]int32
+	halfBlockTablesOnce sync.Once
+)
+
+func initHalfBlockTables() {
+	for table := range halfBlockCandidates {
+		for base := range halfBlockCandidates[table] {
+			for k, j := range scramble {
+				v := clamp[1023&(uint32(base)+modifiers[table][j])]
+				halfBlockCandidates[table][base][k] = int32(v)
+			}
+		}
+	}
+	for channel := range weightedSquares {
+		for i := range weightedSquares[channel] {
+			d := int32(i) - 255
+			weightedSquares[channel][i] = weightValuesI32[channel] * d * d
+		}
+	}
+}
+
+func halfBlockLossesGeneric(h *halfBlock) {
+	for i := range 8 {
+		o0 := 255 - h.orig[0][i]
+		o1 := 255 - h.orig[1][i]
+		o2 := 255 - h.orig[2][i]
+		bestK, bestLoss := int32(0), maxInt32
+		for k := range h.cand {
+			loss := 0 +
+				weightedSquares[0][h.cand[k][0]+o0] +
+				weightedSquares[1][h.cand[k][1]+o1] +
+				weightedSquares[2][h.cand[k][2]+o2]
+			if bestLoss > loss {
+				bestK, bestLoss = int32(k), loss
+			}
+		}
+		h.bestK[i], h.bestLoss[i] = bestK, bestLoss
+	}
+}
+
+// blockLossGeneric returns the weighted squared error, over red, green and
+// blue, between all 16 pixels of two blocks in makeExtract's color layout.
+func blockLossGeneric(pixels *[64]byte, work *[64]byte) (loss int32) {
+	for i := 0; i < 64; i += 4 {
+		d0 := int32(pixels[i+0]) - int32(work[i+0])
+		d1 := int32(pixels[i+1]) - int32(work[i+1])
+		d2 := int32(pixels[i+2]) - int32(work[i+2])
+		loss += 0 +
+			(weightValuesI32[0] * d0 * d0) +
+			(weightValuesI32[1] * d1 * d1) +
+			(weightValuesI32[2] * d2 * d2)
+	}
+	return loss
+}
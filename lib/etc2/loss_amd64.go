@@ -0,0 +1,59 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build amd64 && !purego
+
+package etc2
+
+// useAVX2 is whether the CPU and OS support the AVX2 instructions.
+var useAVX2 = hasAVX2()
+
+func hasAVX2() bool {
+	maxLeaf, _, _, _ := cpuid(0, 0)
+	if maxLeaf < 7 {
+		return false
+	}
+	// CPUID.1:ECX bit 27 is OSXSAVE and bit 28 is AVX.
+	if _, _, ecx1, _ := cpuid(1, 0); (ecx1 & (1<<27 | 1<<28)) != (1<<27 | 1<<28) {
+		return false
+	}
+	// XCR0 bits 1 and 2 are whether the OS saves the XMM and YMM registers.
+	if xcr0, _ := xgetbv(); (xcr0 & 6) != 6 {
+		return false
+	}
+	// CPUID.7.0:EBX bit 5 is AVX2.
+	_, ebx7, _, _ := cpuid(7, 0)
+	return (ebx7 & (1 << 5)) != 0
+}
+
+func halfBlockLosses(h *halfBlock) {
+	if useAVX2 {
+		halfBlockLossesAVX2(h)
+	} else {
+		halfBlockLossesGeneric(h)
+	}
+}
+
+func blockLoss(pixels *[64]byte, work *[64]byte) int32 {
+	if useAVX2 {
+		return blockLossAVX2(pixels, work)
+	}
+	return blockLossGeneric(pixels, work)
+}
+
+// The functions below are implemented in loss_amd64.s.
+
+func cpuid(eaxArg uint32, ecxArg uint32) (eax uint32, ebx uint32, ecx uint32, edx uint32)
+
+func xgetbv() (eax uint32, edx uint32)
+
+//go:noescape
+func halfBlockLossesAVX2(h *halfBlock)
+
+//go:noescape
+func blockLossAVX2(pixels *[64]byte, work *[64]byte) (loss int32)
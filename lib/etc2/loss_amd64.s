@@ -0,0 +1,139 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// The red, green and blue weights are weightValuesI32. Alpha has zero weight.
+DATA weights<>+0x00(SB)/4, $299
+DATA weights<>+0x04(SB)/4, $587
+DATA weights<>+0x08(SB)/4, $114
+DATA weights<>+0x0C(SB)/4, $0
+DATA weights<>+0x10(SB)/4, $299
+DATA weights<>+0x14(SB)/4, $587
+DATA weights<>+0x18(SB)/4, $114
+DATA weights<>+0x1C(SB)/4, $0
+GLOBL weights<>(SB), RODATA|NOPTR, $32
+
+// func cpuid(eaxArg uint32, ecxArg uint32) (eax uint32, ebx uint32, ecx uint32, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax uint32, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
+
+// CANDIDATE_LOSS sets Y3 to the weighted squared error, for each of the 8
+// pixels (whose red, green and blue are in Y0, Y1 and Y2), of the candidate
+// color at the given byte offsets in SI. It clobbers Y4.
+#define CANDIDATE_LOSS(r, g, b) \
+	VPBROADCASTD r(SI), Y3 \
+	VPSUBD       Y0, Y3, Y3 \
+	VPMULLD      Y3, Y3, Y3 \
+	VPMULLD      Y13, Y3, Y3 \
+	VPBROADCASTD g(SI), Y4 \
+	VPSUBD       Y1, Y4, Y4 \
+	VPMULLD      Y4, Y4, Y4 \
+	VPMULLD      Y14, Y4, Y4 \
+	VPADDD       Y4, Y3, Y3 \
+	VPBROADCASTD b(SI), Y4 \
+	VPSUBD       Y2, Y4, Y4 \
+	VPMULLD      Y4, Y4, Y4 \
+	VPMULLD      Y15, Y4, Y4 \
+	VPADDD       Y4, Y3, Y3
+
+// KEEP_BEST updates the best losses (Y8) and best candidate indexes (Y9) with
+// the candidate losses in Y3, whose index is k. Like the pure Go code, it
+// only replaces on a strictly lower loss. It clobbers Y10 and Y11.
+#define KEEP_BEST(k) \
+	MOVL         $k, AX \
+	VMOVD        AX, X11 \
+	VPBROADCASTD X11, Y11 \
+	VPCMPGTD     Y3, Y8, Y10 \
+	VPBLENDVB    Y10, Y3, Y8, Y8 \
+	VPBLENDVB    Y10, Y11, Y9, Y9
+
+// func halfBlockLossesAVX2(h *halfBlock)
+//
+// The halfBlock's orig field is at offset 0, cand at 96, bestK at 160 and
+// bestLoss at 192.
+TEXT ·halfBlockLossesAVX2(SB), NOSPLIT, $0-8
+	MOVQ h+0(FP), SI
+
+	VMOVDQU      0(SI), Y0
+	VMOVDQU      32(SI), Y1
+	VMOVDQU      64(SI), Y2
+	VPBROADCASTD weights<>+0x00(SB), Y13
+	VPBROADCASTD weights<>+0x04(SB), Y14
+	VPBROADCASTD weights<>+0x08(SB), Y15
+
+	CANDIDATE_LOSS(96, 100, 104)
+	VMOVDQU Y3, Y8
+	VPXOR   Y9, Y9, Y9
+
+	CANDIDATE_LOSS(112, 116, 120)
+	KEEP_BEST(1)
+	CANDIDATE_LOSS(128, 132, 136)
+	KEEP_BEST(2)
+	CANDIDATE_LOSS(144, 148, 152)
+	KEEP_BEST(3)
+
+	VMOVDQU Y9, 160(SI)
+	VMOVDQU Y8, 192(SI)
+	VZEROUPPER
+	RET
+
+// BLOCK_LOSS_2 adds the weighted squared error of the two pixels at the given
+// byte offset of SI and DI to Y4. It clobbers Y0 and Y1.
+#define BLOCK_LOSS_2(off) \
+	VPMOVZXBD off(SI), Y0 \
+	VPMOVZXBD off(DI), Y1 \
+	VPSUBD    Y1, Y0, Y0 \
+	VPMULLD   Y0, Y0, Y0 \
+	VPMULLD   Y5, Y0, Y0 \
+	VPADDD    Y0, Y4, Y4
+
+// func blockLossAVX2(pixels *[64]byte, work *[64]byte) (loss int32)
+TEXT ·blockLossAVX2(SB), NOSPLIT, $0-20
+	MOVQ pixels+0(FP), SI
+	MOVQ work+8(FP), DI
+
+	VMOVDQU weights<>(SB), Y5
+	VPXOR   Y4, Y4, Y4
+	BLOCK_LOSS_2(0)
+	BLOCK_LOSS_2(8)
+	BLOCK_LOSS_2(16)
+	BLOCK_LOSS_2(24)
+	BLOCK_LOSS_2(32)
+	BLOCK_LOSS_2(40)
+	BLOCK_LOSS_2(48)
+	BLOCK_LOSS_2(56)
+
+	// Sum the 8 lanes.
+	VEXTRACTI128 $1, Y4, X0
+	VPADDD       X0, X4, X4
+	VPSHUFD      $0x4E, X4, X0
+	VPADDD       X0, X4, X4
+	VPSHUFD      $0xB1, X4, X0
+	VPADDD       X0, X4, X4
+	VMOVD        X4, AX
+	VZEROUPPER
+	MOVL         AX, loss+16(FP)
+	RET
@@ -0,0 +1,160 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build amd64 && !purego
+
+package etc2
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestHalfBlockLossesAVX2(tt *testing.T) {
+	if !useAVX2 {
+		tt.Skip("AVX2 is not available")
+	}
+	halfBlockTablesOnce.Do(initHalfBlockTables)
+
+	fill := func(h *halfBlock, orig int32, cand int32) {
+		for c := range h.orig {
+			for i := range h.orig[c] {
+				h.orig[c][i] = orig
+			}
+		}
+		for k := range h.cand {
+			for c := range 3 {
+				h.cand[k][c] = cand
+			}
+		}
+	}
+
+	type testCase struct {
+		name string
+		h    halfBlock
+	}
+	testCases := []testCase{}
+	for _, tc := range []struct {
+		name       string
+		orig, cand int32
+	}{
+		{"all0", 0, 0},
+		{"all255", 255, 255},
+		{"orig0cand255", 0, 255},
+		{"orig255cand0", 255, 0},
+	} {
+		testCases = append(testCases, testCase{name: tc.name})
+		fill(&testCases[len(testCases)-1].h, tc.orig, tc.cand)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for n := range 1000 {
+		h := halfBlock{}
+		for c := range h.orig {
+			for i := range h.orig[c] {
+				h.orig[c][i] = rng.Int31n(256)
+			}
+		}
+		for k := range h.cand {
+			for c := range 3 {
+				// Every fourth case uses only the extremes, so that ties and
+				// maximal deltas are common.
+				if (n & 3) == 0 {
+					h.cand[k][c] = 255 * rng.Int31n(2)
+				} else {
+					h.cand[k][c] = rng.Int31n(256)
+				}
+			}
+		}
+		testCases = append(testCases, testCase{name: "random", h: h})
+	}
+
+	for i, tc := range testCases {
+		want, got := tc.h, tc.h
+		halfBlockLossesGeneric(&want)
+		halfBlockLossesAVX2(&got)
+		if got.bestK != want.bestK {
+			tt.Fatalf("i=%d (%s): bestK: got %v, want %v", i, tc.name, got.bestK, want.bestK)
+		}
+		if got.bestLoss != want.bestLoss {
+			tt.Fatalf("i=%d (%s): bestLoss: got %v, want %v", i, tc.name, got.bestLoss, want.bestLoss)
+		}
+	}
+}
+
+func TestBlockLossAVX2(tt *testing.T) {
+	if !useAVX2 {
+		tt.Skip("AVX2 is not available")
+	}
+
+	testCases := [][2][64]byte{}
+	for _, pair := range [][2]byte{{0, 0}, {255, 255}, {0, 255}, {255, 0}} {
+		tc := [2][64]byte{}
+		for i := range 64 {
+			tc[0][i], tc[1][i] = pair[0], pair[1]
+		}
+		testCases = append(testCases, tc)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for n := range 1000 {
+		tc := [2][64]byte{}
+		for i := range 64 {
+			if (n & 3) == 0 {
+				tc[0][i], tc[1][i] = byte(255*rng.Intn(2)), byte(255*rng.Intn(2))
+			} else {
+				tc[0][i], tc[1][i] = byte(rng.Intn(256)), byte(rng.Intn(256))
+			}
+		}
+		testCases = append(testCases, tc)
+	}
+
+	for i, tc := range testCases {
+		want := blockLossGeneric(&tc[0], &tc[1])
+		got := blockLossAVX2(&tc[0], &tc[1])
+		if got != want {
+			tt.Fatalf("i=%d: got %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestLossAVX2Weights(tt *testing.T) {
+	if !useAVX2 {
+		tt.Skip("AVX2 is not available")
+	}
+	halfBlockTablesOnce.Do(initHalfBlockTables)
+
+	// A delta of 1 in one channel of one pixel isolates that channel's
+	// weight, which loss_amd64.s hard-codes in its DATA section.
+	for c := range 4 {
+		want := int32(0)
+		if c < 3 {
+			want = weightValuesI32[c]
+		}
+		for i := 0; i < 64; i += 4 {
+			pixels, work := [64]byte{}, [64]byte{}
+			pixels[i+c] = 1
+			if got := blockLossAVX2(&pixels, &work); got != want {
+				tt.Fatalf("blockLossAVX2: channel %d, pixel %d: got %d, want %d", c, i/4, got, want)
+			}
+		}
+
+		if c == 3 {
+			// halfBlock has no padding channel in orig.
+			continue
+		}
+		h := halfBlock{}
+		for k := range h.cand {
+			h.cand[k][c] = 1
+		}
+		halfBlockLossesAVX2(&h)
+		if got := h.bestLoss[0]; got != want {
+			tt.Fatalf("halfBlockLossesAVX2: channel %d: got %d, want %d", c, got, want)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !amd64 || purego
+
+package etc2
+
+func halfBlockLosses(h *halfBlock) {
+	halfBlockLossesGeneric(h)
+}
+
+func blockLoss(pixels *[64]byte, work *[64]byte) int32 {
+	return blockLossGeneric(pixels, work)
+}
@@ -0,0 +1,154 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"io"
+)
+
+// MergePayloads writes to dst a payload that, for each block, holds whichever
+// of the a and b payloads' blocks is closer to src. a and b are two encodings
+// (in the format f) of the whole of src, such as a fast encoding and a slower,
+// higher quality one. Merging lets an already-shipped asset be upgraded
+// incrementally, a block at a time.
+//
+// Closeness is the sum of squared errors per pixel, with red, green and blue
+// weighted as the encoder does. Alpha also counts for the formats that have
+// it. Ties pick the block from a.
+//
+// options may be nil, which means to use the default configuration. It should
+// match the options used to produce a and b. Only the fields that affect how
+// src's pixels are read (NormalMapping, Transform, Background, CompatETCPACK
+// and Compatibility) are used.
+//
+// It returns the number of blocks taken from b.
+func MergePayloads(dst io.Writer, src image.Image, f Format, a []byte, b []byte, options *EncodeOptions) (numBlocksFromB int, retErr error) {
+	if (dst == nil) || (src == nil) || (f.ETCVersion() == 0) {
+		return 0, ErrBadArgument
+	} else if !options.normalMappingIsValid(f) {
+		return 0, ErrBadArgument
+	}
+	r := src.Bounds()
+	if (r.Dx() > 65532) || (r.Dy() > 65532) {
+		return 0, ErrImageIsTooLarge
+	}
+
+	// Strip the sRGB bit, which does not affect decoding.
+	f &^= formatBitSRGBColorSpace
+
+	n := f.PayloadSize(BlocksWide(r.Dx()), BlocksHigh(r.Dy()))
+	if (int64(len(a)) != n) || (int64(len(b)) != n) {
+		return 0, ErrBadArgument
+	}
+
+	bytesPerBlock := f.BytesPerBlock()
+	pixels, work := [64]byte{}, [64]byte{}
+	extract := f.makeExtract(&pixels, src, r, options)
+	buf := make([]byte, 0, defaultWriteBufferSize)
+
+	for blockY := r.Min.Y; blockY < r.Max.Y; blockY += 4 {
+		for blockX := r.Min.X; blockX < r.Max.X; blockX += 4 {
+			extract(blockX, blockY)
+			codeA, codeB := a[:bytesPerBlock], b[:bytesPerBlock]
+			a, b = a[bytesPerBlock:], b[bytesPerBlock:]
+
+			f.decodeRaw(&work, codeA)
+			lossA := f.blockLoss(&pixels, &work)
+			f.decodeRaw(&work, codeB)
+			lossB := f.blockLoss(&pixels, &work)
+			if lossB < lossA {
+				buf = append(buf, codeB...)
+				numBlocksFromB++
+			} else {
+				buf = append(buf, codeA...)
+			}
+
+			if (len(buf) + bytesPerBlock) > cap(buf) {
+				if _, err := dst.Write(buf); err != nil {
+					return numBlocksFromB, err
+				}
+				buf = buf[:0]
+			}
+		}
+	}
+
+	if len(buf) > 0 {
+		if _, err := dst.Write(buf); err != nil {
+			return numBlocksFromB, err
+		}
+	}
+	return numBlocksFromB, nil
+}
+
+// decodeRaw decodes one block's code into work, using the same layout as
+// makeExtract. f must not have its sRGB bit set.
+func (f Format) decodeRaw(work *[64]byte, code []byte) {
+	code0 := readU64BE(code[0:])
+	switch f {
+	case FormatETC1S, FormatETC1, FormatETC2RGB:
+		decodeColor(work, code0, false)
+	case FormatETC2RGBA1:
+		decodeColor(work, code0, true)
+	case FormatETC2RGBA8:
+		decodeColor(work, readU64BE(code[8:]), false)
+		decodeAlpha(work, code0)
+	case FormatETC2R11Unsigned:
+		decode11u(work, 0x00, code0)
+	case FormatETC2R11Signed:
+		decode11s(work, 0x00, code0)
+	case FormatETC2RG11Unsigned:
+		decode11u(work, 0x00, code0)
+		decode11u(work, 0x20, readU64BE(code[8:]))
+	case FormatETC2RG11Signed:
+		decode11s(work, 0x00, code0)
+		decode11s(work, 0x20, readU64BE(code[8:]))
+	}
+}
+
+// blockLoss returns the sum of squared errors between a block's source pixels
+// and its decoded work pixels, both in makeExtract's layout. f must not have
+// its sRGB bit set.
+func (f Format) blockLoss(pixels *[64]byte, work *[64]byte) (loss uint64) {
+	if (f & formatBitDepth11) != 0 {
+		numChannels := 1
+		if (f & formatBitDepth11TwoChannel) != 0 {
+			numChannels = 2
+		}
+		for c := range numChannels {
+			for i := range 16 {
+				j := (0x20 * c) + (2 * i)
+				p := (int64(pixels[j+0]) << 8) | int64(pixels[j+1])
+				w := (int64(work[j+0]) << 8) | int64(work[j+1])
+				loss += uint64((p - w) * (p - w))
+			}
+		}
+		return loss
+	}
+
+	hasAlpha := (f == FormatETC2RGBA1) || (f == FormatETC2RGBA8)
+	for i := range 16 {
+		j := 4 * i
+		if hasAlpha {
+			// Alpha is weighted like the sum of the RGB weights, i.e. like
+			// luma. For one-bit alpha, the colors of transparent source
+			// pixels do not matter.
+			d := int64(pixels[j+3]) - int64(work[j+3])
+			loss += uint64(1000 * d * d)
+			if (f == FormatETC2RGBA1) && (pixels[j+3] < 0x80) {
+				continue
+			}
+		}
+		for c := range 3 {
+			d := int64(pixels[j+c]) - int64(work[j+c])
+			loss += uint64(int64(weightValuesI32[c]) * d * d)
+		}
+	}
+	return loss
+}
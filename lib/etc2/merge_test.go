@@ -0,0 +1,83 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestMergePayloads(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	// Crop the image, to keep the test fast.
+	srcImage = srcImage.(etc2.SubsettableImage).SubImage(image.Rect(20, 16, 60, 40))
+	b := srcImage.Bounds()
+
+	// corrupted returns a copy of srcImage whose left or right half has
+	// inverted colors.
+	corrupted := func(left bool) image.Image {
+		m := image.NewNRGBA(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(srcImage.At(x, y)).(color.NRGBA)
+				if (x < (b.Min.X + (b.Dx() / 2))) == left {
+					c.R, c.G, c.B = ^c.R, ^c.G, ^c.B
+				}
+				m.SetNRGBA(x, y, c)
+			}
+		}
+		return m
+	}
+
+	encode := func(m image.Image, f etc2.Format) []byte {
+		buf := &bytes.Buffer{}
+		if err := etc2.Encode(buf, m, f, nil); err != nil {
+			tt.Fatalf("f=%v: etc2.Encode: %v", f, err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, f := range []etc2.Format{etc2.FormatETC2RGB, etc2.FormatETC2RGBA8, etc2.FormatETC2R11Unsigned} {
+		want := encode(srcImage, f)
+		a := encode(corrupted(true), f)
+		bb := encode(corrupted(false), f)
+
+		// Each block is good in one of a and bb, so merging should recover
+		// the encoding of the uncorrupted image.
+		got := &bytes.Buffer{}
+		numBlocksFromB, err := etc2.MergePayloads(got, srcImage, f, a, bb, nil)
+		if err != nil {
+			tt.Fatalf("f=%v: MergePayloads: %v", f, err)
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			tt.Errorf("f=%v: merged payload differs from the uncorrupted encoding", f)
+		}
+		if wantN := etc2.BlocksWide(b.Dx()) * etc2.BlocksHigh(b.Dy()) / 2; numBlocksFromB != wantN {
+			tt.Errorf("f=%v: numBlocksFromB: got %d, want %d", f, numBlocksFromB, wantN)
+		}
+
+		if _, err := etc2.MergePayloads(io.Discard, srcImage, f, a, bb[1:], nil); err != etc2.ErrBadArgument {
+			tt.Errorf("f=%v: mismatched lengths: got %v, want %v", f, err, etc2.ErrBadArgument)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+// This file holds the color block modes' searches, as encodeColor runs them,
+// and the CandidateGenerator extension point for adding other searches.
+
+// CandidateGenerator proposes color codes for the encoder to consider, in
+// addition to those found by its own searches. This is for experimenting with
+// new heuristics without changing this package. See
+// EncodeOptions.CandidateGenerators.
+type CandidateGenerator interface {
+	// AppendCandidates appends zero or more candidate codes for the block to
+	// dst and returns the extended slice.
+	//
+	// pixels holds the block's 16 source pixels, in row-major order, as
+	// non-premultiplied red, green, blue and alpha bytes. f is the format
+	// being encoded, without its sRGB bit. For FormatETC2RGBA8, the codes are
+	// for the block's color half: its alpha half is encoded separately.
+	//
+	// Each code is the block's 8 color bytes, read as a big-endian uint64.
+	// The encoder decodes each code and keeps it only if it is valid for f
+	// (and for any EncodeOptions.ForceMode) and has a lower error than the
+	// best code found so far.
+	AppendCandidates(dst []uint64, pixels *[64]byte, f Format) []uint64
+}
+
+// colorMode is a search over one color block mode's (or one group of modes')
+// codes, such as the planar mode's. encodeColor runs each applicable
+// colorMode's search in turn, keeping the best code found.
+type colorMode interface {
+	// search returns the mode's best code for e.pixels. If harder, and the
+	// mode has a harder search, it searches more thoroughly (and slowly).
+	search(e *encoder, harder bool) uint64
+
+	// hasHarderSearch returns whether the harder argument to search makes a
+	// difference.
+	hasHarderSearch() bool
+
+	// oneBitAlpha returns whether search's codes are to be decoded (and their
+	// error measured) as FormatETC2RGBA1 codes.
+	oneBitAlpha() bool
+}
+
+// individualDifferentialMode searches the individual and differential modes,
+// for formats without punch-through alpha.
+type individualDifferentialMode struct {
+	reduce reduceFunc
+	etc1s  bool
+}
+
+func (m individualDifferentialMode) search(e *encoder, harder bool) uint64 {
+	return e.encodeRGBSansAlpha(m.reduce, m.etc1s)
+}
+
+func (m individualDifferentialMode) hasHarderSearch() bool { return false }
+func (m individualDifferentialMode) oneBitAlpha() bool     { return false }
+
+// punchThroughDifferentialMode searches the differential mode, for
+// FormatETC2RGBA1. If transparent, the codes can make pixels transparent.
+type punchThroughDifferentialMode struct {
+	transparent bool
+}
+
+func (m punchThroughDifferentialMode) search(e *encoder, harder bool) uint64 {
+	return e.encodeRGBWithAlpha(m.transparent)
+}
+
+func (m punchThroughDifferentialMode) hasHarderSearch() bool { return false }
+func (m punchThroughDifferentialMode) oneBitAlpha() bool     { return true }
+
+// planarMode searches the planar mode.
+type planarMode struct {
+	decodeAsOneBitAlpha bool
+}
+
+func (m planarMode) search(e *encoder, harder bool) uint64 {
+	return e.encodePlanar()
+}
+
+func (m planarMode) hasHarderSearch() bool { return false }
+func (m planarMode) oneBitAlpha() bool     { return m.decodeAsOneBitAlpha }
+
+// tMode searches the T mode.
+type tMode struct {
+	decodeAsOneBitAlpha bool
+}
+
+func (m tMode) search(e *encoder, harder bool) uint64 {
+	return e.encodeT(m.decodeAsOneBitAlpha, harder)
+}
+
+func (m tMode) hasHarderSearch() bool { return true }
+func (m tMode) oneBitAlpha() bool     { return m.decodeAsOneBitAlpha }
+
+// hMode searches the H mode.
+type hMode struct {
+	decodeAsOneBitAlpha bool
+}
+
+func (m hMode) search(e *encoder, harder bool) uint64 {
+	return e.encodeH(m.decodeAsOneBitAlpha, harder)
+}
+
+func (m hMode) hasHarderSearch() bool { return true }
+func (m hMode) oneBitAlpha() bool     { return m.decodeAsOneBitAlpha }
+
+// The colorModePhases results. The order of each phase's modes matters: it
+// matches ETCPACK's, so that ties are broken the same way.
+var (
+	etc1sColorModePhases = [][]colorMode{{
+		individualDifferentialMode{reduceAverage, true},
+	}}
+
+	etc1ColorModePhases = [][]colorMode{{
+		individualDifferentialMode{reduceAverage, false},
+		individualDifferentialMode{reduceQuantize, false},
+	}}
+
+	etc2ColorModePhases = [][]colorMode{{
+		individualDifferentialMode{reduceAverage, false},
+		individualDifferentialMode{reduceQuantize, false},
+		planarMode{false},
+		tMode{false},
+		hMode{false},
+	}}
+
+	etc2RGBA1ColorModePhases = [][]colorMode{{
+		punchThroughDifferentialMode{true},
+		tMode{true},
+		hMode{true},
+	}, {
+		punchThroughDifferentialMode{false},
+		planarMode{false},
+		tMode{false},
+		hMode{false},
+	}}
+)
+
+// colorModePhases returns the color modes that encodeColor searches for the
+// format f, which must not have its sRGB bit set, in phases. Only
+// FormatETC2RGBA1 has more than one phase: the second phase is skipped for
+// blocks with transparent pixels, as its modes cannot produce them.
+func colorModePhases(f Format) [][]colorMode {
+	switch f {
+	case FormatETC1S:
+		return etc1sColorModePhases
+	case FormatETC1:
+		return etc1ColorModePhases
+	case FormatETC2RGBA1:
+		return etc2RGBA1ColorModePhases
+	}
+	return etc2ColorModePhases
+}
+
+// forcedColorModes returns the color modes that encodeColorForced searches for
+// the format f, which must not have its sRGB bit set, and the mode forceMode,
+// which must be valid for f.
+func forcedColorModes(f Format, forceMode BlockMode) []colorMode {
+	formatIsOneBitAlpha := f == FormatETC2RGBA1
+	switch forceMode {
+	case BlockModeIndividual, BlockModeDifferential:
+		if formatIsOneBitAlpha {
+			return []colorMode{
+				punchThroughDifferentialMode{true},
+				punchThroughDifferentialMode{false},
+			}
+		} else if f == FormatETC1S {
+			return etc1sColorModePhases[0]
+		}
+		return etc1ColorModePhases[0]
+	case BlockModeT:
+		return []colorMode{tMode{formatIsOneBitAlpha}}
+	case BlockModeH:
+		return []colorMode{hMode{formatIsOneBitAlpha}}
+	case BlockModePlanar:
+		return []colorMode{planarMode{formatIsOneBitAlpha}}
+	}
+	return nil
+}
+
+// considerGenerated passes the codes from e.generators' AppendCandidates
+// methods to e.consider, skipping any that are invalid for the format f.
+func (e *encoder) considerGenerated(best *colorCandidate, f Format) {
+	formatIsOneBitAlpha := f == FormatETC2RGBA1
+	for _, g := range e.generators {
+		e.generated = g.AppendCandidates(e.generated[:0], &e.pixels, f)
+		for _, code := range e.generated {
+			if e.isValidColorCode(code, f) {
+				e.consider(best, code, formatIsOneBitAlpha)
+			}
+		}
+	}
+}
+
+// isValidColorCode returns whether code is a valid color code for the format
+// f, which must not have its sRGB bit set, and for e.forceMode.
+func (e *encoder) isValidColorCode(code uint64, f Format) bool {
+	mode := colorBlockMode(code, f == FormatETC2RGBA1)
+	if (e.forceMode != BlockModeNone) && (e.forceMode != mode) {
+		return false
+	}
+	switch f {
+	case FormatETC1S:
+		// ETC1S codes are differential codes with zero deltas.
+		const deltas = (7 << 0x38) | (7 << 0x30) | (7 << 0x28)
+		return (mode == BlockModeDifferential) && ((code & deltas) == 0)
+	case FormatETC1:
+		return (mode == BlockModeIndividual) || (mode == BlockModeDifferential)
+	}
+	return true
+}
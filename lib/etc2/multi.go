@@ -0,0 +1,129 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"io"
+)
+
+// EncodeMulti is like Encode but encodes src in several formats in a single
+// pass, writing the formats[i] output to dsts[i]. Each output is the same as
+// what Encode would produce, but each 4×4 block of src is only read once per
+// distinct pixel layout (color, R11 or RG11) instead of once per format. This
+// suits pipelines that ship both ETC1 fallback and ETC2 primary assets.
+//
+// options may be nil, which means to use the default configuration. Its
+// NormalMapping field applies to the RG11 formats only. Its Preview,
+// NumWorkers, FlushEveryBlockRow, OnCheckpoint, Resume, AlphaCoverage and
+// OnBlock fields are ignored.
+func EncodeMulti(dsts []io.Writer, src image.Image, formats []Format, options *EncodeOptions) error {
+	if (src == nil) || (len(dsts) != len(formats)) {
+		return ErrBadArgument
+	}
+	r := src.Bounds()
+	if (r.Dx() > 65532) || (r.Dy() > 65532) {
+		return ErrImageIsTooLarge
+	}
+	sr, err := options.subRect(r)
+	if err != nil {
+		return err
+	}
+	// NormalMapping applies to the RG11 formats only, and is ignored for the
+	// others, so check it as if for an RG11 format.
+	if !options.normalMappingIsValid(FormatETC2RG11Unsigned) {
+		return ErrBadArgument
+	}
+
+	writeBufferSize := defaultWriteBufferSize
+	if (options != nil) && (options.WriteBufferSize != 0) {
+		writeBufferSize = max(16, options.WriteBufferSize)
+	}
+
+	// extractors holds one extract function per distinct pixel layout.
+	type extractor struct {
+		key     Format
+		pixels  [64]byte
+		extract func(blockX int, blockY int)
+	}
+	extractors := []*extractor(nil)
+
+	type output struct {
+		dst  io.Writer
+		f    Format
+		x    *extractor
+		e    *encoder
+		bufJ int
+	}
+	outputs := make([]output, len(formats))
+
+	for i, f := range formats {
+		if (dsts[i] == nil) || (f.ETCVersion() == 0) {
+			return ErrBadArgument
+		}
+		// Strip the sRGB bit. This encoder treats RGB and sRGB equally.
+		f &^= formatBitSRGBColorSpace
+		if !options.forceModeIsValid(f) {
+			return ErrBadArgument
+		}
+
+		key := f.pixelLayout()
+		x := (*extractor)(nil)
+		for _, xx := range extractors {
+			if xx.key == key {
+				x = xx
+				break
+			}
+		}
+		if x == nil {
+			x = &extractor{key: key}
+			x.extract = f.makeExtract(&x.pixels, src, r, options)
+			extractors = append(extractors, x)
+		}
+
+		outputs[i] = output{
+			dst: dsts[i],
+			f:   f,
+			x:   x,
+			e:   newEncoder(writeBufferSize, options),
+		}
+	}
+
+	for blockY := sr.Min.Y; blockY < sr.Max.Y; blockY += 4 {
+		for blockX := sr.Min.X; blockX < sr.Max.X; blockX += 4 {
+			for _, x := range extractors {
+				x.extract(blockX, blockY)
+			}
+
+			for i := range outputs {
+				o := &outputs[i]
+				o.e.pixels = o.x.pixels
+				o.e.encodeBlock(o.e.buf[o.bufJ:], o.f)
+				o.bufJ += o.f.BytesPerBlock()
+
+				if (o.bufJ + o.f.BytesPerBlock()) > len(o.e.buf) {
+					if _, err := o.dst.Write(o.e.buf[:o.bufJ]); err != nil {
+						return err
+					}
+					o.bufJ = 0
+				}
+			}
+		}
+	}
+
+	for i := range outputs {
+		o := &outputs[i]
+		if o.bufJ > 0 {
+			if _, err := o.dst.Write(o.e.buf[:o.bufJ]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"math"
+)
+
+// NormalMapping is how a normal map's 3D unit vectors are re-parameterized as
+// two channels, for the RG11 formats.
+//
+// Simply dropping the Z channel (and reconstructing it as sqrt(1 - X² - Y²))
+// only works for normals that point away from the surface. These mappings
+// cover the whole sphere and spread the precision more evenly.
+type NormalMapping uint8
+
+const (
+	// NormalMappingNone means that the source's red and green channels are
+	// encoded as is.
+	NormalMappingNone = NormalMapping(0)
+
+	// NormalMappingOctahedral projects the unit sphere onto an octahedron,
+	// which is then unfolded onto the unit square.
+	NormalMappingOctahedral = NormalMapping(1)
+
+	// NormalMappingLongLat maps a normal to its longitude and latitude (also
+	// known as spherical coordinates).
+	NormalMappingLongLat = NormalMapping(2)
+)
+
+// String returns a short name for nm, such as "octahedral", suitable for
+// recording in a container file's key-value metadata.
+func (nm NormalMapping) String() string {
+	switch nm {
+	case NormalMappingNone:
+		return "none"
+	case NormalMappingOctahedral:
+		return "octahedral"
+	case NormalMappingLongLat:
+		return "long-lat"
+	}
+	return "invalid"
+}
+
+func (nm NormalMapping) isValid() bool {
+	return nm <= NormalMappingLongLat
+}
+
+// normalMappingIsValid returns whether options.NormalMapping is valid for the
+// format f: either zero or a valid mapping for an RG11 format. options may be
+// nil.
+func (options *EncodeOptions) normalMappingIsValid(f Format) bool {
+	if (options == nil) || (options.NormalMapping == NormalMappingNone) {
+		return true
+	}
+	return options.NormalMapping.isValid() && ((f & formatBitDepth11TwoChannel) != 0)
+}
+
+// mapNormal converts a normal, given as 16-bit RGB values where 0x0000 means
+// -1 and 0xFFFF means +1, to the two 16-bit channels to encode.
+func (nm NormalMapping) mapNormal(r uint32, g uint32, b uint32) (u uint16, v uint16) {
+	x := (float64(r) / 32767.5) - 1
+	y := (float64(g) / 32767.5) - 1
+	z := (float64(b) / 32767.5) - 1
+
+	fu, fv := 0.5, 0.5
+	switch nm {
+	case NormalMappingOctahedral:
+		if l1 := math.Abs(x) + math.Abs(y) + math.Abs(z); l1 != 0 {
+			x, y, z = x/l1, y/l1, z/l1
+		}
+		if z < 0 {
+			x, y = (1-math.Abs(y))*signum(x), (1-math.Abs(x))*signum(y)
+		}
+		fu = (x * 0.5) + 0.5
+		fv = (y * 0.5) + 0.5
+
+	case NormalMappingLongLat:
+		if l2 := math.Sqrt((x * x) + (y * y) + (z * z)); l2 == 0 {
+			fv = 0
+		} else {
+			fu = (math.Atan2(y, x) / (2 * math.Pi)) + 0.5
+			fv = math.Acos(max(-1, min(+1, z/l2))) / math.Pi
+		}
+
+	default:
+		return uint16(r), uint16(g)
+	}
+
+	return unitToU16(fu), unitToU16(fv)
+}
+
+// unmapNormal is the inverse of mapNormal.
+func (nm NormalMapping) unmapNormal(u uint16, v uint16) (r uint16, g uint16, b uint16) {
+	fu := float64(u) / 0xFFFF
+	fv := float64(v) / 0xFFFF
+
+	x, y, z := 0.0, 0.0, 1.0
+	switch nm {
+	case NormalMappingOctahedral:
+		x = (2 * fu) - 1
+		y = (2 * fv) - 1
+		z = 1 - math.Abs(x) - math.Abs(y)
+		if z < 0 {
+			x, y = (1-math.Abs(y))*signum(x), (1-math.Abs(x))*signum(y)
+		}
+		if l2 := math.Sqrt((x * x) + (y * y) + (z * z)); l2 != 0 {
+			x, y, z = x/l2, y/l2, z/l2
+		}
+
+	case NormalMappingLongLat:
+		theta := (fu - 0.5) * (2 * math.Pi)
+		phi := fv * math.Pi
+		x = math.Sin(phi) * math.Cos(theta)
+		y = math.Sin(phi) * math.Sin(theta)
+		z = math.Cos(phi)
+
+	default:
+		return u, v, 0
+	}
+
+	return unitToU16((x * 0.5) + 0.5), unitToU16((y * 0.5) + 0.5), unitToU16((z * 0.5) + 0.5)
+}
+
+// UnmapNormals converts m, decoded from an RG11 format that was encoded with
+// the nm normal mapping, back to a conventional normal map. Each pixel's red,
+// green and blue channels are set to the X, Y and Z components of the unit
+// normal vector, where 0x0000 means -1 and 0xFFFF means +1.
+//
+// For NormalMappingNone, m is left unchanged.
+func (nm NormalMapping) UnmapNormals(m *image.RGBA64) error {
+	if (m == nil) || !nm.isValid() {
+		return ErrBadArgument
+	} else if nm == NormalMappingNone {
+		return nil
+	}
+
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := m.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x, i = x+1, i+8 {
+			rgba := m.Pix[i : i+8]
+			u := (uint16(rgba[0]) << 8) | uint16(rgba[1])
+			v := (uint16(rgba[2]) << 8) | uint16(rgba[3])
+			nx, ny, nz := nm.unmapNormal(u, v)
+			rgba[0] = uint8(nx >> 8)
+			rgba[1] = uint8(nx >> 0)
+			rgba[2] = uint8(ny >> 8)
+			rgba[3] = uint8(ny >> 0)
+			rgba[4] = uint8(nz >> 8)
+			rgba[5] = uint8(nz >> 0)
+			rgba[6] = 0xFF
+			rgba[7] = 0xFF
+		}
+	}
+	return nil
+}
+
+func signum(x float64) float64 {
+	if x < 0 {
+		return -1
+	}
+	return +1
+}
+
+func unitToU16(x float64) uint16 {
+	return uint16(max(0, min(0xFFFF, (x*0xFFFF)+0.5)))
+}
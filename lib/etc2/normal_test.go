@@ -0,0 +1,88 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestNormalMappingRoundTrip(tt *testing.T) {
+	// The normals cover the whole sphere, including those that point into
+	// the surface (with negative Z), which dropping the Z channel would lose.
+	const size = 32
+	src := image.NewRGBA64(image.Rect(0, 0, size, size))
+	normals := [size][size][3]float64{}
+	for y := range size {
+		for x := range size {
+			theta := ((float64(x) + 0.5) / size) * 2 * math.Pi
+			phi := ((float64(y) + 0.5) / size) * math.Pi
+			n := [3]float64{math.Sin(phi) * math.Cos(theta), math.Sin(phi) * math.Sin(theta), math.Cos(phi)}
+			normals[y][x] = n
+			src.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(math.Round(((n[0] * 0.5) + 0.5) * 0xFFFF)),
+				G: uint16(math.Round(((n[1] * 0.5) + 0.5) * 0xFFFF)),
+				B: uint16(math.Round(((n[2] * 0.5) + 0.5) * 0xFFFF)),
+				A: 0xFFFF,
+			})
+		}
+	}
+
+	for _, nm := range []etc2.NormalMapping{etc2.NormalMappingOctahedral, etc2.NormalMappingLongLat} {
+		options := &etc2.EncodeOptions{NormalMapping: nm}
+		if err := etc2.Encode(io.Discard, src, etc2.FormatETC2RGB, options); err != etc2.ErrBadArgument {
+			tt.Fatalf("nm=%v: RGB: got %v, want %v", nm, err, etc2.ErrBadArgument)
+		}
+
+		encoded := &bytes.Buffer{}
+		if err := etc2.Encode(encoded, src, etc2.FormatETC2RG11Unsigned, options); err != nil {
+			tt.Fatalf("nm=%v: Encode: %v", nm, err)
+		}
+		decoded, err := etc2.DecodeRaw(encoded.Bytes(), etc2.FormatETC2RG11Unsigned, size, size)
+		if err != nil {
+			tt.Fatalf("nm=%v: DecodeRaw: %v", nm, err)
+		}
+		dst := image.NewRGBA64(src.Bounds())
+		draw.Draw(dst, dst.Bounds(), decoded, image.Point{}, draw.Src)
+		if err := nm.UnmapNormals(dst); err != nil {
+			tt.Fatalf("nm=%v: UnmapNormals: %v", nm, err)
+		}
+
+		// Block compression is lossy, especially across the octahedral
+		// mapping's folds, but each reconstructed normal should still be
+		// close to the original, and most should be very close.
+		maxAngle, sumAngles := 0.0, 0.0
+		for y := range size {
+			for x := range size {
+				c := dst.RGBA64At(x, y)
+				got := [3]float64{
+					(float64(c.R) / 32767.5) - 1,
+					(float64(c.G) / 32767.5) - 1,
+					(float64(c.B) / 32767.5) - 1,
+				}
+				want := normals[y][x]
+				dot := (got[0] * want[0]) + (got[1] * want[1]) + (got[2] * want[2])
+				angle := math.Acos(max(-1, min(+1, dot))) * (180 / math.Pi)
+				maxAngle, sumAngles = max(maxAngle, angle), sumAngles+angle
+			}
+		}
+		if maxAngle > 6 {
+			tt.Fatalf("nm=%v: maximum angle: got %.3f degrees, want <= 6", nm, maxAngle)
+		} else if meanAngle := sumAngles / (size * size); meanAngle > 1.5 {
+			tt.Fatalf("nm=%v: mean angle: got %.3f degrees, want <= 1.5", nm, meanAngle)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"math"
+	"sync"
+)
+
+// Metric is an EncodeOptions.Metric error measure.
+type Metric uint8
+
+const (
+	// MetricRGB is the squared error of the red, green and blue values,
+	// weighted 0.299, 0.587 and 0.114, like ETCPACK.
+	MetricRGB Metric = 0
+
+	// MetricOklab is the squared Euclidean distance in the Oklab perceptual
+	// color space, treating the pixel values as sRGB. It tracks perceived
+	// color differences more closely than MetricRGB, especially in hue and
+	// in dark regions, which suits photographic content.
+	MetricOklab Metric = 1
+)
+
+// oklabScale converts squared Oklab distances (whose L ranges over [0, 1]) to
+// the int32 losses compared by the encoder. 16 pixels' worth of the largest
+// possible distance (each under 2) still fits.
+const oklabScale = 1 << 24
+
+// srgbToLinearF32 maps an 8-bit sRGB value to its linear light value, in the
+// range [0, 1]. It is initialized by srgbToLinearF32Once.
+var (
+	srgbToLinearF32     [256]float32
+	srgbToLinearF32Once sync.Once
+)
+
+func initSRGBToLinearF32() {
+	for i := range srgbToLinearF32 {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		srgbToLinearF32[i] = float32(v)
+	}
+}
+
+// toOklab converts an 8-bit sRGB color to Oklab's L, a and b. The matrices
+// are from https://bottosson.github.io/posts/oklab/
+func toOklab(r uint8, g uint8, b uint8) [3]float32 {
+	lr, lg, lb := srgbToLinearF32[r], srgbToLinearF32[g], srgbToLinearF32[b]
+	l := cbrt32((0.4122214708 * lr) + (0.5363325363 * lg) + (0.0514459929 * lb))
+	m := cbrt32((0.2119034982 * lr) + (0.6806995451 * lg) + (0.1073969566 * lb))
+	s := cbrt32((0.0883024619 * lr) + (0.2817188376 * lg) + (0.6299787005 * lb))
+	return [3]float32{
+		(0.2104542553 * l) + (0.7936177850 * m) - (0.0040720468 * s),
+		(1.9779984951 * l) - (2.4285922050 * m) + (0.4505937099 * s),
+		(0.0259040371 * l) + (0.7827717662 * m) - (0.8086757660 * s),
+	}
+}
+
+func cbrt32(x float32) float32 {
+	return float32(math.Cbrt(float64(x)))
+}
+
+// prepareOklab sets e.pixelsOklab from e.pixels. It is called once per block,
+// before the color mode search, so that calculateBlockLossOklab need only
+// convert the candidate codes' pixels.
+func (e *encoder) prepareOklab() {
+	for i := range 16 {
+		e.pixelsOklab[i] = toOklab(e.pixels[(4*i)+0], e.pixels[(4*i)+1], e.pixels[(4*i)+2])
+	}
+}
+
+// calculateBlockLossOklab is like calculateBlockLoss but measures the loss
+// between e.pixelsOklab and e.work with MetricOklab.
+func (e *encoder) calculateBlockLossOklab(formatIsOneBitAlpha bool) int32 {
+	loss := float32(0)
+	for i := range 16 {
+		if formatIsOneBitAlpha && (e.pixels[(4*i)+3] < 0x80) {
+			continue
+		}
+		lab := toOklab(e.work[(4*i)+0], e.work[(4*i)+1], e.work[(4*i)+2])
+		for c := range 3 {
+			d := lab[c] - e.pixelsOklab[i][c]
+			loss += d * d
+		}
+	}
+	return int32(loss * oklabScale)
+}
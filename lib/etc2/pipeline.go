@@ -0,0 +1,185 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"io"
+	"sync"
+)
+
+// pipelineRow is one row of blocks passing through encodeRowsPipelined's
+// stages: its extracted pixels and then its encoded blocks.
+type pipelineRow struct {
+	index  int
+	pixels []byte
+	code   []byte
+}
+
+// encodeRowsPipelined is like the loop at the end of EncodeRect, but split
+// into three stages, connected by channels, so that they overlap:
+//
+//   - one goroutine extracts each row of blocks' pixels from src,
+//   - numWorkers goroutines each encode whole rows of blocks (with a helper
+//     goroutine each, if splitRGBA8Rows) and
+//   - the calling goroutine writes the encoded rows to dst, in order.
+//
+// A fixed number of pipelineRow buffers circulate through the stages, which
+// bounds the memory used, however slow dst is.
+func encodeRowsPipelined(e *encoder, dst io.Writer, src image.Image, r image.Rectangle, sr image.Rectangle, f Format, options *EncodeOptions, alphaLUT *[256]uint8, numWorkers int, writeBufferSize int) error {
+	bytesPerBlock := f.BytesPerBlock()
+	blocksWide := BlocksWide(sr.Dx())
+	bytesPerBlockRow := blocksWide * bytesPerBlock
+	firstBlockRow := options.Resume.BlockRow
+	numBlockRows := BlocksHigh(sr.Dy()) - firstBlockRow
+	flushEveryBlockRow := options.FlushEveryBlockRow || (options.OnCheckpoint != nil)
+
+	free := make(chan *pipelineRow, 2*numWorkers)
+	for range cap(free) {
+		free <- &pipelineRow{
+			pixels: make([]byte, 64*blocksWide),
+			code:   make([]byte, bytesPerBlockRow),
+		}
+	}
+	extracted := make(chan *pipelineRow, numWorkers)
+	encoded := make(chan *pipelineRow, numWorkers)
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	defer close(done)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(extracted)
+		pixels := [64]byte{}
+		extract := applyAlphaLUT(f.makeExtract(&pixels, src, r, options), &pixels, alphaLUT)
+		for i := range numBlockRows {
+			row := (*pipelineRow)(nil)
+			select {
+			case row = <-free:
+			case <-done:
+				return
+			}
+			row.index = i
+			blockY := sr.Min.Y + (4 * (firstBlockRow + i))
+			for j, blockX := 0, sr.Min.X; blockX < sr.Max.X; j, blockX = j+1, blockX+4 {
+				extract(blockX, blockY)
+				copy(row.pixels[64*j:], pixels[:])
+			}
+			select {
+			case extracted <- row:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	split := splitRGBA8Rows(f, numBlockRows, numWorkers)
+	workers := sync.WaitGroup{}
+	for range numWorkers {
+		wg.Add(1)
+		workers.Add(1)
+		go func() {
+			defer wg.Done()
+			defer workers.Done()
+			w, a := newEncoder(0, options), (*encoder)(nil)
+			if split {
+				a = newEncoder(0, options)
+			}
+			preview, onBlock := options.preview(), options.onBlock()
+			for row := range extracted {
+				if split {
+					encodeRGBA8Row(w, a, row.code, row.pixels)
+				}
+				blockY := sr.Min.Y + (4 * (firstBlockRow + row.index))
+				for j, blockX := 0, sr.Min.X; blockX < sr.Max.X; j, blockX = j+1, blockX+4 {
+					copy(w.pixels[:], row.pixels[64*j:])
+					code := row.code[j*bytesPerBlock : (j+1)*bytesPerBlock]
+					if !split {
+						w.encodeBlock(code, f)
+					}
+					if preview != nil {
+						w.writePreview(preview, code, f, blockX, blockY, r)
+					}
+					if onBlock != nil {
+						onBlock(w.blockStats(code, f, (blockX-r.Min.X)/4, (blockY-r.Min.Y)/4))
+					}
+				}
+				select {
+				case encoded <- row:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(encoded)
+	}()
+
+	// The workers can finish rows out of order. pending holds those that
+	// are not yet next in line to be written.
+	e.reset(writeBufferSize, options)
+	bufJ, next, pending := 0, 0, map[int]*pipelineRow{}
+	for row := range encoded {
+		pending[row.index] = row
+		for {
+			row := pending[next]
+			if row == nil {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			for j := 0; j < bytesPerBlockRow; j += bytesPerBlock {
+				bufJ += copy(e.buf[bufJ:], row.code[j:j+bytesPerBlock])
+				if (bufJ + bytesPerBlock) > len(e.buf) {
+					if _, err := dst.Write(e.buf[:bufJ]); err != nil {
+						return err
+					}
+					bufJ = 0
+				}
+			}
+			free <- row
+
+			if flushEveryBlockRow {
+				if bufJ > 0 {
+					if _, err := dst.Write(e.buf[:bufJ]); err != nil {
+						return err
+					}
+					bufJ = 0
+				}
+				if flusher, ok := dst.(interface{ Flush() error }); ok {
+					if err := flusher.Flush(); err != nil {
+						return err
+					}
+				}
+			}
+
+			if options.OnCheckpoint != nil {
+				blockRow := firstBlockRow + next
+				if err := options.OnCheckpoint(Checkpoint{
+					BlockRow: blockRow,
+					Offset:   int64(blockRow) * int64(bytesPerBlockRow),
+				}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if bufJ > 0 {
+		if _, err := dst.Write(e.buf[:bufJ]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
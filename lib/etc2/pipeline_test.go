@@ -0,0 +1,93 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+// writerFunc is an io.Writer implemented by a function.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestEncodePipelined(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+
+	// writeSizes is a non-seekable io.Writer, so that numWorkers > 1
+	// pipelines the encode instead of writing rows at their offsets.
+	type writeSizes struct {
+		bytes.Buffer
+		sizes []int
+	}
+
+	errStop := errors.New("stop")
+	for _, f := range []etc2.Format{etc2.FormatETC1, etc2.FormatETC2RGBA8, etc2.FormatETC2RGBA1} {
+		for _, writeBufferSize := range []int{0, 64} {
+			for _, checkpoint := range []bool{false, true} {
+				results := [2]string{}
+				for i, numWorkers := range []int{1, 4} {
+					w := &writeSizes{}
+					checkpoints := []etc2.Checkpoint(nil)
+					options := &etc2.EncodeOptions{
+						NumWorkers:      numWorkers,
+						WriteBufferSize: writeBufferSize,
+					}
+					if checkpoint {
+						options.OnCheckpoint = func(c etc2.Checkpoint) error {
+							checkpoints = append(checkpoints, c)
+							return nil
+						}
+					}
+					if err := etc2.Encode(writerFunc(func(p []byte) (int, error) {
+						w.sizes = append(w.sizes, len(p))
+						return w.Write(p)
+					}), srcImage, f, options); err != nil {
+						tt.Fatalf("f=%v, numWorkers=%d: Encode: %v", f, numWorkers, err)
+					}
+					results[i] = fmt.Sprintf("%x %v %v", w.Bytes(), w.sizes, checkpoints)
+				}
+				if results[0] != results[1] {
+					tt.Fatalf("f=%v, writeBufferSize=%d, checkpoint=%t: outputs differ", f, writeBufferSize, checkpoint)
+				}
+			}
+		}
+
+		// Returning an error from OnCheckpoint stops the pipeline.
+		numCheckpoints := 0
+		if err := etc2.Encode(io.Discard, srcImage, f, &etc2.EncodeOptions{
+			NumWorkers: 4,
+			OnCheckpoint: func(c etc2.Checkpoint) error {
+				if numCheckpoints++; c.BlockRow == 3 {
+					return errStop
+				}
+				return nil
+			},
+		}); err != errStop {
+			tt.Fatalf("f=%v: got %v, want %v", f, err, errStop)
+		} else if numCheckpoints != 3 {
+			tt.Fatalf("f=%v: numCheckpoints: got %d, want 3", f, numCheckpoints)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrProfileViolation = errors.New("etc2: profile violation")
+
+// Profile describes the texture limits of a target GPU (or class of GPUs).
+type Profile struct {
+	// Name is a human-readable name, used in error messages.
+	Name string
+
+	// MaxETCVersion is the newest ETC version (1 or 2) that the GPU supports.
+	MaxETCVersion int
+
+	// MaxTextureSize is the largest allowed width or height, in pixels. Zero
+	// means no limit.
+	MaxTextureSize int
+
+	// PowerOfTwo is whether the width and height must be powers of two.
+	PowerOfTwo bool
+
+	// PowerOfTwoMipmaps is whether mipmapped textures' width and height must
+	// be powers of two, even if non-mipmapped textures' need not be.
+	PowerOfTwoMipmaps bool
+}
+
+var (
+	// ProfileGLES2 is the OpenGL ES 2.0 minimum, with the
+	// OES_compressed_ETC1_RGB8_texture extension. NPOT (non-power-of-two)
+	// textures are allowed but cannot be mipmapped.
+	ProfileGLES2 = Profile{
+		Name:              "gles2",
+		MaxETCVersion:     1,
+		MaxTextureSize:    2048,
+		PowerOfTwoMipmaps: true,
+	}
+
+	// ProfileGLES3 is the OpenGL ES 3.0 minimum.
+	ProfileGLES3 = Profile{
+		Name:           "gles3",
+		MaxETCVersion:  2,
+		MaxTextureSize: 2048,
+	}
+
+	// ProfileGLES32 is the OpenGL ES 3.2 minimum.
+	ProfileGLES32 = Profile{
+		Name:           "gles3.2",
+		MaxETCVersion:  2,
+		MaxTextureSize: 16384,
+	}
+)
+
+// ParseProfile parses a small profile description: a comma-separated list
+// whose first element is a predefined profile name ("gles2", "gles3" or
+// "gles3.2") and whose optional subsequent elements modify it:
+//
+//   - "max-size=N" sets MaxTextureSize.
+//   - "pot" sets PowerOfTwo.
+//   - "pot-mipmaps" sets PowerOfTwoMipmaps.
+//   - "etc1" sets MaxETCVersion to 1.
+//
+// For example, "gles3,max-size=4096,pot".
+func ParseProfile(s string) (Profile, error) {
+	elems := strings.Split(s, ",")
+
+	p := Profile{}
+	switch elems[0] {
+	case ProfileGLES2.Name:
+		p = ProfileGLES2
+	case ProfileGLES3.Name:
+		p = ProfileGLES3
+	case ProfileGLES32.Name:
+		p = ProfileGLES32
+	default:
+		return Profile{}, ErrBadArgument
+	}
+
+	for _, elem := range elems[1:] {
+		switch elem {
+		case "pot":
+			p.PowerOfTwo = true
+		case "pot-mipmaps":
+			p.PowerOfTwoMipmaps = true
+		case "etc1":
+			p.MaxETCVersion = 1
+		default:
+			value, ok := strings.CutPrefix(elem, "max-size=")
+			if !ok {
+				return Profile{}, ErrBadArgument
+			}
+			n, err := strconv.Atoi(value)
+			if (err != nil) || (n < 0) {
+				return Profile{}, ErrBadArgument
+			}
+			p.MaxTextureSize = n
+		}
+	}
+
+	p.Name = s
+	return p, nil
+}
+
+// Check returns a non-nil error (wrapping ErrProfileViolation) if a texture
+// with the given format, dimensions (in pixels) and number of mipmap levels
+// (including the base level) is not supported by the profile.
+func (p *Profile) Check(f Format, width int, height int, numMipmapLevels int) error {
+	if (width < 0) || (height < 0) || (numMipmapLevels < 1) {
+		return ErrBadArgument
+	}
+
+	if v := f.ETCVersion(); v == 0 {
+		return ErrBadArgument
+	} else if v > p.MaxETCVersion {
+		return p.violation("ETC%d formats are not supported", v)
+	}
+
+	if (p.MaxTextureSize > 0) && ((width > p.MaxTextureSize) || (height > p.MaxTextureSize)) {
+		return p.violation("%d×%d exceeds the maximum texture size %d", width, height, p.MaxTextureSize)
+	}
+
+	if !isPowerOfTwo(width) || !isPowerOfTwo(height) {
+		if p.PowerOfTwo {
+			return p.violation("%d×%d is not a power of two", width, height)
+		} else if p.PowerOfTwoMipmaps && (numMipmapLevels > 1) {
+			return p.violation("%d×%d is not a power of two but is mipmapped", width, height)
+		}
+	}
+
+	maxNumMipmapLevels := 1
+	for n := max(width, height); n > 1; n >>= 1 {
+		maxNumMipmapLevels++
+	}
+	if numMipmapLevels > maxNumMipmapLevels {
+		return p.violation("%d mipmap levels exceeds the %d×%d maximum of %d",
+			numMipmapLevels, width, height, maxNumMipmapLevels)
+	}
+
+	return nil
+}
+
+func (p *Profile) violation(format string, args ...any) error {
+	return fmt.Errorf("%w: %s: "+format, append([]any{ErrProfileViolation, p.Name}, args...)...)
+}
+
+func isPowerOfTwo(n int) bool {
+	return (n > 0) && ((n & (n - 1)) == 0)
+}
@@ -0,0 +1,91 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestProfile(tt *testing.T) {
+	parseTestCases := []struct {
+		s    string
+		want etc2.Profile
+	}{
+		{"gles2", etc2.ProfileGLES2},
+		{"gles3", etc2.ProfileGLES3},
+		{"gles3.2", etc2.ProfileGLES32},
+		{"gles3,max-size=4096,pot", etc2.Profile{
+			Name:           "gles3,max-size=4096,pot",
+			MaxETCVersion:  2,
+			MaxTextureSize: 4096,
+			PowerOfTwo:     true,
+		}},
+		{"gles3.2,etc1,pot-mipmaps,max-size=0", etc2.Profile{
+			Name:              "gles3.2,etc1,pot-mipmaps,max-size=0",
+			MaxETCVersion:     1,
+			PowerOfTwoMipmaps: true,
+		}},
+	}
+	for _, tc := range parseTestCases {
+		if got, err := etc2.ParseProfile(tc.s); err != nil {
+			tt.Fatalf("%q: ParseProfile: %v", tc.s, err)
+		} else if got != tc.want {
+			tt.Fatalf("%q: ParseProfile: got %+v, want %+v", tc.s, got, tc.want)
+		}
+	}
+	for _, s := range []string{"", "gles4", "pot", "gles3,", "gles3,max-size=", "gles3,max-size=-1", "gles3,max-size=1k", "gles3,npot"} {
+		if _, err := etc2.ParseProfile(s); err != etc2.ErrBadArgument {
+			tt.Fatalf("%q: ParseProfile: got %v, want %v", s, err, etc2.ErrBadArgument)
+		}
+	}
+
+	pot, err := etc2.ParseProfile("gles3,pot")
+	if err != nil {
+		tt.Fatalf("ParseProfile: %v", err)
+	}
+	checkTestCases := []struct {
+		p               etc2.Profile
+		f               etc2.Format
+		width, height   int
+		numMipmapLevels int
+		wantViolation   bool
+	}{
+		{etc2.ProfileGLES2, etc2.FormatETC1, 2048, 2048, 12, false},
+		{etc2.ProfileGLES2, etc2.FormatETC2RGB, 64, 64, 1, true},
+		{etc2.ProfileGLES2, etc2.FormatETC1, 4096, 64, 1, true},
+		{etc2.ProfileGLES2, etc2.FormatETC1, 100, 60, 1, false},
+		{etc2.ProfileGLES2, etc2.FormatETC1, 100, 60, 2, true},
+		{etc2.ProfileGLES3, etc2.FormatETC2RGBA8, 100, 60, 7, false},
+		{etc2.ProfileGLES3, etc2.FormatETC2RGBA8, 100, 60, 8, true},
+		{etc2.ProfileGLES3, etc2.FormatETC2RGBA8, 2049, 1, 1, true},
+		{etc2.ProfileGLES32, etc2.FormatETC2RGBA8, 16384, 16384, 15, false},
+		{pot, etc2.FormatETC2RGB, 256, 64, 1, false},
+		{pot, etc2.FormatETC2RGB, 256, 60, 1, true},
+	}
+	for i, tc := range checkTestCases {
+		err := tc.p.Check(tc.f, tc.width, tc.height, tc.numMipmapLevels)
+		if gotViolation := errors.Is(err, etc2.ErrProfileViolation); gotViolation != tc.wantViolation {
+			tt.Fatalf("i=%d: Check: got %v, want violation=%t", i, err, tc.wantViolation)
+		} else if !gotViolation && (err != nil) {
+			tt.Fatalf("i=%d: Check: %v", i, err)
+		} else if gotViolation && !strings.Contains(err.Error(), tc.p.Name) {
+			tt.Fatalf("i=%d: Check: got %q, want it to mention %q", i, err, tc.p.Name)
+		}
+	}
+
+	for _, args := range [][3]int{{-1, 4, 1}, {4, -1, 1}, {4, 4, 0}} {
+		if err := etc2.ProfileGLES3.Check(etc2.FormatETC1, args[0], args[1], args[2]); err != etc2.ErrBadArgument {
+			tt.Fatalf("args=%v: Check: got %v, want %v", args, err, etc2.ErrBadArgument)
+		}
+	}
+}
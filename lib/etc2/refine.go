@@ -0,0 +1,211 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"io"
+	"math"
+	"slices"
+)
+
+// Refinement configures EncodeOptions.Refinement, a second encoding pass that
+// spends extra effort on the blocks that look worst after the first pass,
+// instead of uniformly over the whole image.
+type Refinement struct {
+	// Fraction is the fraction, in the range (0, 1], of blocks to re-encode.
+	// Those with the lowest SSIM (structural similarity, of their 4×4 luma
+	// values) against the source are re-encoded first. For example, 0.05
+	// means the worst 5% of blocks.
+	Fraction float64
+
+	// Quality is the effort level for re-encoding those blocks. The zero
+	// value, QualityDefault, means QualityMax. The re-encoding also runs the
+	// T and H modes' fuller searches on every block, not just on those where
+	// the first pass already picked the T or H mode.
+	Quality Quality
+}
+
+// refinement returns options.Refinement, or nil if it does not apply to the
+// format f, which must not have its sRGB bit set. options may be nil.
+func (options *EncodeOptions) refinement(f Format) *Refinement {
+	if (options == nil) || (options.Refinement == nil) || !(options.Refinement.Fraction > 0) ||
+		((f & formatBitDepth11) != 0) || (options.Compatibility == CompatibilityETCPACK) {
+		return nil
+	}
+	return options.Refinement
+}
+
+// encodeRefined is like the loop at the end of EncodeRect, but runs the
+// refinement pass before writing anything to dst. It encodes to memory first
+// (using the options' NumWorkers), re-encodes the worst blocks and then
+// writes the result, calling any Preview, OnBlock, flush and OnCheckpoint
+// hooks as it goes.
+//
+// Both passes cover every block, even when resuming, so that the same blocks
+// are refined as for a one-shot encode. Only the rows from
+// options.Resume.BlockRow onwards are written.
+func encodeRefined(e *encoder, dst io.Writer, src image.Image, r image.Rectangle, sr image.Rectangle, f Format, options *EncodeOptions, alphaLUT *[256]uint8, writeBufferSize int) error {
+	refinement := options.refinement(f)
+	firstPass := *options
+	firstPass.Refinement = nil
+	firstPass.Preview = nil
+	firstPass.OnBlock = nil
+	firstPass.FlushEveryBlockRow = false
+	firstPass.OnCheckpoint = nil
+	firstPass.WriteBufferSize = 0
+	firstPass.Resume = Checkpoint{}
+	w := appendWriter{}
+	if err := EncodeRect(&w, src, r, f, &firstPass); err != nil {
+		return err
+	}
+	data := w.b
+
+	bytesPerBlock := f.BytesPerBlock()
+	colorOffset := 0
+	if f == FormatETC2RGBA8 {
+		colorOffset = 8
+	}
+	blocksWide := BlocksWide(sr.Dx())
+	firstBlockRow := options.Resume.BlockRow
+	numBlocks := len(data) / bytesPerBlock
+	blockXY := func(k int) (int, int) {
+		return sr.Min.X + (4 * (k % blocksWide)), sr.Min.Y + (4 * (k / blocksWide))
+	}
+
+	e.reset(writeBufferSize, options)
+	extract := applyAlphaLUT(f.makeExtract(&e.pixels, src, r, options), &e.pixels, alphaLUT)
+	oneBitAlpha := f == FormatETC2RGBA1
+
+	// Score every block.
+	scores := make([]float64, numBlocks)
+	order := make([]int, numBlocks)
+	for k := range numBlocks {
+		extract(blockXY(k))
+		decodeColor(&e.work, readU64BE(data[(k*bytesPerBlock)+colorOffset:]), oneBitAlpha)
+		scores[k], order[k] = blockSSIM(&e.pixels, &e.work), k
+	}
+	slices.SortStableFunc(order, func(a int, b int) int {
+		if scores[a] < scores[b] {
+			return -1
+		} else if scores[a] > scores[b] {
+			return +1
+		}
+		return 0
+	})
+
+	// Re-encode the worst blocks, keeping any improvements.
+	secondPass := *options
+	secondPass.Compatibility = CompatibilityDefault
+	secondPass.Quality = refinement.Quality
+	if secondPass.Quality == QualityDefault {
+		secondPass.Quality = QualityMax
+	}
+	re := newEncoder(0, &secondPass)
+	re.goHarderAlways = true
+	numRefined := int(math.Ceil(min(1, refinement.Fraction) * float64(numBlocks)))
+	for _, k := range order[:numRefined] {
+		if scores[k] >= 1 {
+			break
+		}
+		extract(blockXY(k))
+		re.pixels = e.pixels
+		code := re.encodeColor(f)
+		decodeColor(&re.work, code, oneBitAlpha)
+		if blockSSIM(&re.pixels, &re.work) > scores[k] {
+			writeU64BE(data[(k*bytesPerBlock)+colorOffset:], code)
+		}
+	}
+
+	// Write the result.
+	preview, onBlock := options.preview(), options.onBlock()
+	flushEveryBlockRow := options.FlushEveryBlockRow || (options.OnCheckpoint != nil)
+	bytesPerBlockRow := blocksWide * bytesPerBlock
+	chunkSize := (writeBufferSize / bytesPerBlock) * bytesPerBlock
+	written := firstBlockRow * bytesPerBlockRow
+	for row, end := firstBlockRow, written+bytesPerBlockRow; end <= len(data); row, end = row+1, end+bytesPerBlockRow {
+		if (preview != nil) || (onBlock != nil) {
+			for k := row * blocksWide; k < ((row + 1) * blocksWide); k++ {
+				blockX, blockY := blockXY(k)
+				code := data[k*bytesPerBlock : (k+1)*bytesPerBlock]
+				if preview != nil {
+					e.writePreview(preview, code, f, blockX, blockY, r)
+				}
+				if onBlock != nil {
+					extract(blockX, blockY)
+					onBlock(e.blockStats(code, f, (blockX-r.Min.X)/4, (blockY-r.Min.Y)/4))
+				}
+			}
+		}
+
+		for (end - written) >= chunkSize {
+			if _, err := dst.Write(data[written : written+chunkSize]); err != nil {
+				return err
+			}
+			written += chunkSize
+		}
+
+		if flushEveryBlockRow {
+			if end > written {
+				if _, err := dst.Write(data[written:end]); err != nil {
+					return err
+				}
+				written = end
+			}
+			if flusher, ok := dst.(interface{ Flush() error }); ok {
+				if err := flusher.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if options.OnCheckpoint != nil {
+			blockRow := row + 1
+			if err := options.OnCheckpoint(Checkpoint{
+				BlockRow: blockRow,
+				Offset:   int64(blockRow) * int64(bytesPerBlockRow),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if written < len(data) {
+		if _, err := dst.Write(data[written:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockSSIM returns the structural similarity of two blocks' 16 luma values
+// (using the ITU-R BT.601 weights), treating the block as a single window.
+// It is 1 for identical blocks and lower for less similar ones.
+func blockSSIM(pixels *[64]byte, work *[64]byte) float64 {
+	const c1 = (0.01 * 255) * (0.01 * 255)
+	const c2 = (0.03 * 255) * (0.03 * 255)
+	const n = 16
+
+	sa, sb, saa, sbb, sab := 0.0, 0.0, 0.0, 0.0, 0.0
+	for i := 0; i < 64; i += 4 {
+		va := (0.299 * float64(pixels[i+0])) + (0.587 * float64(pixels[i+1])) + (0.114 * float64(pixels[i+2]))
+		vb := (0.299 * float64(work[i+0])) + (0.587 * float64(work[i+1])) + (0.114 * float64(work[i+2]))
+		sa += va
+		sb += vb
+		saa += va * va
+		sbb += vb * vb
+		sab += va * vb
+	}
+	muA, muB := sa/n, sb/n
+	varA := (saa / n) - (muA * muA)
+	varB := (sbb / n) - (muB * muB)
+	covAB := (sab / n) - (muA * muB)
+	return ((2*muA*muB + c1) * (2*covAB + c2)) /
+		(((muA * muA) + (muB * muB) + c1) * (varA + varB + c2))
+}
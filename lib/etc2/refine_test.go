@@ -0,0 +1,89 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/metrics"
+)
+
+func TestEncodeRefinement(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	src, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	const f = etc2.FormatETC2RGB
+	const numBlocks = 20 * 15
+	const fraction = 0.25
+
+	encode := func(options *etc2.EncodeOptions) (data []byte, numOnBlockCalls int64) {
+		options.OnBlock = func(etc2.BlockStats) { atomic.AddInt64(&numOnBlockCalls, 1) }
+		data, err := etc2.AppendEncoded(nil, src, f, options)
+		if err != nil {
+			tt.Fatalf("AppendEncoded: %v", err)
+		}
+		return data, numOnBlockCalls
+	}
+	plain, _ := encode(&etc2.EncodeOptions{})
+	refined, _ := encode(&etc2.EncodeOptions{
+		Refinement: &etc2.Refinement{Fraction: fraction},
+	})
+
+	numChanged := 0
+	for i := 0; i < len(plain); i += 8 {
+		if !bytes.Equal(plain[i:i+8], refined[i:i+8]) {
+			numChanged++
+		}
+	}
+	if (numChanged == 0) || (numChanged > int(math.Ceil(fraction*numBlocks))) {
+		tt.Fatalf("numChanged: got %d, want in (0, %d]", numChanged, int(math.Ceil(fraction*numBlocks)))
+	}
+
+	ssims := [2]float64{}
+	for i, data := range [2][]byte{plain, refined} {
+		m, err := f.NewImage(80, 60)
+		if err != nil {
+			tt.Fatalf("NewImage: %v", err)
+		} else if err := f.DecodeBytes(m, data, 20, 15, nil); err != nil {
+			tt.Fatalf("DecodeBytes: %v", err)
+		}
+		if ssims[i], err = metrics.SSIM(src, m); err != nil {
+			tt.Fatalf("SSIM: %v", err)
+		}
+	}
+	if ssims[1] <= ssims[0] {
+		tt.Fatalf("SSIM (plain, refined): got %v, want increasing", ssims)
+	}
+
+	// The output should not depend on the NumWorkers or flushing options.
+	for _, options := range []*etc2.EncodeOptions{
+		{NumWorkers: 3},
+		{FlushEveryBlockRow: true, WriteBufferSize: 40},
+	} {
+		options.Refinement = &etc2.Refinement{Fraction: fraction}
+		got, numOnBlockCalls := encode(options)
+		if !bytes.Equal(got, refined) {
+			tt.Fatalf("NumWorkers=%d, FlushEveryBlockRow=%t: output differs", options.NumWorkers, options.FlushEveryBlockRow)
+		} else if numOnBlockCalls != numBlocks {
+			tt.Fatalf("NumWorkers=%d, FlushEveryBlockRow=%t: numOnBlockCalls: got %d, want %d",
+				options.NumWorkers, options.FlushEveryBlockRow, numOnBlockCalls, numBlocks)
+		}
+	}
+}
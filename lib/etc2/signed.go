@@ -0,0 +1,133 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"image/color"
+)
+
+// SignedImage is a source image of signed values, such as a heightfield or a
+// displacement map, for the FormatETC2R11Signed and FormatETC2RG11Signed
+// formats. Encoding a SignedImage to those formats reads its values through
+// SignedAt instead of At, with no color conversion. The NormalMapping,
+// SourceIsPremultiplied and Transform options do not apply.
+//
+// Values are in the range [-32767, +32767], where ±32767 means ±1.0. A value
+// of -32768 is treated as -32767. The R11 format only uses the r value.
+//
+// For other formats, a SignedImage is treated like any other image.Image. Its
+// At method should return the values biased by 0x8000, as the signed formats
+// decode to, such as a color.RGBA64 with R set to uint16(r + 0x8000).
+type SignedImage interface {
+	image.Image
+	SignedAt(x int, y int) (r int16, g int16)
+}
+
+// Signed16Image is an in-memory SignedImage.
+type Signed16Image struct {
+	// Pix holds the image's values, NumChannels per pixel. The value for
+	// channel c of the pixel at (x, y) is at Pix[(y-Rect.Min.Y)*Stride +
+	// (x-Rect.Min.X)*NumChannels + c].
+	Pix []int16
+	// Stride is the Pix stride (in int16 elements, not bytes) between
+	// vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+	// NumChannels is 1 (red only) or 2 (red and green).
+	NumChannels int
+}
+
+// NewSigned16Image returns a new Signed16Image with the given bounds and
+// number of channels, which must be 1 or 2.
+func NewSigned16Image(r image.Rectangle, numChannels int) *Signed16Image {
+	numChannels = max(1, min(2, numChannels))
+	w, h := r.Dx(), r.Dy()
+	if (w <= 0) || (h <= 0) {
+		return &Signed16Image{Rect: r, NumChannels: numChannels}
+	}
+	return &Signed16Image{
+		Pix:         make([]int16, numChannels*w*h),
+		Stride:      numChannels * w,
+		Rect:        r,
+		NumChannels: numChannels,
+	}
+}
+
+// ColorModel implements the image.Image interface.
+func (m *Signed16Image) ColorModel() color.Model { return color.RGBA64Model }
+
+// Bounds implements the image.Image interface.
+func (m *Signed16Image) Bounds() image.Rectangle { return m.Rect }
+
+// At implements the image.Image interface. It returns the values biased by
+// 0x8000, as the signed formats decode to.
+func (m *Signed16Image) At(x int, y int) color.Color {
+	r, g := m.SignedAt(x, y)
+	return color.RGBA64{
+		R: biasSigned16(r),
+		G: biasSigned16(g),
+		B: 0x0000,
+		A: 0xFFFF,
+	}
+}
+
+// SignedAt implements the SignedImage interface.
+func (m *Signed16Image) SignedAt(x int, y int) (r int16, g int16) {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return 0, 0
+	}
+	i := m.PixOffset(x, y)
+	if m.NumChannels < 2 {
+		return m.Pix[i], 0
+	}
+	return m.Pix[i], m.Pix[i+1]
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (m *Signed16Image) PixOffset(x int, y int) int {
+	return ((y - m.Rect.Min.Y) * m.Stride) + ((x - m.Rect.Min.X) * m.NumChannels)
+}
+
+// SetSigned sets the pixel at (x, y) to the values r and g. g is ignored if
+// NumChannels is 1.
+func (m *Signed16Image) SetSigned(x int, y int, r int16, g int16) {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return
+	}
+	i := m.PixOffset(x, y)
+	m.Pix[i] = r
+	if m.NumChannels >= 2 {
+		m.Pix[i+1] = g
+	}
+}
+
+// SubImage returns an image representing the portion of the image m visible
+// through r. The returned value shares pixels with the original image.
+func (m *Signed16Image) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(m.Rect)
+	if r.Empty() {
+		return &Signed16Image{NumChannels: m.NumChannels}
+	}
+	i := m.PixOffset(r.Min.X, r.Min.Y)
+	return &Signed16Image{
+		Pix:         m.Pix[i:],
+		Stride:      m.Stride,
+		Rect:        r,
+		NumChannels: m.NumChannels,
+	}
+}
+
+// biasSigned16 maps a signed value in the range [-32767, +32767] (with -32768
+// treated as -32767) to the range [0x0001, 0xFFFF], centered on 0x8000.
+func biasSigned16(v int16) uint16 {
+	return uint16(int32(max(-32767, v)) + 0x8000)
+}
@@ -0,0 +1,64 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestEncodeSignedImage(tt *testing.T) {
+	const width, height = 16, 8
+	for _, f := range []etc2.Format{etc2.FormatETC2R11Signed, etc2.FormatETC2RG11Signed} {
+		numChannels := 1
+		if f == etc2.FormatETC2RG11Signed {
+			numChannels = 2
+		}
+		src := etc2.NewSigned16Image(image.Rect(0, 0, width, height), numChannels)
+		for y := range height {
+			for x := range width {
+				// A horizontal ramp, from -1 to +1, and a vertical ramp. The
+				// left column of blocks is solid -1 (in the red channel).
+				r := int16(max(-32767, ((x-8)*4096)+(y*16)))
+				if x < 4 {
+					r = -32768
+				}
+				src.SetSigned(x, y, r, int16(-y*4096))
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		if err := etc2.Encode(buf, src, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode: %v", f, err)
+		}
+		raw := make([]int16, width*height*numChannels)
+		if err := f.Decode11Bit(raw, bytes.NewReader(buf.Bytes()), width/4, height/4); err != nil {
+			tt.Fatalf("f=%v: Decode11Bit: %v", f, err)
+		}
+		for y := range height {
+			for x := range width {
+				r, g := src.SignedAt(x, y)
+				for c, v := range []int16{r, g}[:numChannels] {
+					got := int(raw[(((y*width)+x)*numChannels)+c])
+					want := (max(-32767, int(v)) * 1023) / 32767
+					if (x < 4) && (c == 0) {
+						if got != -1023 {
+							tt.Fatalf("f=%v: (%d, %d, %d): got %d, want -1023", f, x, y, c, got)
+						}
+					} else if d := got - want; (d < -16) || (d > +16) {
+						tt.Fatalf("f=%v: (%d, %d, %d): got %d, want %d", f, x, y, c, got, want)
+					}
+				}
+			}
+		}
+	}
+}
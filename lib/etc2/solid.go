@@ -0,0 +1,252 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"sync"
+)
+
+// This file holds the encoder's fast paths for blocks whose 16 pixels are all
+// the same color, or (for FormatETC2RGBA1) all transparent. UI atlases are
+// dominated by such blocks, and the general search (T, H, planar, etc.) is
+// wasted on them.
+
+// solidEntry is the best base value, and its absolute error, for encoding
+// one channel of a solid block.
+type solidEntry struct {
+	base uint8
+	err  uint8
+}
+
+// solidDiff[table][index][v] is the best 5-bit base (for the differential
+// mode) for encoding a channel's 8-bit value v with the given table and pixel
+// index. solidIndiv is the same, for the individual mode's 4-bit bases.
+//
+// Both are initialized by solidTablesOnce.
+var (
+	solidDiff       [8][4][256]solidEntry
+	solidIndiv      [8][4][256]solidEntry
+	solidTablesOnce sync.Once
+)
+
+func initSolidTables() {
+	for table := range solidDiff {
+		for index := range solidDiff[table] {
+			m := modifiers[table][index]
+			for v := range 256 {
+				best := solidEntry{err: 0xFF}
+				for base := range uint32(32) {
+					expanded := (base << 3) | (base >> 2)
+					if d := absDiff(clamp[1023&(expanded+m)], uint8(v)); best.err > d {
+						best = solidEntry{base: uint8(base), err: d}
+					}
+				}
+				solidDiff[table][index][v] = best
+
+				best = solidEntry{err: 0xFF}
+				for base := range uint32(16) {
+					expanded := (base << 4) | base
+					if d := absDiff(clamp[1023&(expanded+m)], uint8(v)); best.err > d {
+						best = solidEntry{base: uint8(base), err: d}
+					}
+				}
+				solidIndiv[table][index][v] = best
+			}
+		}
+	}
+}
+
+func absDiff(a uint8, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// solidIndexes returns the pixel index bits that select index for all 16
+// pixels.
+func solidIndexes(index int) uint64 {
+	code := uint64(0)
+	if (index & 2) != 0 {
+		code |= 0xFFFF_0000
+	}
+	if (index & 1) != 0 {
+		code |= 0x0000_FFFF
+	}
+	return code
+}
+
+// solidTransparentCode is a FormatETC2RGBA1 color code whose 16 pixels are all
+// transparent: the differential (opaque) bit is off and every pixel index is
+// 2.
+const solidTransparentCode = 0x0000_0000_FFFF_0000
+
+// encodeSolidColor returns a code for e.pixels if all 16 of them have the same
+// red, green and blue values (or, for FormatETC2RGBA1, are all transparent).
+// The code is the best of the differential, individual (for formats that allow
+// it), planar and T (for the ETC2 formats) modes' single color codes, plus
+// FormatETC2RGBA1's zero-modifier code. It returns
+// false if the pixels differ or if that code is not acceptable (in the sense
+// of colorCandidate.acceptable), in which case the general search is needed.
+func (e *encoder) encodeSolidColor(f Format) (uint64, bool) {
+	p := &e.pixels
+	formatIsOneBitAlpha := f == FormatETC2RGBA1
+	if formatIsOneBitAlpha {
+		numTransparent := 0
+		for i := 3; i < 64; i += 4 {
+			if p[i] < 0x80 {
+				numTransparent++
+			}
+		}
+		if numTransparent == 16 {
+			return solidTransparentCode, true
+		} else if numTransparent != 0 {
+			return 0, false
+		}
+	}
+	for i := 4; i < 64; i += 4 {
+		if (p[i+0] != p[0]) || (p[i+1] != p[1]) || (p[i+2] != p[2]) {
+			return 0, false
+		}
+	}
+	r, g, b := p[0], p[1], p[2]
+	solidTablesOnce.Do(initSolidTables)
+
+	best := colorCandidate{loss: maxInt32}
+	allowIndiv := (f != FormatETC1S) && !formatIsOneBitAlpha
+	for _, diff := range [2]bool{true, false} {
+		if !diff && !allowIndiv {
+			break
+		}
+		tables := &solidDiff
+		if !diff {
+			tables = &solidIndiv
+		}
+
+		bestLoss, bestTable, bestIndex := maxInt32, 0, 0
+		for table := range tables {
+			for index := range tables[table] {
+				eR := int32(tables[table][index][r].err)
+				eG := int32(tables[table][index][g].err)
+				eB := int32(tables[table][index][b].err)
+				loss := 0 +
+					(weightValuesI32[0] * eR * eR) +
+					(weightValuesI32[1] * eG * eG) +
+					(weightValuesI32[2] * eB * eB)
+				if bestLoss > loss {
+					bestLoss, bestTable, bestIndex = loss, table, index
+				}
+			}
+		}
+
+		t := &tables[bestTable][bestIndex]
+		code := (uint64(bestTable) << 37) |
+			(uint64(bestTable) << 34) |
+			solidIndexes(bestIndex)
+		if diff {
+			// Both sub-blocks have the same base, so the deltas are zero.
+			code |= (uint64(t[r].base) << 59) |
+				(uint64(t[g].base) << 51) |
+				(uint64(t[b].base) << 43) |
+				(1 << 33) // Diff bit.
+		} else {
+			code |= (uint64(t[r].base) << 60) |
+				(uint64(t[r].base) << 56) |
+				(uint64(t[g].base) << 52) |
+				(uint64(t[g].base) << 48) |
+				(uint64(t[b].base) << 44) |
+				(uint64(t[b].base) << 40)
+		}
+		e.consider(&best, code, formatIsOneBitAlpha)
+	}
+
+	if formatIsOneBitAlpha {
+		// With the opaque bit off, pixel index 0's modifier is zero (and index
+		// 2 is transparent), so every pixel decodes to the 5:5:5 base color.
+		code := uint64(0)
+		for c, shift := range [3]uint64{59, 51, 43} {
+			bestD, base5 := maxInt32, uint64(0)
+			for base := range uint32(32) {
+				d := int32((base<<3)|(base>>2)) - int32(p[c])
+				if bestD > (d * d) {
+					bestD, base5 = d*d, uint64(base)
+				}
+			}
+			code |= base5 << shift
+		}
+		e.consider(&best, code, formatIsOneBitAlpha)
+	}
+
+	if (f & formatBitsETC2) == formatBitsETC2 {
+		// With equal O, H and V colors, every pixel decodes to O.
+		o := [3]int32{
+			((int32(r) * 0x3F) + 0x7F) / 0xFF,
+			((int32(g) * 0x7F) + 0x7F) / 0xFF,
+			((int32(b) * 0x3F) + 0x7F) / 0xFF,
+		}
+		e.consider(&best, packPlanar(o, o, o), formatIsOneBitAlpha)
+
+		e.consider(&best, solidT([3]uint8{r, g, b}, formatIsOneBitAlpha), formatIsOneBitAlpha)
+	}
+
+	return best.code, best.acceptable
+}
+
+// solidT returns the best T mode code whose 16 pixels all have the same paint
+// color. That is either the first 4:4:4 color (index 0) or the second 4:4:4
+// color plus or minus a distance (index 1 or 3). H mode's paint colors are
+// also 4:4:4 colors plus or minus a distance, so it has nothing more to offer.
+func solidT(rgb [3]uint8, formatIsOneBitAlpha bool) uint64 {
+	colors := [2][3]uint8{}
+	bestLoss, bestWhich, bestIndex := int32(0), uint32(0), 0
+	for c := range 3 {
+		colors[0][c] = uint8(((uint32(rgb[c]) * 0x0F) + 0x7F) / 0xFF)
+		d := (int32(colors[0][c]) * 0x11) - int32(rgb[c])
+		bestLoss += weightValuesI32[c] * d * d
+	}
+
+	for which := range uint32(8) {
+		for _, index := range [2]int{1, 3} {
+			delta := uint32(thModifiers[which])
+			if index == 3 {
+				delta = -delta
+			}
+			loss, color1 := int32(0), [3]uint8{}
+			for c := range 3 {
+				bestDD := maxInt32
+				for base := range uint32(16) {
+					d := int32(clamp[1023&((base*0x11)+delta)]) - int32(rgb[c])
+					if bestDD > (d * d) {
+						bestDD, color1[c] = d*d, uint8(base)
+					}
+				}
+				loss += weightValuesI32[c] * bestDD
+			}
+			if bestLoss > loss {
+				bestLoss, bestWhich, bestIndex, colors[1] = loss, which, index, color1
+			}
+		}
+	}
+
+	return packT(&colors, bestWhich, uint32(solidIndexes(bestIndex)), formatIsOneBitAlpha)
+}
+
+// encodeSolidAlpha returns an alpha code that exactly encodes e.pixels' alpha
+// values if all 16 of them are the same.
+func (e *encoder) encodeSolidAlpha() (uint64, bool) {
+	a := e.pixels[3]
+	for i := 7; i < 64; i += 4 {
+		if e.pixels[i] != a {
+			return 0, false
+		}
+	}
+	// Table 13's index 4 modifier is zero, so every pixel decodes to the base.
+	const multiplier, table, indexes = 1, 13, 0x9249_2492_4924
+	return (uint64(a) << 56) | (multiplier << 52) | (table << 48) | indexes, true
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+// colorLoss returns the luma-weighted squared difference between a's and b's
+// red, green and blue values.
+func TestEncodeSolidBlocks(tt *testing.T) {
+	// Each 4×4 block is a single color. The last one is transparent.
+	colors := []color.NRGBA{
+		{R: 0x48, G: 0xBE, B: 0x79, A: 0x80},
+		{R: 0xFF, G: 0xC6, B: 0x00, A: 0xFF},
+		{R: 0x25, G: 0x58, B: 0xFF, A: 0xFF},
+		{R: 0x57, G: 0xBE, B: 0xCF, A: 0xC0},
+		{R: 0x12, G: 0x34, B: 0x56, A: 0x00},
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 4*len(colors), 4))
+	for x := range 4 * len(colors) {
+		for y := range 4 {
+			src.SetNRGBA(x, y, colors[x/4])
+		}
+	}
+
+	formats := []etc2.Format{
+		etc2.FormatETC1,
+		etc2.FormatETC1S,
+		etc2.FormatETC2RGB,
+		etc2.FormatETC2RGBA1,
+		etc2.FormatETC2RGBA8,
+	}
+	for _, f := range formats {
+		// CompatETCPACK disables the fast path, so fullImage is what the full
+		// mode search produces.
+		decoded := [2]*image.NRGBA{}
+		for i, compat := range []bool{false, true} {
+			buf := &bytes.Buffer{}
+			if err := etc2.Encode(buf, src, f, &etc2.EncodeOptions{CompatETCPACK: compat}); err != nil {
+				tt.Fatalf("f=%v: Encode: %v", f, err)
+			}
+			m, err := etc2.DecodeRaw(buf.Bytes(), f, 4*len(colors), 4)
+			if err != nil {
+				tt.Fatalf("f=%v: DecodeRaw: %v", f, err)
+			}
+			decoded[i] = image.NewNRGBA(m.Bounds())
+			draw.Draw(decoded[i], m.Bounds(), m, image.Point{}, draw.Src)
+		}
+		fastImage, fullImage := decoded[0], decoded[1]
+
+		for b, c := range colors {
+			fastLoss, fullLoss := 0, 0
+			for y := range 4 {
+				for x := 4 * b; x < 4*(b+1); x++ {
+					fast, full := fastImage.NRGBAAt(x, y), fullImage.NRGBAAt(x, y)
+					if (f == etc2.FormatETC2RGBA1) && (c.A < 0x80) {
+						if fast.A != 0 {
+							tt.Fatalf("f=%v, b=%d: got alpha 0x%02X, want 0x00", f, b, fast.A)
+						}
+						continue
+					} else if (f == etc2.FormatETC2RGBA8) && (fast.A != c.A) {
+						tt.Fatalf("f=%v, b=%d: got alpha 0x%02X, want 0x%02X", f, b, fast.A, c.A)
+					}
+					fastLoss += colorLoss(fast, c)
+					fullLoss += colorLoss(full, c)
+				}
+			}
+			if fastLoss > fullLoss {
+				tt.Fatalf("f=%v, b=%d: fast path loss %d exceeds full search loss %d",
+					f, b, fastLoss, fullLoss)
+			}
+		}
+	}
+}
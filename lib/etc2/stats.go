@@ -0,0 +1,147 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"io"
+	"math"
+	"sync"
+)
+
+// BlockStats describes one encoded block, for EncodeOptions.OnBlock.
+type BlockStats struct {
+	// X and Y are the block's coordinates, measured in blocks from the
+	// encoded rectangle's top-left corner (not SubRect's).
+	X, Y int
+
+	// Mode is the mode of the block's color code, or BlockModeNone for the
+	// R11 and RG11 formats.
+	Mode BlockMode
+
+	// Loss is the sum, over the block's 16 pixels (including any padding), of
+	// the squared differences between the encoder's input (after Transform,
+	// NormalMapping, etc.) and the decoded values.
+	//
+	// For the color formats, the red, green and blue differences are on the
+	// 8-bit scale and are weighted by 299, 587 and 114, like the encoder's
+	// own mode search. For FormatETC2RGBA1, pixels whose input is transparent
+	// are skipped. For the R11 and RG11 formats, the differences are on the
+	// 16-bit scale and are not weighted.
+	Loss int64
+
+	// AlphaLoss is the sum of the squared alpha differences, on the 8-bit
+	// scale, for the formats that have alpha. It is zero for other formats.
+	AlphaLoss int64
+}
+
+// blockStats returns the BlockStats for the block code just encoded from
+// e.pixels, whose coordinates (in blocks) are x and y. f must not have its
+// sRGB bit set.
+func (e *encoder) blockStats(code []byte, f Format, x int, y int) BlockStats {
+	b := DecodedBlock{}
+	copy(b.Code[:], code)
+	f.decodeBlock(&b, &e.work)
+	s := BlockStats{X: x, Y: y, Mode: b.Mode}
+
+	if (f & formatBitDepth11) != 0 {
+		n := 32
+		if (f & formatBitDepth11TwoChannel) != 0 {
+			n = 64
+		}
+		for i := 0; i < n; i += 2 {
+			p := (int64(e.pixels[i+0]) << 8) | int64(e.pixels[i+1])
+			w := (int64(e.work[i+0]) << 8) | int64(e.work[i+1])
+			s.Loss += (p - w) * (p - w)
+		}
+		return s
+	}
+
+	s.Loss = int64(e.calculateBlockLoss(f == FormatETC2RGBA1))
+	if f.AlphaModel() != AlphaModelOpaque {
+		for i := 3; i < 64; i += 4 {
+			d := int64(e.pixels[i]) - int64(e.work[i])
+			s.AlphaLoss += d * d
+		}
+	}
+	return s
+}
+
+// EncodeStats summarizes an encode, for EncodeWithStats.
+type EncodeStats struct {
+	// NumBlocks is the number of blocks encoded.
+	NumBlocks int
+
+	// BytesWritten is the number of bytes of encoded output.
+	BytesWritten int64
+
+	// Loss and AlphaLoss are the sums of every block's BlockStats fields of
+	// the same name.
+	Loss      int64
+	AlphaLoss int64
+
+	// PSNR is the peak signal-to-noise ratio, in decibels, derived from Loss.
+	// For the color formats, it is over the red, green and blue channels
+	// (weighted as per BlockStats.Loss) on the 8-bit scale. For the R11 and
+	// RG11 formats, it is over their one or two channels on the 16-bit scale.
+	// It is +Inf if Loss is zero.
+	PSNR float64
+
+	// ModeCounts[m] is the number of blocks whose color code has the
+	// BlockMode m. For the R11 and RG11 formats, every block counts towards
+	// ModeCounts[BlockModeNone].
+	ModeCounts [BlockModePlanar + 1]int
+}
+
+// EncodeWithStats is like Encode but also returns statistics about the
+// encoded blocks, such as the total loss and how many blocks used each mode.
+// Build pipelines can log these without decoding the output and comparing it
+// with src.
+//
+// options may be nil, which means to use the default configuration. Its
+// OnBlock field, if non-nil, is still called for every block.
+func EncodeWithStats(dst io.Writer, src image.Image, f Format, options *EncodeOptions) (EncodeStats, error) {
+	o := EncodeOptions{}
+	if options != nil {
+		o = *options
+	}
+	stats, mu := EncodeStats{}, sync.Mutex{}
+	onBlock := o.OnBlock
+	o.OnBlock = func(s BlockStats) {
+		mu.Lock()
+		stats.NumBlocks++
+		stats.Loss += s.Loss
+		stats.AlphaLoss += s.AlphaLoss
+		if int(s.Mode) < len(stats.ModeCounts) {
+			stats.ModeCounts[s.Mode]++
+		}
+		mu.Unlock()
+		if onBlock != nil {
+			onBlock(s)
+		}
+	}
+
+	err := Encode(dst, src, f, &o)
+	stats.BytesWritten = int64(stats.NumBlocks) * int64(f.BytesPerBlock())
+
+	stats.PSNR = math.Inf(+1)
+	if stats.Loss > 0 {
+		mse, peak := float64(stats.Loss)/float64(16*stats.NumBlocks), 255.0
+		if (f & formatBitDepth11) == 0 {
+			mse /= float64(weightValuesI32[0] + weightValuesI32[1] + weightValuesI32[2])
+		} else {
+			if (f & formatBitDepth11TwoChannel) != 0 {
+				mse /= 2
+			}
+			peak = 65535
+		}
+		stats.PSNR = 10 * math.Log10((peak*peak)/mse)
+	}
+	return stats, err
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+// colorLoss returns the luma-weighted squared difference between a's and b's
+// red, green and blue values.
+func TestEncodeWithStats(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	src, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	const f = etc2.FormatETC2RGB
+
+	buf := &bytes.Buffer{}
+	stats, err := etc2.EncodeWithStats(buf, src, f, nil)
+	if err != nil {
+		tt.Fatalf("EncodeWithStats: %v", err)
+	}
+	m, err := etc2.DecodeRaw(buf.Bytes(), f, 80, 60)
+	if err != nil {
+		tt.Fatalf("DecodeRaw: %v", err)
+	}
+	wantLoss := int64(0)
+	for y := range 60 {
+		for x := range 80 {
+			got := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			want := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+			wantLoss += int64(colorLoss(got, want))
+		}
+	}
+	wantPSNR := 10 * math.Log10((255*255)/(float64(wantLoss)/(80*60*1000)))
+
+	numBlocks := 0
+	for _, n := range stats.ModeCounts {
+		numBlocks += n
+	}
+	if (stats.NumBlocks != 300) || (numBlocks != 300) || (stats.BytesWritten != int64(buf.Len())) {
+		tt.Fatalf("got %d blocks (mode counts sum to %d), %d bytes, want 300 blocks, %d bytes",
+			stats.NumBlocks, numBlocks, stats.BytesWritten, buf.Len())
+	} else if stats.Loss != wantLoss {
+		tt.Fatalf("Loss: got %d, want %d", stats.Loss, wantLoss)
+	} else if math.Abs(stats.PSNR-wantPSNR) > 1e-9 {
+		tt.Fatalf("PSNR: got %v, want %v", stats.PSNR, wantPSNR)
+	}
+
+	// Encoding concurrently, to a file, gives the same statistics.
+	file, err := os.Create(filepath.Join(tt.TempDir(), "out.etc2"))
+	if err != nil {
+		tt.Fatalf("os.Create: %v", err)
+	}
+	defer file.Close()
+	calls := atomic.Int64{}
+	stats4, err := etc2.EncodeWithStats(file, src, f, &etc2.EncodeOptions{
+		NumWorkers: 4,
+		OnBlock:    func(s etc2.BlockStats) { calls.Add(1) },
+	})
+	if err != nil {
+		tt.Fatalf("EncodeWithStats (NumWorkers=4): %v", err)
+	} else if stats4 != stats {
+		tt.Fatalf("NumWorkers=4: got %+v, want %+v", stats4, stats)
+	} else if calls.Load() != 300 {
+		tt.Fatalf("NumWorkers=4: OnBlock calls: got %d, want 300", calls.Load())
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"image"
+	"io"
+	"sync"
+)
+
+// DecodeBlocks decodes the ETC-compressed image in src one 4×4 block at a
+// time, in row-major order, calling fn with each block's coordinates (measured
+// in blocks) and decoded pixels. Unlike Decode, the whole image is never held
+// in memory, so arbitrarily large textures can be processed with only a few
+// KiB of working memory.
+//
+// tile is a 4×4 image whose concrete type is that returned by f.NewImage. It
+// is re-used for every block, so fn should not retain it. If fn returns a
+// non-nil error then decoding stops and DecodeBlocks returns that error.
+func (f Format) DecodeBlocks(src io.Reader, widthInBlocks int, heightInBlocks int, fn func(blockX int, blockY int, tile SubsettableImage) error) error {
+	if (src == nil) || (fn == nil) ||
+		(widthInBlocks < 0) || (widthInBlocks > 16384) ||
+		(heightInBlocks < 0) || (heightInBlocks > 16384) {
+		return ErrBadArgument
+	}
+	tile, err := f.NewImage(4, 4)
+	if err != nil {
+		return err
+	}
+	for blockY := range heightInBlocks {
+		for blockX := range widthInBlocks {
+			if err := f.Decode(tile, src, 1, 1); err != nil {
+				return err
+			} else if err := fn(blockX, blockY, tile); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EncodeBlocks is the inverse of DecodeBlocks. It encodes an image one 4×4
+// block at a time, in row-major order, calling fn to fill in each block's
+// pixels before encoding them to dst in the format f.
+//
+// tile is a 4×4 image whose concrete type is that returned by f.NewImage. It
+// is re-used for every block and its previous contents are not cleared, so fn
+// should set every pixel. If fn returns a non-nil error then encoding stops
+// and EncodeBlocks returns that error.
+//
+// options may be nil, which means to use the default configuration. Its
+// SubRect, Preview, NumWorkers, OnCheckpoint, Resume and AlphaCoverage fields
+// are ignored.
+func EncodeBlocks(dst io.Writer, f Format, widthInBlocks int, heightInBlocks int, fn func(blockX int, blockY int, tile SubsettableImage) error, options *EncodeOptions) error {
+	if (dst == nil) || (fn == nil) || (f.ETCVersion() == 0) ||
+		(widthInBlocks < 0) || (widthInBlocks > 16383) ||
+		(heightInBlocks < 0) || (heightInBlocks > 16383) {
+		return ErrBadArgument
+	} else if !options.normalMappingIsValid(f) {
+		return ErrBadArgument
+	}
+	tile, err := f.NewImage(4, 4)
+	if err != nil {
+		return err
+	}
+
+	// Strip the sRGB bit. This encoder treats RGB and sRGB equally.
+	f &^= formatBitSRGBColorSpace
+	if !options.forceModeIsValid(f) {
+		return ErrBadArgument
+	}
+
+	writeBufferSize := defaultWriteBufferSize
+	flushEveryBlockRow := false
+	if options != nil {
+		if options.WriteBufferSize != 0 {
+			writeBufferSize = max(16, options.WriteBufferSize)
+		}
+		flushEveryBlockRow = options.FlushEveryBlockRow
+	}
+	bytesPerBlock := f.BytesPerBlock()
+
+	e, bufJ := newEncoder(writeBufferSize, options), 0
+	extract := f.makeExtract(&e.pixels, tile, tile.Bounds(), options)
+	onBlock := options.onBlock()
+
+	for blockY := range heightInBlocks {
+		for blockX := range widthInBlocks {
+			if err := fn(blockX, blockY, tile); err != nil {
+				return err
+			}
+			extract(0, 0)
+			e.encodeBlock(e.buf[bufJ:], f)
+			if onBlock != nil {
+				onBlock(e.blockStats(e.buf[bufJ:bufJ+bytesPerBlock], f, blockX, blockY))
+			}
+			bufJ += bytesPerBlock
+
+			if (bufJ + bytesPerBlock) > len(e.buf) {
+				if _, err := dst.Write(e.buf[:bufJ]); err != nil {
+					return err
+				}
+				bufJ = 0
+			}
+		}
+
+		if flushEveryBlockRow {
+			if bufJ > 0 {
+				if _, err := dst.Write(e.buf[:bufJ]); err != nil {
+					return err
+				}
+				bufJ = 0
+			}
+			if flusher, ok := dst.(interface{ Flush() error }); ok {
+				if err := flusher.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if bufJ > 0 {
+		if _, err := dst.Write(e.buf[:bufJ]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewReader returns an io.ReadCloser whose bytes are src encoded in the format
+// f, the same bytes that Encode would write. Encoding happens lazily, as the
+// caller reads, which lets the payload be streamed (such as into an HTTP
+// response or a tar file) without holding all of it in memory. At most
+// options.WriteBufferSize bytes are encoded ahead of the reader.
+//
+// Encoding runs on another goroutine, which starts on the first Read. Any
+// error from Encode, such as ErrBadArgument, is returned by Read (instead of
+// io.EOF). Callers that stop reading early should call Close, which stops the
+// encoding.
+//
+// options may be nil, which means to use the default configuration. Its
+// FlushEveryBlockRow field is ignored.
+func NewReader(src image.Image, f Format, options *EncodeOptions) io.ReadCloser {
+	pr, pw := io.Pipe()
+	return &encodingReader{
+		pr: pr,
+		start: func() {
+			go func() {
+				pw.CloseWithError(Encode(pw, src, f, options))
+			}()
+		},
+	}
+}
+
+// encodingReader is NewReader's io.ReadCloser.
+type encodingReader struct {
+	pr        *io.PipeReader
+	start     func()
+	startOnce sync.Once
+}
+
+func (r *encodingReader) Read(p []byte) (int, error) {
+	r.startOnce.Do(r.start)
+	return r.pr.Read(p)
+}
+
+// Close implements the io.Closer interface. If the encoding has not finished,
+// the encoding goroutine's next write fails, which stops it.
+func (r *encodingReader) Close() error {
+	r.startOnce.Do(func() {})
+	return r.pr.Close()
+}
@@ -0,0 +1,161 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestNewReader(tt *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 90, 70))
+	for y := range 70 {
+		for x := range 90 {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(7 * x), uint8(11 * y), uint8(x ^ y), 0xFF})
+		}
+	}
+	want := &bytes.Buffer{}
+	if err := etc2.Encode(want, src, etc2.FormatETC2RGB, nil); err != nil {
+		tt.Fatalf("Encode: %v", err)
+	}
+
+	for _, options := range []*etc2.EncodeOptions{nil, {WriteBufferSize: 64, NumWorkers: 4}} {
+		r := etc2.NewReader(src, etc2.FormatETC2RGB, options)
+		got, err := io.ReadAll(iotest.HalfReader(r))
+		if err != nil {
+			tt.Fatalf("options=%+v: ReadAll: %v", options, err)
+		} else if !bytes.Equal(got, want.Bytes()) {
+			tt.Fatalf("options=%+v: NewReader and Encode differ", options)
+		} else if err := r.Close(); err != nil {
+			tt.Fatalf("options=%+v: Close: %v", options, err)
+		}
+	}
+
+	// Closing early stops the encoding.
+	r := etc2.NewReader(src, etc2.FormatETC2RGB, &etc2.EncodeOptions{WriteBufferSize: 64})
+	if _, err := io.ReadFull(r, make([]byte, 100)); err != nil {
+		tt.Fatalf("ReadFull: %v", err)
+	} else if err := r.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	} else if _, err := r.Read(make([]byte, 100)); err != io.ErrClosedPipe {
+		tt.Fatalf("Read after Close: got %v, want %v", err, io.ErrClosedPipe)
+	}
+
+	// Encoding errors are passed to the reader.
+	if _, err := io.ReadAll(etc2.NewReader(src, etc2.FormatInvalid, nil)); err != etc2.ErrBadArgument {
+		tt.Fatalf("FormatInvalid: got %v, want %v", err, etc2.ErrBadArgument)
+	}
+}
+
+func TestDecodeBlocksAndEncodeBlocks(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	pngImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	const widthInBlocks, heightInBlocks = 80 / 4, 60 / 4
+
+	// Setting a tile's pixels converts them to the tile's color model, which
+	// can lose precision for semi-transparent pixels. Compositing the source
+	// over white first makes every pixel opaque, so that it is lossless.
+	srcImage := image.NewRGBA(pngImage.Bounds())
+	draw.Draw(srcImage, srcImage.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(srcImage, srcImage.Bounds(), pngImage, image.Point{}, draw.Over)
+
+	errStop := errors.New("stop")
+	for _, f := range []etc2.Format{etc2.FormatETC1, etc2.FormatETC2RGBA1, etc2.FormatETC2R11Unsigned} {
+		want := &bytes.Buffer{}
+		if err := etc2.Encode(want, srcImage, f, nil); err != nil {
+			tt.Fatalf("f=%v: Encode: %v", f, err)
+		}
+
+		// EncodeBlocks, given the source one block at a time, should match
+		// Encode.
+		got := &bytes.Buffer{}
+		numBlocks := 0
+		if err := etc2.EncodeBlocks(got, f, widthInBlocks, heightInBlocks, func(blockX int, blockY int, tile etc2.SubsettableImage) error {
+			if (blockX != (numBlocks % widthInBlocks)) || (blockY != (numBlocks / widthInBlocks)) {
+				tt.Fatalf("f=%v: block %d: got (%d, %d)", f, numBlocks, blockX, blockY)
+			}
+			numBlocks++
+			for y := range 4 {
+				for x := range 4 {
+					tile.(draw.Image).Set(x, y, srcImage.At((4*blockX)+x, (4*blockY)+y))
+				}
+			}
+			return nil
+		}, nil); err != nil {
+			tt.Fatalf("f=%v: EncodeBlocks: %v", f, err)
+		} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			tt.Fatalf("f=%v: EncodeBlocks: encodings differ", f)
+		}
+
+		// DecodeBlocks' tiles should match the corresponding pixels of
+		// DecodeRaw.
+		decoded, err := etc2.DecodeRaw(want.Bytes(), f, 80, 60)
+		if err != nil {
+			tt.Fatalf("f=%v: DecodeRaw: %v", f, err)
+		}
+		numBlocks = 0
+		if err := f.DecodeBlocks(bytes.NewReader(want.Bytes()), widthInBlocks, heightInBlocks, func(blockX int, blockY int, tile etc2.SubsettableImage) error {
+			if (blockX != (numBlocks % widthInBlocks)) || (blockY != (numBlocks / widthInBlocks)) {
+				tt.Fatalf("f=%v: block %d: got (%d, %d)", f, numBlocks, blockX, blockY)
+			}
+			numBlocks++
+			for y := range 4 {
+				for x := range 4 {
+					if g, w := tile.At(x, y), decoded.At((4*blockX)+x, (4*blockY)+y); g != w {
+						tt.Fatalf("f=%v: block (%d, %d), (%d, %d): got %v, want %v", f, blockX, blockY, x, y, g, w)
+					}
+				}
+			}
+			return nil
+		}); err != nil {
+			tt.Fatalf("f=%v: DecodeBlocks: %v", f, err)
+		} else if numBlocks != (widthInBlocks * heightInBlocks) {
+			tt.Fatalf("f=%v: numBlocks: got %d, want %d", f, numBlocks, widthInBlocks*heightInBlocks)
+		}
+
+		// Errors from fn stop the walk and are returned as is.
+		numBlocks = 0
+		stopAt := func(blockX int, blockY int, tile etc2.SubsettableImage) error {
+			if numBlocks++; numBlocks == 3 {
+				return errStop
+			}
+			return nil
+		}
+		if err := f.DecodeBlocks(bytes.NewReader(want.Bytes()), widthInBlocks, heightInBlocks, stopAt); err != errStop {
+			tt.Fatalf("f=%v: DecodeBlocks(stopAt): got %v, want %v", f, err, errStop)
+		}
+		numBlocks = 0
+		if err := etc2.EncodeBlocks(io.Discard, f, widthInBlocks, heightInBlocks, stopAt, nil); err != errStop {
+			tt.Fatalf("f=%v: EncodeBlocks(stopAt): got %v, want %v", f, err, errStop)
+		}
+
+		// A truncated src gives a *TruncatedError.
+		if err := f.DecodeBlocks(bytes.NewReader(want.Bytes()[:want.Len()-1]), widthInBlocks, heightInBlocks, func(int, int, etc2.SubsettableImage) error {
+			return nil
+		}); !errors.As(err, new(*etc2.TruncatedError)) {
+			tt.Fatalf("f=%v: truncated: got %v, want an *etc2.TruncatedError", f, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2
+
+import (
+	"fmt"
+	"image"
+)
+
+// ValueRange is a range of 16-bit (0x0000 to 0xFFFF) values, for
+// EncodeOptions.ValueRange.
+//
+// Height maps and other single-channel data rarely use the full range. For
+// the R11 formats, stretching the values' range to the full range before
+// encoding (and recording the ValueRange, to undo the stretch after decoding)
+// spends all of the format's precision on the values that actually occur.
+//
+// For the signed R11 format, the values are those that Format.Decode gives,
+// with 0x8000 meaning zero.
+type ValueRange struct {
+	Min, Max uint16
+}
+
+// fullValueRange is the identity ValueRange, which changes nothing.
+var fullValueRange = ValueRange{0x0000, 0xFFFF}
+
+// String returns vr's minimum and maximum, separated by a comma, such as
+// "1200,47000", suitable for recording in a container file's key-value
+// metadata. ParseValueRange is its inverse.
+func (vr ValueRange) String() string {
+	return fmt.Sprintf("%d,%d", vr.Min, vr.Max)
+}
+
+// ParseValueRange parses the form that ValueRange.String returns.
+func ParseValueRange(s string) (ValueRange, error) {
+	vr := ValueRange{}
+	if n, err := fmt.Sscanf(s, "%d,%d", &vr.Min, &vr.Max); (err != nil) || (n != 2) || (vr.String() != s) {
+		return ValueRange{}, ErrBadArgument
+	}
+	return vr, nil
+}
+
+// Normalize maps a value in vr to the full range. Values outside of vr are
+// clamped. If vr.Max is not greater than vr.Min, it returns zero.
+func (vr ValueRange) Normalize(v uint16) uint16 {
+	if vr.Max <= vr.Min {
+		return 0
+	}
+	v = max(vr.Min, min(vr.Max, v))
+	n, d := uint32(v-vr.Min), uint32(vr.Max-vr.Min)
+	return uint16(((n * 0xFFFF) + (d / 2)) / d)
+}
+
+// Denormalize is the inverse of Normalize, mapping a (decoded) full range
+// value back to vr.
+func (vr ValueRange) Denormalize(v uint16) uint16 {
+	if vr.Max <= vr.Min {
+		return vr.Min
+	}
+	d := uint32(vr.Max - vr.Min)
+	return vr.Min + uint16(((uint32(v)*d)+0x7FFF)/0xFFFF)
+}
+
+// DenormalizeGray16 applies Denormalize to every pixel of m, such as one
+// decoded from an R11 format that was encoded with EncodeOptions.ValueRange.
+func (vr ValueRange) DenormalizeGray16(m *image.Gray16) {
+	if vr == fullValueRange {
+		return
+	}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(row); i += 2 {
+			v := vr.Denormalize((uint16(row[i+0]) << 8) | uint16(row[i+1]))
+			row[i+0] = uint8(v >> 8)
+			row[i+1] = uint8(v >> 0)
+		}
+	}
+}
+
+// MeasureValueRange returns the range of src's values, as the R11 format f
+// (unsigned or signed) would encode them, suitable for EncodeOptions.ValueRange.
+// options' fields that affect the values, such as Transform and
+// CompatETCPACK's gray weights, are honored. Its SubRect and ValueRange are
+// ignored: the range is over all of src.
+//
+// options may be nil, which means to use the default configuration.
+func MeasureValueRange(src image.Image, f Format, options *EncodeOptions) (ValueRange, error) {
+	if (src == nil) || ((f &^ formatBitDepth11Signed) != FormatETC2R11Unsigned) {
+		return ValueRange{}, ErrBadArgument
+	}
+	b := src.Bounds()
+	if b.Empty() {
+		return fullValueRange, nil
+	}
+	pixels := [64]byte{}
+	extract := f.makeRawExtract(&pixels, src, b, options)
+	vr := ValueRange{0xFFFF, 0x0000}
+	for blockY := b.Min.Y; blockY < b.Max.Y; blockY += 4 {
+		for blockX := b.Min.X; blockX < b.Max.X; blockX += 4 {
+			extract(blockX, blockY)
+			for i := range 16 {
+				if inBlockBounds(b, blockX, blockY, i) {
+					v := (uint16(pixels[(2*i)+0]) << 8) | uint16(pixels[(2*i)+1])
+					vr.Min, vr.Max = min(vr.Min, v), max(vr.Max, v)
+				}
+			}
+		}
+	}
+	return vr, nil
+}
+
+// valueRange returns options.ValueRange, or nil if it does not apply to the
+// format f (which must not have its sRGB bit set) or changes nothing. options
+// may be nil.
+func (options *EncodeOptions) valueRange(f Format) *ValueRange {
+	if (options == nil) || (options.ValueRange == nil) || (*options.ValueRange == fullValueRange) ||
+		((f &^ formatBitDepth11Signed) != FormatETC2R11Unsigned) {
+		return nil
+	}
+	return options.ValueRange
+}
+
+// applyValueRange returns extract, modified to then Normalize the pixels'
+// (R11) values. It returns extract unchanged if vr is nil.
+func applyValueRange(extract func(blockX int, blockY int), pixels *[64]byte, vr *ValueRange) func(blockX int, blockY int) {
+	if vr == nil {
+		return extract
+	}
+	r := *vr
+	return func(blockX int, blockY int) {
+		extract(blockX, blockY)
+		for i := 0; i < 32; i += 2 {
+			v := r.Normalize((uint16(pixels[i+0]) << 8) | uint16(pixels[i+1]))
+			pixels[i+0] = uint8(v >> 8)
+			pixels[i+1] = uint8(v >> 0)
+		}
+	}
+}
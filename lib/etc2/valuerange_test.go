@@ -0,0 +1,74 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestEncodeValueRange(tt *testing.T) {
+	const width, height = 16, 16
+	const lo, hi = 20000, 24000
+	src := image.NewGray16(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			// A gentle slope, such as a height map's, that uses only a narrow
+			// part of the full range.
+			src.SetGray16(x, y, color.Gray16{uint16(lo + (((x * width) + y) * (hi - lo) / ((width * height) - 1)))})
+		}
+	}
+
+	f := etc2.FormatETC2R11Unsigned
+	vr, err := etc2.MeasureValueRange(src, f, nil)
+	if err != nil {
+		tt.Fatalf("MeasureValueRange: %v", err)
+	} else if want := (etc2.ValueRange{Min: lo, Max: hi}); vr != want {
+		tt.Fatalf("MeasureValueRange: got %v, want %v", vr, want)
+	}
+	if got, err := etc2.ParseValueRange(vr.String()); err != nil {
+		tt.Fatalf("ParseValueRange: %v", err)
+	} else if got != vr {
+		tt.Fatalf("ParseValueRange: got %v, want %v", got, vr)
+	}
+	for _, s := range []string{"", "1", "1,2,3", "-1,2", "1,65536", " 1,2"} {
+		if _, err := etc2.ParseValueRange(s); !errors.Is(err, etc2.ErrBadArgument) {
+			tt.Fatalf("ParseValueRange(%q): got %v, want %v", s, err, etc2.ErrBadArgument)
+		}
+	}
+
+	maxErrors := [2]int{}
+	for i, options := range []*etc2.EncodeOptions{nil, {ValueRange: &vr}} {
+		buf := &bytes.Buffer{}
+		if err := etc2.Encode(buf, src, f, options); err != nil {
+			tt.Fatalf("i=%d: Encode: %v", i, err)
+		}
+		dst := image.NewGray16(image.Rect(0, 0, width, height))
+		if err := f.Decode(dst, bytes.NewReader(buf.Bytes()), width/4, height/4); err != nil {
+			tt.Fatalf("i=%d: Decode: %v", i, err)
+		}
+		if options != nil {
+			vr.DenormalizeGray16(dst)
+		}
+		for y := range height {
+			for x := range width {
+				d := int(dst.Gray16At(x, y).Y) - int(src.Gray16At(x, y).Y)
+				maxErrors[i] = max(maxErrors[i], d, -d)
+			}
+		}
+	}
+	if maxErrors[1] >= maxErrors[0] {
+		tt.Fatalf("max errors: got %d (with ValueRange), want less than %d (without)", maxErrors[1], maxErrors[0])
+	}
+}
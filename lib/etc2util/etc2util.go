@@ -0,0 +1,200 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// ----------------
+
+// Package etc2util provides one-call helpers for encoding and decoding ETC
+// image files, covering common scripting use cases.
+//
+// The container or image file format is chosen by each filename's extension
+// (when writing) or magic bytes (when reading). Files are written atomically:
+// to a temporary file in the same directory that is then renamed, so that
+// readers never see a partially written file.
+package etc2util
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nigeltao/etc2/lib/container"
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/ktx"
+	"github.com/nigeltao/etc2/lib/ktx2"
+	"github.com/nigeltao/etc2/lib/nie"
+	"github.com/nigeltao/etc2/lib/pkm"
+
+	_ "image/gif"
+	_ "image/jpeg"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrNoImage              = errors.New("etc2util: no image")
+	ErrUnsupportedExtension = errors.New("etc2util: unsupported filename extension")
+)
+
+// EncodeOptions are optional arguments to EncodeFile. The zero value is valid
+// and means to use the default configuration.
+type EncodeOptions struct {
+	// If zero, the default is to use etc2.FormatETC2RGB.
+	Format etc2.Format
+
+	// KeyValues is the metadata to write, in order. It is only used for KTX
+	// and KTX2 files.
+	KeyValues []ktx.KeyValue
+
+	// ETC2Options, if non-nil, are passed on to the etc2 package's encoder.
+	ETC2Options *etc2.EncodeOptions
+}
+
+// EncodeFile reads the image file (such as a PNG file) at srcPath and writes
+// it, ETC-compressed, to dstPath. dstPath's extension, ".ktx", ".ktx2" or
+// ".pkm", gives the container format.
+//
+// options may be nil, which means to use the default configuration.
+func EncodeFile(dstPath string, srcPath string, options *EncodeOptions) error {
+	if options == nil {
+		options = &EncodeOptions{}
+	}
+	encode := (func(w io.Writer, src image.Image) error)(nil)
+	switch strings.ToLower(filepath.Ext(dstPath)) {
+	case ".ktx":
+		encode = func(w io.Writer, src image.Image) error {
+			return ktx.Encode(w, src, &ktx.EncodeOptions{
+				Format:      options.Format,
+				KeyValues:   options.KeyValues,
+				ETC2Options: options.ETC2Options,
+			})
+		}
+	case ".ktx2":
+		encode = func(w io.Writer, src image.Image) error {
+			return ktx2.Encode(w, src, &ktx2.EncodeOptions{
+				KTXOptions: &ktx.EncodeOptions{
+					Format:      options.Format,
+					KeyValues:   options.KeyValues,
+					ETC2Options: options.ETC2Options,
+				},
+			})
+		}
+	case ".pkm":
+		encode = func(w io.Writer, src image.Image) error {
+			return pkm.Encode(w, src, &pkm.EncodeOptions{
+				Format:      options.Format,
+				ETC2Options: options.ETC2Options,
+			})
+		}
+	default:
+		return ErrUnsupportedExtension
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	src, _, err := image.Decode(srcFile)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomically(dstPath, func(w *os.File) error {
+		return encode(w, src)
+	})
+}
+
+// DecodeFile reads the ETC-compressed file (such as a KTX or PKM file) at
+// srcPath and writes its first (largest) image to dstPath. dstPath's
+// extension, ".nie" or ".png", gives the image file format.
+func DecodeFile(dstPath string, srcPath string) error {
+	encode := (func(w io.Writer, src image.Image) error)(nil)
+	switch strings.ToLower(filepath.Ext(dstPath)) {
+	case ".nie":
+		encode = func(w io.Writer, src image.Image) error {
+			b, err := nie.EncodeBN8(src)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(b)
+			return err
+		}
+	case ".png":
+		encode = png.Encode
+	default:
+		return ErrUnsupportedExtension
+	}
+
+	src, err := Decode(srcPath)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomically(dstPath, func(w *os.File) error {
+		return encode(w, src)
+	})
+}
+
+// Decode reads the ETC-compressed file (such as a KTX or PKM file) at srcPath
+// and returns its first (largest) image.
+func Decode(srcPath string) (image.Image, error) {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer srcFile.Close()
+
+	ret := image.Image(nil)
+	err = container.Walk(srcFile, func(desc container.ImageDesc, decode func() (image.Image, error)) error {
+		m, err := decode()
+		if err != nil {
+			return err
+		}
+		ret = m
+		return container.SkipAll
+	})
+	if err != nil {
+		return nil, err
+	} else if ret == nil {
+		return nil, ErrNoImage
+	}
+	return ret, nil
+}
+
+// WriteFileAtomically calls write with a temporary file in dstPath's
+// directory and, if that succeeds, renames the temporary file to dstPath. If
+// it fails, the temporary file is removed and dstPath is left untouched.
+func WriteFileAtomically(dstPath string, write func(w *os.File) error) (retErr error) {
+	f, err := os.CreateTemp(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	// os.CreateTemp uses mode 0600, but os.Create (before the umask) uses
+	// 0666. Split the difference: only the owner can write.
+	if err := f.Chmod(0644); err != nil {
+		return err
+	} else if err := write(f); err != nil {
+		return err
+	} else if err := f.Sync(); err != nil {
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dstPath)
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package etc2util
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestEncodeFileDecodeFile(tt *testing.T) {
+	tmpDir := tt.TempDir()
+	pkmPath := filepath.Join(tmpDir, "out.pkm")
+	niePath := filepath.Join(tmpDir, "out.nie")
+
+	if err := EncodeFile(pkmPath, "../../res/0-original-png/mona-lisa.21x32.png", &EncodeOptions{
+		Format: etc2.FormatETC1,
+	}); err != nil {
+		tt.Fatalf("EncodeFile: %v", err)
+	}
+	if got, err := os.ReadFile(pkmPath); err != nil {
+		tt.Fatalf("os.ReadFile(pkm): %v", err)
+	} else if want, err := os.ReadFile("../../res/1-encoded-pkm/mona-lisa.21x32.etc1.pkm"); err != nil {
+		tt.Fatalf("os.ReadFile(golden pkm): %v", err)
+	} else if !bytes.Equal(got, want) {
+		tt.Fatalf("EncodeFile: output differs from the golden PKM file")
+	}
+
+	if err := DecodeFile(niePath, pkmPath); err != nil {
+		tt.Fatalf("DecodeFile: %v", err)
+	}
+	if got, err := os.ReadFile(niePath); err != nil {
+		tt.Fatalf("os.ReadFile(nie): %v", err)
+	} else if want, err := os.ReadFile("../../res/3-decoded-nie/mona-lisa.21x32.etc1.nie"); err != nil {
+		tt.Fatalf("os.ReadFile(golden nie): %v", err)
+	} else if !bytes.Equal(got, want) {
+		tt.Fatalf("DecodeFile: output differs from the golden NIE file")
+	}
+
+	// No temporary files should be left behind.
+	if entries, err := os.ReadDir(tmpDir); err != nil {
+		tt.Fatalf("os.ReadDir: %v", err)
+	} else if len(entries) != 2 {
+		tt.Fatalf("os.ReadDir: got %d entries, want 2", len(entries))
+	}
+
+	// ETC1 data is valid ETC2 RGB data, so KTX2 output (which stores it as
+	// such) decodes to the same pixels.
+	ktx2Path := filepath.Join(tmpDir, "out.ktx2")
+	if err := EncodeFile(ktx2Path, "../../res/0-original-png/mona-lisa.21x32.png", &EncodeOptions{
+		Format: etc2.FormatETC1,
+	}); err != nil {
+		tt.Fatalf("EncodeFile(ktx2): %v", err)
+	} else if err := DecodeFile(niePath, ktx2Path); err != nil {
+		tt.Fatalf("DecodeFile(ktx2): %v", err)
+	}
+	if got, err := os.ReadFile(niePath); err != nil {
+		tt.Fatalf("os.ReadFile(nie): %v", err)
+	} else if want, err := os.ReadFile("../../res/3-decoded-nie/mona-lisa.21x32.etc1.nie"); err != nil {
+		tt.Fatalf("os.ReadFile(golden nie): %v", err)
+	} else if !bytes.Equal(got, want) {
+		tt.Fatalf("DecodeFile(ktx2): output differs from the golden NIE file")
+	}
+
+	if err := EncodeFile(filepath.Join(tmpDir, "out.dds"), "../../res/0-original-png/mona-lisa.21x32.png", nil); err != ErrUnsupportedExtension {
+		tt.Fatalf("EncodeFile(.dds): got %v, want %v", err, ErrUnsupportedExtension)
+	}
+}
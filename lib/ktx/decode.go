@@ -0,0 +1,311 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ktx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+var (
+	ErrNotAKTXFile        = errors.New("ktx: not a KTX file")
+	ErrUnsupportedFormat  = errors.New("ktx: unsupported format")
+	ErrUnsupportedTexture = errors.New("ktx: unsupported texture")
+)
+
+func init() {
+	image.RegisterFormat("ktx", Magic, Decode, DecodeConfig)
+}
+
+// HeaderError is the error for a malformed, truncated or unsupported KTX
+// header. It wraps ErrNotAKTXFile, ErrUnsupportedFormat,
+// ErrUnsupportedTexture or io.ErrUnexpectedEOF, which errors.Is matches.
+type HeaderError struct {
+	// Offset is the byte offset, from the start of the file, of the field.
+	Offset int64
+
+	// Field describes the header field and what was expected of it.
+	Field string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("%v (%s, at byte offset %d)", e.Err, e.Field, e.Offset)
+}
+
+func (e *HeaderError) Unwrap() error { return e.Err }
+
+// headerFieldNames are the names of the header's uint32 fields, in order.
+var headerFieldNames = [13]string{
+	"endianness",
+	"glType",
+	"glTypeSize",
+	"glFormat",
+	"glInternalFormat",
+	"glBaseInternalFormat",
+	"pixelWidth",
+	"pixelHeight",
+	"pixelDepth",
+	"numberOfArrayElements",
+	"numberOfFaces",
+	"numberOfMipmapLevels",
+	"bytesOfKeyValueData",
+}
+
+// newHeaderError returns a *HeaderError for the i'th uint32 field, per
+// headerFieldNames.
+func newHeaderError(i int, want string, err error) *HeaderError {
+	return &HeaderError{int64(12 + (4 * i)), headerFieldNames[i] + " (want " + want + ")", err}
+}
+
+// DecodeLayout reads a KTX file's header from r. Only the ETC formats and
+// textures with a non-zero Width and Height (not 1D textures) are supported.
+func DecodeLayout(r io.Reader) (Layout, error) {
+	buf := [HeaderSize]byte{}
+	if n, err := io.ReadFull(r, buf[:]); err != nil {
+		if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+			err = &HeaderError{int64(n), "truncated header", io.ErrUnexpectedEOF}
+		}
+		return Layout{}, err
+	} else if string(buf[:len(Magic)]) != Magic {
+		return Layout{}, &HeaderError{0, "magic (want the KTX 1.1 identifier)", ErrNotAKTXFile}
+	}
+
+	order := binary.ByteOrder(binary.LittleEndian)
+	switch binary.LittleEndian.Uint32(buf[12:]) {
+	case 0x0403_0201:
+		// No-op.
+	case 0x0102_0304:
+		order = binary.BigEndian
+	default:
+		return Layout{}, newHeaderError(0, "0x04030201", ErrNotAKTXFile)
+	}
+	u32 := func(i int) uint32 {
+		return order.Uint32(buf[12+(4*i):])
+	}
+
+	// The fields are, in order: endianness, glType, glTypeSize, glFormat,
+	// glInternalFormat, glBaseInternalFormat, pixelWidth, pixelHeight,
+	// pixelDepth, numberOfArrayElements, numberOfFaces, numberOfMipmapLevels
+	// and bytesOfKeyValueData.
+	for i := 6; i <= 12; i++ {
+		if u32(i) > 0xFFFF {
+			return Layout{}, newHeaderError(i, "at most 0xFFFF", ErrUnsupportedTexture)
+		}
+	}
+	l := Layout{
+		Format:              etc2.FormatFromOpenGL(u32(4)),
+		Width:               int(u32(6)),
+		Height:              int(u32(7)),
+		Depth:               int(u32(8)),
+		NumArrayElements:    int(u32(9)),
+		NumFaces:            int(u32(10)),
+		NumMipmapLevels:     max(1, int(u32(11))),
+		BytesOfKeyValueData: int(u32(12)),
+	}
+	switch {
+	case u32(1) != 0:
+		return Layout{}, newHeaderError(1, "0 for a compressed texture", ErrUnsupportedFormat)
+	case u32(3) != 0:
+		return Layout{}, newHeaderError(3, "0 for a compressed texture", ErrUnsupportedFormat)
+	case l.Format == etc2.FormatInvalid:
+		return Layout{}, newHeaderError(4, "an ETC format", ErrUnsupportedFormat)
+	case (l.Width == 0) || (l.Width > 65532):
+		return Layout{}, newHeaderError(6, "1 to 65532", ErrUnsupportedTexture)
+	case (l.Height == 0) || (l.Height > 65532):
+		return Layout{}, newHeaderError(7, "1 to 65532", ErrUnsupportedTexture)
+	case (l.NumFaces != 1) && (l.NumFaces != 6):
+		return Layout{}, newHeaderError(10, "1 or 6", ErrUnsupportedTexture)
+	case l.NumMipmapLevels > 17:
+		return Layout{}, newHeaderError(11, "at most 17", ErrUnsupportedTexture)
+	case (l.BytesOfKeyValueData & 3) != 0:
+		return Layout{}, newHeaderError(12, "a multiple of 4", ErrUnsupportedTexture)
+	}
+	return l, nil
+}
+
+// DecodeKeyValues reads a KTX file's header and key-value metadata from r, in
+// the order that they are stored.
+func DecodeKeyValues(r io.Reader) ([]KeyValue, error) {
+	buf := [HeaderSize]byte{}
+	if n, err := io.ReadFull(r, buf[:]); err != nil {
+		if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+			err = &HeaderError{int64(n), "truncated header", io.ErrUnexpectedEOF}
+		}
+		return nil, err
+	}
+	l, err := DecodeLayout(bytes.NewReader(buf[:]))
+	if err != nil {
+		return nil, err
+	}
+	order := binary.ByteOrder(binary.LittleEndian)
+	if binary.LittleEndian.Uint32(buf[12:]) == 0x0102_0304 {
+		order = binary.BigEndian
+	}
+
+	data := make([]byte, l.BytesOfKeyValueData)
+	if n, err := io.ReadFull(r, data); err != nil {
+		if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+			err = &HeaderError{int64(HeaderSize + n), "truncated key-value data", io.ErrUnexpectedEOF}
+		}
+		return nil, err
+	}
+	keyValues := []KeyValue(nil)
+	for i := 0; i < len(data); {
+		n := int(order.Uint32(data[i:]))
+		kv := data[i+4:]
+		if n > len(kv) {
+			return nil, &HeaderError{int64(HeaderSize + i), "keyAndValueByteSize (want at most the remaining key-value data)", ErrNotAKTXFile}
+		}
+		kv = kv[:n]
+		key, value, ok := bytes.Cut(kv, []byte{0x00})
+		if !ok || (len(key) == 0) {
+			return nil, &HeaderError{int64(HeaderSize + i + 4), "key (want a non-empty, NUL-terminated key)", ErrNotAKTXFile}
+		}
+		keyValues = append(keyValues, KeyValue{
+			Key:   string(key),
+			Value: bytes.Clone(value),
+		})
+		i += 4 + ((n + 3) &^ 3)
+	}
+	return keyValues, nil
+}
+
+// DecodeConfig reads a KTX image configuration from r. It describes the first
+// (largest) image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	l, err := DecodeLayout(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: l.Format.ColorModel(),
+		Width:      l.Width,
+		Height:     l.Height,
+	}, nil
+}
+
+// Decode reads a KTX image from r. It returns the first image: mipmap level
+// 0, face 0 and layer 0.
+func Decode(r io.Reader) (image.Image, error) {
+	return DecodeWithOptions(r, nil)
+}
+
+// DecodeOptions are optional arguments to DecodeWithOptions and
+// DecodeImageWithOptions. The zero value is valid and means to use the default
+// configuration.
+type DecodeOptions struct {
+	// ETC2Options, if non-nil, are passed on to etc2.Format.DecodeWithOptions.
+	ETC2Options *etc2.DecodeOptions
+
+	// MipLevel is the mipmap level that DecodeWithOptions decodes. Zero means
+	// the base (largest) level. Smaller levels, such as for thumbnails, are
+	// decoded without decoding the larger ones, although their bytes are
+	// still read (and discarded) from the io.Reader. Layout.LevelSize gives
+	// each level's dimensions.
+	//
+	// It is ignored by DecodeImageWithOptions, which takes a level argument.
+	MipLevel int
+}
+
+// DecodeWithOptions is like Decode but with optional arguments.
+//
+// If ETC2Options.Partial is set and the payload is truncated, it returns both
+// the partially decoded image and the error. The same applies to
+// DecodeImageWithOptions.
+//
+// options may be nil, which means to use the default configuration.
+func DecodeWithOptions(r io.Reader, options *DecodeOptions) (image.Image, error) {
+	l, err := DecodeLayout(r)
+	if err != nil {
+		return nil, err
+	}
+	level := 0
+	if options != nil {
+		level = options.MipLevel
+	}
+	if (level < 0) || (level >= l.NumMipmapLevels) {
+		return nil, ErrBadArgument
+	}
+	// Skip the key-value data, any larger levels and the imageSize.
+	offset, err := l.BlockOffset(level, 0, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, r, offset-HeaderSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return decodeImage(r, &l, level, options)
+}
+
+// DecodeImage reads the image at the given mipmap level, face and layer from
+// the KTX file r, whose header has already been decoded as l. layer has the
+// same meaning as for Layout.BlockOffset.
+func DecodeImage(r io.ReaderAt, l *Layout, level int, face int, layer int) (image.Image, error) {
+	return DecodeImageWithOptions(r, l, level, face, layer, nil)
+}
+
+// DecodeImageWithOptions is like DecodeImage but with optional arguments.
+//
+// options may be nil, which means to use the default configuration.
+func DecodeImageWithOptions(r io.ReaderAt, l *Layout, level int, face int, layer int, options *DecodeOptions) (image.Image, error) {
+	if (r == nil) || (l == nil) {
+		return nil, ErrBadArgument
+	}
+	offset, err := l.BlockOffset(level, face, layer, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(io.NewSectionReader(r, offset, l.bytesPerImage(level)), l, level, options)
+}
+
+func decodeImage(r io.Reader, l *Layout, level int, options *DecodeOptions) (image.Image, error) {
+	etc2Options := (*etc2.DecodeOptions)(nil)
+	if options != nil {
+		etc2Options = options.ETC2Options
+	}
+	w, h := levelSize(l.Width, level), levelSize(l.Height, level)
+	m, err := l.Format.NewImageWithOptions(w, h, nil, etc2Options)
+	if err != nil {
+		return nil, err
+	}
+	err = l.Format.DecodeWithOptions(m, r, etc2.BlocksWide(w), etc2.BlocksHigh(h), etc2Options)
+	if (err != nil) && ((etc2Options == nil) || !etc2Options.Partial) {
+		return nil, err
+	}
+	return m.SubImage(image.Rect(0, 0, w, h)), err
+}
+
+// LevelSize returns the width and height, in pixels, of the given mipmap
+// level's images. Level 0 is the base (largest) level and each subsequent
+// level halves the dimensions, rounding down, to a minimum of 1.
+func (l *Layout) LevelSize(level int) (width int, height int) {
+	return levelSize(l.Width, level), levelSize(l.Height, level)
+}
+
+// NumLayers returns the number of layers (as passed to BlockOffset or
+// DecodeImage) in the given mipmap level.
+func (l *Layout) NumLayers(level int) int {
+	n := max(1, l.NumArrayElements)
+	if l.Depth > 0 {
+		n *= levelSize(l.Depth, level)
+	}
+	return n
+}
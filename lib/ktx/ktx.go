@@ -0,0 +1,413 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// ----------------
+
+// Package ktx implements the KTX (Khronos Texture, version 1) container format
+// for ETC textures.
+//
+// KTX is specified at
+// https://registry.khronos.org/KTX/specs/1.0/ktxspec.v1.html
+package ktx
+
+import (
+	"errors"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+// Magic is the byte string prefix of every KTX (version 1) image file.
+const Magic = "\xABKTX 11\xBB\r\n\x1A\n"
+
+var (
+	ErrBadArgument     = errors.New("ktx: bad argument")
+	ErrImageIsTooLarge = errors.New("ktx: image is too large")
+)
+
+// KeyValue is a key-value metadata pair.
+//
+// By convention, string values (such as those of the "KTXorientation" key)
+// include a trailing NUL byte.
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// EncodeOptions are optional arguments to Encode. The zero value is valid and
+// means to use the default configuration.
+type EncodeOptions struct {
+	// If zero, the default is to use etc2.FormatETC2RGB.
+	Format etc2.Format
+
+	// KeyValues is the metadata to write, in order. Keys must be non-empty
+	// and must not contain a NUL byte.
+	KeyValues []KeyValue
+
+	// ETC2Options, if non-nil, are passed on to etc2.EncodeRect for each
+	// image. Its SubRect field must be empty, as a KTX file always holds
+	// whole images.
+	//
+	// When writing mipmaps, an AlphaCoverage with a zero Target applies the
+	// base level's coverage (of its source image) to every level.
+	ETC2Options *etc2.EncodeOptions
+
+	// Mipmaps is whether to write a full mipmap chain, down to 1×1, instead
+	// of only the base level. Each level is downsampled, by a box filter, from
+	// the level before it. It is not supported for 3D textures.
+	Mipmaps bool
+
+	// MipmapsInSRGBSpace is whether, for the sRGB formats, to average the
+	// sRGB-encoded values as is when downsampling mipmap levels. By default,
+	// they are converted to linear light, averaged and converted back, as
+	// averaging sRGB-encoded values darkens the smaller levels. The other
+	// formats' values are always averaged as is.
+	MipmapsInSRGBSpace bool
+
+	// NormalizeRange is whether, for the R11 formats, to stretch the source
+	// values' range to the full range before encoding, as per
+	// etc2.EncodeOptions.ValueRange, and to record that range in the
+	// ValueRangeKey metadata. The range is measured over all of the base
+	// level's images and applies to every level. It replaces any ValueRange
+	// in ETC2Options. It is not supported for the other formats.
+	NormalizeRange bool
+}
+
+// ValueRangeKey is the metadata key under which Encode records the
+// etc2.ValueRange, when EncodeOptions.NormalizeRange is set. Its value is the
+// ValueRange's String, with a trailing NUL byte.
+const ValueRangeKey = "etc2ValueRange"
+
+// NormalMappingKey is the metadata key under which Encode records a non-zero
+// etc2.EncodeOptions.NormalMapping, needed to reconstruct the normals after
+// decoding. Its value is the NormalMapping's String, with a trailing NUL
+// byte.
+const NormalMappingKey = "etc2NormalMapping"
+
+// Encode writes src to w in the KTX format.
+//
+// options may be nil, which means to use the default configuration.
+func Encode(w io.Writer, src image.Image, options *EncodeOptions) error {
+	if src == nil {
+		return ErrBadArgument
+	}
+	return encode(w, []source{{src, src.Bounds()}}, Layout{}, options)
+}
+
+// EncodeArray writes a slice of same-sized images to w as the layers of a KTX
+// array texture.
+//
+// options may be nil, which means to use the default configuration.
+func EncodeArray(w io.Writer, layers []image.Image, options *EncodeOptions) error {
+	sources, err := makeSources(layers)
+	if err != nil {
+		return err
+	}
+	return encode(w, sources, Layout{NumArrayElements: len(layers)}, options)
+}
+
+// Encode3D writes a slice of same-sized images to w as the depth slices (from
+// front to back) of a KTX 3D texture.
+//
+// Note that OpenGL ES does not support ETC-compressed 3D textures, only
+// arrays. Other APIs (or software decoders) may still accept them.
+//
+// options may be nil, which means to use the default configuration.
+func Encode3D(w io.Writer, slices []image.Image, options *EncodeOptions) error {
+	sources, err := makeSources(slices)
+	if err != nil {
+		return err
+	}
+	return encode(w, sources, Layout{Depth: len(slices)}, options)
+}
+
+// EncodeCubemap writes six images to w as a KTX cube map. The faces are in
+// the +X, -X, +Y, -Y, +Z, -Z order. Each face must be square and all faces
+// must have the same size.
+//
+// options may be nil, which means to use the default configuration.
+func EncodeCubemap(w io.Writer, faces *[6]image.Image, options *EncodeOptions) error {
+	if faces == nil {
+		return ErrBadArgument
+	}
+	sources, err := makeSources(faces[:])
+	if err != nil {
+		return err
+	}
+	return encode(w, sources, Layout{NumFaces: 6}, options)
+}
+
+// EncodeCubemapCross is like EncodeCubemap but the six faces are taken from a
+// single image, laid out as a horizontal cross:
+//
+//	    +Y
+//	-X  +Z  +X  -Z
+//	    -Y
+//
+// src's width and height must be in a 4:3 ratio. The faces are cropped from
+// src without copying any pixels.
+func EncodeCubemapCross(w io.Writer, src image.Image, options *EncodeOptions) error {
+	if src == nil {
+		return ErrBadArgument
+	}
+	b := src.Bounds()
+	n := b.Dx() / 4
+	if (n == 0) || (b.Dx() != (4 * n)) || (b.Dy() != (3 * n)) {
+		return ErrBadArgument
+	}
+	cells := [6]image.Point{
+		{2, 1}, // +X
+		{0, 1}, // -X
+		{1, 0}, // +Y
+		{1, 2}, // -Y
+		{1, 1}, // +Z
+		{3, 1}, // -Z
+	}
+	sources := make([]source, 6)
+	for i, c := range cells {
+		min := b.Min.Add(c.Mul(n))
+		sources[i] = source{src, image.Rectangle{Min: min, Max: min.Add(image.Point{X: n, Y: n})}}
+	}
+	return encode(w, sources, Layout{NumFaces: 6}, options)
+}
+
+// source is an image and the rectangle within it to encode.
+type source struct {
+	m image.Image
+	r image.Rectangle
+}
+
+func makeSources(images []image.Image) ([]source, error) {
+	if len(images) == 0 {
+		return nil, ErrBadArgument
+	}
+	sources := make([]source, len(images))
+	for i, m := range images {
+		if m == nil {
+			return nil, ErrBadArgument
+		}
+		sources[i] = source{m, m.Bounds()}
+	}
+	return sources, nil
+}
+
+// encode writes a KTX file holding the sources, which are all the same size.
+// The sources are ordered by layer, then face, then depth slice. Only l's
+// Depth, NumArrayElements and NumFaces fields are used.
+func encode(w io.Writer, sources []source, l Layout, options *EncodeOptions) error {
+	if (w == nil) || (len(sources) == 0) || (len(sources) != l.numImagesPerLevel(0)) {
+		return ErrBadArgument
+	}
+	bW, bH := sources[0].r.Dx(), sources[0].r.Dy()
+	if (bW > 65532) || (bH > 65532) {
+		return ErrImageIsTooLarge
+	}
+	for _, s := range sources {
+		if (s.r.Dx() != bW) || (s.r.Dy() != bH) {
+			return ErrBadArgument
+		}
+	}
+	if (l.NumFaces == 6) && (bW != bH) {
+		return ErrBadArgument
+	}
+
+	f := etc2.FormatETC2RGB
+	keyValues := []KeyValue(nil)
+	etc2Options := (*etc2.EncodeOptions)(nil)
+	numLevels, linear := 1, false
+	if options != nil {
+		if options.Format != 0 {
+			f = options.Format
+		}
+		keyValues = options.KeyValues
+		etc2Options = options.ETC2Options
+		if (etc2Options != nil) && !etc2Options.SubRect.Empty() {
+			return ErrBadArgument
+		}
+		if options.Mipmaps {
+			if l.Depth > 0 {
+				return ErrBadArgument
+			}
+			numLevels = NumMipmapLevels(bW, bH)
+			linear = isSRGB(f) && !options.MipmapsInSRGBSpace
+		}
+	}
+	baseInternalFormat := openGLBaseInternalFormat(f)
+	if baseInternalFormat == 0 {
+		return ErrBadArgument
+	}
+
+	if (options != nil) && options.NormalizeRange {
+		if (f != etc2.FormatETC2R11Unsigned) && (f != etc2.FormatETC2R11Signed) {
+			return ErrBadArgument
+		}
+		vr := etc2.ValueRange{Min: 0xFFFF, Max: 0x0000}
+		for _, s := range sources {
+			v, err := etc2.MeasureValueRange(croppedImage{s.m, s.r}, f, etc2Options)
+			if err != nil {
+				return err
+			}
+			vr.Min, vr.Max = min(vr.Min, v.Min), max(vr.Max, v.Max)
+		}
+		o := etc2.EncodeOptions{}
+		if etc2Options != nil {
+			o = *etc2Options
+		}
+		o.ValueRange = &vr
+		etc2Options = &o
+		keyValues = replaceKeyValue(keyValues, KeyValue{
+			Key:   ValueRangeKey,
+			Value: append([]byte(vr.String()), 0x00),
+		})
+	}
+
+	if (etc2Options != nil) && (etc2Options.NormalMapping != etc2.NormalMappingNone) {
+		keyValues = replaceKeyValue(keyValues, KeyValue{
+			Key:   NormalMappingKey,
+			Value: append([]byte(etc2Options.NormalMapping.String()), 0x00),
+		})
+	}
+
+	keyValueData := []byte(nil)
+	for _, kv := range keyValues {
+		if (kv.Key == "") || (strings.IndexByte(kv.Key, 0x00) >= 0) {
+			return ErrBadArgument
+		}
+		n := len(kv.Key) + 1 + len(kv.Value)
+		keyValueData = appendU32LE(keyValueData, uint32(n))
+		keyValueData = append(keyValueData, kv.Key...)
+		keyValueData = append(keyValueData, 0x00)
+		keyValueData = append(keyValueData, kv.Value...)
+		for ; (n & 3) != 0; n++ {
+			keyValueData = append(keyValueData, 0x00)
+		}
+	}
+
+	l.Format = f
+	l.Width = bW
+	l.Height = bH
+	l.NumMipmapLevels = numLevels
+	l.BytesOfKeyValueData = len(keyValueData)
+
+	buf := make([]byte, 0, 68+len(keyValueData))
+	buf = append(buf, Magic...)
+	buf = appendU32LE(buf, 0x0403_0201)                // endianness
+	buf = appendU32LE(buf, 0)                          // glType
+	buf = appendU32LE(buf, 1)                          // glTypeSize
+	buf = appendU32LE(buf, 0)                          // glFormat
+	buf = appendU32LE(buf, f.OpenGLInternalFormat())   // glInternalFormat
+	buf = appendU32LE(buf, baseInternalFormat)         // glBaseInternalFormat
+	buf = appendU32LE(buf, uint32(l.Width))            // pixelWidth
+	buf = appendU32LE(buf, uint32(l.Height))           // pixelHeight
+	buf = appendU32LE(buf, uint32(l.Depth))            // pixelDepth
+	buf = appendU32LE(buf, uint32(l.NumArrayElements)) // numberOfArrayElements
+	buf = appendU32LE(buf, uint32(max(1, l.NumFaces))) // numberOfFaces
+	buf = appendU32LE(buf, uint32(l.NumMipmapLevels))  // numberOfMipmapLevels
+	buf = appendU32LE(buf, uint32(len(keyValueData)))  // bytesOfKeyValueData
+	buf = append(buf, keyValueData...)
+	buf = appendU32LE(buf, uint32(l.ImageSize(0)))
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+
+	// sourceOptions[i] is etc2Options, with sources[i]'s base level coverage
+	// as the AlphaCoverage Target, if needed.
+	sourceOptions := make([]*etc2.EncodeOptions, len(sources))
+	for i, s := range sources {
+		sourceOptions[i] = etc2Options
+		if (numLevels > 1) && (etc2Options != nil) &&
+			(etc2Options.AlphaCoverage != nil) && (etc2Options.AlphaCoverage.Target == 0) {
+			ac := *etc2Options.AlphaCoverage
+			ac.Target = etc2.MeasureAlphaCoverage(croppedImage{s.m, s.r}, ac.Threshold)
+			o := *etc2Options
+			o.AlphaCoverage = &ac
+			sourceOptions[i] = &o
+		}
+	}
+
+	// Each face's payload size is always a multiple of 8, so no cubePadding
+	// or mipPadding is needed.
+	sources = append([]source(nil), sources...)
+	srcIsPremultiplied := (etc2Options != nil) && etc2Options.SourceIsPremultiplied
+	for level := range numLevels {
+		if level > 0 {
+			if _, err := w.Write(appendU32LE(buf[:0], uint32(l.ImageSize(level)))); err != nil {
+				return err
+			}
+		}
+		for i, s := range sources {
+			if err := etc2.EncodeRect(w, s.m, s.r, f, sourceOptions[i]); err != nil {
+				return err
+			}
+			if (level + 1) < numLevels {
+				m := downsample(s.m, s.r, linear, srcIsPremultiplied)
+				sources[i] = source{m, m.Bounds()}
+			}
+		}
+	}
+	return nil
+}
+
+// croppedImage is an image.Image restricted to the r sub-rectangle.
+type croppedImage struct {
+	image.Image
+	r image.Rectangle
+}
+
+func (m croppedImage) Bounds() image.Rectangle { return m.r }
+
+// openGLBaseInternalFormat returns the OpenGL base internal format (the
+// number of channels) for f, or 0 if f is invalid.
+func openGLBaseInternalFormat(f etc2.Format) uint32 {
+	switch f {
+	case etc2.FormatETC1S,
+		etc2.FormatETC1,
+		etc2.FormatETC2RGB,
+		etc2.FormatETC2SRGB:
+		return 0x1907 // GL_RGB
+
+	case etc2.FormatETC2RGBA1,
+		etc2.FormatETC2RGBA8,
+		etc2.FormatETC2SRGBA1,
+		etc2.FormatETC2SRGBA8:
+		return 0x1908 // GL_RGBA
+
+	case etc2.FormatETC2R11Unsigned,
+		etc2.FormatETC2R11Signed:
+		return 0x1903 // GL_RED
+
+	case etc2.FormatETC2RG11Unsigned,
+		etc2.FormatETC2RG11Signed:
+		return 0x8227 // GL_RG
+	}
+
+	return 0
+}
+
+func appendU32LE(b []byte, u uint32) []byte {
+	return append(b,
+		uint8(u>>0),
+		uint8(u>>8),
+		uint8(u>>16),
+		uint8(u>>24),
+	)
+}
+
+// replaceKeyValue returns a copy of keyValues without any existing value for
+// kv's key and with kv appended. keyValues itself is not modified.
+func replaceKeyValue(keyValues []KeyValue, kv KeyValue) []KeyValue {
+	kvs := make([]KeyValue, 0, len(keyValues)+1)
+	for _, x := range keyValues {
+		if x.Key != kv.Key {
+			kvs = append(kvs, x)
+		}
+	}
+	return append(kvs, kv)
+}
@@ -0,0 +1,534 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ktx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+func TestLayoutBlockOffset(tt *testing.T) {
+	const width, height, numLayers = 12, 8, 3
+	layers := make([]image.Image, numLayers)
+	for i := range layers {
+		m := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := range height {
+			for x := range width {
+				m.SetRGBA(x, y, color.RGBA{uint8(16 * x), uint8(16 * y), uint8(64 * i), 0xFF})
+			}
+		}
+		layers[i] = m
+	}
+
+	keyValues := []KeyValue{{Key: "KTXorientation", Value: []byte("S=r,T=d\x00")}}
+	buf := &bytes.Buffer{}
+	err := EncodeArray(buf, layers, &EncodeOptions{
+		Format:    etc2.FormatETC2RGBA8,
+		KeyValues: keyValues,
+	})
+	if err != nil {
+		tt.Fatalf("EncodeArray: %v", err)
+	}
+	got := buf.Bytes()
+
+	l := Layout{
+		Format:              etc2.FormatETC2RGBA8,
+		Width:               width,
+		Height:              height,
+		NumArrayElements:    numLayers,
+		NumFaces:            1,
+		NumMipmapLevels:     1,
+		BytesOfKeyValueData: 28,
+	}
+	if got, want := l.ImageSize(0), int64(numLayers*3*2*16); got != want {
+		tt.Fatalf("ImageSize: got %d, want %d", got, want)
+	}
+
+	for i, layer := range layers {
+		payload := &bytes.Buffer{}
+		if err := etc2.Encode(payload, layer, etc2.FormatETC2RGBA8, nil); err != nil {
+			tt.Fatalf("etc2.Encode: %v", err)
+		}
+		want := payload.Bytes()[etc2.FormatETC2RGBA8.BlockOffset(3, 2, 1):][:16]
+
+		offset, err := l.BlockOffset(0, 0, i, 2, 1)
+		if err != nil {
+			tt.Fatalf("BlockOffset: %v", err)
+		}
+		if !bytes.Equal(got[offset:offset+16], want) {
+			tt.Errorf("layer %d: got % x, want % x", i, got[offset:offset+16], want)
+		}
+	}
+
+	if _, err := l.BlockOffset(0, 0, numLayers, 0, 0); err != ErrBadArgument {
+		tt.Errorf("out of range layer: got %v, want %v", err, ErrBadArgument)
+	}
+
+	if decoded, err := DecodeLayout(bytes.NewReader(got)); err != nil {
+		tt.Fatalf("DecodeLayout: %v", err)
+	} else if decoded != l {
+		tt.Fatalf("DecodeLayout: got %+v, want %+v", decoded, l)
+	}
+	for i, layer := range layers {
+		m, err := DecodeImage(bytes.NewReader(got), &l, 0, 0, i)
+		if err != nil {
+			tt.Fatalf("DecodeImage: %v", err)
+		}
+		payload := &bytes.Buffer{}
+		if err := etc2.Encode(payload, layer, etc2.FormatETC2RGBA8, nil); err != nil {
+			tt.Fatalf("etc2.Encode: %v", err)
+		}
+		want, err := etc2.FormatETC2RGBA8.NewImage(width, height)
+		if err != nil {
+			tt.Fatalf("NewImage: %v", err)
+		}
+		if err := etc2.FormatETC2RGBA8.Decode(want, payload, 3, 2); err != nil {
+			tt.Fatalf("etc2.Decode: %v", err)
+		}
+		if got, want := m.(*image.NRGBA).Pix, want.(*image.NRGBA).Pix; !bytes.Equal(got, want) {
+			tt.Errorf("layer %d: decoded pixels differ", i)
+		}
+	}
+	if m, err := Decode(bytes.NewReader(got)); err != nil {
+		tt.Fatalf("Decode: %v", err)
+	} else if b := m.Bounds(); b != image.Rect(0, 0, width, height) {
+		tt.Fatalf("Decode: got bounds %v, want %v", b, image.Rect(0, 0, width, height))
+	}
+}
+
+func TestEncode3D(tt *testing.T) {
+	const width, height, depth = 12, 8, 3
+	slices := make([]image.Image, depth)
+	for i := range slices {
+		m := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := range height {
+			for x := range width {
+				m.SetRGBA(x, y, color.RGBA{uint8(16 * x), uint8(64 * i), uint8(16 * y), 0xFF})
+			}
+		}
+		slices[i] = m
+	}
+
+	buf := &bytes.Buffer{}
+	if err := Encode3D(buf, slices, nil); err != nil {
+		tt.Fatalf("Encode3D: %v", err)
+	}
+	got := buf.Bytes()
+	l, err := DecodeLayout(bytes.NewReader(got))
+	if err != nil {
+		tt.Fatalf("DecodeLayout: %v", err)
+	} else if (l.Depth != depth) || (l.NumArrayElements != 0) || (l.NumFaces != 1) || (l.NumMipmapLevels != 1) {
+		tt.Fatalf("DecodeLayout: got %+v, want depth %d and no array elements", l, depth)
+	} else if got, want := l.ImageSize(0), int64(depth*3*2*8); got != want {
+		tt.Fatalf("ImageSize: got %d, want %d", got, want)
+	}
+
+	// The depth slices are stored front to back.
+	for i, slice := range slices {
+		payload := &bytes.Buffer{}
+		if err := etc2.Encode(payload, slice, etc2.FormatETC2RGB, nil); err != nil {
+			tt.Fatalf("slice=%d: etc2.Encode: %v", i, err)
+		}
+		offset, err := l.BlockOffset(0, 0, i, 0, 0)
+		if err != nil {
+			tt.Fatalf("slice=%d: BlockOffset: %v", i, err)
+		} else if !bytes.Equal(got[offset:offset+int64(payload.Len())], payload.Bytes()) {
+			tt.Fatalf("slice=%d: encodings differ", i)
+		}
+	}
+
+	if err := Encode3D(&bytes.Buffer{}, slices, &EncodeOptions{Mipmaps: true}); err != ErrBadArgument {
+		tt.Fatalf("Mipmaps: got %v, want %v", err, ErrBadArgument)
+	} else if err := Encode3D(&bytes.Buffer{}, nil, nil); err != ErrBadArgument {
+		tt.Fatalf("no slices: got %v, want %v", err, ErrBadArgument)
+	}
+	mismatched := append([]image.Image{image.NewRGBA(image.Rect(0, 0, width, height+1))}, slices[1:]...)
+	if err := EncodeArray(&bytes.Buffer{}, mismatched, nil); err != ErrBadArgument {
+		tt.Fatalf("EncodeArray(mismatched): got %v, want %v", err, ErrBadArgument)
+	} else if err := Encode3D(&bytes.Buffer{}, mismatched, nil); err != ErrBadArgument {
+		tt.Fatalf("Encode3D(mismatched): got %v, want %v", err, ErrBadArgument)
+	}
+}
+
+func TestEncodeMipmaps(tt *testing.T) {
+	// A 1-pixel black and white checkerboard averages to a flat mid-gray.
+	const width, height = 8, 6
+	src := image.NewGray(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			src.Pix[(y*src.Stride)+x] = uint8(0xFF * ((x ^ y) & 1))
+		}
+	}
+
+	// In linear light, the average of 0x00 and 0xFF is 0xBC in sRGB.
+	testCases := []struct {
+		inSRGBSpace bool
+		want        uint8
+	}{
+		{false, 0xBC},
+		{true, 0x80},
+	}
+	for _, tc := range testCases {
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, src, &EncodeOptions{
+			Format:             etc2.FormatETC2SRGB,
+			Mipmaps:            true,
+			MipmapsInSRGBSpace: tc.inSRGBSpace,
+		}); err != nil {
+			tt.Fatalf("inSRGBSpace=%t: Encode: %v", tc.inSRGBSpace, err)
+		}
+		l, err := DecodeLayout(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			tt.Fatalf("inSRGBSpace=%t: DecodeLayout: %v", tc.inSRGBSpace, err)
+		} else if got, want := l.NumMipmapLevels, 4; got != want {
+			tt.Fatalf("inSRGBSpace=%t: NumMipmapLevels: got %d, want %d", tc.inSRGBSpace, got, want)
+		}
+
+		for level := 1; level < l.NumMipmapLevels; level++ {
+			m, err := DecodeImage(bytes.NewReader(buf.Bytes()), &l, level, 0, 0)
+			if err != nil {
+				tt.Fatalf("inSRGBSpace=%t, level=%d: DecodeImage: %v", tc.inSRGBSpace, level, err)
+			}
+			wantBounds := image.Rect(0, 0, max(1, width>>level), max(1, height>>level))
+			if got := m.Bounds(); got != wantBounds {
+				tt.Fatalf("inSRGBSpace=%t, level=%d: got bounds %v, want %v",
+					tc.inSRGBSpace, level, got, wantBounds)
+			}
+			c := color.NRGBAModel.Convert(m.At(0, 0)).(color.NRGBA)
+			if d := int(c.G) - int(tc.want); (d < -4) || (4 < d) {
+				tt.Fatalf("inSRGBSpace=%t, level=%d: got green 0x%02X, want 0x%02X",
+					tc.inSRGBSpace, level, c.G, tc.want)
+			}
+		}
+	}
+}
+
+func TestEncodeMipmapsPremultiplied(tt *testing.T) {
+	// Even columns are a premultiplied orange at half alpha and odd columns
+	// are transparent, so that every smaller level is a flat orange at a
+	// quarter alpha. Weighting the premultiplied colors by alpha again would
+	// give invalid premultiplied colors: red greater than alpha.
+	const width, height = 8, 8
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := 0; x < width; x += 2 {
+			src.SetRGBA(x, y, color.RGBA{0x80, 0x40, 0x20, 0x80})
+		}
+	}
+	want := color.NRGBA{0x40, 0x20, 0x10, 0x40}
+
+	for _, f := range []etc2.Format{etc2.FormatETC2RGBA8, etc2.FormatETC2SRGBA8} {
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, src, &EncodeOptions{
+			Format:      f,
+			Mipmaps:     true,
+			ETC2Options: &etc2.EncodeOptions{SourceIsPremultiplied: true},
+		}); err != nil {
+			tt.Fatalf("f=%v: Encode: %v", f, err)
+		}
+		l, err := DecodeLayout(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			tt.Fatalf("f=%v: DecodeLayout: %v", f, err)
+		}
+
+		// The decoded (non-premultiplied) values are the premultiplied
+		// values that were encoded as is.
+		for level := 1; level < l.NumMipmapLevels; level++ {
+			m, err := DecodeImage(bytes.NewReader(buf.Bytes()), &l, level, 0, 0)
+			if err != nil {
+				tt.Fatalf("f=%v, level=%d: DecodeImage: %v", f, level, err)
+			}
+			b := m.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					c := m.At(x, y).(color.NRGBA)
+					for i, d := range [4]int{
+						int(c.R) - int(want.R),
+						int(c.G) - int(want.G),
+						int(c.B) - int(want.B),
+						int(c.A) - int(want.A),
+					} {
+						if (d < -4) || (4 < d) {
+							tt.Fatalf("f=%v, level=%d, (%d, %d), channel %d: got %v, want %v",
+								f, level, x, y, i, c, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeMipLevel(tt *testing.T) {
+	const width, height = 20, 12
+	src := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(12 * x), uint8(20 * y), 0x80, 0xFF})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, src, &EncodeOptions{
+		Format:  etc2.FormatETC2RGB,
+		Mipmaps: true,
+	}); err != nil {
+		tt.Fatalf("Encode: %v", err)
+	}
+	l, err := DecodeLayout(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		tt.Fatalf("DecodeLayout: %v", err)
+	}
+
+	for level := range l.NumMipmapLevels {
+		want, err := DecodeImage(bytes.NewReader(buf.Bytes()), &l, level, 0, 0)
+		if err != nil {
+			tt.Fatalf("level=%d: DecodeImage: %v", level, err)
+		}
+		got, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{MipLevel: level})
+		if err != nil {
+			tt.Fatalf("level=%d: DecodeWithOptions: %v", level, err)
+		} else if !bytes.Equal(got.(*image.RGBA).Pix, want.(*image.RGBA).Pix) {
+			tt.Fatalf("level=%d: decoded pixels differ", level)
+		}
+		w, h := l.LevelSize(level)
+		if b := got.Bounds(); b != image.Rect(0, 0, w, h) {
+			tt.Fatalf("level=%d: got bounds %v, want %dx%d", level, b, w, h)
+		}
+	}
+
+	if _, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{MipLevel: l.NumMipmapLevels}); err != ErrBadArgument {
+		tt.Fatalf("out of range MipLevel: got %v, want %v", err, ErrBadArgument)
+	}
+}
+
+func TestDecodeLayoutErrors(tt *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, image.NewGray(image.Rect(0, 0, 8, 8)), &EncodeOptions{Format: etc2.FormatETC2RGB}); err != nil {
+		tt.Fatalf("Encode: %v", err)
+	}
+	valid := buf.Bytes()
+
+	// Each test case sets the uint32 field (after the 12 byte identifier) at
+	// index i to v, and the resulting *HeaderError should identify it.
+	testCases := []struct {
+		i       int
+		v       uint32
+		wantErr error
+	}{
+		{0, 0x1234_5678, ErrNotAKTXFile},
+		{1, 0x1401, ErrUnsupportedFormat},
+		{4, 0x1908, ErrUnsupportedFormat},
+		{6, 0, ErrUnsupportedTexture},
+		{7, 65536, ErrUnsupportedTexture},
+		{10, 2, ErrUnsupportedTexture},
+		{11, 18, ErrUnsupportedTexture},
+		{12, 3, ErrUnsupportedTexture},
+	}
+	for _, tc := range testCases {
+		data := bytes.Clone(valid)
+		binary.LittleEndian.PutUint32(data[12+(4*tc.i):], tc.v)
+		_, err := DecodeLayout(bytes.NewReader(data))
+		headerErr := (*HeaderError)(nil)
+		if !errors.As(err, &headerErr) {
+			tt.Errorf("i=%d: got %v, want a *HeaderError", tc.i, err)
+		} else if wantOffset := int64(12 + (4 * tc.i)); !errors.Is(err, tc.wantErr) || (headerErr.Offset != wantOffset) {
+			tt.Errorf("i=%d: got %v at %d, want %v at %d", tc.i, headerErr.Err, headerErr.Offset, tc.wantErr, wantOffset)
+		}
+	}
+
+	if _, err := DecodeLayout(bytes.NewReader(valid[:20])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		tt.Errorf("short header: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestEncodeNormalizeRange(tt *testing.T) {
+	src := image.NewGray16(image.Rect(0, 0, 8, 8))
+	for y := range 8 {
+		for x := range 8 {
+			src.SetGray16(x, y, color.Gray16{uint16(30000 + (100 * ((8 * y) + x)))})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, src, &EncodeOptions{
+		Format:         etc2.FormatETC2R11Unsigned,
+		KeyValues:      []KeyValue{{"foo", []byte("bar\x00")}, {ValueRangeKey, []byte("0,1\x00")}},
+		NormalizeRange: true,
+	}); err != nil {
+		tt.Fatalf("Encode: %v", err)
+	}
+	kvs, err := DecodeKeyValues(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		tt.Fatalf("DecodeKeyValues: %v", err)
+	}
+	if got, want := fmt.Sprintf("%q", kvs), `[{"foo" "bar\x00"} {"etc2ValueRange" "30000,36300\x00"}]`; got != want {
+		tt.Fatalf("key values:\ngot  %s\nwant %s", got, want)
+	}
+
+	if err := Encode(&bytes.Buffer{}, src, &EncodeOptions{
+		Format:         etc2.FormatETC2RG11Unsigned,
+		NormalizeRange: true,
+	}); err != ErrBadArgument {
+		tt.Fatalf("RG11: got %v, want %v", err, ErrBadArgument)
+	}
+}
+
+func TestEncodeNormalMapping(tt *testing.T) {
+	src := image.NewRGBA64(image.Rect(0, 0, 8, 8))
+	for _, nm := range []etc2.NormalMapping{etc2.NormalMappingNone, etc2.NormalMappingOctahedral, etc2.NormalMappingLongLat} {
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, src, &EncodeOptions{
+			Format:      etc2.FormatETC2RG11Unsigned,
+			KeyValues:   []KeyValue{{NormalMappingKey, []byte("stale\x00")}, {"foo", []byte("bar\x00")}},
+			ETC2Options: &etc2.EncodeOptions{NormalMapping: nm},
+		}); err != nil {
+			tt.Fatalf("nm=%v: Encode: %v", nm, err)
+		}
+		kvs, err := DecodeKeyValues(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			tt.Fatalf("nm=%v: DecodeKeyValues: %v", nm, err)
+		}
+		want := `[{"etc2NormalMapping" "stale\x00"} {"foo" "bar\x00"}]`
+		if nm != etc2.NormalMappingNone {
+			want = fmt.Sprintf(`[{"foo" "bar\x00"} {"etc2NormalMapping" "%s\x00"}]`, nm)
+		}
+		if got := fmt.Sprintf("%q", kvs); got != want {
+			tt.Fatalf("nm=%v: key values:\ngot  %s\nwant %s", nm, got, want)
+		}
+	}
+
+	if err := Encode(&bytes.Buffer{}, src, &EncodeOptions{
+		Format:      etc2.FormatETC2RGB,
+		ETC2Options: &etc2.EncodeOptions{NormalMapping: etc2.NormalMappingOctahedral},
+	}); err != etc2.ErrBadArgument {
+		tt.Fatalf("RGB: got %v, want %v", err, etc2.ErrBadArgument)
+	}
+}
+
+// makeCubemapFaces returns six distinct, size×size faces.
+func makeCubemapFaces(size int) *[6]image.Image {
+	faces := &[6]image.Image{}
+	for i := range faces {
+		m := image.NewNRGBA(image.Rect(0, 0, size, size))
+		for y := range size {
+			for x := range size {
+				m.SetNRGBA(x, y, color.NRGBA{uint8(40 * i), uint8(8 * x), uint8(8 * y), 0xFF})
+			}
+		}
+		faces[i] = m
+	}
+	return faces
+}
+
+func TestEncodeCubemap(tt *testing.T) {
+	const size = 8
+	faces := makeCubemapFaces(size)
+	buf := &bytes.Buffer{}
+	if err := EncodeCubemap(buf, faces, &EncodeOptions{Mipmaps: true}); err != nil {
+		tt.Fatalf("EncodeCubemap: %v", err)
+	}
+	got := buf.Bytes()
+
+	l, err := DecodeLayout(bytes.NewReader(got))
+	if err != nil {
+		tt.Fatalf("DecodeLayout: %v", err)
+	} else if (l.NumFaces != 6) || (l.NumArrayElements != 0) || (l.NumMipmapLevels != 4) {
+		tt.Fatalf("DecodeLayout: got %+v, want 6 faces, 0 array elements and 4 levels", l)
+	}
+
+	// For non-array cube maps, imageSize is the size of one face, not of the
+	// whole level.
+	for level, want := range []int64{2 * 2 * 8, 1 * 1 * 8, 1 * 1 * 8, 1 * 1 * 8} {
+		if got := l.ImageSize(level); got != want {
+			tt.Fatalf("level=%d: ImageSize: got %d, want %d", level, got, want)
+		}
+	}
+	offset := int64(HeaderSize + l.BytesOfKeyValueData)
+	if got, want := int64(binary.LittleEndian.Uint32(got[offset:])), l.ImageSize(0); got != want {
+		tt.Fatalf("imageSize field: got %d, want %d", got, want)
+	}
+
+	// The faces are stored in the given (+X, -X, +Y, -Y, +Z, -Z) order.
+	for i, face := range faces {
+		payload := &bytes.Buffer{}
+		if err := etc2.Encode(payload, face, etc2.FormatETC2RGB, nil); err != nil {
+			tt.Fatalf("face=%d: etc2.Encode: %v", i, err)
+		}
+		offset, err := l.BlockOffset(0, i, 0, 0, 0)
+		if err != nil {
+			tt.Fatalf("face=%d: BlockOffset: %v", i, err)
+		} else if !bytes.Equal(got[offset:offset+int64(payload.Len())], payload.Bytes()) {
+			tt.Fatalf("face=%d: encodings differ", i)
+		}
+	}
+
+	if _, err := l.BlockOffset(0, 6, 0, 0, 0); err != ErrBadArgument {
+		tt.Fatalf("out of range face: got %v, want %v", err, ErrBadArgument)
+	}
+	nonSquare := *faces
+	nonSquare[3] = image.NewNRGBA(image.Rect(0, 0, size, size+4))
+	if err := EncodeCubemap(&bytes.Buffer{}, &nonSquare, nil); err != ErrBadArgument {
+		tt.Fatalf("non-square face: got %v, want %v", err, ErrBadArgument)
+	}
+	missing := *faces
+	missing[5] = nil
+	if err := EncodeCubemap(&bytes.Buffer{}, &missing, nil); err != ErrBadArgument {
+		tt.Fatalf("missing face: got %v, want %v", err, ErrBadArgument)
+	}
+}
+
+func TestEncodeCubemapCross(tt *testing.T) {
+	const size = 8
+	faces := makeCubemapFaces(size)
+	want := &bytes.Buffer{}
+	if err := EncodeCubemap(want, faces, nil); err != nil {
+		tt.Fatalf("EncodeCubemap: %v", err)
+	}
+
+	// Lay the faces out as a horizontal cross, in a larger image whose
+	// Bounds().Min is not the origin:
+	//
+	//	    +Y
+	//	-X  +Z  +X  -Z
+	//	    -Y
+	origin := image.Point{X: 3, Y: 5}
+	cross := image.NewNRGBA(image.Rectangle{Min: origin, Max: origin.Add(image.Point{X: 4 * size, Y: 3 * size})})
+	cells := [6]image.Point{{2, 1}, {0, 1}, {1, 0}, {1, 2}, {1, 1}, {3, 1}}
+	for i, c := range cells {
+		min := origin.Add(c.Mul(size))
+		for y := range size {
+			for x := range size {
+				cross.Set(min.X+x, min.Y+y, faces[i].At(x, y))
+			}
+		}
+	}
+	got := &bytes.Buffer{}
+	if err := EncodeCubemapCross(got, cross, nil); err != nil {
+		tt.Fatalf("EncodeCubemapCross: %v", err)
+	} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		tt.Fatalf("EncodeCubemapCross: output differs from EncodeCubemap")
+	}
+
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 4*size, 4*size),
+		image.Rect(0, 0, (4*size)+1, 3*size),
+		image.Rect(0, 0, 3, 2),
+	} {
+		if err := EncodeCubemapCross(&bytes.Buffer{}, image.NewNRGBA(r), nil); err != ErrBadArgument {
+			tt.Fatalf("bounds=%v: got %v, want %v", r, err, ErrBadArgument)
+		}
+	}
+}
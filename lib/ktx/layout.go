@@ -0,0 +1,108 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ktx
+
+import (
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+// HeaderSize is the size, in bytes, of a KTX file's fixed-size header,
+// including the Magic bytes but excluding the key-value data.
+const HeaderSize = 64
+
+// Layout holds the KTX header fields that determine where each block's bytes
+// are in a KTX file.
+//
+// Depth, NumArrayElements and NumFaces follow the KTX header's conventions:
+// zero Depth means a 2D (not 3D) texture, zero NumArrayElements means a non-
+// array texture and NumFaces is either 1 or 6, although zero is treated as 1.
+type Layout struct {
+	Format              etc2.Format
+	Width               int
+	Height              int
+	Depth               int
+	NumArrayElements    int
+	NumFaces            int
+	NumMipmapLevels     int
+	BytesOfKeyValueData int
+}
+
+// levelSize returns a mipmap level's width or height (or depth), given the
+// base level's.
+func levelSize(n int, level int) int {
+	return max(1, n>>level)
+}
+
+// numImagesPerLevel returns the number of 2D images in the given mipmap level.
+func (l *Layout) numImagesPerLevel(level int) int {
+	n := max(1, l.NumArrayElements) * max(1, l.NumFaces)
+	if l.Depth > 0 {
+		n *= levelSize(l.Depth, level)
+	}
+	return n
+}
+
+// bytesPerImage returns the size of one 2D image in the given mipmap level.
+func (l *Layout) bytesPerImage(level int) int64 {
+	widthInBlocks := etc2.BlocksWide(levelSize(l.Width, level))
+	heightInBlocks := etc2.BlocksHigh(levelSize(l.Height, level))
+	return l.Format.PayloadSize(widthInBlocks, heightInBlocks)
+}
+
+// ImageSize returns the value of the imageSize field that precedes the given
+// mipmap level's data. For non-array cube maps, this is the size of one face.
+// Otherwise, it is the size of the whole mipmap level.
+func (l *Layout) ImageSize(level int) int64 {
+	if (l.NumFaces == 6) && (l.NumArrayElements == 0) {
+		return l.bytesPerImage(level)
+	}
+	return l.bytesPerImage(level) * int64(l.numImagesPerLevel(level))
+}
+
+// BlockOffset returns the byte offset, from the start of the KTX file, of the
+// block at (blockX, blockY) in the given mipmap level, face and layer. blockX
+// and blockY are measured in blocks, not pixels.
+//
+// layer is the array element or, for 3D textures, the depth slice. For 3D
+// array textures, it is (arrayElement * levelDepth) + depthSlice.
+func (l *Layout) BlockOffset(level int, face int, layer int, blockX int, blockY int) (int64, error) {
+	if (l.Format.ETCVersion() == 0) || (l.Width <= 0) || (l.Height <= 0) ||
+		(level < 0) || (level >= max(1, l.NumMipmapLevels)) ||
+		(face < 0) || (face >= max(1, l.NumFaces)) ||
+		(blockX < 0) || (blockX >= etc2.BlocksWide(levelSize(l.Width, level))) ||
+		(blockY < 0) || (blockY >= etc2.BlocksHigh(levelSize(l.Height, level))) {
+		return 0, ErrBadArgument
+	}
+
+	numLayers := max(1, l.NumArrayElements)
+	depth := 1
+	if l.Depth > 0 {
+		depth = levelSize(l.Depth, level)
+		numLayers *= depth
+	}
+	if (layer < 0) || (layer >= numLayers) {
+		return 0, ErrBadArgument
+	}
+
+	// Each level's data is preceded by a 4-byte imageSize. Block data sizes
+	// are always multiples of 8, so no cubePadding or mipPadding is needed.
+	offset := int64(HeaderSize + l.BytesOfKeyValueData)
+	for i := range level {
+		offset += 4 + (l.bytesPerImage(i) * int64(l.numImagesPerLevel(i)))
+	}
+	offset += 4
+
+	// Images are ordered by array element, then face, then depth slice.
+	arrayElement, depthSlice := layer/depth, layer%depth
+	imageIndex := (((arrayElement * max(1, l.NumFaces)) + face) * depth) + depthSlice
+	offset += int64(imageIndex) * l.bytesPerImage(level)
+
+	widthInBlocks := etc2.BlocksWide(levelSize(l.Width, level))
+	return offset + l.Format.BlockOffset(widthInBlocks, blockX, blockY), nil
+}
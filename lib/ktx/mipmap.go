@@ -0,0 +1,198 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ktx
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sync"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+// NumMipmapLevels returns the length of a full mipmap chain, down to 1×1, for
+// a base level of the given width and height.
+func NumMipmapLevels(width int, height int) int {
+	n := 1
+	for m := max(width, height); m > 1; m >>= 1 {
+		n++
+	}
+	return n
+}
+
+// isSRGB returns whether f's color values are sRGB-encoded.
+func isSRGB(f etc2.Format) bool {
+	switch f {
+	case etc2.FormatETC2SRGB, etc2.FormatETC2SRGBA1, etc2.FormatETC2SRGBA8:
+		return true
+	}
+	return false
+}
+
+// downsample returns the next mipmap level after the r sub-rectangle of src:
+// an image half as wide and high (rounding down, but at least 1 pixel). Each
+// destination pixel is the alpha-weighted average of the source pixels that it
+// covers, which is a 2×2 box filter when r's dimensions are even.
+//
+// If linear is true, the red, green and blue values are treated as sRGB-
+// encoded: they are converted to linear light before averaging and back to
+// sRGB afterwards. Averaging sRGB-encoded values directly would darken the
+// result.
+//
+// If srcIsPremultiplied is true, src's colors are used as is instead of being
+// un-premultiplied, like etc2.EncodeOptions.SourceIsPremultiplied, and the
+// returned image's colors should be treated the same way. Premultiplied
+// values are already weighted by alpha, so all four channels are averaged
+// with equal weights. If linear is also true, alpha is divided out before
+// the sRGB conversion and multiplied back in afterwards.
+func downsample(src image.Image, r image.Rectangle, linear bool, srcIsPremultiplied bool) *image.NRGBA64 {
+	sw, sh := r.Dx(), r.Dy()
+	dw, dh := max(1, sw/2), max(1, sh/2)
+	dst := image.NewNRGBA64(image.Rect(0, 0, dw, dh))
+	if linear {
+		srgbToLinearOnce.Do(initSRGBToLinear)
+	}
+
+	for dy := range dh {
+		y0 := r.Min.Y + ((dy * sh) / dh)
+		y1 := r.Min.Y + (((dy + 1) * sh) / dh)
+		for dx := range dw {
+			x0 := r.Min.X + ((dx * sw) / dw)
+			x1 := r.Min.X + (((dx + 1) * sw) / dw)
+
+			// The color sums are premultiplied by alpha.
+			sumR, sumG, sumB, sumA, n := 0.0, 0.0, 0.0, 0.0, 0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					c := color.NRGBA64{}
+					if srcIsPremultiplied {
+						cr, cg, cb, ca := premultipliedAt(src, x, y)
+						if !linear {
+							sumR += float64(cr)
+							sumG += float64(cg)
+							sumB += float64(cb)
+							sumA += float64(ca)
+							n++
+							continue
+						}
+						c = unpremultiply(cr, cg, cb, ca)
+					} else {
+						c = color.NRGBA64Model.Convert(src.At(x, y)).(color.NRGBA64)
+					}
+					a := float64(c.A)
+					if linear {
+						sumR += a * srgbToLinear[c.R]
+						sumG += a * srgbToLinear[c.G]
+						sumB += a * srgbToLinear[c.B]
+					} else {
+						sumR += a * float64(c.R)
+						sumG += a * float64(c.G)
+						sumB += a * float64(c.B)
+					}
+					sumA += a
+					n++
+				}
+			}
+			vA := sumA / float64(n)
+			if srcIsPremultiplied && !linear {
+				dst.SetNRGBA64(dx, dy, color.NRGBA64{
+					R: uint16(math.Round(sumR / float64(n))),
+					G: uint16(math.Round(sumG / float64(n))),
+					B: uint16(math.Round(sumB / float64(n))),
+					A: uint16(math.Round(vA)),
+				})
+				continue
+			} else if sumA == 0 {
+				continue
+			}
+
+			vR, vG, vB := sumR/sumA, sumG/sumA, sumB/sumA
+			if linear {
+				vR, vG, vB = linearToSRGB(vR), linearToSRGB(vG), linearToSRGB(vB)
+			}
+			if srcIsPremultiplied {
+				vR, vG, vB = (vR*vA)/0xFFFF, (vG*vA)/0xFFFF, (vB*vA)/0xFFFF
+			}
+			dst.SetNRGBA64(dx, dy, color.NRGBA64{
+				R: uint16(math.Round(vR)),
+				G: uint16(math.Round(vG)),
+				B: uint16(math.Round(vB)),
+				A: uint16(math.Round(vA)),
+			})
+		}
+	}
+	return dst
+}
+
+// premultipliedAt returns src's premultiplied color at (x, y). Like
+// etc2.EncodeOptions.SourceIsPremultiplied, it treats the *image.NRGBA and
+// *image.NRGBA64 types' values (such as those returned by downsample) as
+// already premultiplied.
+func premultipliedAt(src image.Image, x int, y int) (r uint32, g uint32, b uint32, a uint32) {
+	switch m := src.(type) {
+	case *image.NRGBA:
+		c := m.NRGBAAt(x, y)
+		return 0x101 * uint32(c.R), 0x101 * uint32(c.G), 0x101 * uint32(c.B), 0x101 * uint32(c.A)
+	case interface {
+		NRGBA64At(x int, y int) color.NRGBA64
+	}:
+		c := m.NRGBA64At(x, y)
+		return uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)
+	}
+	return src.At(x, y).RGBA()
+}
+
+// unpremultiply converts premultiplied 16-bit color values, as returned by
+// color.Color's RGBA method, to non-premultiplied ones. Values greater than
+// the alpha, which are not valid premultiplied colors, are clamped.
+func unpremultiply(r uint32, g uint32, b uint32, a uint32) color.NRGBA64 {
+	if a == 0 {
+		return color.NRGBA64{}
+	} else if a == 0xFFFF {
+		return color.NRGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: 0xFFFF}
+	}
+	return color.NRGBA64{
+		R: uint16((min(r, a) * 0xFFFF) / a),
+		G: uint16((min(g, a) * 0xFFFF) / a),
+		B: uint16((min(b, a) * 0xFFFF) / a),
+		A: uint16(a),
+	}
+}
+
+// srgbToLinear maps a 16-bit sRGB value to its linear light value, on the
+// same 16-bit scale.
+var (
+	srgbToLinear     *[65536]float64
+	srgbToLinearOnce sync.Once
+)
+
+func initSRGBToLinear() {
+	srgbToLinear = &[65536]float64{}
+	for i := range srgbToLinear {
+		v := float64(i) / 0xFFFF
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		srgbToLinear[i] = v * 0xFFFF
+	}
+}
+
+// linearToSRGB is the inverse of srgbToLinear.
+func linearToSRGB(v float64) float64 {
+	v /= 0xFFFF
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = (1.055 * math.Pow(v, 1/2.4)) - 0.055
+	}
+	return min(0xFFFF, max(0, v*0xFFFF))
+}
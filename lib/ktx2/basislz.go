@@ -0,0 +1,743 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ktx2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+)
+
+// BasisLZ is the supercompression scheme used by the basisu and toktx tools
+// for ETC1S payloads. Each image's blocks are coded as indexes into two
+// codebooks (of ETC1S base color and intensity pairs, and of 4×4 selectors)
+// that are shared by the whole file and held in its supercompression global
+// data. The codebooks, indexes and various Huffman tables are all specified
+// by the KTX 2.0 specification's BasisLZ section and by
+// https://github.com/BinomialLLC/basis_universal/wiki/.basis-File-Format-and-ETC1S-Texture-Video-Specification
+//
+// Transcoding to ETC1 (and therefore ETC2 RGB) is lossless: each ETC1S block
+// is already an ETC1 block. Alpha slices are ETC1S too, and are re-encoded as
+// EAC alpha, giving ETC2 RGBA8.
+
+const (
+	// khrDFModelETC1S is the Khronos Data Format's KHR_DF_MODEL_ETC1S color
+	// model.
+	khrDFModelETC1S = 163
+
+	// khrDFChannelETC1SAAA is the channel ID of an ETC1S alpha slice.
+	khrDFChannelETC1SAAA = 15
+
+	// khrDFTransferSRGB is the Khronos Data Format's KHR_DF_TRANSFER_SRGB
+	// transfer function.
+	khrDFTransferSRGB = 2
+
+	// basisLZImageIsPFrame is the imageFlags bit for a video P-frame, which
+	// is coded relative to the previous frame.
+	basisLZImageIsPFrame = 0x02
+)
+
+// These constants come from the ETC1S texture specification.
+const (
+	// The endpoint predictor symbols code four 2-bit predictors (one per block
+	// of a 2×2 group of blocks), plus a symbol to repeat the previous one.
+	endpointPredRepeatLastSymbol = 4 * 4 * 4 * 4
+	endpointPredCountVLCBits     = 4
+	endpointPredMinRepeatCount   = 3
+
+	selectorHistoryBufRLECountThreshold = 3
+	selectorHistoryBufRLECountTotal     = 64
+
+	// The Huffman tables' code lengths are themselves Huffman coded, using
+	// 17 literal lengths (0 to 16) and 4 run-length codes.
+	huffmanMaxCodeSize       = 16
+	huffmanSmallZeroRunCode  = 17
+	huffmanBigZeroRunCode    = 18
+	huffmanSmallRepeatCode   = 19
+	huffmanBigRepeatCode     = 20
+	huffmanTotalLengthCodes  = 21
+	huffmanLengthCodeSizeLog = 3
+)
+
+// huffmanSortedLengthCodes is the order that the code length codes' sizes are
+// stored in, most likely to be used first.
+var huffmanSortedLengthCodes = [huffmanTotalLengthCodes]uint8{
+	huffmanSmallZeroRunCode, huffmanBigZeroRunCode,
+	huffmanSmallRepeatCode, huffmanBigRepeatCode,
+	0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15, 16,
+}
+
+// etc1sEndpoint is an ETC1S block's 5-bit base color and 3-bit intensity
+// table index.
+type etc1sEndpoint struct {
+	color5 [3]uint8
+	inten  uint8
+}
+
+// etc1sSelectors holds a block's 16 2-bit selectors, one byte per row. Within
+// a row, the left-most pixel's selector is in the low bits. Selectors are in
+// increasing order of intensity modifier: 0 means the most negative.
+type etc1sSelectors [4]uint8
+
+// basisLZImageDesc is one of the global data's imageDescs. The offsets are
+// relative to the start of the mipmap level's data.
+type basisLZImageDesc struct {
+	flags       uint32
+	rgbOffset   uint32
+	rgbLength   uint32
+	alphaOffset uint32
+	alphaLength uint32
+}
+
+// basisLZ is a BasisLZ-supercompressed file's decoded global data.
+type basisLZ struct {
+	hasAlpha bool
+
+	// end is the byte offset, from the start of the file, of the end of the
+	// global data.
+	end int64
+
+	endpoints  []etc1sEndpoint
+	selectors  []etc1sSelectors
+	imageDescs []basisLZImageDesc
+
+	endpointPredModel          huffmanTable
+	deltaEndpointModel         huffmanTable
+	selectorModel              huffmanTable
+	selectorHistoryBufRLEModel huffmanTable
+	selectorHistoryBufSize     int
+}
+
+// decodeBasisLZ reads a BasisLZ-supercompressed file's data format descriptor
+// and supercompression global data from r, which is positioned consumed bytes
+// into the file. header is the file's header. It returns the etc2.Format that
+// the file's images are transcoded to.
+func decodeBasisLZ(r io.Reader, header *[HeaderSize]byte, consumed int64, numImages int) (etc2.Format, *basisLZ, error) {
+	dfdByteOffset := int64(binary.LittleEndian.Uint32(header[48:]))
+	dfdByteLength := int64(binary.LittleEndian.Uint32(header[52:]))
+	sgdByteOffset := binary.LittleEndian.Uint64(header[64:])
+	sgdByteLength := binary.LittleEndian.Uint64(header[72:])
+
+	// The data format descriptor starts with its total size and then the
+	// basic descriptor block's vendorId, descriptorType, versionNumber and
+	// descriptorBlockSize. Its colorModel and transferFunction are at offsets
+	// 12 and 14 and its 16-byte samples start at offset 28.
+	if (dfdByteOffset < consumed) || (dfdByteLength < 44) || (dfdByteLength > (1 << 16)) {
+		return 0, nil, &HeaderError{48, "dfdByteOffset and dfdByteLength (want a basic descriptor block)", ErrUnsupportedFormat}
+	} else if err := skip(r, dfdByteOffset-consumed); err != nil {
+		return 0, nil, err
+	}
+	dfd := make([]byte, dfdByteLength)
+	if _, err := io.ReadFull(r, dfd); err != nil {
+		return 0, nil, truncated(err, dfdByteOffset, "truncated data format descriptor")
+	} else if dfd[12] != khrDFModelETC1S {
+		return 0, nil, &HeaderError{dfdByteOffset + 12, "data format descriptor colorModel (want KHR_DF_MODEL_ETC1S)", ErrUnsupportedBasisLZ}
+	}
+	b := &basisLZ{}
+	numSamples := (int(binary.LittleEndian.Uint16(dfd[10:])) - 24) / 16
+	for i := 0; (i < numSamples) && ((28 + (16 * i) + 16) <= len(dfd)); i++ {
+		if (dfd[28+(16*i)+3] & 0x0F) == khrDFChannelETC1SAAA {
+			b.hasAlpha = true
+		}
+	}
+	f, srgb := etc2.FormatETC2RGB, dfd[14] == khrDFTransferSRGB
+	switch {
+	case b.hasAlpha && srgb:
+		f = etc2.FormatETC2SRGBA8
+	case b.hasAlpha:
+		f = etc2.FormatETC2RGBA8
+	case srgb:
+		f = etc2.FormatETC2SRGB
+	}
+
+	consumed = dfdByteOffset + dfdByteLength
+	if (sgdByteOffset < uint64(consumed)) || (sgdByteOffset > (1 << 62)) || (sgdByteLength > (1 << 28)) {
+		return 0, nil, &HeaderError{64, "sgdByteOffset and sgdByteLength (want global data after the data format descriptor)", ErrUnsupportedTexture}
+	} else if err := skip(r, int64(sgdByteOffset)-consumed); err != nil {
+		return 0, nil, err
+	}
+	sgd := make([]byte, sgdByteLength)
+	if _, err := io.ReadFull(r, sgd); err != nil {
+		return 0, nil, truncated(err, int64(sgdByteOffset), "truncated supercompression global data")
+	}
+	b.end = int64(sgdByteOffset + sgdByteLength)
+	if err := b.decodeGlobalData(sgd, numImages); err != nil {
+		return 0, nil, err
+	}
+	return f, b, nil
+}
+
+// skip discards n bytes from r.
+func skip(r io.Reader, n int64) error {
+	if _, err := io.CopyN(io.Discard, r, n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// truncated converts an io.ReadFull error for the field at offset to a
+// *HeaderError, if it was caused by too few bytes.
+func truncated(err error, offset int64, field string) error {
+	if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+		return &HeaderError{offset, field, io.ErrUnexpectedEOF}
+	}
+	return err
+}
+
+// decodeGlobalData decodes the supercompression global data: a 20-byte
+// header, numImages 20-byte imageDescs and then the endpoint codebook,
+// selector codebook and Huffman tables.
+func (b *basisLZ) decodeGlobalData(sgd []byte, numImages int) error {
+	if len(sgd) < 20 {
+		return ErrInvalidBasisLZ
+	}
+	numEndpoints := int(binary.LittleEndian.Uint16(sgd[0:]))
+	numSelectors := int(binary.LittleEndian.Uint16(sgd[2:]))
+	endpointsLength := uint64(binary.LittleEndian.Uint32(sgd[4:]))
+	selectorsLength := uint64(binary.LittleEndian.Uint32(sgd[8:]))
+	tablesLength := uint64(binary.LittleEndian.Uint32(sgd[12:]))
+	extendedLength := uint64(binary.LittleEndian.Uint32(sgd[16:]))
+	sgd = sgd[20:]
+	if (numEndpoints == 0) || (numSelectors == 0) ||
+		(uint64(len(sgd)) != ((20 * uint64(numImages)) + endpointsLength + selectorsLength + tablesLength + extendedLength)) {
+		return ErrInvalidBasisLZ
+	}
+
+	b.imageDescs = make([]basisLZImageDesc, numImages)
+	for i := range b.imageDescs {
+		b.imageDescs[i] = basisLZImageDesc{
+			flags:       binary.LittleEndian.Uint32(sgd[0:]),
+			rgbOffset:   binary.LittleEndian.Uint32(sgd[4:]),
+			rgbLength:   binary.LittleEndian.Uint32(sgd[8:]),
+			alphaOffset: binary.LittleEndian.Uint32(sgd[12:]),
+			alphaLength: binary.LittleEndian.Uint32(sgd[16:]),
+		}
+		sgd = sgd[20:]
+	}
+
+	if err := b.decodeEndpoints(sgd[:endpointsLength], numEndpoints); err != nil {
+		return err
+	}
+	sgd = sgd[endpointsLength:]
+	if err := b.decodeSelectors(sgd[:selectorsLength], numSelectors); err != nil {
+		return err
+	}
+	sgd = sgd[selectorsLength:]
+	return b.decodeTables(sgd[:tablesLength])
+}
+
+// decodeEndpoints decodes the endpoint codebook. Each base color channel and
+// the intensity is delta coded from the previous entry's.
+func (b *basisLZ) decodeEndpoints(data []byte, numEndpoints int) error {
+	br := bitReader{data: data}
+	colorDeltaModels := [3]huffmanTable{}
+	intenDeltaModel := huffmanTable{}
+	for i := range colorDeltaModels {
+		if !br.readHuffmanTable(&colorDeltaModels[i]) {
+			return ErrInvalidBasisLZ
+		}
+	}
+	if !br.readHuffmanTable(&intenDeltaModel) {
+		return ErrInvalidBasisLZ
+	}
+	grayscale := br.bits(1) != 0
+
+	// Which of the three color delta models applies depends on the previous
+	// value: at most 9, at most 21 or at most 31.
+	prevColor5, prevInten := [3]uint8{16, 16, 16}, uint8(0)
+	b.endpoints = make([]etc1sEndpoint, numEndpoints)
+	for i := range b.endpoints {
+		prevInten = (prevInten + uint8(br.decode(&intenDeltaModel))) & 7
+		b.endpoints[i].inten = prevInten
+		numChannels := 3
+		if grayscale {
+			numChannels = 1
+		}
+		for c := range numChannels {
+			m := &colorDeltaModels[2]
+			if prevColor5[c] <= 9 {
+				m = &colorDeltaModels[0]
+			} else if prevColor5[c] <= 21 {
+				m = &colorDeltaModels[1]
+			}
+			prevColor5[c] = (prevColor5[c] + uint8(br.decode(m))) & 31
+			b.endpoints[i].color5[c] = prevColor5[c]
+		}
+		if grayscale {
+			b.endpoints[i].color5[1] = b.endpoints[i].color5[0]
+			b.endpoints[i].color5[2] = b.endpoints[i].color5[0]
+		}
+	}
+	if br.bad {
+		return ErrInvalidBasisLZ
+	}
+	return nil
+}
+
+// decodeSelectors decodes the selector codebook. Each row of each entry is
+// either stored raw or XOR-delta coded from the previous entry's.
+func (b *basisLZ) decodeSelectors(data []byte, numSelectors int) error {
+	br := bitReader{data: data}
+	if br.bits(1) != 0 {
+		return fmt.Errorf("%w (global selector codebook)", ErrUnsupportedBasisLZ)
+	} else if br.bits(1) != 0 {
+		return fmt.Errorf("%w (hybrid selector codebook)", ErrUnsupportedBasisLZ)
+	}
+	raw := br.bits(1) != 0
+	deltaModel := huffmanTable{}
+	if !raw && !br.readHuffmanTable(&deltaModel) {
+		return ErrInvalidBasisLZ
+	}
+
+	b.selectors = make([]etc1sSelectors, numSelectors)
+	prev := etc1sSelectors{}
+	for i := range b.selectors {
+		for y := range 4 {
+			if raw || (i == 0) {
+				b.selectors[i][y] = uint8(br.bits(8))
+			} else {
+				b.selectors[i][y] = prev[y] ^ uint8(br.decode(&deltaModel))
+			}
+		}
+		prev = b.selectors[i]
+	}
+	if br.bad {
+		return ErrInvalidBasisLZ
+	}
+	return nil
+}
+
+// decodeTables decodes the Huffman tables used by every image's slices.
+func (b *basisLZ) decodeTables(data []byte) error {
+	br := bitReader{data: data}
+	if !br.readHuffmanTable(&b.endpointPredModel) ||
+		!br.readHuffmanTable(&b.deltaEndpointModel) ||
+		!br.readHuffmanTable(&b.selectorModel) ||
+		!br.readHuffmanTable(&b.selectorHistoryBufRLEModel) {
+		return ErrInvalidBasisLZ
+	}
+	b.selectorHistoryBufSize = int(br.bits(13))
+	if br.bad || (b.selectorHistoryBufSize == 0) {
+		return ErrInvalidBasisLZ
+	}
+	return nil
+}
+
+// transcodeLevel converts a mipmap level's BasisLZ data to ETC2, per
+// l.Format. The result holds l.numImagesPerLevel(level) images.
+func (b *basisLZ) transcodeLevel(data []byte, l *Layout, level int) ([]byte, error) {
+	firstImage := 0
+	for i := range level {
+		firstImage += l.numImagesPerLevel(i)
+	}
+	numImages := l.numImagesPerLevel(level)
+	if (firstImage + numImages) > len(b.imageDescs) {
+		return nil, ErrInvalidBasisLZ
+	}
+	width, height := levelSize(l.Width, level), levelSize(l.Height, level)
+	widthInBlocks, heightInBlocks := etc2.BlocksWide(width), etc2.BlocksHigh(height)
+	etc1Size := 8 * widthInBlocks * heightInBlocks
+
+	dst := make([]byte, 0, l.bytesPerImage(level)*int64(numImages))
+	for _, desc := range b.imageDescs[firstImage : firstImage+numImages] {
+		if (desc.flags & basisLZImageIsPFrame) != 0 {
+			return nil, fmt.Errorf("%w (video P-frame)", ErrUnsupportedBasisLZ)
+		}
+		rgb, err := sliceData(data, desc.rgbOffset, desc.rgbLength)
+		if err != nil {
+			return nil, err
+		}
+		rgbETC1 := make([]byte, etc1Size)
+		if err := b.transcodeSlice(rgbETC1, rgb, widthInBlocks, heightInBlocks); err != nil {
+			return nil, err
+		}
+		if !b.hasAlpha {
+			dst = append(dst, rgbETC1...)
+			continue
+		}
+
+		alpha, err := sliceData(data, desc.alphaOffset, desc.alphaLength)
+		if err != nil {
+			return nil, err
+		}
+		alphaETC1 := make([]byte, etc1Size)
+		if err := b.transcodeSlice(alphaETC1, alpha, widthInBlocks, heightInBlocks); err != nil {
+			return nil, err
+		}
+		rgba8, err := combineAlpha(rgbETC1, alphaETC1, widthInBlocks, heightInBlocks)
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, rgba8...)
+	}
+	return dst, nil
+}
+
+// sliceData returns the length bytes at offset in data.
+func sliceData(data []byte, offset uint32, length uint32) ([]byte, error) {
+	if (length == 0) || (uint64(offset)+uint64(length)) > uint64(len(data)) {
+		return nil, ErrInvalidBasisLZ
+	}
+	return data[offset : offset+length], nil
+}
+
+// combineAlpha returns an ETC2 RGBA8 payload whose color blocks are rgbETC1's
+// (verbatim) and whose EAC alpha blocks encode the green channel of
+// alphaETC1.
+func combineAlpha(rgbETC1 []byte, alphaETC1 []byte, widthInBlocks int, heightInBlocks int) ([]byte, error) {
+	w, h := 4*widthInBlocks, 4*heightInBlocks
+	alphaImage, err := etc2.DecodeRaw(alphaETC1, etc2.FormatETC1, w, h)
+	if err != nil {
+		return nil, err
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			_, g, _, _ := alphaImage.At(x, y).RGBA()
+			m.SetNRGBA(x, y, color.NRGBA{A: uint8(g >> 8)})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := etc2.Encode(buf, m, etc2.FormatETC2RGBA8, nil); err != nil {
+		return nil, err
+	}
+	rgba8 := buf.Bytes()
+	for i := 0; i < len(rgbETC1); i += 8 {
+		copy(rgba8[(2*i)+8:(2*i)+16], rgbETC1[i:i+8])
+	}
+	return rgba8, nil
+}
+
+// blockEndpointPred is the per-column state, for the current and previous
+// rows of blocks, that transcodeSlice uses to predict endpoint indexes.
+type blockEndpointPred struct {
+	endpointIndex int
+	predBits      uint32
+}
+
+// transcodeSlice decodes one slice's coded endpoint and selector indexes,
+// writing ETC1 blocks to dst.
+func (b *basisLZ) transcodeSlice(dst []byte, data []byte, widthInBlocks int, heightInBlocks int) error {
+	br := bitReader{data: data}
+	numEndpoints, numSelectors := len(b.endpoints), len(b.selectors)
+	selectorHistoryBufRLESymbol := numSelectors + b.selectorHistoryBufSize
+	history := newApproxMoveToFront(b.selectorHistoryBufSize)
+	preds := [2][]blockEndpointPred{
+		make([]blockEndpointPred, widthInBlocks),
+		make([]blockEndpointPred, widthInBlocks),
+	}
+
+	curPredBits, prevEndpointPredSymbol, endpointPredRepeatCount := uint32(0), uint32(0), 0
+	prevEndpointIndex, selectorRLECount := 0, 0
+	for blockY := range heightInBlocks {
+		cur := blockY & 1
+		for blockX := range widthInBlocks {
+			// Every 2×2 group of blocks has a symbol that holds each block's
+			// endpoint predictor. The bottom row's half of it is saved for
+			// the next row of blocks.
+			if (blockX & 1) == 0 {
+				if (blockY & 1) == 0 {
+					if endpointPredRepeatCount > 0 {
+						endpointPredRepeatCount--
+						curPredBits = prevEndpointPredSymbol
+					} else if curPredBits = uint32(br.decode(&b.endpointPredModel)); curPredBits == endpointPredRepeatLastSymbol {
+						endpointPredRepeatCount = int(br.decodeVLC(endpointPredCountVLCBits)) + endpointPredMinRepeatCount - 1
+						curPredBits = prevEndpointPredSymbol
+					} else {
+						prevEndpointPredSymbol = curPredBits
+					}
+					preds[cur^1][blockX].predBits = curPredBits >> 4
+				} else {
+					curPredBits = preds[cur][blockX].predBits
+				}
+			}
+
+			// Predict the endpoint index from the left, upper or upper-left
+			// block, or decode a delta from the previous block's.
+			endpointIndex := 0
+			switch curPredBits & 3 {
+			case 0:
+				if blockX == 0 {
+					return ErrInvalidBasisLZ
+				}
+				endpointIndex = prevEndpointIndex
+			case 1:
+				if blockY == 0 {
+					return ErrInvalidBasisLZ
+				}
+				endpointIndex = preds[cur^1][blockX].endpointIndex
+			case 2:
+				if (blockX == 0) || (blockY == 0) {
+					return ErrInvalidBasisLZ
+				}
+				endpointIndex = preds[cur^1][blockX-1].endpointIndex
+			default:
+				endpointIndex = prevEndpointIndex + br.decode(&b.deltaEndpointModel)
+				if endpointIndex >= numEndpoints {
+					endpointIndex -= numEndpoints
+				}
+			}
+			curPredBits >>= 2
+			preds[cur][blockX].endpointIndex = endpointIndex
+			prevEndpointIndex = endpointIndex
+
+			// Decode the selector index, either directly or as an index into
+			// the history buffer of recently used selectors. A run-length
+			// coded symbol repeats the history buffer's first entry.
+			selectorSymbol := 0
+			if selectorRLECount > 0 {
+				selectorRLECount--
+				selectorSymbol = numSelectors
+			} else if selectorSymbol = br.decode(&b.selectorModel); selectorSymbol == selectorHistoryBufRLESymbol {
+				runSymbol := br.decode(&b.selectorHistoryBufRLEModel)
+				if runSymbol == (selectorHistoryBufRLECountTotal - 1) {
+					selectorRLECount = int(br.decodeVLC(7)) + selectorHistoryBufRLECountThreshold
+				} else {
+					selectorRLECount = runSymbol + selectorHistoryBufRLECountThreshold
+				}
+				if selectorRLECount > (widthInBlocks * heightInBlocks) {
+					return ErrInvalidBasisLZ
+				}
+				selectorSymbol = numSelectors
+				selectorRLECount--
+			}
+			selectorIndex := 0
+			if selectorSymbol >= numSelectors {
+				i := selectorSymbol - numSelectors
+				if i >= len(history.values) {
+					return ErrInvalidBasisLZ
+				}
+				selectorIndex = history.values[i]
+				history.use(i)
+			} else {
+				selectorIndex = selectorSymbol
+				history.add(selectorIndex)
+			}
+
+			if br.bad || (endpointIndex < 0) || (endpointIndex >= numEndpoints) || (selectorIndex >= numSelectors) {
+				return ErrInvalidBasisLZ
+			}
+			code := etc1Block(&b.endpoints[endpointIndex], &b.selectors[selectorIndex])
+			binary.BigEndian.PutUint64(dst[8*((blockY*widthInBlocks)+blockX):], code)
+		}
+	}
+	return nil
+}
+
+// etc1Block returns the ETC1 block code (to be written big-endian) for an
+// ETC1S endpoint and selectors. It uses the differential mode with a zero
+// delta, so that both half-blocks have the same base color and table.
+func etc1Block(e *etc1sEndpoint, s *etc1sSelectors) uint64 {
+	code := uint64(e.color5[0])<<59 |
+		uint64(e.color5[1])<<51 |
+		uint64(e.color5[2])<<43 |
+		uint64(e.inten)<<37 |
+		uint64(e.inten)<<34 |
+		1<<33 // The diff bit. The flip bit is zero.
+
+	// ETC1 pixel indexes are column-major, with the MSBs and LSBs in separate
+	// 16-bit halves. selectorToETC1 maps from increasing intensity modifiers
+	// to ETC1's (+small, +large, -small, -large) order.
+	for y := range 4 {
+		for x := range 4 {
+			index := selectorToETC1[(s[y]>>(2*x))&3]
+			i := (4 * x) + y
+			code |= uint64(index&1)<<i | uint64(index>>1)<<(i+16)
+		}
+	}
+	return code
+}
+
+var selectorToETC1 = [4]uint8{3, 2, 0, 1}
+
+// approxMoveToFront is the ETC1S selector history buffer. Adding a value
+// replaces the entry at a rover in the buffer's second half. Using an entry
+// swaps it with the entry at half its index, moving it towards the front.
+type approxMoveToFront struct {
+	values []int
+	rover  int
+}
+
+func newApproxMoveToFront(n int) *approxMoveToFront {
+	return &approxMoveToFront{values: make([]int, n), rover: n / 2}
+}
+
+func (a *approxMoveToFront) add(v int) {
+	a.values[a.rover] = v
+	if a.rover++; a.rover == len(a.values) {
+		a.rover = len(a.values) / 2
+	}
+}
+
+func (a *approxMoveToFront) use(i int) {
+	if i > 0 {
+		a.values[i/2], a.values[i] = a.values[i], a.values[i/2]
+	}
+}
+
+// bitReader reads bits, least significant bit first, from data. Reading past
+// the end yields zeroes and sets bad.
+type bitReader struct {
+	data []byte
+	pos  uint64
+	bad  bool
+}
+
+func (br *bitReader) bits(n uint32) (v uint32) {
+	for i := range n {
+		j := br.pos >> 3
+		if j >= uint64(len(br.data)) {
+			br.bad = true
+			return 0
+		}
+		v |= uint32((br.data[j]>>(br.pos&7))&1) << i
+		br.pos++
+	}
+	return v
+}
+
+// decodeVLC decodes a variable length code: chunks of chunkBits bits, each
+// followed by a bit for whether more chunks follow.
+func (br *bitReader) decodeVLC(chunkBits uint32) (v uint32) {
+	for shift := uint32(0); ; shift += chunkBits {
+		if shift >= 32 {
+			br.bad = true
+			return 0
+		}
+		x := br.bits(chunkBits + 1)
+		v |= (x & ((1 << chunkBits) - 1)) << shift
+		if (x >> chunkBits) == 0 {
+			return v
+		}
+	}
+}
+
+// huffmanTable is a canonical Huffman code. Shorter codes come first and,
+// for codes of equal length, lower symbols come first.
+type huffmanTable struct {
+	// counts[n] is the number of codes of length n.
+	counts [huffmanMaxCodeSize + 1]uint16
+
+	// symbols are the coded symbols, in code order.
+	symbols []uint16
+}
+
+// init sets h from each symbol's code length (zero for unused symbols). It
+// returns false if the lengths are over-subscribed.
+func (h *huffmanTable) init(codeSizes []uint8) bool {
+	*h = huffmanTable{}
+	for _, n := range codeSizes {
+		h.counts[n]++
+	}
+	h.counts[0] = 0
+	left := 1
+	for n := 1; n <= huffmanMaxCodeSize; n++ {
+		if left = (left << 1) - int(h.counts[n]); left < 0 {
+			return false
+		}
+	}
+	offsets := [huffmanMaxCodeSize + 2]int{}
+	for n := 1; n <= huffmanMaxCodeSize; n++ {
+		offsets[n+1] = offsets[n] + int(h.counts[n])
+	}
+	h.symbols = make([]uint16, offsets[huffmanMaxCodeSize+1])
+	for sym, n := range codeSizes {
+		if n != 0 {
+			h.symbols[offsets[n]] = uint16(sym)
+			offsets[n]++
+		}
+	}
+	return true
+}
+
+// decode decodes one symbol. Each code is stored most significant bit first.
+func (br *bitReader) decode(h *huffmanTable) int {
+	code, first, index := 0, 0, 0
+	for n := 1; n <= huffmanMaxCodeSize; n++ {
+		code |= int(br.bits(1))
+		count := int(h.counts[n])
+		if (code - first) < count {
+			return int(h.symbols[index+code-first])
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	br.bad = true
+	return 0
+}
+
+// readHuffmanTable reads a Huffman table's code lengths, which are themselves
+// Huffman coded, with run-length codes for runs of zeroes and of repeated
+// lengths. It returns false if the table is invalid. An empty table is valid
+// (but any attempt to decode with it will fail).
+func (br *bitReader) readHuffmanTable(h *huffmanTable) bool {
+	*h = huffmanTable{}
+	numSymbols := int(br.bits(14))
+	if numSymbols == 0 {
+		return !br.bad
+	}
+	lengthCodeSizes := [huffmanTotalLengthCodes]uint8{}
+	numLengthCodes := int(br.bits(5))
+	if (numLengthCodes < 1) || (numLengthCodes > huffmanTotalLengthCodes) {
+		return false
+	}
+	for _, c := range huffmanSortedLengthCodes[:numLengthCodes] {
+		lengthCodeSizes[c] = uint8(br.bits(huffmanLengthCodeSizeLog))
+	}
+	lengthTable := huffmanTable{}
+	if !lengthTable.init(lengthCodeSizes[:]) || (len(lengthTable.symbols) == 0) {
+		return false
+	}
+
+	codeSizes := make([]uint8, numSymbols)
+	for i := 0; i < numSymbols; {
+		c := br.decode(&lengthTable)
+		if br.bad {
+			return false
+		}
+		runLength := 0
+		switch c {
+		case huffmanSmallZeroRunCode:
+			if i += int(br.bits(3)) + 3; i > numSymbols {
+				return false
+			}
+			continue
+		case huffmanBigZeroRunCode:
+			if i += int(br.bits(7)) + 11; i > numSymbols {
+				return false
+			}
+			continue
+		case huffmanSmallRepeatCode:
+			runLength = int(br.bits(2)) + 3
+		case huffmanBigRepeatCode:
+			runLength = int(br.bits(7)) + 7
+		default:
+			codeSizes[i] = uint8(c)
+			i++
+			continue
+		}
+		if (i == 0) || (codeSizes[i-1] == 0) || ((i + runLength) > numSymbols) {
+			return false
+		}
+		for prev := codeSizes[i-1]; runLength > 0; runLength-- {
+			codeSizes[i] = prev
+			i++
+		}
+	}
+	return !br.bad && h.init(codeSizes)
+}
@@ -0,0 +1,349 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ktx2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/ktx"
+	"github.com/nigeltao/etc2/lib/supercompress"
+)
+
+// These constants come from the Khronos Data Format specification.
+const (
+	khrDFModelETC2          = 161
+	khrDFPrimariesBT709     = 1
+	khrDFTransferLinear     = 1
+	khrDFChannelETC2Red     = 0
+	khrDFChannelETC2Green   = 1
+	khrDFChannelETC2Color   = 2
+	khrDFChannelETC2Alpha   = 15
+	khrDFSampleTypeSigned   = 0x40
+	khrDFVersionNumber      = 2
+	khrDFBasicBlockBaseSize = 24
+)
+
+// EncodeOptions are optional arguments to Encode. The zero value is valid and
+// means to use the default configuration.
+type EncodeOptions struct {
+	// KTXOptions, if non-nil, has the same meaning as for the ktx package's
+	// encoder: the format, key-value metadata, mipmaps and ETC2Options. A
+	// KTXorientation value, such as "S=r,T=d", is converted to its KTX 2.0
+	// form, such as "rd". Key-value metadata is written sorted by key, as KTX
+	// 2.0 requires.
+	KTXOptions *ktx.EncodeOptions
+
+	// SupercompressionScheme is the ID of the supercompress package's
+	// registered Supercompressor that compresses each mipmap level, such as
+	// supercompress.IDZstandard. Zero means no supercompression. BasisLZ is
+	// not supported.
+	SupercompressionScheme uint32
+}
+
+// Encode writes src to w in the KTX 2.0 format.
+//
+// The whole file is built in memory before being written, as KTX 2.0 stores
+// the smallest mipmap level first but the level index, which precedes all
+// levels, holds every level's (possibly supercompressed) size.
+//
+// options may be nil, which means to use the default configuration.
+func Encode(w io.Writer, src image.Image, options *EncodeOptions) error {
+	return encode(w, options, func(w io.Writer, o *ktx.EncodeOptions) error {
+		return ktx.Encode(w, src, o)
+	})
+}
+
+// EncodeArray writes a slice of same-sized images to w as the layers of a KTX
+// 2.0 array texture.
+//
+// options may be nil, which means to use the default configuration.
+func EncodeArray(w io.Writer, layers []image.Image, options *EncodeOptions) error {
+	return encode(w, options, func(w io.Writer, o *ktx.EncodeOptions) error {
+		return ktx.EncodeArray(w, layers, o)
+	})
+}
+
+// Encode3D writes a slice of same-sized images to w as the depth slices (from
+// front to back) of a KTX 2.0 3D texture.
+//
+// options may be nil, which means to use the default configuration.
+func Encode3D(w io.Writer, slices []image.Image, options *EncodeOptions) error {
+	return encode(w, options, func(w io.Writer, o *ktx.EncodeOptions) error {
+		return ktx.Encode3D(w, slices, o)
+	})
+}
+
+// EncodeCubemap writes six images to w as a KTX 2.0 cube map, like
+// ktx.EncodeCubemap.
+//
+// options may be nil, which means to use the default configuration.
+func EncodeCubemap(w io.Writer, faces *[6]image.Image, options *EncodeOptions) error {
+	return encode(w, options, func(w io.Writer, o *ktx.EncodeOptions) error {
+		return ktx.EncodeCubemap(w, faces, o)
+	})
+}
+
+// EncodeCubemapCross is like EncodeCubemap but the six faces are taken from a
+// single image, laid out as a horizontal cross, like ktx.EncodeCubemapCross.
+//
+// options may be nil, which means to use the default configuration.
+func EncodeCubemapCross(w io.Writer, src image.Image, options *EncodeOptions) error {
+	return encode(w, options, func(w io.Writer, o *ktx.EncodeOptions) error {
+		return ktx.EncodeCubemapCross(w, src, o)
+	})
+}
+
+// encode calls encodeKTX to write a KTX file to memory and then writes the
+// same images and key-value metadata to w in the KTX 2.0 format. Both formats
+// order each mipmap level's images by layer, then face, then depth slice, so
+// each level's data is copied as is, other than being supercompressed.
+func encode(w io.Writer, options *EncodeOptions, encodeKTX func(w io.Writer, options *ktx.EncodeOptions) error) error {
+	if w == nil {
+		return ErrBadArgument
+	}
+	ktxOptions, scheme := (*ktx.EncodeOptions)(nil), uint32(supercompress.IDNone)
+	if options != nil {
+		ktxOptions, scheme = options.KTXOptions, options.SupercompressionScheme
+	}
+	if scheme == supercompress.IDBasisLZ {
+		return ErrBadArgument
+	}
+	s, err := supercompress.Lookup(scheme)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := encodeKTX(buf, ktxOptions); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	kl, err := ktx.DecodeLayout(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	keyValues, err := ktx.DecodeKeyValues(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	vkFormat := vulkanFromFormat(kl.Format)
+	if vkFormat == 0 {
+		return ErrUnsupportedFormat
+	}
+	l := Layout{
+		Format:           kl.Format,
+		Width:            kl.Width,
+		Height:           kl.Height,
+		Depth:            kl.Depth,
+		NumArrayElements: kl.NumArrayElements,
+		NumFaces:         max(1, kl.NumFaces),
+		NumMipmapLevels:  kl.NumMipmapLevels,
+	}
+
+	// Each KTX level's data is preceded by a 4-byte imageSize.
+	levels := make([][]byte, l.NumMipmapLevels)
+	uncompressedLengths := make([]int, l.NumMipmapLevels)
+	offset := int64(ktx.HeaderSize + kl.BytesOfKeyValueData)
+	for i := range levels {
+		n := l.bytesPerImage(i) * int64(l.numImagesPerLevel(i))
+		offset += 4
+		if (offset + n) > int64(len(data)) {
+			return io.ErrUnexpectedEOF
+		}
+		levels[i], uncompressedLengths[i] = data[offset:offset+n], int(n)
+		offset += n
+		if scheme != supercompress.IDNone {
+			if levels[i], err = s.Compress(levels[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	dfd := appendDFD(nil, l.Format)
+	kvd := appendKeyValueData(nil, keyValues)
+	dfdByteOffset := HeaderSize + (24 * len(levels))
+	kvdByteOffset := dfdByteOffset + len(dfd)
+	levelsByteOffset := kvdByteOffset + len(kvd)
+	if len(kvd) == 0 {
+		kvdByteOffset = 0
+	}
+
+	// Uncompressed levels must start at a multiple of the block size (which is
+	// 8 or 16, also a multiple of 4). As every level's size is a multiple of
+	// it too, only the first level needs padding.
+	padding := 0
+	if scheme == supercompress.IDNone {
+		bytesPerBlock := l.Format.BytesPerBlock()
+		padding = (bytesPerBlock - (levelsByteOffset % bytesPerBlock)) % bytesPerBlock
+		levelsByteOffset += padding
+	}
+
+	header := make([]byte, 0, levelsByteOffset)
+	header = append(header, Magic...)
+	header = binary.LittleEndian.AppendUint32(header, vkFormat)
+	header = binary.LittleEndian.AppendUint32(header, 1) // typeSize
+	header = binary.LittleEndian.AppendUint32(header, uint32(l.Width))
+	header = binary.LittleEndian.AppendUint32(header, uint32(l.Height))
+	header = binary.LittleEndian.AppendUint32(header, uint32(l.Depth))
+	header = binary.LittleEndian.AppendUint32(header, uint32(l.NumArrayElements))
+	header = binary.LittleEndian.AppendUint32(header, uint32(l.NumFaces))
+	header = binary.LittleEndian.AppendUint32(header, uint32(l.NumMipmapLevels))
+	header = binary.LittleEndian.AppendUint32(header, scheme)
+	header = binary.LittleEndian.AppendUint32(header, uint32(dfdByteOffset))
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(dfd)))
+	header = binary.LittleEndian.AppendUint32(header, uint32(kvdByteOffset))
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(kvd)))
+	header = binary.LittleEndian.AppendUint64(header, 0) // sgdByteOffset
+	header = binary.LittleEndian.AppendUint64(header, 0) // sgdByteLength
+
+	// The level index lists the base (largest) level first, but the data is
+	// stored smallest level first.
+	levelOffsets := make([]int, len(levels))
+	for i, o := len(levels)-1, levelsByteOffset; i >= 0; i-- {
+		levelOffsets[i] = o
+		o += len(levels[i])
+	}
+	for i, level := range levels {
+		header = binary.LittleEndian.AppendUint64(header, uint64(levelOffsets[i]))
+		header = binary.LittleEndian.AppendUint64(header, uint64(len(level)))
+		header = binary.LittleEndian.AppendUint64(header, uint64(uncompressedLengths[i]))
+	}
+	header = append(header, dfd...)
+	header = append(header, kvd...)
+	header = append(header, make([]byte, padding)...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for i := len(levels) - 1; i >= 0; i-- {
+		if _, err := w.Write(levels[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendDFD appends the data format descriptor for f: its total size and then
+// one basic descriptor block, with one sample per 64 bits of each block.
+func appendDFD(b []byte, f etc2.Format) []byte {
+	transfer, channels, sampleType := uint8(khrDFTransferLinear), []uint8(nil), uint8(0)
+	switch f {
+	case etc2.FormatETC2SRGB, etc2.FormatETC2SRGBA1, etc2.FormatETC2SRGBA8:
+		transfer = khrDFTransferSRGB
+	}
+	switch f {
+	case etc2.FormatETC2RGBA8, etc2.FormatETC2SRGBA8:
+		channels = []uint8{khrDFChannelETC2Alpha, khrDFChannelETC2Color}
+	case etc2.FormatETC2R11Unsigned:
+		channels = []uint8{khrDFChannelETC2Red}
+	case etc2.FormatETC2R11Signed:
+		channels, sampleType = []uint8{khrDFChannelETC2Red}, khrDFSampleTypeSigned
+	case etc2.FormatETC2RG11Unsigned:
+		channels = []uint8{khrDFChannelETC2Red, khrDFChannelETC2Green}
+	case etc2.FormatETC2RG11Signed:
+		channels, sampleType = []uint8{khrDFChannelETC2Red, khrDFChannelETC2Green}, khrDFSampleTypeSigned
+	default:
+		channels = []uint8{khrDFChannelETC2Color}
+	}
+
+	blockSize := khrDFBasicBlockBaseSize + (16 * len(channels))
+	b = binary.LittleEndian.AppendUint32(b, uint32(4+blockSize))
+	b = binary.LittleEndian.AppendUint32(b, 0) // vendorId and descriptorType
+	b = binary.LittleEndian.AppendUint16(b, khrDFVersionNumber)
+	b = binary.LittleEndian.AppendUint16(b, uint16(blockSize))
+	b = append(b, khrDFModelETC2, khrDFPrimariesBT709, transfer, 0) // flags
+	b = append(b, 3, 3, 0, 0)                                       // texelBlockDimension0-3
+	b = append(b, uint8(f.BytesPerBlock()), 0, 0, 0, 0, 0, 0, 0)    // bytesPlane0-7
+	for i, channel := range channels {
+		lower, upper := uint32(0), uint32(0xFFFF_FFFF)
+		if sampleType == khrDFSampleTypeSigned {
+			lower, upper = 0x8000_0000, 0x7FFF_FFFF
+		}
+		b = binary.LittleEndian.AppendUint16(b, uint16(64*i)) // bitOffset
+		b = append(b, 63, channel|sampleType)                 // bitLength and channelType
+		b = append(b, 0, 0, 0, 0)                             // samplePosition0-3
+		b = binary.LittleEndian.AppendUint32(b, lower)
+		b = binary.LittleEndian.AppendUint32(b, upper)
+	}
+	return b
+}
+
+// appendKeyValueData appends the key-value data for keyValues, sorted by key
+// as KTX 2.0 requires.
+func appendKeyValueData(b []byte, keyValues []ktx.KeyValue) []byte {
+	keyValues = slices.Clone(keyValues)
+	slices.SortStableFunc(keyValues, func(x ktx.KeyValue, y ktx.KeyValue) int {
+		return strings.Compare(x.Key, y.Key)
+	})
+	for _, kv := range keyValues {
+		value := kv.Value
+		if kv.Key == "KTXorientation" {
+			value = convertOrientation(value)
+		}
+		n := len(kv.Key) + 1 + len(value)
+		b = binary.LittleEndian.AppendUint32(b, uint32(n))
+		b = append(b, kv.Key...)
+		b = append(b, 0x00)
+		b = append(b, value...)
+		for ; (n & 3) != 0; n++ {
+			b = append(b, 0x00)
+		}
+	}
+	return b
+}
+
+// convertOrientation converts a KTX KTXorientation value, such as
+// "S=r,T=d\x00", to its KTX 2.0 form, such as "rd\x00". Values already in the
+// KTX 2.0 form are unchanged.
+func convertOrientation(value []byte) []byte {
+	if !bytes.Contains(value, []byte("=")) {
+		return value
+	}
+	ret := []byte(nil)
+	for _, part := range bytes.Split(bytes.TrimSuffix(value, []byte{0x00}), []byte(",")) {
+		if _, v, ok := bytes.Cut(part, []byte("=")); ok && (len(v) == 1) {
+			ret = append(ret, v[0])
+		}
+	}
+	return append(ret, 0x00)
+}
+
+// vulkanFromFormat returns the Vulkan VkFormat for f, or 0 if f is invalid.
+// It is the inverse of formatFromVulkan, other than mapping the ETC1 formats
+// to ETC2 RGB, as Vulkan has no separate ETC1 format.
+func vulkanFromFormat(f etc2.Format) uint32 {
+	switch f {
+	case etc2.FormatETC1S, etc2.FormatETC1, etc2.FormatETC2RGB:
+		return 147 // VK_FORMAT_ETC2_R8G8B8_UNORM_BLOCK
+	case etc2.FormatETC2SRGB:
+		return 148 // VK_FORMAT_ETC2_R8G8B8_SRGB_BLOCK
+	case etc2.FormatETC2RGBA1:
+		return 149 // VK_FORMAT_ETC2_R8G8B8A1_UNORM_BLOCK
+	case etc2.FormatETC2SRGBA1:
+		return 150 // VK_FORMAT_ETC2_R8G8B8A1_SRGB_BLOCK
+	case etc2.FormatETC2RGBA8:
+		return 151 // VK_FORMAT_ETC2_R8G8B8A8_UNORM_BLOCK
+	case etc2.FormatETC2SRGBA8:
+		return 152 // VK_FORMAT_ETC2_R8G8B8A8_SRGB_BLOCK
+	case etc2.FormatETC2R11Unsigned:
+		return 153 // VK_FORMAT_EAC_R11_UNORM_BLOCK
+	case etc2.FormatETC2R11Signed:
+		return 154 // VK_FORMAT_EAC_R11_SNORM_BLOCK
+	case etc2.FormatETC2RG11Unsigned:
+		return 155 // VK_FORMAT_EAC_R11G11_UNORM_BLOCK
+	case etc2.FormatETC2RG11Signed:
+		return 156 // VK_FORMAT_EAC_R11G11_SNORM_BLOCK
+	}
+	return 0
+}
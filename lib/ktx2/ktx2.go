@@ -0,0 +1,557 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// ----------------
+
+// Package ktx2 implements a decoder and encoder for the KTX 2.0 (Khronos
+// Texture, version 2) container format for ETC textures.
+//
+// Supercompressed levels, such as Zstandard-supercompressed levels written by
+// the toktx tool, are decompressed by the supercompress package's registered
+// Supercompressor for the file's supercompressionScheme. BasisLZ (ETC1S)
+// payloads, as written by the basisu and toktx tools, are transcoded to ETC2
+// RGB, or to ETC2 RGBA8 if they have an alpha slice. BasisLZ video (P-frames)
+// is not supported.
+//
+// UASTC payloads are not supported. DecodeLayout reports them as a
+// *HeaderError that wraps ErrUnsupportedUASTC, so that callers can tell them
+// apart from other unsupported formats and transcode them to ETC2 beforehand
+// (such as with the basisu tool).
+//
+// KTX 2.0 is specified at
+// https://registry.khronos.org/KTX/specs/2.0/ktxspec.v2.html
+package ktx2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/ktx"
+	"github.com/nigeltao/etc2/lib/supercompress"
+)
+
+// Magic is the byte string prefix of every KTX 2.0 image file.
+const Magic = "\xABKTX 20\xBB\r\n\x1A\n"
+
+// HeaderSize is the size, in bytes, of a KTX 2.0 file's fixed-size header,
+// including the Magic bytes but excluding the level index that follows it.
+const HeaderSize = 80
+
+var (
+	ErrBadArgument        = errors.New("ktx2: bad argument")
+	ErrInvalidBasisLZ     = errors.New("ktx2: invalid BasisLZ data")
+	ErrNotAKTX2File       = errors.New("ktx2: not a KTX 2.0 file")
+	ErrUnsupportedBasisLZ = errors.New("ktx2: unsupported BasisLZ supercompression")
+	ErrUnsupportedFormat  = errors.New("ktx2: unsupported format")
+	ErrUnsupportedTexture = errors.New("ktx2: unsupported texture")
+	ErrUnsupportedUASTC   = errors.New("ktx2: unsupported UASTC payload")
+)
+
+// khrDFModelUASTC is the Khronos Data Format's KHR_DF_MODEL_UASTC color
+// model.
+const khrDFModelUASTC = 166
+
+// HeaderError is the error for a malformed, truncated or unsupported KTX 2.0
+// header, level index, data format descriptor, key-value data or
+// supercompression global data. It wraps ErrNotAKTX2File,
+// ErrUnsupportedBasisLZ, ErrUnsupportedFormat, ErrUnsupportedTexture,
+// ErrUnsupportedUASTC or io.ErrUnexpectedEOF, which errors.Is matches.
+type HeaderError struct {
+	// Offset is the byte offset, from the start of the file, of the field.
+	Offset int64
+
+	// Field describes the header field and what was expected of it.
+	Field string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("%v (%s, at byte offset %d)", e.Err, e.Field, e.Offset)
+}
+
+func (e *HeaderError) Unwrap() error { return e.Err }
+
+// headerFieldNames are the names of the header's first nine uint32 fields, in
+// order.
+var headerFieldNames = [9]string{
+	"vkFormat",
+	"typeSize",
+	"pixelWidth",
+	"pixelHeight",
+	"pixelDepth",
+	"layerCount",
+	"faceCount",
+	"levelCount",
+	"supercompressionScheme",
+}
+
+// newHeaderError returns a *HeaderError for the i'th uint32 field, per
+// headerFieldNames.
+func newHeaderError(i int, want string, err error) *HeaderError {
+	return &HeaderError{int64(12 + (4 * i)), headerFieldNames[i] + " (want " + want + ")", err}
+}
+
+func init() {
+	image.RegisterFormat("ktx2", Magic, Decode, DecodeConfig)
+}
+
+// Level is one entry of a KTX 2.0 file's level index. The offset is from the
+// start of the file. The lengths are of the (possibly supercompressed) data in
+// the file and of that data after any decompression.
+type Level struct {
+	ByteOffset             uint64
+	ByteLength             uint64
+	UncompressedByteLength uint64
+}
+
+// Layout holds the KTX 2.0 header fields that determine where each image's
+// bytes are in a KTX 2.0 file.
+//
+// Depth, NumArrayElements and NumFaces follow the same conventions as the
+// ktx.Layout fields of the same name. NumMipmapLevels is at least 1, even if
+// the header's levelCount is 0, and len(Levels) equals NumMipmapLevels.
+type Layout struct {
+	Format                 etc2.Format
+	Width                  int
+	Height                 int
+	Depth                  int
+	NumArrayElements       int
+	NumFaces               int
+	NumMipmapLevels        int
+	SupercompressionScheme uint32
+	Levels                 []Level
+
+	// basisLZ is the decoded supercompression global data, if
+	// SupercompressionScheme is supercompress.IDBasisLZ.
+	basisLZ *basisLZ
+}
+
+// DecodeLayout reads a KTX 2.0 file's header and level index from r. Only the
+// ETC formats and textures with a non-zero Width and Height (not 1D textures)
+// are supported.
+//
+// For BasisLZ-supercompressed files, it also reads the data format descriptor
+// and supercompression global data, and Format is the ETC2 format that the
+// images are transcoded to.
+func DecodeLayout(r io.Reader) (Layout, error) {
+	buf := [HeaderSize]byte{}
+	if n, err := io.ReadFull(r, buf[:]); err != nil {
+		if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+			err = &HeaderError{int64(n), "truncated header", io.ErrUnexpectedEOF}
+		}
+		return Layout{}, err
+	} else if string(buf[:len(Magic)]) != Magic {
+		return Layout{}, &HeaderError{0, "magic (want the KTX 2.0 identifier)", ErrNotAKTX2File}
+	}
+	u32 := func(i int) uint32 {
+		return binary.LittleEndian.Uint32(buf[12+(4*i):])
+	}
+
+	// The fields are, in order: vkFormat, typeSize, pixelWidth, pixelHeight,
+	// pixelDepth, layerCount, faceCount, levelCount and
+	// supercompressionScheme. The index (of the data format descriptor,
+	// key-value data and supercompression global data) follows.
+	for i := 2; i <= 7; i++ {
+		if u32(i) > 0xFFFF {
+			return Layout{}, newHeaderError(i, "at most 0xFFFF", ErrUnsupportedTexture)
+		}
+	}
+	l := Layout{
+		Format:                 formatFromVulkan(u32(0)),
+		Width:                  int(u32(2)),
+		Height:                 int(u32(3)),
+		Depth:                  int(u32(4)),
+		NumArrayElements:       int(u32(5)),
+		NumFaces:               int(u32(6)),
+		NumMipmapLevels:        max(1, int(u32(7))),
+		SupercompressionScheme: u32(8),
+	}
+	isBasisLZ := l.SupercompressionScheme == supercompress.IDBasisLZ
+	if isBasisLZ {
+		// BasisLZ files have an undefined vkFormat. The data format
+		// descriptor, read below, says whether there is alpha and sRGB.
+		if u32(0) != 0 {
+			return Layout{}, newHeaderError(0, "0 for BasisLZ", ErrUnsupportedFormat)
+		}
+	} else if u32(0) == 0 {
+		// An undefined vkFormat means that the data format descriptor
+		// (whose offset, from the start of the file, is u32(9)) describes the
+		// payload.
+		if isUASTC(r, u32(9), HeaderSize) {
+			return Layout{}, &HeaderError{int64(u32(9)) + 12, "data format descriptor colorModel (want not KHR_DF_MODEL_UASTC)", ErrUnsupportedUASTC}
+		}
+		return Layout{}, newHeaderError(0, "an ETC format", ErrUnsupportedFormat)
+	}
+	switch {
+	case u32(1) != 1:
+		return Layout{}, newHeaderError(1, "1 for a compressed texture", ErrUnsupportedFormat)
+	case (l.Format == etc2.FormatInvalid) && !isBasisLZ:
+		return Layout{}, newHeaderError(0, "an ETC format", ErrUnsupportedFormat)
+	case (l.Width == 0) || (l.Width > 65532):
+		return Layout{}, newHeaderError(2, "1 to 65532", ErrUnsupportedTexture)
+	case (l.Height == 0) || (l.Height > 65532):
+		return Layout{}, newHeaderError(3, "1 to 65532", ErrUnsupportedTexture)
+	case (l.NumFaces != 1) && (l.NumFaces != 6):
+		return Layout{}, newHeaderError(6, "1 or 6", ErrUnsupportedTexture)
+	case l.NumMipmapLevels > 17:
+		return Layout{}, newHeaderError(7, "at most 17", ErrUnsupportedTexture)
+	}
+
+	l.Levels = make([]Level, l.NumMipmapLevels)
+	for i := range l.Levels {
+		b := [24]byte{}
+		offset := int64(HeaderSize + (24 * i))
+		if n, err := io.ReadFull(r, b[:]); err != nil {
+			if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+				err = &HeaderError{offset + int64(n), "truncated level index", io.ErrUnexpectedEOF}
+			}
+			return Layout{}, err
+		}
+		l.Levels[i] = Level{
+			ByteOffset:             binary.LittleEndian.Uint64(b[0:]),
+			ByteLength:             binary.LittleEndian.Uint64(b[8:]),
+			UncompressedByteLength: binary.LittleEndian.Uint64(b[16:]),
+		}
+		if isBasisLZ {
+			continue
+		}
+		want := uint64(l.bytesPerImage(i)) * uint64(l.numImagesPerLevel(i))
+		if (l.SupercompressionScheme == supercompress.IDNone) && (l.Levels[i].ByteLength != want) {
+			return Layout{}, &HeaderError{offset + 8, fmt.Sprintf("level %d byteLength (want %d)", i, want), ErrUnsupportedTexture}
+		} else if (l.Levels[i].UncompressedByteLength != 0) && (l.Levels[i].UncompressedByteLength != want) {
+			return Layout{}, &HeaderError{offset + 16, fmt.Sprintf("level %d uncompressedByteLength (want 0 or %d)", i, want), ErrUnsupportedTexture}
+		}
+	}
+
+	if isBasisLZ {
+		numImages := 0
+		for i := range l.Levels {
+			numImages += l.numImagesPerLevel(i)
+		}
+		consumed := int64(HeaderSize + (24 * len(l.Levels)))
+		var err error
+		if l.Format, l.basisLZ, err = decodeBasisLZ(r, &buf, consumed, numImages); err != nil {
+			return Layout{}, err
+		}
+	}
+	return l, nil
+}
+
+// isUASTC returns whether the data format descriptor, at dfdByteOffset in the
+// file, has the UASTC color model. r is positioned consumed bytes into the
+// file. Any error (or an out of order descriptor) gives false.
+func isUASTC(r io.Reader, dfdByteOffset uint32, consumed int64) bool {
+	// The descriptor starts with its total size and then the basic descriptor
+	// block's vendorId, descriptorType, versionNumber and descriptorBlockSize.
+	// The colorModel is the following byte.
+	if (int64(dfdByteOffset) < consumed) || (dfdByteOffset > (1 << 30)) {
+		return false
+	} else if _, err := io.CopyN(io.Discard, r, int64(dfdByteOffset)-consumed); err != nil {
+		return false
+	}
+	b := [13]byte{}
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return false
+	}
+	return b[12] == khrDFModelUASTC
+}
+
+// DecodeKeyValues reads a KTX 2.0 file's key-value metadata from r, in the
+// order that they are stored (which KTX 2.0 requires to be sorted by key).
+func DecodeKeyValues(r io.Reader) ([]ktx.KeyValue, error) {
+	buf := [HeaderSize]byte{}
+	if n, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, truncated(err, int64(n), "truncated header")
+	} else if string(buf[:len(Magic)]) != Magic {
+		return nil, &HeaderError{0, "magic (want the KTX 2.0 identifier)", ErrNotAKTX2File}
+	}
+	kvdByteOffset := int64(binary.LittleEndian.Uint32(buf[56:]))
+	kvdByteLength := int64(binary.LittleEndian.Uint32(buf[60:]))
+	if kvdByteLength == 0 {
+		return nil, nil
+	} else if (kvdByteOffset < HeaderSize) || (kvdByteLength > (1 << 24)) {
+		return nil, &HeaderError{56, "kvdByteOffset and kvdByteLength (want after the header and at most 16 MiB)", ErrUnsupportedTexture}
+	} else if err := skip(r, kvdByteOffset-HeaderSize); err != nil {
+		return nil, truncated(err, kvdByteOffset, "truncated key-value data")
+	}
+
+	data := make([]byte, kvdByteLength)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, truncated(err, kvdByteOffset, "truncated key-value data")
+	}
+	keyValues := []ktx.KeyValue(nil)
+	for i := 0; (i + 4) <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[i:]))
+		kv := data[i+4:]
+		if n > len(kv) {
+			return nil, &HeaderError{kvdByteOffset + int64(i), "keyAndValueByteLength (want at most the remaining key-value data)", ErrNotAKTX2File}
+		}
+		kv = kv[:n]
+		key, value, ok := bytes.Cut(kv, []byte{0x00})
+		if !ok || (len(key) == 0) {
+			return nil, &HeaderError{kvdByteOffset + int64(i+4), "key (want a non-empty, NUL-terminated key)", ErrNotAKTX2File}
+		}
+		keyValues = append(keyValues, ktx.KeyValue{
+			Key:   string(key),
+			Value: bytes.Clone(value),
+		})
+		i += 4 + ((n + 3) &^ 3)
+	}
+	return keyValues, nil
+}
+
+// DecodeConfig reads a KTX 2.0 image configuration from r. It describes the
+// first (largest) image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	l, err := DecodeLayout(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: l.Format.ColorModel(),
+		Width:      l.Width,
+		Height:     l.Height,
+	}, nil
+}
+
+// Decode reads a KTX 2.0 image from r. It returns the first image: mipmap
+// level 0, face 0 and layer 0.
+func Decode(r io.Reader) (image.Image, error) {
+	return DecodeWithOptions(r, nil)
+}
+
+// DecodeOptions are optional arguments to DecodeWithOptions and
+// DecodeImageWithOptions. The zero value is valid and means to use the default
+// configuration.
+type DecodeOptions struct {
+	// ETC2Options, if non-nil, are passed on to etc2.Format.DecodeWithOptions.
+	ETC2Options *etc2.DecodeOptions
+
+	// MipLevel is the mipmap level that DecodeWithOptions decodes. Zero means
+	// the base (largest) level. Smaller levels, such as for thumbnails, are
+	// decoded without reading or decoding the larger ones, as KTX 2.0 files
+	// typically store the smallest level first. Layout.LevelSize gives each
+	// level's dimensions.
+	//
+	// It is ignored by DecodeImageWithOptions, which takes a level argument.
+	MipLevel int
+}
+
+// DecodeWithOptions is like Decode but with optional arguments.
+//
+// options may be nil, which means to use the default configuration.
+func DecodeWithOptions(r io.Reader, options *DecodeOptions) (image.Image, error) {
+	l, err := DecodeLayout(r)
+	if err != nil {
+		return nil, err
+	}
+	level := 0
+	if options != nil {
+		level = options.MipLevel
+	}
+	if (level < 0) || (level >= len(l.Levels)) {
+		return nil, ErrBadArgument
+	}
+	// Skip to the level's data. KTX 2.0 files typically store level 0 last.
+	consumed := uint64(HeaderSize + (24 * len(l.Levels)))
+	if l.basisLZ != nil {
+		consumed = uint64(l.basisLZ.end)
+	}
+	if (l.Levels[level].ByteOffset < consumed) || (l.Levels[level].ByteOffset > (1 << 62)) {
+		return nil, ErrUnsupportedTexture
+	} else if _, err := io.CopyN(io.Discard, r, int64(l.Levels[level].ByteOffset-consumed)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	data, err := l.readLevel(r, level)
+	if err != nil {
+		return nil, err
+	}
+	return l.decodeImage(data, level, 0, 0, options)
+}
+
+// DecodeImage reads the image at the given mipmap level, face and layer from
+// the KTX 2.0 file r, whose header has already been decoded as l. layer has
+// the same meaning as for ktx.Layout.BlockOffset.
+func DecodeImage(r io.ReaderAt, l *Layout, level int, face int, layer int) (image.Image, error) {
+	return DecodeImageWithOptions(r, l, level, face, layer, nil)
+}
+
+// DecodeImageWithOptions is like DecodeImage but with optional arguments.
+//
+// options may be nil, which means to use the default configuration.
+func DecodeImageWithOptions(r io.ReaderAt, l *Layout, level int, face int, layer int, options *DecodeOptions) (image.Image, error) {
+	if (r == nil) || (l == nil) ||
+		(level < 0) || (level >= len(l.Levels)) ||
+		(face < 0) || (face >= max(1, l.NumFaces)) ||
+		(layer < 0) || (layer >= l.NumLayers(level)) {
+		return nil, ErrBadArgument
+	}
+	data, err := ReadLevel(r, l, level)
+	if err != nil {
+		return nil, err
+	}
+	return l.decodeImage(data, level, face, layer, options)
+}
+
+// ReadLevel reads the given mipmap level's data from the KTX 2.0 file r, whose
+// header has already been decoded as l, decompressing it if supercompressed.
+//
+// Every face and layer of a level is stored in the same (supercompressed)
+// byte range, so callers that decode more than one of them should read the
+// level once and pass it to DecodeLevelImage, instead of calling DecodeImage
+// for each.
+func ReadLevel(r io.ReaderAt, l *Layout, level int) ([]byte, error) {
+	if (r == nil) || (l == nil) || (level < 0) || (level >= len(l.Levels)) {
+		return nil, ErrBadArgument
+	}
+	lv := l.Levels[level]
+	if lv.ByteOffset > (1 << 62) {
+		return nil, ErrUnsupportedTexture
+	}
+	return l.readLevel(io.NewSectionReader(r, int64(lv.ByteOffset), int64(lv.ByteLength)), level)
+}
+
+// DecodeLevelImage decodes the image at the given face and layer of data, the
+// result of ReadLevel for the same l and level.
+//
+// options may be nil, which means to use the default configuration.
+func DecodeLevelImage(data []byte, l *Layout, level int, face int, layer int, options *DecodeOptions) (image.Image, error) {
+	if (l == nil) ||
+		(level < 0) || (level >= len(l.Levels)) ||
+		(face < 0) || (face >= max(1, l.NumFaces)) ||
+		(layer < 0) || (layer >= l.NumLayers(level)) ||
+		(int64(len(data)) != (l.bytesPerImage(level) * int64(l.numImagesPerLevel(level)))) {
+		return nil, ErrBadArgument
+	}
+	return l.decodeImage(data, level, face, layer, options)
+}
+
+// readLevel reads the given level's data from r, decompressing it if
+// supercompressed. The returned data's length is always the level's
+// (uncompressed) size, even if a registered Supercompressor misbehaves.
+func (l *Layout) readLevel(r io.Reader, level int) ([]byte, error) {
+	lv := l.Levels[level]
+	want := l.bytesPerImage(level) * int64(l.numImagesPerLevel(level))
+	if (lv.ByteLength > (1 << 30)) || (want > (1 << 30)) {
+		return nil, ErrUnsupportedTexture
+	}
+	data := make([]byte, lv.ByteLength)
+	_, err := io.ReadFull(r, data)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if l.SupercompressionScheme == supercompress.IDBasisLZ {
+		if l.basisLZ == nil {
+			return nil, ErrUnsupportedBasisLZ
+		} else if data, err = l.basisLZ.transcodeLevel(data, l, level); err != nil {
+			return nil, err
+		}
+	} else if l.SupercompressionScheme != supercompress.IDNone {
+		s, err := supercompress.Lookup(l.SupercompressionScheme)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = s.Decompress(data, int(want)); err != nil {
+			return nil, err
+		}
+	}
+	if int64(len(data)) != want {
+		return nil, supercompress.ErrSizeMismatch
+	}
+	return data, nil
+}
+
+// decodeImage decodes the image at the given face and layer of the level's
+// (uncompressed) data.
+func (l *Layout) decodeImage(data []byte, level int, face int, layer int, options *DecodeOptions) (image.Image, error) {
+	etc2Options := (*etc2.DecodeOptions)(nil)
+	if options != nil {
+		etc2Options = options.ETC2Options
+	}
+	n := l.bytesPerImage(level)
+	i := (int64(layer) * int64(max(1, l.NumFaces))) + int64(face)
+	w, h := levelSize(l.Width, level), levelSize(l.Height, level)
+	return etc2.DecodeRawWithOptions(data[i*n:(i+1)*n], l.Format, w, h, etc2Options)
+}
+
+// LevelSize returns the width and height, in pixels, of the given mipmap
+// level's images. Level 0 is the base (largest) level and each subsequent
+// level halves the dimensions, rounding down, to a minimum of 1.
+func (l *Layout) LevelSize(level int) (width int, height int) {
+	return levelSize(l.Width, level), levelSize(l.Height, level)
+}
+
+// NumLayers returns the number of layers (as passed to DecodeImage) in the
+// given mipmap level.
+func (l *Layout) NumLayers(level int) int {
+	n := max(1, l.NumArrayElements)
+	if l.Depth > 0 {
+		n *= levelSize(l.Depth, level)
+	}
+	return n
+}
+
+// levelSize returns a mipmap level's width or height (or depth), given the
+// base level's.
+func levelSize(n int, level int) int {
+	return max(1, n>>level)
+}
+
+// numImagesPerLevel returns the number of 2D images in the given mipmap level.
+func (l *Layout) numImagesPerLevel(level int) int {
+	return l.NumLayers(level) * max(1, l.NumFaces)
+}
+
+// bytesPerImage returns the size of one 2D image in the given mipmap level.
+func (l *Layout) bytesPerImage(level int) int64 {
+	widthInBlocks := etc2.BlocksWide(levelSize(l.Width, level))
+	heightInBlocks := etc2.BlocksHigh(levelSize(l.Height, level))
+	return l.Format.PayloadSize(widthInBlocks, heightInBlocks)
+}
+
+// formatFromVulkan returns the etc2.Format whose Vulkan VkFormat is vkFormat,
+// or etc2.FormatInvalid. Vulkan has no separate ETC1 format: ETC1 data is
+// stored as (and is valid) ETC2 RGB.
+func formatFromVulkan(vkFormat uint32) etc2.Format {
+	switch vkFormat {
+	case 147: // VK_FORMAT_ETC2_R8G8B8_UNORM_BLOCK
+		return etc2.FormatETC2RGB
+	case 148: // VK_FORMAT_ETC2_R8G8B8_SRGB_BLOCK
+		return etc2.FormatETC2SRGB
+	case 149: // VK_FORMAT_ETC2_R8G8B8A1_UNORM_BLOCK
+		return etc2.FormatETC2RGBA1
+	case 150: // VK_FORMAT_ETC2_R8G8B8A1_SRGB_BLOCK
+		return etc2.FormatETC2SRGBA1
+	case 151: // VK_FORMAT_ETC2_R8G8B8A8_UNORM_BLOCK
+		return etc2.FormatETC2RGBA8
+	case 152: // VK_FORMAT_ETC2_R8G8B8A8_SRGB_BLOCK
+		return etc2.FormatETC2SRGBA8
+	case 153: // VK_FORMAT_EAC_R11_UNORM_BLOCK
+		return etc2.FormatETC2R11Unsigned
+	case 154: // VK_FORMAT_EAC_R11_SNORM_BLOCK
+		return etc2.FormatETC2R11Signed
+	case 155: // VK_FORMAT_EAC_R11G11_UNORM_BLOCK
+		return etc2.FormatETC2RG11Unsigned
+	case 156: // VK_FORMAT_EAC_R11G11_SNORM_BLOCK
+		return etc2.FormatETC2RG11Signed
+	}
+	return etc2.FormatInvalid
+}
@@ -0,0 +1,764 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ktx2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/ktx"
+	"github.com/nigeltao/etc2/lib/supercompress"
+)
+
+// makeKTX2 returns a KTX 2.0 file holding the given levels' (uncompressed)
+// payloads, supercompressed with scheme. As is conventional, the levels'
+// data is stored smallest first.
+func makeKTX2(tt *testing.T, vkFormat uint32, width int, height int, scheme uint32, levels [][]byte) []byte {
+	stored := make([][]byte, len(levels))
+	for i, level := range levels {
+		stored[i] = level
+		if scheme != supercompress.IDNone {
+			s, err := supercompress.Lookup(scheme)
+			if err != nil {
+				tt.Fatalf("Lookup: %v", err)
+			}
+			if stored[i], err = s.Compress(level); err != nil {
+				tt.Fatalf("Compress: %v", err)
+			}
+		}
+	}
+
+	b := []byte(Magic)
+	for _, u := range []uint32{vkFormat, 1, uint32(width), uint32(height), 0, 0, 1, uint32(len(levels)), scheme} {
+		b = binary.LittleEndian.AppendUint32(b, u)
+	}
+	b = append(b, make([]byte, HeaderSize-len(b))...)
+
+	offset := uint64(HeaderSize + (24 * len(levels)))
+	offsets := make([]uint64, len(levels))
+	for i := len(levels) - 1; i >= 0; i-- {
+		offsets[i] = offset
+		offset += uint64(len(stored[i]))
+	}
+	for i := range levels {
+		b = binary.LittleEndian.AppendUint64(b, offsets[i])
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(stored[i])))
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(levels[i])))
+	}
+	for i := len(levels) - 1; i >= 0; i-- {
+		b = append(b, stored[i]...)
+	}
+	return b
+}
+
+func TestDecode(tt *testing.T) {
+	const f, vkFormat = etc2.FormatETC2RGBA8, 151
+	sizes := []image.Point{{12, 8}, {6, 4}}
+	levels := make([][]byte, len(sizes))
+	wants := make([]image.Image, len(sizes))
+	for i, size := range sizes {
+		src := image.NewNRGBA(image.Rectangle{Max: size})
+		for y := range size.Y {
+			for x := range size.X {
+				src.SetNRGBA(x, y, color.NRGBA{uint8(20 * x), uint8(30 * y), uint8(64 * i), uint8(0xFF - x)})
+			}
+		}
+		buf := &bytes.Buffer{}
+		if err := etc2.Encode(buf, src, f, nil); err != nil {
+			tt.Fatalf("Encode: %v", err)
+		}
+		levels[i] = buf.Bytes()
+		want, err := etc2.DecodeRaw(levels[i], f, size.X, size.Y)
+		if err != nil {
+			tt.Fatalf("DecodeRaw: %v", err)
+		}
+		wants[i] = want
+	}
+
+	for _, scheme := range []uint32{supercompress.IDNone, supercompress.IDZstandard, supercompress.IDZLIB} {
+		data := makeKTX2(tt, vkFormat, sizes[0].X, sizes[0].Y, scheme, levels)
+
+		l, err := DecodeLayout(bytes.NewReader(data))
+		if err != nil {
+			tt.Fatalf("scheme=%d: DecodeLayout: %v", scheme, err)
+		} else if (l.Format != f) || (l.NumMipmapLevels != len(sizes)) || (l.SupercompressionScheme != scheme) {
+			tt.Fatalf("scheme=%d: DecodeLayout: got %+v", scheme, l)
+		}
+
+		for level, want := range wants {
+			m, err := DecodeImage(bytes.NewReader(data), &l, level, 0, 0)
+			if err != nil {
+				tt.Fatalf("scheme=%d, level=%d: DecodeImage: %v", scheme, level, err)
+			} else if !sameNRGBA(m, want) {
+				tt.Fatalf("scheme=%d, level=%d: DecodeImage: pixels differ", scheme, level)
+			}
+
+			m, err = DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{MipLevel: level})
+			if err != nil {
+				tt.Fatalf("scheme=%d, level=%d: DecodeWithOptions: %v", scheme, level, err)
+			} else if !sameNRGBA(m, want) {
+				tt.Fatalf("scheme=%d, level=%d: DecodeWithOptions: pixels differ", scheme, level)
+			} else if w, h := l.LevelSize(level); (w != sizes[level].X) || (h != sizes[level].Y) {
+				tt.Fatalf("scheme=%d, level=%d: LevelSize: got %dx%d, want %v", scheme, level, w, h, sizes[level])
+			}
+		}
+		if _, err := DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{MipLevel: len(sizes)}); err != ErrBadArgument {
+			tt.Fatalf("scheme=%d: out of range MipLevel: got %v, want %v", scheme, err, ErrBadArgument)
+		}
+
+		if m, err := Decode(bytes.NewReader(data)); err != nil {
+			tt.Fatalf("scheme=%d: Decode: %v", scheme, err)
+		} else if !sameNRGBA(m, wants[0]) {
+			tt.Fatalf("scheme=%d: Decode: pixels differ", scheme)
+		}
+	}
+
+	// UASTC files have an undefined (zero) vkFormat and a data format
+	// descriptor, here placed straight after the level index, whose color
+	// model is KHR_DF_MODEL_UASTC.
+	for _, colorModel := range []uint8{khrDFModelUASTC, 0} {
+		uastc := makeKTX2(tt, 0, sizes[0].X, sizes[0].Y, supercompress.IDNone, levels)
+		dfdByteOffset := HeaderSize + (24 * len(levels))
+		binary.LittleEndian.PutUint32(uastc[48:], uint32(dfdByteOffset))
+		uastc[dfdByteOffset+12] = colorModel
+		want := ErrUnsupportedUASTC
+		if colorModel != khrDFModelUASTC {
+			want = ErrUnsupportedFormat
+		}
+		_, err := DecodeLayout(bytes.NewReader(uastc))
+		if !errors.Is(err, want) {
+			tt.Fatalf("colorModel=%d: got %v, want %v", colorModel, err, want)
+		}
+		headerErr := (*HeaderError)(nil)
+		if !errors.As(err, &headerErr) {
+			tt.Fatalf("colorModel=%d: got %T, want *HeaderError", colorModel, err)
+		} else if (colorModel == khrDFModelUASTC) && (headerErr.Offset != int64(dfdByteOffset+12)) {
+			tt.Fatalf("colorModel=%d: Offset: got %d, want %d", colorModel, headerErr.Offset, dfdByteOffset+12)
+		}
+	}
+
+}
+
+// bitWriter writes bits, least significant bit first, as bitReader reads
+// them.
+type bitWriter struct {
+	buf []byte
+	n   uint
+}
+
+func (w *bitWriter) bits(v uint32, n int) {
+	for i := range n {
+		if (w.n & 7) == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= uint8((v>>i)&1) << (w.n & 7)
+		w.n++
+	}
+}
+
+// code writes a Huffman code, most significant bit first.
+func (w *bitWriter) code(code uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bits(code>>i, 1)
+	}
+}
+
+func (w *bitWriter) vlc(v uint32, chunkBits int) {
+	for {
+		chunk := v & ((1 << chunkBits) - 1)
+		if v >>= chunkBits; v == 0 {
+			w.bits(chunk, chunkBits+1)
+			return
+		}
+		w.bits(chunk|(1<<chunkBits), chunkBits+1)
+	}
+}
+
+// fixedTable writes a Huffman table that gives each of numSymbols symbols a
+// code of the same length, which it returns. Symbol s's code is then s.
+//
+// The code lengths are coded as a literal length followed by repeat codes.
+// The three code length codes used (the literal length, the small repeat code
+// and the big repeat code, in that canonical order) each have a 2-bit code.
+func (w *bitWriter) fixedTable(numSymbols int) (codeSize int) {
+	codeSize = 1
+	for (1 << codeSize) < numSymbols {
+		codeSize++
+	}
+	w.bits(uint32(numSymbols), 14)
+	numLengthCodes := 1 + slices.Index(huffmanSortedLengthCodes[:], uint8(codeSize))
+	w.bits(uint32(numLengthCodes), 5)
+	for _, c := range huffmanSortedLengthCodes[:numLengthCodes] {
+		if (c == uint8(codeSize)) || (c == huffmanSmallRepeatCode) || (c == huffmanBigRepeatCode) {
+			w.bits(2, 3)
+		} else {
+			w.bits(0, 3)
+		}
+	}
+	w.code(0, 2)
+	for remaining := numSymbols - 1; remaining > 0; {
+		switch {
+		case remaining >= 7:
+			n := min(remaining, 7+127)
+			w.code(2, 2)
+			w.bits(uint32(n-7), 7)
+			remaining -= n
+		case remaining >= 3:
+			w.code(1, 2)
+			w.bits(uint32(remaining-3), 2)
+			remaining = 0
+		default:
+			w.code(0, 2)
+			remaining--
+		}
+	}
+	return codeSize
+}
+
+// etc1sEncoder writes BasisLZ data, mirroring basisLZ's decoding. It only
+// makes simple choices, but it uses every kind of endpoint prediction and
+// selector coding.
+type etc1sEncoder struct {
+	endpoints              []etc1sEndpoint
+	selectors              []etc1sSelectors
+	selectorHistoryBufSize int
+}
+
+func (enc *etc1sEncoder) endpointsData() []byte {
+	w := &bitWriter{}
+	for range 3 {
+		w.fixedTable(32)
+	}
+	w.fixedTable(8)
+	w.bits(0, 1) // Not grayscale.
+	prevColor5, prevInten := [3]uint8{16, 16, 16}, uint8(0)
+	for _, e := range enc.endpoints {
+		w.code(uint32((e.inten-prevInten)&7), 3)
+		prevInten = e.inten
+		for c := range 3 {
+			w.code(uint32((e.color5[c]-prevColor5[c])&31), 5)
+			prevColor5[c] = e.color5[c]
+		}
+	}
+	return w.buf
+}
+
+func (enc *etc1sEncoder) selectorsData() []byte {
+	w := &bitWriter{}
+	w.bits(0, 1) // No global selector codebook.
+	w.bits(0, 1) // No hybrid selector codebook.
+	w.bits(1, 1) // Raw encoding.
+	for _, s := range enc.selectors {
+		for _, row := range s {
+			w.bits(uint32(row), 8)
+		}
+	}
+	return w.buf
+}
+
+// tablesData returns the Huffman tables and the code sizes that they imply.
+func (enc *etc1sEncoder) tablesData() (data []byte, codeSizes [4]int) {
+	w := &bitWriter{}
+	codeSizes[0] = w.fixedTable(endpointPredRepeatLastSymbol + 1)
+	codeSizes[1] = w.fixedTable(len(enc.endpoints))
+	codeSizes[2] = w.fixedTable(len(enc.selectors) + enc.selectorHistoryBufSize + 1)
+	codeSizes[3] = w.fixedTable(selectorHistoryBufRLECountTotal)
+	w.bits(uint32(enc.selectorHistoryBufSize), 13)
+	return w.buf, codeSizes
+}
+
+// sliceData codes the endpoint and selector indexes e[y][x] and s[y][x].
+func (enc *etc1sEncoder) sliceData(e [][]int, s [][]int) []byte {
+	_, codeSizes := enc.tablesData()
+	heightInBlocks, widthInBlocks := len(e), len(e[0])
+	pred := func(x int, y int) uint32 {
+		switch {
+		case (x >= widthInBlocks) || (y >= heightInBlocks):
+			return 0
+		case (x > 0) && (e[y][x] == e[y][x-1]):
+			return 0
+		case (y > 0) && (e[y][x] == e[y-1][x]):
+			return 1
+		case (x > 0) && (y > 0) && (e[y][x] == e[y-1][x-1]):
+			return 2
+		}
+		return 3
+	}
+	groups := []uint32(nil)
+	for y := 0; y < heightInBlocks; y += 2 {
+		for x := 0; x < widthInBlocks; x += 2 {
+			groups = append(groups, pred(x, y)|(pred(x+1, y)<<2)|(pred(x, y+1)<<4)|(pred(x+1, y+1)<<6))
+		}
+	}
+	flat := []int(nil)
+	for _, row := range s {
+		flat = append(flat, row...)
+	}
+
+	w := &bitWriter{}
+	history := newApproxMoveToFront(enc.selectorHistoryBufSize)
+	prevGroup, groupRepeats, groupIndex := uint32(0), 0, 0
+	prevEndpointIndex, selectorRLECount := 0, 0
+	for y := range heightInBlocks {
+		for x := range widthInBlocks {
+			if ((x & 1) == 0) && ((y & 1) == 0) {
+				g := groups[groupIndex]
+				run := 0
+				for (groupIndex+run < len(groups)) && (groups[groupIndex+run] == prevGroup) {
+					run++
+				}
+				switch {
+				case groupRepeats > 0:
+					groupRepeats--
+				case run >= endpointPredMinRepeatCount:
+					w.code(endpointPredRepeatLastSymbol, codeSizes[0])
+					w.vlc(uint32(run-endpointPredMinRepeatCount), endpointPredCountVLCBits)
+					groupRepeats = run - 1
+				default:
+					w.code(g, codeSizes[0])
+					prevGroup = g
+				}
+				groupIndex++
+			}
+			if pred(x, y) == 3 {
+				delta := e[y][x] - prevEndpointIndex
+				if delta < 0 {
+					delta += len(enc.endpoints)
+				}
+				w.code(uint32(delta), codeSizes[1])
+			}
+			prevEndpointIndex = e[y][x]
+
+			i := (y * widthInBlocks) + x
+			run := 0
+			for (i+run < len(flat)) && (flat[i+run] == flat[i]) {
+				run++
+			}
+			switch j := slices.Index(history.values, flat[i]); {
+			case selectorRLECount > 0:
+				selectorRLECount--
+			case (j == 0) && (run >= selectorHistoryBufRLECountThreshold):
+				run = min(run, selectorHistoryBufRLECountThreshold+selectorHistoryBufRLECountTotal-2)
+				w.code(uint32(len(enc.selectors)+enc.selectorHistoryBufSize), codeSizes[2])
+				w.code(uint32(run-selectorHistoryBufRLECountThreshold), codeSizes[3])
+				selectorRLECount = run - 1
+			case j >= 0:
+				w.code(uint32(len(enc.selectors)+j), codeSizes[2])
+				history.use(j)
+			default:
+				w.code(uint32(flat[i]), codeSizes[2])
+				history.add(flat[i])
+			}
+		}
+	}
+	return w.buf
+}
+
+// makeBasisLZ returns a single-level BasisLZ KTX 2.0 file. alpha may be nil.
+func makeBasisLZ(enc *etc1sEncoder, width int, height int, rgb []byte, alpha []byte) []byte {
+	samples := [][2]uint8{{0, 0}}
+	if alpha != nil {
+		samples = [][2]uint8{{0, 0}, {64, khrDFChannelETC1SAAA}}
+	}
+	dfd := binary.LittleEndian.AppendUint32(nil, uint32(28+(16*len(samples))))
+	dfd = binary.LittleEndian.AppendUint32(dfd, 0)
+	dfd = binary.LittleEndian.AppendUint16(dfd, 2)
+	dfd = binary.LittleEndian.AppendUint16(dfd, uint16(24+(16*len(samples))))
+	dfd = append(dfd, khrDFModelETC1S, 1, khrDFTransferSRGB, 0, 3, 3, 0, 0)
+	dfd = append(dfd, uint8(8*len(samples)), 0, 0, 0, 0, 0, 0, 0)
+	for _, sample := range samples {
+		dfd = append(dfd, sample[0], 0, 63, sample[1], 0, 0, 0, 0)
+		dfd = binary.LittleEndian.AppendUint32(dfd, 0)
+		dfd = binary.LittleEndian.AppendUint32(dfd, 0xFFFF_FFFF)
+	}
+
+	endpoints, selectors := enc.endpointsData(), enc.selectorsData()
+	tables, _ := enc.tablesData()
+	sgd := binary.LittleEndian.AppendUint16(nil, uint16(len(enc.endpoints)))
+	sgd = binary.LittleEndian.AppendUint16(sgd, uint16(len(enc.selectors)))
+	for _, n := range []int{len(endpoints), len(selectors), len(tables), 0, 0} {
+		// The last 0 is the imageDesc's imageFlags.
+		sgd = binary.LittleEndian.AppendUint32(sgd, uint32(n))
+	}
+	for _, n := range []int{0, len(rgb), len(rgb), len(alpha)} {
+		sgd = binary.LittleEndian.AppendUint32(sgd, uint32(n))
+	}
+	sgd = append(sgd, endpoints...)
+	sgd = append(sgd, selectors...)
+	sgd = append(sgd, tables...)
+
+	dfdByteOffset := HeaderSize + 24
+	sgdByteOffset := dfdByteOffset + len(dfd)
+	levelByteOffset := sgdByteOffset + len(sgd)
+	b := []byte(Magic)
+	for _, u := range []uint32{0, 1, uint32(width), uint32(height), 0, 0, 1, 1, supercompress.IDBasisLZ} {
+		b = binary.LittleEndian.AppendUint32(b, u)
+	}
+	for _, u := range []uint32{uint32(dfdByteOffset), uint32(len(dfd)), 0, 0} {
+		b = binary.LittleEndian.AppendUint32(b, u)
+	}
+	for _, u := range []uint64{uint64(sgdByteOffset), uint64(len(sgd)), uint64(levelByteOffset), uint64(len(rgb) + len(alpha)), 0} {
+		b = binary.LittleEndian.AppendUint64(b, u)
+	}
+	b = append(b, dfd...)
+	b = append(b, sgd...)
+	b = append(b, rgb...)
+	return append(b, alpha...)
+}
+
+func TestDecodeBasisLZ(tt *testing.T) {
+	enc := &etc1sEncoder{
+		endpoints: []etc1sEndpoint{
+			{[3]uint8{3, 20, 31}, 0},
+			{[3]uint8{16, 16, 16}, 2},
+			{[3]uint8{31, 0, 9}, 7},
+			{[3]uint8{8, 25, 4}, 4},
+			{[3]uint8{0, 0, 0}, 5},
+			{[3]uint8{12, 12, 12}, 1},
+		},
+		selectors: []etc1sSelectors{
+			{0x00, 0x00, 0x00, 0x00},
+			{0xE4, 0xE4, 0xE4, 0xE4},
+			{0x1B, 0x6C, 0xB1, 0xC6},
+			{0xFF, 0x55, 0xAA, 0x00},
+			{0x39, 0x93, 0x4E, 0xE1},
+		},
+		selectorHistoryBufSize: 8,
+	}
+
+	// The top rows of blocks have the same endpoint, so that the endpoint
+	// predictor symbols repeat, and the same selector, so that it is
+	// run-length coded. The bottom rows vary.
+	const widthInBlocks, heightInBlocks = 16, 6
+	e, s := make([][]int, heightInBlocks), make([][]int, heightInBlocks)
+	for y := range heightInBlocks {
+		e[y], s[y] = make([]int, widthInBlocks), make([]int, widthInBlocks)
+		for x := range widthInBlocks {
+			if y < 4 {
+				e[y][x] = 2
+			} else {
+				e[y][x] = ((7 * x) + (3 * y)) % len(enc.endpoints)
+			}
+			if y > 0 {
+				s[y][x] = (x + (2 * y)) % len(enc.selectors)
+			}
+		}
+	}
+
+	// The alpha slice's endpoints are gray.
+	ea, sa := make([][]int, heightInBlocks), make([][]int, heightInBlocks)
+	for y := range heightInBlocks {
+		ea[y], sa[y] = make([]int, widthInBlocks), make([]int, widthInBlocks)
+		for x := range widthInBlocks {
+			ea[y][x] = 1 + (4 * ((x + y) & 1))
+			sa[y][x] = (x * y) % len(enc.selectors)
+		}
+	}
+
+	// expand returns the pixel at (x, y), per the ETC1 specification.
+	modifiers := [8][2]int{{2, 8}, {5, 17}, {9, 29}, {13, 42}, {18, 60}, {24, 80}, {33, 106}, {47, 183}}
+	expand := func(e [][]int, s [][]int, x int, y int) (rgb [3]uint8) {
+		endpoint := enc.endpoints[e[y/4][x/4]]
+		sel := (enc.selectors[s[y/4][x/4]][y&3] >> (2 * (x & 3))) & 3
+		m := modifiers[endpoint.inten]
+		modifier := [4]int{-m[1], -m[0], m[0], m[1]}[sel]
+		for c := range 3 {
+			v := int(endpoint.color5[c]<<3) | int(endpoint.color5[c]>>2)
+			rgb[c] = uint8(max(0, min(255, v+modifier)))
+		}
+		return rgb
+	}
+
+	const width, height = (4 * widthInBlocks) - 1, (4 * heightInBlocks) - 2
+	for _, withAlpha := range []bool{false, true} {
+		alpha := []byte(nil)
+		if withAlpha {
+			alpha = enc.sliceData(ea, sa)
+		}
+		data := makeBasisLZ(enc, width, height, enc.sliceData(e, s), alpha)
+
+		l, err := DecodeLayout(bytes.NewReader(data))
+		if err != nil {
+			tt.Fatalf("withAlpha=%t: DecodeLayout: %v", withAlpha, err)
+		}
+		wantFormat := etc2.FormatETC2SRGB
+		if withAlpha {
+			wantFormat = etc2.FormatETC2SRGBA8
+		}
+		if l.Format != wantFormat {
+			tt.Fatalf("withAlpha=%t: Format: got %v, want %v", withAlpha, l.Format, wantFormat)
+		}
+
+		m, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			tt.Fatalf("withAlpha=%t: Decode: %v", withAlpha, err)
+		} else if got := m.Bounds(); got != image.Rect(0, 0, width, height) {
+			tt.Fatalf("withAlpha=%t: Bounds: got %v", withAlpha, got)
+		}
+		for y := range height {
+			for x := range width {
+				got := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+				want := expand(e, s, x, y)
+				if (got.R != want[0]) || (got.G != want[1]) || (got.B != want[2]) {
+					tt.Fatalf("withAlpha=%t: (%d, %d): got %v, want %v", withAlpha, x, y, got, want)
+				}
+				wantA := uint8(0xFF)
+				if withAlpha {
+					wantA = expand(ea, sa, x, y)[1]
+				}
+				if d := int(got.A) - int(wantA); (d < -16) || (d > +16) {
+					tt.Fatalf("withAlpha=%t: (%d, %d): alpha: got %d, want %d", withAlpha, x, y, got.A, wantA)
+				}
+			}
+		}
+
+		if m2, err := DecodeImage(bytes.NewReader(data), &l, 0, 0, 0); err != nil {
+			tt.Fatalf("withAlpha=%t: DecodeImage: %v", withAlpha, err)
+		} else if !sameNRGBA(m, m2) {
+			tt.Fatalf("withAlpha=%t: DecodeImage: pixels differ", withAlpha)
+		}
+	}
+
+	// A BasisLZ file must have an ETC1S data format descriptor.
+	data := makeBasisLZ(enc, width, height, enc.sliceData(e, s), nil)
+	data[HeaderSize+24+12] = khrDFModelUASTC
+	if _, err := DecodeLayout(bytes.NewReader(data)); !errors.Is(err, ErrUnsupportedBasisLZ) {
+		tt.Fatalf("UASTC colorModel: got %v, want %v", err, ErrUnsupportedBasisLZ)
+	}
+
+	// Corrupt slice data is rejected, not decoded as garbage.
+	data = makeBasisLZ(enc, width, height, enc.sliceData(e, s)[:4], nil)
+	if _, err := Decode(bytes.NewReader(data)); err != ErrInvalidBasisLZ {
+		tt.Fatalf("truncated slice: got %v, want %v", err, ErrInvalidBasisLZ)
+	}
+}
+
+// truncating is a misbehaving Supercompressor whose Decompress returns too
+// little data.
+type truncating struct{}
+
+func (truncating) ID() uint32   { return 0x1_0001 }
+func (truncating) Name() string { return "truncating" }
+
+func (truncating) Compress(src []byte) ([]byte, error) {
+	return bytes.Clone(src), nil
+}
+
+func (truncating) Decompress(src []byte, decompressedLength int) ([]byte, error) {
+	return src[:len(src)/2], nil
+}
+
+func TestDecodeBadSupercompression(tt *testing.T) {
+	if err := supercompress.Register(truncating{}); err != nil {
+		tt.Fatalf("Register: %v", err)
+	}
+	const vkFormat = 151 // VK_FORMAT_ETC2_R8G8B8A8_UNORM_BLOCK
+	level := make([]byte, etc2.FormatETC2RGBA8.PayloadSize(3, 2))
+	data := makeKTX2(tt, vkFormat, 12, 8, truncating{}.ID(), [][]byte{level})
+	if _, err := Decode(bytes.NewReader(data)); err != supercompress.ErrSizeMismatch {
+		tt.Fatalf("truncating: got %v, want %v", err, supercompress.ErrSizeMismatch)
+	}
+
+	// The header's dimensions imply a multi-gigabyte level, which is rejected
+	// before it is passed to Decompress. The uncompressedByteLength is zero,
+	// meaning unknown.
+	data = makeKTX2(tt, vkFormat, 12, 8, supercompress.IDZLIB, [][]byte{level})
+	binary.LittleEndian.PutUint32(data[20:], 65532)
+	binary.LittleEndian.PutUint32(data[24:], 65532)
+	binary.LittleEndian.PutUint64(data[HeaderSize+16:], 0)
+	if _, err := Decode(bytes.NewReader(data)); err != ErrUnsupportedTexture {
+		tt.Fatalf("huge: got %v, want %v", err, ErrUnsupportedTexture)
+	}
+}
+
+// checkSameImages checks that the KTX 2.0 file got holds the same images as
+// the KTX file want, for each supercompression scheme that got was encoded
+// with.
+func checkSameImages(tt *testing.T, name string, got []byte, want []byte) {
+	wl, err := ktx.DecodeLayout(bytes.NewReader(want))
+	if err != nil {
+		tt.Fatalf("%s: ktx.DecodeLayout: %v", name, err)
+	}
+	gl, err := DecodeLayout(bytes.NewReader(got))
+	if err != nil {
+		tt.Fatalf("%s: DecodeLayout: %v", name, err)
+	}
+	if (gl.Format != wl.Format) || (gl.Width != wl.Width) || (gl.Height != wl.Height) ||
+		(gl.Depth != wl.Depth) || (gl.NumArrayElements != wl.NumArrayElements) ||
+		(gl.NumFaces != wl.NumFaces) || (gl.NumMipmapLevels != wl.NumMipmapLevels) {
+		tt.Fatalf("%s: layouts differ:\ngot  %+v\nwant %+v", name, gl, wl)
+	}
+	for level := range gl.NumMipmapLevels {
+		if (gl.SupercompressionScheme == supercompress.IDNone) &&
+			((gl.Levels[level].ByteOffset % uint64(gl.Format.BytesPerBlock())) != 0) {
+			tt.Fatalf("%s, level=%d: ByteOffset %d is not block-aligned", name, level, gl.Levels[level].ByteOffset)
+		}
+		for face := range gl.NumFaces {
+			for layer := range gl.NumLayers(level) {
+				gm, err := DecodeImage(bytes.NewReader(got), &gl, level, face, layer)
+				if err != nil {
+					tt.Fatalf("%s, level=%d, face=%d, layer=%d: DecodeImage: %v", name, level, face, layer, err)
+				}
+				wm, err := ktx.DecodeImage(bytes.NewReader(want), &wl, level, face, layer)
+				if err != nil {
+					tt.Fatalf("%s, level=%d, face=%d, layer=%d: ktx.DecodeImage: %v", name, level, face, layer, err)
+				}
+				if !sameNRGBA(gm, wm) {
+					tt.Fatalf("%s, level=%d, face=%d, layer=%d: pixels differ", name, level, face, layer)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeCubemap(tt *testing.T) {
+	const size = 8
+	faces := &[6]image.Image{}
+	for i := range faces {
+		m := image.NewNRGBA(image.Rect(0, 0, size, size))
+		for y := range size {
+			for x := range size {
+				m.SetNRGBA(x, y, color.NRGBA{uint8(40 * i), uint8(8 * x), uint8(8 * y), uint8(0xFF - x)})
+			}
+		}
+		faces[i] = m
+	}
+	cross := image.NewNRGBA(image.Rect(0, 0, 4*size, 3*size))
+	for i, c := range [6]image.Point{{2, 1}, {0, 1}, {1, 0}, {1, 2}, {1, 1}, {3, 1}} {
+		draw.Draw(cross, image.Rectangle{Min: c.Mul(size), Max: c.Add(image.Point{1, 1}).Mul(size)}, faces[i], image.Point{}, draw.Src)
+	}
+
+	ktxOptions := &ktx.EncodeOptions{
+		Format: etc2.FormatETC2RGBA8,
+		KeyValues: []ktx.KeyValue{
+			{Key: "KTXorientation", Value: []byte("S=r,T=d\x00")},
+			{Key: "KTXwriter", Value: []byte("test\x00")},
+			{Key: "AssetID", Value: []byte("42\x00")},
+		},
+		Mipmaps: true,
+	}
+	want := &bytes.Buffer{}
+	if err := ktx.EncodeCubemap(want, faces, ktxOptions); err != nil {
+		tt.Fatalf("ktx.EncodeCubemap: %v", err)
+	}
+
+	for _, scheme := range []uint32{supercompress.IDNone, supercompress.IDZstandard} {
+		options := &EncodeOptions{KTXOptions: ktxOptions, SupercompressionScheme: scheme}
+		got := &bytes.Buffer{}
+		if err := EncodeCubemap(got, faces, options); err != nil {
+			tt.Fatalf("scheme=%d: EncodeCubemap: %v", scheme, err)
+		}
+		checkSameImages(tt, "EncodeCubemap", got.Bytes(), want.Bytes())
+
+		gotCross := &bytes.Buffer{}
+		if err := EncodeCubemapCross(gotCross, cross, options); err != nil {
+			tt.Fatalf("scheme=%d: EncodeCubemapCross: %v", scheme, err)
+		} else if !bytes.Equal(gotCross.Bytes(), got.Bytes()) {
+			tt.Fatalf("scheme=%d: EncodeCubemapCross: output differs from EncodeCubemap", scheme)
+		}
+
+		// The key-value data is sorted by key, with the KTXorientation
+		// value in its KTX 2.0 form.
+		kvs, err := DecodeKeyValues(bytes.NewReader(got.Bytes()))
+		if err != nil {
+			tt.Fatalf("scheme=%d: DecodeKeyValues: %v", scheme, err)
+		}
+		wantKVs := []ktx.KeyValue{
+			{Key: "AssetID", Value: []byte("42\x00")},
+			{Key: "KTXorientation", Value: []byte("rd\x00")},
+			{Key: "KTXwriter", Value: []byte("test\x00")},
+		}
+		if !slices.EqualFunc(kvs, wantKVs, func(x ktx.KeyValue, y ktx.KeyValue) bool {
+			return (x.Key == y.Key) && bytes.Equal(x.Value, y.Value)
+		}) {
+			tt.Fatalf("scheme=%d: DecodeKeyValues: got %q, want %q", scheme, kvs, wantKVs)
+		}
+
+		// The data format descriptor's basic descriptor block has the ETC2
+		// color model and, for RGBA8, an alpha and a color sample.
+		data := got.Bytes()
+		dfd := data[binary.LittleEndian.Uint32(data[48:]):]
+		if got, want := dfd[12], uint8(khrDFModelETC2); got != want {
+			tt.Fatalf("scheme=%d: colorModel: got %d, want %d", scheme, got, want)
+		} else if got, want := binary.LittleEndian.Uint16(dfd[10:]), uint16(24+(2*16)); got != want {
+			tt.Fatalf("scheme=%d: descriptorBlockSize: got %d, want %d", scheme, got, want)
+		} else if got, want := [2]uint8{dfd[28+3], dfd[44+3]}, [2]uint8{khrDFChannelETC2Alpha, khrDFChannelETC2Color}; got != want {
+			tt.Fatalf("scheme=%d: channelTypes: got %v, want %v", scheme, got, want)
+		}
+	}
+
+	if err := EncodeCubemap(&bytes.Buffer{}, faces, &EncodeOptions{SupercompressionScheme: supercompress.IDBasisLZ}); err != ErrBadArgument {
+		tt.Fatalf("BasisLZ: got %v, want %v", err, ErrBadArgument)
+	}
+}
+
+func TestEncodeArrayAnd3D(tt *testing.T) {
+	const width, height, n = 12, 8, 3
+	images := make([]image.Image, n)
+	for i := range images {
+		m := image.NewGray16(image.Rect(0, 0, width, height))
+		for y := range height {
+			for x := range width {
+				m.SetGray16(x, y, color.Gray16{uint16((4000 * x) + (3000 * y) + (500 * i))})
+			}
+		}
+		images[i] = m
+	}
+
+	testCases := []struct {
+		name      string
+		mipmaps   bool
+		encodeKTX func(w io.Writer, images []image.Image, options *ktx.EncodeOptions) error
+		encode    func(w io.Writer, images []image.Image, options *EncodeOptions) error
+	}{
+		{"EncodeArray", false, ktx.EncodeArray, EncodeArray},
+		{"EncodeArray", true, ktx.EncodeArray, EncodeArray},
+		{"Encode3D", false, ktx.Encode3D, Encode3D},
+	}
+	for _, tc := range testCases {
+		ktxOptions := &ktx.EncodeOptions{Format: etc2.FormatETC2R11Signed, Mipmaps: tc.mipmaps}
+		want := &bytes.Buffer{}
+		if err := tc.encodeKTX(want, images, ktxOptions); err != nil {
+			tt.Fatalf("%s, mipmaps=%t: ktx: %v", tc.name, tc.mipmaps, err)
+		}
+		for _, scheme := range []uint32{supercompress.IDNone, supercompress.IDZLIB} {
+			got := &bytes.Buffer{}
+			if err := tc.encode(got, images, &EncodeOptions{KTXOptions: ktxOptions, SupercompressionScheme: scheme}); err != nil {
+				tt.Fatalf("%s, mipmaps=%t, scheme=%d: %v", tc.name, tc.mipmaps, scheme, err)
+			}
+			checkSameImages(tt, tc.name, got.Bytes(), want.Bytes())
+
+			// There is no key-value data, so kvdByteOffset is zero.
+			if kvs, err := DecodeKeyValues(bytes.NewReader(got.Bytes())); (kvs != nil) || (err != nil) {
+				tt.Fatalf("%s, mipmaps=%t, scheme=%d: DecodeKeyValues: got %q, %v", tc.name, tc.mipmaps, scheme, kvs, err)
+			} else if u := binary.LittleEndian.Uint32(got.Bytes()[56:]); u != 0 {
+				tt.Fatalf("%s, mipmaps=%t, scheme=%d: kvdByteOffset: got %d, want 0", tc.name, tc.mipmaps, scheme, u)
+			}
+		}
+	}
+}
+
+func sameNRGBA(a image.Image, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	na := image.NewNRGBA(a.Bounds())
+	draw.Draw(na, na.Bounds(), a, a.Bounds().Min, draw.Src)
+	nb := image.NewNRGBA(b.Bounds())
+	draw.Draw(nb, nb.Bounds(), b, b.Bounds().Min, draw.Src)
+	return bytes.Equal(na.Pix, nb.Pix)
+}
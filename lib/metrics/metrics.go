@@ -0,0 +1,161 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// ----------------
+
+// Package metrics implements image quality metrics, such as PSNR and SSIM,
+// for comparing an original image with its compressed-then-decompressed
+// version.
+//
+// All metrics work on non-premultiplied 8-bit scale values: 16-bit channels
+// are divided by 257, so that the peak value is 255 regardless of the images'
+// bit depths. The two images must have the same width and height but their
+// Bounds().Min may differ.
+package metrics
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+var (
+	ErrBadArgument     = errors.New("metrics: bad argument")
+	ErrSizeMismatch    = errors.New("metrics: size mismatch")
+	ErrImageIsTooSmall = errors.New("metrics: image is too small")
+)
+
+// MSE returns the mean squared error of each of the R, G, B and A channels.
+func MSE(a image.Image, b image.Image) (mse [4]float64, retErr error) {
+	ab, bb, err := checkSizes(a, b)
+	if err != nil {
+		return mse, err
+	}
+	for y := range ab.Dy() {
+		for x := range ab.Dx() {
+			ca := nrgba(a, ab.Min.X+x, ab.Min.Y+y)
+			cb := nrgba(b, bb.Min.X+x, bb.Min.Y+y)
+			for i := range 4 {
+				d := ca[i] - cb[i]
+				mse[i] += d * d
+			}
+		}
+	}
+	n := float64(ab.Dx() * ab.Dy())
+	for i := range mse {
+		mse[i] /= n
+	}
+	return mse, nil
+}
+
+// PSNR returns the peak signal-to-noise ratio, in decibels, over the R, G and
+// B channels. It is +Inf if those channels are identical.
+func PSNR(a image.Image, b image.Image) (float64, error) {
+	mse, err := MSE(a, b)
+	if err != nil {
+		return 0, err
+	}
+	return PSNRFromMSE((mse[0] + mse[1] + mse[2]) / 3), nil
+}
+
+// PSNRFromMSE converts a mean squared error (on the 8-bit scale) to a peak
+// signal-to-noise ratio, in decibels.
+func PSNRFromMSE(mse float64) float64 {
+	if mse <= 0 {
+		return math.Inf(+1)
+	}
+	return 10 * math.Log10((255*255)/mse)
+}
+
+// ssimWindowSize and ssimWindowStride are the size and spacing of the square
+// windows that SSIM averages over.
+const (
+	ssimWindowSize   = 8
+	ssimWindowStride = 4
+)
+
+// SSIM returns the mean structural similarity index of the two images' luma
+// (using the ITU-R BT.601 weights), computed over 8×8 windows spaced 4 pixels
+// apart. It is 1 for identical images and lower for less similar ones.
+//
+// It returns ErrImageIsTooSmall if the images are smaller than 8×8.
+func SSIM(a image.Image, b image.Image) (float64, error) {
+	ab, bb, err := checkSizes(a, b)
+	if err != nil {
+		return 0, err
+	}
+	w, h := ab.Dx(), ab.Dy()
+	if (w < ssimWindowSize) || (h < ssimWindowSize) {
+		return 0, ErrImageIsTooSmall
+	}
+
+	lumaA := make([]float64, w*h)
+	lumaB := make([]float64, w*h)
+	for y := range h {
+		for x := range w {
+			lumaA[(y*w)+x] = luma(nrgba(a, ab.Min.X+x, ab.Min.Y+y))
+			lumaB[(y*w)+x] = luma(nrgba(b, bb.Min.X+x, bb.Min.Y+y))
+		}
+	}
+
+	const c1 = (0.01 * 255) * (0.01 * 255)
+	const c2 = (0.03 * 255) * (0.03 * 255)
+	const n = ssimWindowSize * ssimWindowSize
+
+	sum, count := 0.0, 0
+	for y0 := 0; (y0 + ssimWindowSize) <= h; y0 += ssimWindowStride {
+		for x0 := 0; (x0 + ssimWindowSize) <= w; x0 += ssimWindowStride {
+			sa, sb, saa, sbb, sab := 0.0, 0.0, 0.0, 0.0, 0.0
+			for y := y0; y < (y0 + ssimWindowSize); y++ {
+				for x := x0; x < (x0 + ssimWindowSize); x++ {
+					va, vb := lumaA[(y*w)+x], lumaB[(y*w)+x]
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+			muA, muB := sa/n, sb/n
+			varA := (saa / n) - (muA * muA)
+			varB := (sbb / n) - (muB * muB)
+			covAB := (sab / n) - (muA * muB)
+			sum += ((2*muA*muB + c1) * (2*covAB + c2)) /
+				(((muA * muA) + (muB * muB) + c1) * (varA + varB + c2))
+			count++
+		}
+	}
+	return sum / float64(count), nil
+}
+
+func checkSizes(a image.Image, b image.Image) (ab image.Rectangle, bb image.Rectangle, retErr error) {
+	if (a == nil) || (b == nil) {
+		return image.Rectangle{}, image.Rectangle{}, ErrBadArgument
+	}
+	ab, bb = a.Bounds(), b.Bounds()
+	if ab.Size() != bb.Size() {
+		return image.Rectangle{}, image.Rectangle{}, ErrSizeMismatch
+	}
+	return ab, bb, nil
+}
+
+// nrgba returns m's non-premultiplied color at (x, y), on the 8-bit scale.
+func nrgba(m image.Image, x int, y int) [4]float64 {
+	c := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+	return [4]float64{
+		float64(c.R) / 257,
+		float64(c.G) / 257,
+		float64(c.B) / 257,
+		float64(c.A) / 257,
+	}
+}
+
+func luma(c [4]float64) float64 {
+	return (0.299 * c[0]) + (0.587 * c[1]) + (0.114 * c[2])
+}
@@ -0,0 +1,67 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestMetrics(tt *testing.T) {
+	a := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	b := image.NewNRGBA64(image.Rect(10, 20, 26, 36))
+	for y := range 16 {
+		for x := range 16 {
+			c := color.NRGBA{uint8(16 * x), uint8(16 * y), 0x80, 0xFF}
+			a.SetNRGBA(x, y, c)
+			b.Set(10+x, 20+y, c)
+		}
+	}
+
+	if psnr, err := PSNR(a, b); err != nil {
+		tt.Fatalf("PSNR: %v", err)
+	} else if !math.IsInf(psnr, +1) {
+		tt.Fatalf("PSNR of identical images: got %v, want +Inf", psnr)
+	}
+	if ssim, err := SSIM(a, b); err != nil {
+		tt.Fatalf("SSIM: %v", err)
+	} else if math.Abs(ssim-1) > 1e-9 {
+		tt.Fatalf("SSIM of identical images: got %v, want 1", ssim)
+	}
+
+	// Change every red value by 10, so that the red MSE is 100.
+	for y := range 16 {
+		for x := range 16 {
+			a.Pix[a.PixOffset(x, y)] += 10
+		}
+	}
+	mse, err := MSE(a, b)
+	if err != nil {
+		tt.Fatalf("MSE: %v", err)
+	}
+	if want := [4]float64{100, 0, 0, 0}; mse != want {
+		tt.Fatalf("MSE: got %v, want %v", mse, want)
+	}
+	if psnr, err := PSNR(a, b); err != nil {
+		tt.Fatalf("PSNR: %v", err)
+	} else if want := PSNRFromMSE(100.0 / 3); math.Abs(psnr-want) > 1e-9 {
+		tt.Fatalf("PSNR: got %v, want %v", psnr, want)
+	}
+	if ssim, err := SSIM(a, b); err != nil {
+		tt.Fatalf("SSIM: %v", err)
+	} else if (ssim >= 1) || (ssim < 0.9) {
+		tt.Fatalf("SSIM: got %v, want in [0.9, 1)", ssim)
+	}
+
+	if _, err := MSE(a, image.NewNRGBA(image.Rect(0, 0, 16, 15))); err != ErrSizeMismatch {
+		tt.Fatalf("MSE(mismatched): got %v, want %v", err, ErrSizeMismatch)
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// ----------------
+
+// Package nie implements the NIE (Naive) image file format.
+//
+// NIE is specified at
+// https://github.com/google/wuffs/blob/main/doc/spec/nie-spec.md
+//
+// Decoding supports every variant: BGRA or RGBA order, non-premultiplied or
+// premultiplied alpha and 4 or 8 bytes per pixel. Encoding produces the "bn4"
+// and "bn8" variants (BGRA order, non-premultiplied alpha).
+package nie
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Magic is the byte string prefix of every NIE image file.
+const Magic = "n\xC3\xAFE\xFF"
+
+// HeaderSize is the size, in bytes, of a NIE file's header, including the
+// Magic bytes. The pixel data immediately follows the header.
+const HeaderSize = 16
+
+var (
+	ErrBadArgument          = errors.New("nie: bad argument")
+	ErrNotANIEFile          = errors.New("nie: not a NIE file")
+	ErrUnsupportedImageType = errors.New("nie: unsupported image type")
+)
+
+func init() {
+	image.RegisterFormat("nie", Magic, Decode, DecodeConfig)
+}
+
+// header is a parsed NIE header.
+type header struct {
+	bgra          bool
+	premultiplied bool
+	bytesPerPixel int
+	width         int
+	height        int
+}
+
+func parseHeader(b []byte) (h header, retErr error) {
+	if (len(b) < HeaderSize) || (string(b[:len(Magic)]) != Magic) {
+		return header{}, ErrNotANIEFile
+	}
+	switch b[5] {
+	case 'b':
+		h.bgra = true
+	case 'r':
+		// No-op.
+	default:
+		return header{}, ErrNotANIEFile
+	}
+	switch b[6] {
+	case 'n':
+		// No-op.
+	case 'p':
+		h.premultiplied = true
+	default:
+		return header{}, ErrNotANIEFile
+	}
+	switch b[7] {
+	case '4':
+		h.bytesPerPixel = 4
+	case '8':
+		h.bytesPerPixel = 8
+	default:
+		return header{}, ErrNotANIEFile
+	}
+	width := readU32LE(b[8:])
+	height := readU32LE(b[12:])
+	if (width >= 0x8000_0000) || (height >= 0x8000_0000) {
+		return header{}, ErrNotANIEFile
+	}
+	h.width, h.height = int(width), int(height)
+	return h, nil
+}
+
+func (h *header) colorModel() color.Model {
+	switch {
+	case (h.bytesPerPixel == 4) && !h.premultiplied:
+		return color.NRGBAModel
+	case (h.bytesPerPixel == 4) && h.premultiplied:
+		return color.RGBAModel
+	case (h.bytesPerPixel == 8) && !h.premultiplied:
+		return color.NRGBA64Model
+	}
+	return color.RGBA64Model
+}
+
+// DecodeConfig reads a NIE image configuration from r.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	b := [HeaderSize]byte{}
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return image.Config{}, err
+	}
+	h, err := parseHeader(b[:])
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: h.colorModel(),
+		Width:      h.width,
+		Height:     h.height,
+	}, nil
+}
+
+// Decode reads a NIE image from r.
+//
+// The returned image's concrete type is an *image.NRGBA, *image.RGBA,
+// *image.NRGBA64 or *image.RGBA64, depending on whether the NIE file has 4 or
+// 8 bytes per pixel and non-premultiplied or premultiplied alpha.
+func Decode(r io.Reader) (image.Image, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeBytes(src)
+}
+
+// DecodeBytes is like Decode but takes the whole NIE file as a byte slice.
+func DecodeBytes(src []byte) (image.Image, error) {
+	h, err := parseHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	src = src[HeaderSize:]
+	rowSize := h.width * h.bytesPerPixel
+	if uint64(len(src)) < (uint64(rowSize) * uint64(h.height)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	// r and b are the source byte offsets (within each channel) of red and
+	// blue. A destination channel i comes from source channel i, after
+	// swapping r and b.
+	r, b := 0, 2
+	if h.bgra {
+		r, b = 2, 0
+	}
+	bounds := image.Rect(0, 0, h.width, h.height)
+	dst, stride := []byte(nil), 0
+	ret := image.Image(nil)
+	switch {
+	case (h.bytesPerPixel == 4) && !h.premultiplied:
+		m := image.NewNRGBA(bounds)
+		dst, stride, ret = m.Pix, m.Stride, m
+	case (h.bytesPerPixel == 4) && h.premultiplied:
+		m := image.NewRGBA(bounds)
+		dst, stride, ret = m.Pix, m.Stride, m
+	case (h.bytesPerPixel == 8) && !h.premultiplied:
+		m := image.NewNRGBA64(bounds)
+		dst, stride, ret = m.Pix, m.Stride, m
+	default:
+		m := image.NewRGBA64(bounds)
+		dst, stride, ret = m.Pix, m.Stride, m
+	}
+
+	for y := range h.height {
+		row := dst[y*stride:]
+		if h.bytesPerPixel == 4 {
+			for x := range h.width {
+				s, d := src[4*x:][:4], row[4*x:][:4]
+				d[0], d[1], d[2], d[3] = s[r], s[1], s[b], s[3]
+			}
+		} else {
+			// NIE is little-endian but image.NRGBA64 and image.RGBA64 are
+			// big-endian.
+			for x := range h.width {
+				s, d := src[8*x:][:8], row[8*x:][:8]
+				d[0], d[1] = s[(2*r)+1], s[(2*r)+0]
+				d[2], d[3] = s[3], s[2]
+				d[4], d[5] = s[(2*b)+1], s[(2*b)+0]
+				d[6], d[7] = s[7], s[6]
+			}
+		}
+		src = src[rowSize:]
+	}
+	return ret, nil
+}
+
+// DecodeBN8 decodes src, a NIE file in BGRA order, non-premultiplied alpha, 8
+// bytes per pixel (16 bits per channel). It is the inverse of EncodeBN8.
+func DecodeBN8(src []byte) (*image.NRGBA64, error) {
+	if h, err := parseHeader(src); err != nil {
+		return nil, err
+	} else if !h.bgra || h.premultiplied || (h.bytesPerPixel != 8) {
+		return nil, ErrUnsupportedImageType
+	}
+	m, err := DecodeBytes(src)
+	if err != nil {
+		return nil, err
+	}
+	return m.(*image.NRGBA64), nil
+}
+
+// DecodeBN4 decodes src, a NIE file in BGRA order, non-premultiplied alpha, 4
+// bytes per pixel (8 bits per channel). It is the inverse of EncodeBN4.
+func DecodeBN4(src []byte) (*image.NRGBA, error) {
+	if h, err := parseHeader(src); err != nil {
+		return nil, err
+	} else if !h.bgra || h.premultiplied || (h.bytesPerPixel != 4) {
+		return nil, ErrUnsupportedImageType
+	}
+	m, err := DecodeBytes(src)
+	if err != nil {
+		return nil, err
+	}
+	return m.(*image.NRGBA), nil
+}
+
+// EncodeBN4 encodes m as a NIE file in BGRA order, non-premultiplied alpha, 4
+// bytes per pixel (8 bits per channel). 16-bit channels keep only their high
+// byte, like the standard library's color.NRGBAModel.
+//
+// m can be any image.Image. Premultiplied colors are converted to
+// non-premultiplied ones, which is lossy for partially transparent pixels.
+func EncodeBN4(m image.Image) (ret []byte, retErr error) {
+	if m == nil {
+		return nil, ErrBadArgument
+	}
+	b := m.Bounds()
+	ret = appendHeader(ret, "bn4", b)
+
+	switch m := m.(type) {
+	case *image.Gray:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				at := m.GrayAt(x, y)
+				ret = append(ret, at.Y, at.Y, at.Y, 0xFF)
+			}
+		}
+		return ret, nil
+
+	case *image.Gray16:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				at := m.Gray16At(x, y)
+				ret = append(ret, uint8(at.Y>>8), uint8(at.Y>>8), uint8(at.Y>>8), 0xFF)
+			}
+		}
+		return ret, nil
+
+	case *image.NRGBA:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				at := m.NRGBAAt(x, y)
+				ret = append(ret, at.B, at.G, at.R, at.A)
+			}
+		}
+		return ret, nil
+
+	case *image.NRGBA64:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				at := m.NRGBA64At(x, y)
+				ret = append(ret, uint8(at.B>>8), uint8(at.G>>8), uint8(at.R>>8), uint8(at.A>>8))
+			}
+		}
+		return ret, nil
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			at := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			ret = append(ret, at.B, at.G, at.R, at.A)
+		}
+	}
+	return ret, nil
+}
+
+// EncodeBN8 encodes m as a NIE file in BGRA order, non-premultiplied alpha, 8
+// bytes per pixel (16 bits per channel).
+//
+// m can be any image.Image. Premultiplied colors are converted to
+// non-premultiplied ones, which is lossy for partially transparent pixels.
+func EncodeBN8(m image.Image) (ret []byte, retErr error) {
+	if m == nil {
+		return nil, ErrBadArgument
+	}
+	b := m.Bounds()
+	ret = appendHeader(ret, "bn8", b)
+
+	switch m := m.(type) {
+	case *image.Gray:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				at := m.GrayAt(x, y)
+				ret = append(ret,
+					uint8(at.Y), uint8(at.Y),
+					uint8(at.Y), uint8(at.Y),
+					uint8(at.Y), uint8(at.Y),
+					0xFF, 0xFF,
+				)
+			}
+		}
+		return ret, nil
+
+	case *image.Gray16:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				at := m.Gray16At(x, y)
+				ret = append(ret,
+					uint8(at.Y>>0), uint8(at.Y>>8),
+					uint8(at.Y>>0), uint8(at.Y>>8),
+					uint8(at.Y>>0), uint8(at.Y>>8),
+					0xFF, 0xFF,
+				)
+			}
+		}
+		return ret, nil
+
+	case *image.NRGBA:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				at := m.NRGBAAt(x, y)
+				ret = append(ret,
+					uint8(at.B), uint8(at.B),
+					uint8(at.G), uint8(at.G),
+					uint8(at.R), uint8(at.R),
+					uint8(at.A), uint8(at.A),
+				)
+			}
+		}
+		return ret, nil
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			at := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+			ret = append(ret,
+				uint8(at.B>>0), uint8(at.B>>8),
+				uint8(at.G>>0), uint8(at.G>>8),
+				uint8(at.R>>0), uint8(at.R>>8),
+				uint8(at.A>>0), uint8(at.A>>8),
+			)
+		}
+	}
+	return ret, nil
+}
+
+func appendHeader(b []byte, variant string, bounds image.Rectangle) []byte {
+	b = append(b, Magic...)
+	b = append(b, variant...)
+	b = appendU32LE(b, uint32(bounds.Dx()))
+	return appendU32LE(b, uint32(bounds.Dy()))
+}
+
+func readU32LE(b []byte) uint32 {
+	return (uint32(b[0]) << 0) |
+		(uint32(b[1]) << 8) |
+		(uint32(b[2]) << 16) |
+		(uint32(b[3]) << 24)
+}
+
+func appendU32LE(b []byte, u uint32) []byte {
+	return append(b,
+		uint8(u>>0),
+		uint8(u>>8),
+		uint8(u>>16),
+		uint8(u>>24),
+	)
+}
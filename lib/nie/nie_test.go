@@ -0,0 +1,128 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package nie
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoundTrip(tt *testing.T) {
+	filenames, err := filepath.Glob("../../res/3-decoded-nie/*.nie")
+	if err != nil {
+		tt.Fatalf("filepath.Glob: %v", err)
+	} else if len(filenames) == 0 {
+		tt.Fatalf("no NIE files found")
+	}
+
+	for _, filename := range filenames {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			tt.Fatalf("os.ReadFile: %v", err)
+		}
+		m, err := DecodeBN8(src)
+		if err != nil {
+			tt.Errorf("%s: DecodeBN8: %v", filename, err)
+			continue
+		}
+		got, err := EncodeBN8(m)
+		if err != nil {
+			tt.Errorf("%s: EncodeBN8: %v", filename, err)
+			continue
+		}
+		if !bytes.Equal(got, src) {
+			tt.Errorf("%s: round trip differs", filename)
+		}
+
+		if m2, format, err := image.Decode(bytes.NewReader(src)); err != nil {
+			tt.Errorf("%s: image.Decode: %v", filename, err)
+		} else if format != "nie" {
+			tt.Errorf("%s: image.Decode format: got %q, want %q", filename, format, "nie")
+		} else if !bytes.Equal(m2.(*image.NRGBA64).Pix, m.Pix) {
+			tt.Errorf("%s: image.Decode pixels differ", filename)
+		}
+	}
+
+	if _, err := DecodeBN8([]byte("not a NIE file..")); err != ErrNotANIEFile {
+		tt.Errorf("bad magic: got %v, want %v", err, ErrNotANIEFile)
+	}
+}
+
+func TestVariants(tt *testing.T) {
+	// One pixel: red 0x1234, green 0x5678, blue 0x9ABC and alpha 0xDEF0.
+	pixels := map[string][]byte{
+		"bn4": {0x9A, 0x56, 0x12, 0xDE},
+		"rn4": {0x12, 0x56, 0x9A, 0xDE},
+		"bp4": {0x9A, 0x56, 0x12, 0xDE},
+		"rp4": {0x12, 0x56, 0x9A, 0xDE},
+		"bn8": {0xBC, 0x9A, 0x78, 0x56, 0x34, 0x12, 0xF0, 0xDE},
+		"rn8": {0x34, 0x12, 0x78, 0x56, 0xBC, 0x9A, 0xF0, 0xDE},
+		"bp8": {0xBC, 0x9A, 0x78, 0x56, 0x34, 0x12, 0xF0, 0xDE},
+		"rp8": {0x34, 0x12, 0x78, 0x56, 0xBC, 0x9A, 0xF0, 0xDE},
+	}
+	for variant, pixel := range pixels {
+		src := append([]byte(Magic+variant+"\x01\x00\x00\x00\x01\x00\x00\x00"), pixel...)
+		m, err := DecodeBytes(src)
+		if err != nil {
+			tt.Errorf("%s: DecodeBytes: %v", variant, err)
+			continue
+		}
+		got, want := color.Color(nil), color.Color(nil)
+		switch variant {
+		case "bn4", "rn4":
+			got, want = m.(*image.NRGBA).At(0, 0), color.NRGBA{0x12, 0x56, 0x9A, 0xDE}
+		case "bp4", "rp4":
+			got, want = m.(*image.RGBA).At(0, 0), color.RGBA{0x12, 0x56, 0x9A, 0xDE}
+		case "bn8", "rn8":
+			got, want = m.(*image.NRGBA64).At(0, 0), color.NRGBA64{0x1234, 0x5678, 0x9ABC, 0xDEF0}
+		case "bp8", "rp8":
+			got, want = m.(*image.RGBA64).At(0, 0), color.RGBA64{0x1234, 0x5678, 0x9ABC, 0xDEF0}
+		}
+		if got != want {
+			tt.Errorf("%s: got %v, want %v", variant, got, want)
+		}
+	}
+
+	// EncodeBN4 and EncodeBN8 accept any image.Image, and DecodeBN4 and
+	// DecodeBN8 invert them.
+	src := image.NewPaletted(image.Rect(0, 0, 2, 1), color.Palette{
+		color.NRGBA{0x12, 0x56, 0x9A, 0xDE},
+		color.Gray16{0x3456},
+	})
+	src.Pix[1] = 1
+	for i, want := range []color.Color{src.Palette[0], src.Palette[1]} {
+		enc4, err := EncodeBN4(src)
+		if err != nil {
+			tt.Fatalf("EncodeBN4: %v", err)
+		}
+		dec4, err := DecodeBN4(enc4)
+		if err != nil {
+			tt.Fatalf("DecodeBN4: %v", err)
+		}
+		if got, want := dec4.At(i, 0), color.NRGBAModel.Convert(want); got != want {
+			tt.Errorf("BN4 pixel %d: got %v, want %v", i, got, want)
+		}
+
+		enc8, err := EncodeBN8(src)
+		if err != nil {
+			tt.Fatalf("EncodeBN8: %v", err)
+		}
+		dec8, err := DecodeBN8(enc8)
+		if err != nil {
+			tt.Fatalf("DecodeBN8: %v", err)
+		}
+		if got, want := dec8.At(i, 0), color.NRGBA64Model.Convert(want); got != want {
+			tt.Errorf("BN8 pixel %d: got %v, want %v", i, got, want)
+		}
+	}
+}
@@ -13,6 +13,7 @@ package pkm
 
 import (
 	"errors"
+	"fmt"
 	"image"
 	"io"
 
@@ -22,6 +23,16 @@ import (
 // Magic is the byte string prefix of every PKM image file.
 const Magic = "PKM "
 
+// HeaderSize is the size, in bytes, of a PKM file's header, including the
+// Magic bytes. The payload immediately follows the header.
+const HeaderSize = 16
+
+// BlockOffset returns the byte offset, from the start of a PKM file, of the
+// block at (blockX, blockY). All arguments are measured in blocks.
+func BlockOffset(f etc2.Format, widthInBlocks int, blockX int, blockY int) int64 {
+	return HeaderSize + f.BlockOffset(widthInBlocks, blockX, blockY)
+}
+
 func init() {
 	image.RegisterFormat("pkm", Magic, Decode, DecodeConfig)
 }
@@ -32,6 +43,28 @@ var (
 	ErrImageIsTooLarge = errors.New("pkm: image is too large")
 )
 
+// HeaderError is the error for a malformed or truncated PKM header. It wraps
+// ErrNotAPKMFile or io.ErrUnexpectedEOF, which errors.Is matches.
+//
+// Errors in the payload that follows the header are reported by the etc2
+// package, such as an *etc2.TruncatedError.
+type HeaderError struct {
+	// Offset is the byte offset, from the start of the file, of the field.
+	Offset int64
+
+	// Field describes the header field and what was expected of it.
+	Field string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("%v (%s, at byte offset %d)", e.Err, e.Field, e.Offset)
+}
+
+func (e *HeaderError) Unwrap() error { return e.Err }
+
 var pkmToETC2Formats = [12]etc2.Format{
 	0x00: etc2.FormatETC1,
 	0x01: etc2.FormatETC2RGB,
@@ -47,17 +80,27 @@ var pkmToETC2Formats = [12]etc2.Format{
 	0x0B: etc2.FormatETC2SRGBA1,
 }
 
-func decodeConfig(r io.Reader) (retFormat etc2.Format, retConfig image.Config, retErr error) {
-	buf := [16]byte{}
-	if _, err := io.ReadFull(r, buf[:]); err != nil {
+// DecodeHeader reads a PKM file's header from r, returning its texture format
+// as well as its image configuration. Unlike Decode, it reads only the first
+// HeaderSize bytes and does not decode any pixels.
+//
+// PKM does not distinguish etc2.FormatETC1S from etc2.FormatETC1, so the
+// returned format is never the former.
+func DecodeHeader(r io.Reader) (retFormat etc2.Format, retConfig image.Config, retErr error) {
+	buf := [HeaderSize]byte{}
+	if n, err := io.ReadFull(r, buf[:]); err != nil {
+		if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+			err = &HeaderError{int64(n), "truncated header", io.ErrUnexpectedEOF}
+		}
 		return 0, image.Config{}, err
-	} else if (buf[0] != Magic[0]) ||
-		(buf[1] != Magic[1]) ||
-		(buf[2] != Magic[2]) ||
-		(buf[3] != Magic[3]) ||
-		(buf[5] != 0x30) ||
-		(buf[6] != 0x00) {
-		return 0, image.Config{}, ErrNotAPKMFile
+	}
+	return parseHeader(&buf)
+}
+
+// parseHeader is like DecodeHeader but parses the header from buf.
+func parseHeader(buf *[HeaderSize]byte) (retFormat etc2.Format, retConfig image.Config, retErr error) {
+	if string(buf[:4]) != Magic {
+		return 0, image.Config{}, &HeaderError{0, `magic (want "PKM ")`, ErrNotAPKMFile}
 	}
 
 	etcVersion := 0
@@ -65,14 +108,19 @@ func decodeConfig(r io.Reader) (retFormat etc2.Format, retConfig image.Config, r
 	case 0x31, 0x32:
 		etcVersion = int(buf[4]) & 0x03
 	default:
-		return 0, image.Config{}, ErrNotAPKMFile
+		return 0, image.Config{}, &HeaderError{4, `version (want "10" or "20")`, ErrNotAPKMFile}
+	}
+	if buf[5] != 0x30 {
+		return 0, image.Config{}, &HeaderError{5, `version (want "10" or "20")`, ErrNotAPKMFile}
+	} else if buf[6] != 0x00 {
+		return 0, image.Config{}, &HeaderError{6, "format (want a high byte of 0x00)", ErrNotAPKMFile}
 	}
 
 	if f := int(buf[7]); f < len(pkmToETC2Formats) {
 		retFormat = pkmToETC2Formats[f]
 	}
 	if retFormat.ETCVersion() != etcVersion {
-		return 0, image.Config{}, ErrNotAPKMFile
+		return 0, image.Config{}, &HeaderError{7, "format (want one valid for the version)", ErrNotAPKMFile}
 	}
 
 	roundedUpWidth := (uint32(buf[8]) << 8) | uint32(buf[9])
@@ -80,9 +128,10 @@ func decodeConfig(r io.Reader) (retFormat etc2.Format, retConfig image.Config, r
 	width := (uint32(buf[12]) << 8) | uint32(buf[13])
 	height := (uint32(buf[14]) << 8) | uint32(buf[15])
 
-	if (((width + 3) &^ 3) != roundedUpWidth) ||
-		(((height + 3) &^ 3) != roundedUpHeight) {
-		return 0, image.Config{}, ErrNotAPKMFile
+	if ((width + 3) &^ 3) != roundedUpWidth {
+		return 0, image.Config{}, &HeaderError{8, "rounded up width (want the width rounded up to a multiple of 4)", ErrNotAPKMFile}
+	} else if ((height + 3) &^ 3) != roundedUpHeight {
+		return 0, image.Config{}, &HeaderError{10, "rounded up height (want the height rounded up to a multiple of 4)", ErrNotAPKMFile}
 	}
 
 	return retFormat, image.Config{
@@ -94,32 +143,183 @@ func decodeConfig(r io.Reader) (retFormat etc2.Format, retConfig image.Config, r
 
 // DecodeConfig reads a PKM image configuration from r.
 func DecodeConfig(r io.Reader) (image.Config, error) {
-	_, config, err := decodeConfig(r)
+	_, config, err := DecodeHeader(r)
 	return config, err
 }
 
 // Decode reads a PKM image from r.
 func Decode(r io.Reader) (image.Image, error) {
-	format, config, err := decodeConfig(r)
+	return DecodeWithOptions(r, nil)
+}
+
+// DecodeOptions are optional arguments to DecodeWithOptions. The zero value is
+// valid and means to use the default configuration.
+type DecodeOptions struct {
+	// AllocPix, if non-nil, allocates the decoded image's Pix slice, such as
+	// etc2.Arena.Alloc. See etc2.Format.NewImageUsing.
+	AllocPix func(n int) []byte
+
+	// ColorOnly is whether to skip decoding alpha for the
+	// etc2.FormatETC2RGBA8 and etc2.FormatETC2SRGBA8 formats. The decoded
+	// image is then an opaque *image.RGBA. See etc2.DecodeOptions.ColorOnly.
+	//
+	// It is equivalent to setting ETC2Options.ColorOnly.
+	ColorOnly bool
+
+	// ETC2Options, if non-nil, are passed on to etc2.Format.DecodeWithOptions.
+	ETC2Options *etc2.DecodeOptions
+
+	// Padded is whether to return the whole decoded image, with its width and
+	// height rounded up to a multiple of 4, instead of a SubImage cropped to
+	// the PKM file's stated width and height. Its concrete type is then
+	// exactly that returned by etc2.Format.NewImageWithOptions (such as
+	// *image.NRGBA) and its Pix slice holds every block's pixels, contiguously,
+	// ready to upload as whole blocks.
+	Padded bool
+}
+
+// etc2Options returns the options to pass on to the etc2 package, folding in
+// ColorOnly. options may be nil.
+func (options *DecodeOptions) etc2Options() *etc2.DecodeOptions {
+	if options == nil {
+		return nil
+	} else if !options.ColorOnly {
+		return options.ETC2Options
+	}
+	o := etc2.DecodeOptions{}
+	if options.ETC2Options != nil {
+		o = *options.ETC2Options
+	}
+	o.ColorOnly = true
+	return &o
+}
+
+// DecodeWithOptions is like Decode but with optional arguments.
+//
+// If ETC2Options.Partial is set and the payload is truncated, it returns both
+// the partially decoded image and the error.
+//
+// options may be nil, which means to use the default configuration.
+func DecodeWithOptions(r io.Reader, options *DecodeOptions) (image.Image, error) {
+	format, config, err := DecodeHeader(r)
 	if err != nil {
 		return nil, err
 	}
-	m, err := format.NewImage(config.Width, config.Height)
+	return decodePayload(format, config, options, func(m image.Image, widthInBlocks int, heightInBlocks int, etc2Options *etc2.DecodeOptions) error {
+		return format.DecodeWithOptions(m, r, widthInBlocks, heightInBlocks, etc2Options)
+	})
+}
+
+// decodePayload allocates the image for a PKM file with the given format and
+// configuration and calls decode to decode the payload into it.
+func decodePayload(format etc2.Format, config image.Config, options *DecodeOptions, decode func(m image.Image, widthInBlocks int, heightInBlocks int, etc2Options *etc2.DecodeOptions) error) (image.Image, error) {
+	allocPix := (func(n int) []byte)(nil)
+	if options != nil {
+		allocPix = options.AllocPix
+	}
+	etc2Options := options.etc2Options()
+	m, err := format.NewImageWithOptions(config.Width, config.Height, allocPix, etc2Options)
 	if err != nil {
 		return nil, err
 	}
 	b := m.Bounds()
-	if err = format.Decode(m, r, b.Dx()/4, b.Dy()/4); err != nil {
+	err = decode(m, b.Dx()/4, b.Dy()/4, etc2Options)
+	if (err != nil) && ((etc2Options == nil) || !etc2Options.Partial) {
 		return nil, err
+	} else if (options != nil) && options.Padded {
+		return m, err
 	}
 	return m.SubImage(image.Rect(0, 0, config.Width, config.Height)), err
 }
 
+// DecodeBytes is like Decode except that the PKM file is the in-memory data
+// instead of an io.Reader, such as a memory-mapped file. Neither the header
+// nor the payload is copied before decoding.
+func DecodeBytes(data []byte) (image.Image, error) {
+	return DecodeBytesWithOptions(data, nil)
+}
+
+// DecodeBytesWithOptions is like DecodeBytes but with optional arguments.
+//
+// options may be nil, which means to use the default configuration.
+func DecodeBytesWithOptions(data []byte, options *DecodeOptions) (image.Image, error) {
+	if len(data) < HeaderSize {
+		return nil, &HeaderError{int64(len(data)), "truncated header", io.ErrUnexpectedEOF}
+	}
+	format, config, err := parseHeader((*[HeaderSize]byte)(data))
+	if err != nil {
+		return nil, err
+	}
+	return decodePayload(format, config, options, func(m image.Image, widthInBlocks int, heightInBlocks int, etc2Options *etc2.DecodeOptions) error {
+		return format.DecodeBytes(m, data[HeaderSize:], widthInBlocks, heightInBlocks, etc2Options)
+	})
+}
+
+// DecodeAlpha reads only the alpha channel of a PKM image from r. The PKM
+// file's format must be etc2.FormatETC2RGBA8 or etc2.FormatETC2SRGBA8. See
+// etc2.Format.DecodeAlpha.
+func DecodeAlpha(r io.Reader) (*image.Alpha, error) {
+	format, config, err := DecodeHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	m := image.NewAlpha(image.Rect(0, 0, config.Width, config.Height))
+	widthInBlocks := etc2.BlocksWide(config.Width)
+	heightInBlocks := etc2.BlocksHigh(config.Height)
+	if err := format.DecodeAlpha(m, r, widthInBlocks, heightInBlocks); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DecodeAt reads a PKM image from r, writing its pixels into dst with the
+// image's top-left corner at p. This can assemble an atlas from multiple PKM
+// tiles without allocating an intermediate image for each tile.
+//
+// dst's concrete type must match the PKM file's format: the type returned by
+// etc2.Format.NewImage. Pixels beyond the PKM image's width and height (which
+// pad its dimensions up to a multiple of 4) or outside of dst's bounds are not
+// written.
+//
+// It returns the PKM image's configuration.
+func DecodeAt(dst etc2.SubsettableImage, p image.Point, r io.Reader) (image.Config, error) {
+	return DecodeAtWithOptions(dst, p, r, nil)
+}
+
+// DecodeAtWithOptions is like DecodeAt but with optional arguments. dst's
+// concrete type must then match etc2.Format.NewImageWithOptions. The
+// AllocPix option is ignored, as dst is already allocated.
+//
+// options may be nil, which means to use the default configuration.
+func DecodeAtWithOptions(dst etc2.SubsettableImage, p image.Point, r io.Reader, options *DecodeOptions) (image.Config, error) {
+	if dst == nil {
+		return image.Config{}, ErrBadArgument
+	}
+	format, config, err := DecodeHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	clipped := dst.SubImage(image.Rectangle{
+		Min: p,
+		Max: p.Add(image.Point{X: config.Width, Y: config.Height}),
+	})
+	widthInBlocks := etc2.BlocksWide(config.Width)
+	heightInBlocks := etc2.BlocksHigh(config.Height)
+	if err := format.DecodeAtWithOptions(clipped, p, r, widthInBlocks, heightInBlocks, options.etc2Options()); err != nil {
+		return image.Config{}, err
+	}
+	return config, nil
+}
+
 // EncodeOptions are optional arguments to Encode. The zero value is valid and
 // means to use the default configuration.
 type EncodeOptions struct {
 	// If zero, the default is to use etc2.FormatETC2RGB.
 	Format etc2.Format
+
+	// ETC2Options, if non-nil, are passed on to etc2.Encode. Its SubRect
+	// field must be empty, as a PKM file always holds the whole image.
+	ETC2Options *etc2.EncodeOptions
 }
 
 // Encode writes src to w in the PKM format.
@@ -127,40 +327,119 @@ type EncodeOptions struct {
 // options may be nil, which means to use the default configuration.
 func Encode(w io.Writer, src image.Image, options *EncodeOptions) error {
 	b := src.Bounds()
-	bW, bH := b.Dx(), b.Dy()
-	if (bW > 65532) || (bH > 65532) {
-		return ErrImageIsTooLarge
+	f := etc2.FormatETC2RGB
+	etc2Options := (*etc2.EncodeOptions)(nil)
+	if options != nil {
+		if options.Format != 0 {
+			f = options.Format
+		}
+		etc2Options = options.ETC2Options
+		if (etc2Options != nil) && !etc2Options.SubRect.Empty() {
+			return ErrBadArgument
+		}
+	}
+	enc := NewEncoder(w)
+	if err := enc.WriteHeader(f, b.Dx(), b.Dy()); err != nil {
+		return err
 	}
 
-	f := etc2.FormatETC2RGB
-	if (options != nil) && (options.Format != 0) {
-		f = options.Format
+	// If w is seekable then let etc2.Encode write the payload directly, so
+	// that it can encode rows of blocks concurrently.
+	if ws, ok := w.(writerAtSeeker); ok && (etc2Options != nil) && (etc2Options.NumWorkers > 1) {
+		if _, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			return etc2.Encode(w, src, f, etc2Options)
+		}
+	}
+
+	if err := etc2.Encode(enc, src, f, etc2Options); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// writerAtSeeker is an io.WriterAt whose current (io.Writer) position can be
+// queried and set, such as an *os.File.
+type writerAtSeeker interface {
+	io.WriterAt
+	io.Seeker
+}
+
+// Encoder writes a PKM file incrementally: first the header and then the
+// payload (the encoded blocks), in one or more Write calls. This lets very
+// large textures be produced by a pipeline without holding the whole image or
+// payload in memory.
+type Encoder struct {
+	w         io.Writer
+	remaining int64
+	header    bool
+}
+
+// NewEncoder returns an Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// WriteHeader writes the PKM header for an image with the given format and
+// dimensions (in pixels). It must be called exactly once, before any Write
+// calls.
+func (e *Encoder) WriteHeader(f etc2.Format, width int, height int) error {
+	if e.header || (width < 0) || (height < 0) {
+		return ErrBadArgument
+	} else if (width > 65532) || (height > 65532) {
+		return ErrImageIsTooLarge
 	}
 	version := f.ETCVersion()
 	if version == 0 {
 		return ErrBadArgument
 	}
 
-	buf := [16]byte{}
+	buf := [HeaderSize]byte{}
 	copy(buf[:4], Magic)
 	buf[0x04] = 0x30 | uint8(version)
 	buf[0x05] = 0x30
 	buf[0x06] = 0x00
 	buf[0x07] = byte(f.PKMFormat())
 
-	roundedUpW := (bW + 3) &^ 3
-	roundedUpH := (bH + 3) &^ 3
+	roundedUpW, roundedUpH := etc2.PaddedSize(width, height)
 	buf[0x08] = uint8(roundedUpW >> 8)
 	buf[0x09] = uint8(roundedUpW >> 0)
 	buf[0x0A] = uint8(roundedUpH >> 8)
 	buf[0x0B] = uint8(roundedUpH >> 0)
-	buf[0x0C] = uint8(bW >> 8)
-	buf[0x0D] = uint8(bW >> 0)
-	buf[0x0E] = uint8(bH >> 8)
-	buf[0x0F] = uint8(bH >> 0)
-	if _, err := w.Write(buf[:]); err != nil {
+	buf[0x0C] = uint8(width >> 8)
+	buf[0x0D] = uint8(width >> 0)
+	buf[0x0E] = uint8(height >> 8)
+	buf[0x0F] = uint8(height >> 0)
+	if _, err := e.w.Write(buf[:]); err != nil {
 		return err
 	}
 
-	return etc2.Encode(w, src, f, nil)
+	e.header = true
+	e.remaining = f.PayloadSize(etc2.BlocksWide(width), etc2.BlocksHigh(height))
+	return nil
+}
+
+// Write writes encoded block bytes, in row-major order. The bytes do not have
+// to be split on block boundaries, but writing more than the header's width
+// and height require is an error.
+//
+// An Encoder is an io.Writer, so it can be passed to etc2.Encode.
+func (e *Encoder) Write(blockBytes []byte) (int, error) {
+	if !e.header || (int64(len(blockBytes)) > e.remaining) {
+		return 0, ErrBadArgument
+	}
+	n, err := e.w.Write(blockBytes)
+	e.remaining -= int64(n)
+	return n, err
+}
+
+// Close returns an error if fewer payload bytes were written than the
+// header's width and height require. It does not close the underlying
+// io.Writer.
+func (e *Encoder) Close() error {
+	if !e.header {
+		return ErrBadArgument
+	} else if e.remaining > 0 {
+		return io.ErrShortWrite
+	}
+	return nil
 }
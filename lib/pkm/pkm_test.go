@@ -10,13 +10,20 @@ package pkm
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"image"
+	"image/color"
+	"image/draw"
 	"image/png"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"testing/iotest"
 
-	"github.com/nigeltao/etc2/internal/nie"
 	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/nie"
 )
 
 func TestDecode(tt *testing.T) {
@@ -91,6 +98,278 @@ func TestDecode(tt *testing.T) {
 	}
 }
 
+// fragmentingReader delivers its data in pieces of varying size (cycling
+// through sizes), including empty (0, nil) reads, like a network reader might.
+type fragmentingReader struct {
+	data  []byte
+	sizes []int
+	i     int
+}
+
+func (r *fragmentingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := min(len(p), len(r.data), r.sizes[r.i%len(r.sizes)])
+	r.i++
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// contextReader is a context-aware reader: it fails with ctx.Err() once ctx
+// is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// stuckReader always returns (0, nil).
+type stuckReader struct{}
+
+func (stuckReader) Read(p []byte) (int, error) {
+	return 0, nil
+}
+
+func TestDecodeHeader(tt *testing.T) {
+	testCases := []struct {
+		name   string
+		format etc2.Format
+		width  int
+		height int
+	}{
+		{"36.etc2-rg11s", etc2.FormatETC2RG11Signed, 16, 16},
+		{"49.etc2-srgba1", etc2.FormatETC2SRGBA1, 16, 16},
+		{"dice.80x60.etc2-rgba8", etc2.FormatETC2RGBA8, 80, 60},
+		// PKM has no ETC1S format byte.
+		{"mona-lisa.21x32.etc1s", etc2.FormatETC1, 21, 32},
+	}
+
+	for _, tc := range testCases {
+		srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + tc.name + ".pkm")
+		if err != nil {
+			tt.Errorf("tc=%q: os.ReadFile: %v", tc.name, err)
+			continue
+		}
+		// Only the header is needed.
+		f, config, err := DecodeHeader(bytes.NewReader(srcBytes[:HeaderSize]))
+		if err != nil {
+			tt.Errorf("tc=%q: DecodeHeader: %v", tc.name, err)
+		} else if (f != tc.format) || (config.Width != tc.width) || (config.Height != tc.height) {
+			tt.Errorf("tc=%q: got %v %d×%d, want %v %d×%d",
+				tc.name, f, config.Width, config.Height, tc.format, tc.width, tc.height)
+		} else if config.ColorModel != f.ColorModel() {
+			tt.Errorf("tc=%q: ColorModel differs", tc.name)
+		}
+	}
+
+	if _, _, err := DecodeHeader(bytes.NewReader(make([]byte, HeaderSize))); !errors.Is(err, ErrNotAPKMFile) {
+		tt.Errorf("not PKM: got %v, want %v", err, ErrNotAPKMFile)
+	}
+}
+
+func TestDecodeShortReads(tt *testing.T) {
+	const tc = "dice.80x60.etc2-rgba8"
+	srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + tc + ".pkm")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	want, err := Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("Decode: %v", err)
+	}
+	wantPix := want.(*image.NRGBA).Pix
+
+	readers := map[string]func() io.Reader{
+		"DataErrReader": func() io.Reader { return iotest.DataErrReader(bytes.NewReader(srcBytes)) },
+		"HalfReader":    func() io.Reader { return iotest.HalfReader(bytes.NewReader(srcBytes)) },
+		"OneByteReader": func() io.Reader { return iotest.OneByteReader(bytes.NewReader(srcBytes)) },
+		"fragmenting":   func() io.Reader { return &fragmentingReader{data: srcBytes, sizes: []int{3, 0, 17, 1, 0, 0, 250, 7}} },
+		"contextReader": func() io.Reader {
+			return &contextReader{context.Background(), iotest.HalfReader(bytes.NewReader(srcBytes))}
+		},
+		"MultiReader": func() io.Reader {
+			return io.MultiReader(bytes.NewReader(srcBytes[:5]), bytes.NewReader(srcBytes[5:1000]), bytes.NewReader(srcBytes[1000:]))
+		},
+	}
+	for name, newReader := range readers {
+		got, err := Decode(newReader())
+		if err != nil {
+			tt.Errorf("%s: Decode: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got.(*image.NRGBA).Pix, wantPix) {
+			tt.Errorf("%s: pixels differ", name)
+		}
+	}
+
+	// Truncated input, at any length (including within the header), is
+	// io.ErrUnexpectedEOF.
+	for n := 0; n < len(srcBytes); n += max(1, n/16) {
+		r := iotest.OneByteReader(bytes.NewReader(srcBytes[:n]))
+		if _, err := Decode(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+			tt.Fatalf("truncated to %d bytes: got %v, want %v", n, err, io.ErrUnexpectedEOF)
+		}
+	}
+
+	// Other errors, such as a cancelled context's, are passed through.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := io.MultiReader(bytes.NewReader(srcBytes[:HeaderSize+100]), &contextReader{ctx, bytes.NewReader(srcBytes[HeaderSize+100:])})
+	if _, err := Decode(r); err != context.Canceled {
+		tt.Errorf("cancelled: got %v, want %v", err, context.Canceled)
+	}
+	if _, err := Decode(iotest.TimeoutReader(bytes.NewReader(srcBytes))); err != iotest.ErrTimeout {
+		tt.Errorf("TimeoutReader: got %v, want %v", err, iotest.ErrTimeout)
+	}
+
+	// A reader that never makes progress is io.ErrNoProgress, not a hang.
+	stuck := io.MultiReader(bytes.NewReader(srcBytes[:HeaderSize]), stuckReader{})
+	if _, err := Decode(stuck); err != io.ErrNoProgress {
+		tt.Errorf("stuck: got %v, want %v", err, io.ErrNoProgress)
+	}
+}
+
+func TestDecodePartial(tt *testing.T) {
+	const tc = "dice.80x60.etc2-rgba8"
+	srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + tc + ".pkm")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	want, err := Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("Decode: %v", err)
+	}
+	wantM := want.(*image.NRGBA)
+	options := &DecodeOptions{ETC2Options: &etc2.DecodeOptions{Partial: true}}
+
+	// The payload is 300 blocks of 16 bytes, more than etc2's 4096 byte
+	// buffer, so some truncations are in its second fill.
+	for n := HeaderSize; n < len(srcBytes); n += 53 {
+		got, err := DecodeWithOptions(iotest.HalfReader(bytes.NewReader(srcBytes[:n])), options)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			tt.Fatalf("n=%d: got %v, want %v", n, err, io.ErrUnexpectedEOF)
+		} else if got == nil {
+			tt.Fatalf("n=%d: got nil image", n)
+		}
+		gotM := got.(*image.NRGBA)
+		numCompleteBlocks := (n - HeaderSize) / 16
+		for y := range 60 {
+			for x := range 80 {
+				wantC := color.NRGBA{}
+				if ((20 * (y / 4)) + (x / 4)) < numCompleteBlocks {
+					wantC = wantM.NRGBAAt(x, y)
+				}
+				if gotC := gotM.NRGBAAt(x, y); gotC != wantC {
+					tt.Fatalf("n=%d: (%d, %d): got %v, want %v", n, x, y, gotC, wantC)
+				}
+			}
+		}
+	}
+
+	// Without the option, truncation still returns no image.
+	if got, err := Decode(bytes.NewReader(srcBytes[:1000])); (got != nil) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		tt.Fatalf("non-partial: got %T, %v, want nil, %v", got, err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDecodeErrors(tt *testing.T) {
+	const tc = "dice.80x60.etc2-rgba8"
+	srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + tc + ".pkm")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+
+	// Malformed headers give a *HeaderError, identifying the bad field.
+	headerTestCases := []struct {
+		i          int
+		b          byte
+		wantOffset int64
+	}{
+		{0, 'Q', 0},
+		{4, '3', 4},
+		{5, '1', 5},
+		{6, 0x01, 6},
+		{7, 0x00, 7}, // An ETC1 format in an ETC2 ("20") file.
+		{9, 0x53, 8},
+		{11, 0x3D, 10},
+	}
+	for _, htc := range headerTestCases {
+		data := bytes.Clone(srcBytes)
+		data[htc.i] = htc.b
+		_, err := Decode(bytes.NewReader(data))
+		headerErr := (*HeaderError)(nil)
+		if !errors.As(err, &headerErr) {
+			tt.Errorf("i=%d: got %v, want a *HeaderError", htc.i, err)
+		} else if !errors.Is(err, ErrNotAPKMFile) || (headerErr.Offset != htc.wantOffset) {
+			tt.Errorf("i=%d: got %v at %d, want %v at %d", htc.i, headerErr.Err, headerErr.Offset, ErrNotAPKMFile, htc.wantOffset)
+		}
+	}
+	if _, err := DecodeBytes(srcBytes[:10]); !errors.As(err, new(*HeaderError)) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		tt.Errorf("short header: got %v, want a *HeaderError wrapping %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// Truncated payloads give an *etc2.TruncatedError, identifying the first
+	// incomplete block. There are 16 bytes per block.
+	for _, n := range []int{HeaderSize, HeaderSize + 1000, len(srcBytes) - 1} {
+		wantBlockIndex := (n - HeaderSize) / 16
+		wantOffset := int64(16 * wantBlockIndex)
+		for _, decode := range []func([]byte) error{
+			func(b []byte) error { _, err := Decode(bytes.NewReader(b)); return err },
+			func(b []byte) error { _, err := DecodeBytes(b); return err },
+		} {
+			err := decode(srcBytes[:n])
+			truncatedErr := (*etc2.TruncatedError)(nil)
+			if !errors.As(err, &truncatedErr) {
+				tt.Errorf("n=%d: got %v, want an *etc2.TruncatedError", n, err)
+			} else if (truncatedErr.BlockIndex != wantBlockIndex) || (truncatedErr.Offset != wantOffset) {
+				tt.Errorf("n=%d: got block %d at %d, want block %d at %d",
+					n, truncatedErr.BlockIndex, truncatedErr.Offset, wantBlockIndex, wantOffset)
+			}
+		}
+	}
+}
+
+func TestDecodePadded(tt *testing.T) {
+	const tc = "mona-lisa.21x32.etc2-rgb"
+	srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + tc + ".pkm")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	want, err := Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("Decode: %v", err)
+	}
+	got, err := DecodeWithOptions(bytes.NewReader(srcBytes), &DecodeOptions{Padded: true})
+	if err != nil {
+		tt.Fatalf("DecodeWithOptions: %v", err)
+	}
+
+	gotM, ok := got.(*image.RGBA)
+	if !ok {
+		tt.Fatalf("got %T, want *image.RGBA", got)
+	} else if gotB, wantB := gotM.Bounds(), image.Rect(0, 0, 24, 32); gotB != wantB {
+		tt.Fatalf("Bounds: got %v, want %v", gotB, wantB)
+	} else if (gotM.Stride != (4 * 24)) || (len(gotM.Pix) != (4 * 24 * 32)) {
+		tt.Fatalf("Stride, len(Pix): got %d, %d", gotM.Stride, len(gotM.Pix))
+	}
+	wantM := want.(*image.RGBA)
+	for y := range 32 {
+		for x := range 21 {
+			if gotC, wantC := gotM.RGBAAt(x, y), wantM.RGBAAt(x, y); gotC != wantC {
+				tt.Fatalf("(%d, %d): got %v, want %v", x, y, gotC, wantC)
+			}
+		}
+	}
+}
+
 func TestEncode(tt *testing.T) {
 	testCases := []struct {
 		filename string
@@ -124,7 +403,7 @@ func TestEncode(tt *testing.T) {
 	cachedSrcImages := map[string]image.Image{}
 
 	for _, tc := range testCases {
-		tcString := tc.filename + "." + formatString(tc.format)
+		tcString := tc.filename + "." + tc.format.String()
 
 		srcImage := cachedSrcImages[tc.filename]
 		if srcImage == nil {
@@ -143,9 +422,13 @@ func TestEncode(tt *testing.T) {
 			cachedSrcImages[tc.filename] = srcImage
 		}
 
+		// The golden files were produced by the ETCPACK program.
 		buf := &bytes.Buffer{}
 		options := &EncodeOptions{
 			Format: tc.format,
+			ETC2Options: &etc2.EncodeOptions{
+				CompatETCPACK: true,
+			},
 		}
 		if err := Encode(buf, srcImage, options); err != nil {
 			tt.Errorf("tc=%q: Encode: %v", tcString, err)
@@ -179,36 +462,344 @@ func TestEncode(tt *testing.T) {
 	}
 }
 
-func formatString(f etc2.Format) string {
-	switch f {
-	case etc2.FormatETC1:
-		return "etc1"
-	case etc2.FormatETC1S:
-		return "etc1s"
+func TestEncodePreview(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/mona-lisa.21x32.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
 
-	case etc2.FormatETC2RGB:
-		return "etc2-rgb"
-	case etc2.FormatETC2RGBA8:
-		return "etc2-rgba8"
-	case etc2.FormatETC2RGBA1:
-		return "etc2-rgba1"
+	formats := []etc2.Format{
+		etc2.FormatETC1,
+		etc2.FormatETC2RGB,
+		etc2.FormatETC2RGBA1,
+		etc2.FormatETC2SRGBA8,
+		etc2.FormatETC2R11Signed,
+		etc2.FormatETC2RG11Unsigned,
+	}
+	for _, f := range formats {
+		for _, numWorkers := range []int{1, 4} {
+			preview, err := f.NewImage(21, 32)
+			if err != nil {
+				tt.Fatalf("f=%v: NewImage: %v", f, err)
+			}
+			// Encoding to a seekable *os.File lets numWorkers > 1 encode
+			// rows of blocks concurrently.
+			encoded, err := os.CreateTemp(tt.TempDir(), "preview.pkm")
+			if err != nil {
+				tt.Fatalf("os.CreateTemp: %v", err)
+			}
+			defer encoded.Close()
+			if err := Encode(encoded, srcImage, &EncodeOptions{
+				Format: f,
+				ETC2Options: &etc2.EncodeOptions{
+					Preview:    preview.(draw.Image),
+					NumWorkers: numWorkers,
+				},
+			}); err != nil {
+				tt.Fatalf("f=%v: Encode: %v", f, err)
+			}
+			decoded, err := Decode(io.NewSectionReader(encoded, 0, 1<<20))
+			if err != nil {
+				tt.Fatalf("f=%v: Decode: %v", f, err)
+			}
+			for y := range 32 {
+				for x := range 21 {
+					if got, want := preview.At(x, y), decoded.At(x, y); got != want {
+						tt.Fatalf("f=%v, numWorkers=%d: (%d, %d): got %v, want %v",
+							f, numWorkers, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
 
-	case etc2.FormatETC2SRGB:
-		return "etc2-srgb"
-	case etc2.FormatETC2SRGBA8:
-		return "etc2-srgba8"
-	case etc2.FormatETC2SRGBA1:
-		return "etc2-srgba1"
+func TestEncodeAppendModeFile(tt *testing.T) {
+	srcBytes, err := os.ReadFile("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	srcImage, err := png.Decode(bytes.NewReader(srcBytes))
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+	options := &EncodeOptions{
+		Format:      etc2.FormatETC2RGBA8,
+		ETC2Options: &etc2.EncodeOptions{NumWorkers: 8},
+	}
+	want := &bytes.Buffer{}
+	if err := Encode(want, srcImage, options); err != nil {
+		tt.Fatalf("Encode(bytes.Buffer): %v", err)
+	}
 
-	case etc2.FormatETC2R11Unsigned:
-		return "etc2-r11u"
-	case etc2.FormatETC2RG11Unsigned:
-		return "etc2-rg11u"
-	case etc2.FormatETC2R11Signed:
-		return "etc2-r11s"
-	case etc2.FormatETC2RG11Signed:
-		return "etc2-rg11s"
+	// An *os.File opened with O_APPEND is an io.WriterAt and an io.Seeker,
+	// but its WriteAt method always fails, so the encoder must fall back to
+	// sequential writes, appending after the existing contents.
+	const prefix = "existing contents\n"
+	filename := filepath.Join(tt.TempDir(), "append.pkm")
+	if err := os.WriteFile(filename, []byte(prefix), 0o644); err != nil {
+		tt.Fatalf("os.WriteFile: %v", err)
 	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		tt.Fatalf("os.OpenFile: %v", err)
+	}
+	err = Encode(file, srcImage, options)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		tt.Fatalf("Encode(O_APPEND file): %v", err)
+	}
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	} else if !bytes.Equal(got, append([]byte(prefix), want.Bytes()...)) {
+		tt.Fatalf("got %d bytes, want %d bytes", len(got), len(prefix)+want.Len())
+	}
+}
+
+// sameImage returns whether a and b have the same bounds and pixels.
+func sameImage(a image.Image, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.NRGBA64Model.Convert(a.At(x, y)) != color.NRGBA64Model.Convert(b.At(x, y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestDecodeAlpha(tt *testing.T) {
+	for _, tc := range []string{"49.etc2-rgba8", "49.etc2-srgba8", "dice.80x60.etc2-rgba8"} {
+		srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + tc + ".pkm")
+		if err != nil {
+			tt.Fatalf("tc=%q: os.ReadFile: %v", tc, err)
+		}
+		full, err := Decode(bytes.NewReader(srcBytes))
+		if err != nil {
+			tt.Fatalf("tc=%q: Decode: %v", tc, err)
+		}
+		alpha, err := DecodeAlpha(bytes.NewReader(srcBytes))
+		if err != nil {
+			tt.Fatalf("tc=%q: DecodeAlpha: %v", tc, err)
+		}
+		b := full.Bounds()
+		if got := alpha.Bounds(); got != b {
+			tt.Fatalf("tc=%q: bounds: got %v, want %v", tc, got, b)
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if got, want := alpha.AlphaAt(x, y).A, full.(*image.NRGBA).NRGBAAt(x, y).A; got != want {
+					tt.Fatalf("tc=%q: (%d, %d): got 0x%02X, want 0x%02X", tc, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/49.etc2-rgb.pkm")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+	if _, err := DecodeAlpha(bytes.NewReader(srcBytes)); err != etc2.ErrBadArgument {
+		tt.Fatalf("etc2-rgb: got %v, want %v", err, etc2.ErrBadArgument)
+	}
+}
+
+func TestDecodeColorOnly(tt *testing.T) {
+	for _, tc := range []string{"49.etc2-rgba8", "dice.80x60.etc2-rgba8"} {
+		srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + tc + ".pkm")
+		if err != nil {
+			tt.Fatalf("tc=%q: os.ReadFile: %v", tc, err)
+		}
+		full, err := Decode(bytes.NewReader(srcBytes))
+		if err != nil {
+			tt.Fatalf("tc=%q: Decode: %v", tc, err)
+		}
+		b := full.Bounds()
+
+		// The ColorOnly option can be set at the pkm or etc2 level, and
+		// DecodeAtWithOptions also takes it. Below, a nil options means to
+		// check the DecodeAtWithOptions result.
+		atDst := image.NewRGBA(b)
+		if _, err := DecodeAtWithOptions(atDst, image.Point{}, bytes.NewReader(srcBytes), &DecodeOptions{
+			ETC2Options: &etc2.DecodeOptions{ColorOnly: true},
+		}); err != nil {
+			tt.Fatalf("tc=%q: DecodeAtWithOptions: %v", tc, err)
+		}
+		for i, options := range []*DecodeOptions{
+			{ColorOnly: true},
+			{ETC2Options: &etc2.DecodeOptions{ColorOnly: true}},
+			nil,
+		} {
+			colorOnly := image.Image(atDst)
+			if options != nil {
+				colorOnly, err = DecodeWithOptions(bytes.NewReader(srcBytes), options)
+				if err != nil {
+					tt.Fatalf("tc=%q, i=%d: DecodeWithOptions: %v", tc, i, err)
+				}
+			}
+			m, ok := colorOnly.(*image.RGBA)
+			if !ok {
+				tt.Fatalf("tc=%q, i=%d: got %T, want *image.RGBA", tc, i, colorOnly)
+			}
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					want := full.(*image.NRGBA).NRGBAAt(x, y)
+					want.A = 0xFF
+					if got := m.RGBAAt(x, y); (got.R != want.R) || (got.G != want.G) || (got.B != want.B) || (got.A != want.A) {
+						tt.Fatalf("tc=%q, i=%d: (%d, %d): got %v, want %v", tc, i, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeBytes(tt *testing.T) {
+	for _, tc := range []string{"36.etc2-rg11s", "49.etc2-rgba1", "dice.80x60.etc2-rgba8", "mona-lisa.21x32.etc1"} {
+		srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + tc + ".pkm")
+		if err != nil {
+			tt.Fatalf("tc=%q: os.ReadFile: %v", tc, err)
+		}
+		want, err := Decode(bytes.NewReader(srcBytes))
+		if err != nil {
+			tt.Fatalf("tc=%q: Decode: %v", tc, err)
+		}
+		got, err := DecodeBytes(srcBytes)
+		if err != nil {
+			tt.Fatalf("tc=%q: DecodeBytes: %v", tc, err)
+		} else if !sameImage(got, want) {
+			tt.Fatalf("tc=%q: DecodeBytes and Decode differ", tc)
+		}
+
+		// Truncate the payload in the middle of a block.
+		truncated := srcBytes[:HeaderSize+19]
+		if _, err := DecodeBytes(truncated); !errors.Is(err, io.ErrUnexpectedEOF) {
+			tt.Fatalf("tc=%q: truncated: got %v, want %v", tc, err, io.ErrUnexpectedEOF)
+		}
+		options := &DecodeOptions{ETC2Options: &etc2.DecodeOptions{Partial: true}}
+		want, wantErr := DecodeWithOptions(bytes.NewReader(truncated), options)
+		got, gotErr := DecodeBytesWithOptions(truncated, options)
+		if !errors.Is(gotErr, io.ErrUnexpectedEOF) || !errors.Is(wantErr, io.ErrUnexpectedEOF) {
+			tt.Fatalf("tc=%q: partial: got %v and %v, want %v", tc, gotErr, wantErr, io.ErrUnexpectedEOF)
+		} else if !sameImage(got, want) {
+			tt.Fatalf("tc=%q: partial: DecodeBytesWithOptions and DecodeWithOptions differ", tc)
+		}
+	}
+
+	if _, err := DecodeBytes([]byte("PKM 20")); !errors.Is(err, io.ErrUnexpectedEOF) {
+		tt.Fatalf("short header: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDecodeAt(tt *testing.T) {
+	testCases := []string{
+		"mona-lisa.21x32.etc1",
+		"mona-lisa.21x32.etc2-rgb",
+	}
+
+	atlas := image.NewRGBA(image.Rect(0, 0, 21*len(testCases), 32))
+	for i, tc := range testCases {
+		srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + tc + ".pkm")
+		if err != nil {
+			tt.Fatalf("tc=%q: os.ReadFile(pkm): %v", tc, err)
+		}
+		if _, err := DecodeAt(atlas, image.Point{X: 21 * i, Y: 0}, bytes.NewReader(srcBytes)); err != nil {
+			tt.Fatalf("tc=%q: DecodeAt: %v", tc, err)
+		}
+	}
+
+	for i, tc := range testCases {
+		srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/" + tc + ".pkm")
+		if err != nil {
+			tt.Fatalf("tc=%q: os.ReadFile(pkm): %v", tc, err)
+		}
+		want, err := Decode(bytes.NewReader(srcBytes))
+		if err != nil {
+			tt.Fatalf("tc=%q: Decode: %v", tc, err)
+		}
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 21; x++ {
+				if got, want := atlas.At((21*i)+x, y), want.At(x, y); got != want {
+					tt.Fatalf("tc=%q: pixel (%d, %d): got %v, want %v", tc, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestEncoder(tt *testing.T) {
+	want, err := os.ReadFile("../../res/1-encoded-pkm/mona-lisa.21x32.etc2-rgb.pkm")
+	if err != nil {
+		tt.Fatalf("os.ReadFile: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	if err := enc.WriteHeader(etc2.FormatETC2RGB, 21, 32); err != nil {
+		tt.Fatalf("WriteHeader: %v", err)
+	}
+	// Write the payload in pieces that don't align with block boundaries.
+	for payload := want[HeaderSize:]; len(payload) > 0; {
+		n := min(len(payload), 13)
+		if _, err := enc.Write(payload[:n]); err != nil {
+			tt.Fatalf("Write: %v", err)
+		}
+		payload = payload[n:]
+		if len(payload) == 0 {
+			break
+		} else if err := enc.Close(); err != io.ErrShortWrite {
+			tt.Fatalf("early Close: got %v, want %v", err, io.ErrShortWrite)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		tt.Fatalf("Close: %v", err)
+	}
+	if _, err := enc.Write([]byte{0x00}); err != ErrBadArgument {
+		tt.Fatalf("Write past end: got %v, want %v", err, ErrBadArgument)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		tt.Fatalf("got %d bytes, want %d bytes", len(got), len(want))
+	}
+}
+
+func benchmarkDecode(b *testing.B, options *DecodeOptions, reset func()) {
+	srcBytes, err := os.ReadFile("../../res/1-encoded-pkm/mona-lisa.21x32.etc2-rgb.pkm")
+	if err != nil {
+		b.Fatalf("os.ReadFile: %v", err)
+	}
+	r := bytes.NewReader(srcBytes)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	// Decode many small images, as a server decoding icons would.
+	for range b.N {
+		r.Reset(srcBytes)
+		if _, err := DecodeWithOptions(r, options); err != nil {
+			b.Fatalf("DecodeWithOptions: %v", err)
+		}
+		if reset != nil {
+			reset()
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	benchmarkDecode(b, nil, nil)
+}
 
-	return "invalid"
+func BenchmarkDecodeArena(b *testing.B) {
+	arena := etc2.NewArena(64 << 10)
+	benchmarkDecode(b, &DecodeOptions{AllocPix: arena.Alloc}, arena.Reset)
 }
@@ -0,0 +1,196 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// ----------------
+
+// Package supercompress provides general purpose (lossless) compressors that
+// can be applied on top of an ETC-compressed payload, such as KTX2's
+// supercompression schemes.
+//
+// Each Supercompressor has a numeric ID, as stored in a container's header.
+// The built-in IDs match KTX2's supercompressionScheme values. Users can
+// Register other (such as proprietary or experimental) codecs under other
+// IDs without changing any container code.
+//
+// IDNone, IDZstandard and IDZLIB are built in.
+package supercompress
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	ErrBadArgument   = errors.New("supercompress: bad argument")
+	ErrDuplicateID   = errors.New("supercompress: duplicate ID")
+	ErrSizeMismatch  = errors.New("supercompress: size mismatch")
+	ErrUnsupportedID = errors.New("supercompress: unsupported ID")
+)
+
+// These IDs are KTX2's supercompressionScheme values.
+const (
+	IDNone      = 0
+	IDBasisLZ   = 1
+	IDZstandard = 2
+	IDZLIB      = 3
+)
+
+// Supercompressor compresses and decompresses whole payloads.
+//
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Supercompressor interface {
+	// ID returns the numeric ID stored in a container's header.
+	ID() uint32
+
+	// Name returns a short, human-readable name, such as "zlib".
+	Name() string
+
+	// Compress returns the compressed form of src.
+	Compress(src []byte) ([]byte, error)
+
+	// Decompress returns the decompressed form of src. The caller passes the
+	// decompressed length, which containers like KTX2 record out of band.
+	// Implementations should return ErrSizeMismatch if the actual length
+	// differs.
+	Decompress(src []byte, decompressedLength int) ([]byte, error)
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[uint32]Supercompressor{
+		IDNone:      none{},
+		IDZstandard: zstdSupercompressor{},
+		IDZLIB:      zlibSupercompressor{},
+	}
+)
+
+// Register adds s to the set of Supercompressors that Lookup can return. It
+// returns ErrDuplicateID if another Supercompressor, including a built-in
+// one, already has the same ID.
+func Register(s Supercompressor) error {
+	if s == nil {
+		return ErrBadArgument
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registry[s.ID()]; ok {
+		return ErrDuplicateID
+	}
+	registry[s.ID()] = s
+	return nil
+}
+
+// Lookup returns the registered Supercompressor with the given ID.
+func Lookup(id uint32) (Supercompressor, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	if s, ok := registry[id]; ok {
+		return s, nil
+	}
+	return nil, ErrUnsupportedID
+}
+
+// none is the identity Supercompressor.
+type none struct{}
+
+func (none) ID() uint32   { return IDNone }
+func (none) Name() string { return "none" }
+
+func (none) Compress(src []byte) ([]byte, error) {
+	return bytes.Clone(src), nil
+}
+
+func (none) Decompress(src []byte, decompressedLength int) ([]byte, error) {
+	if len(src) != decompressedLength {
+		return nil, ErrSizeMismatch
+	}
+	return bytes.Clone(src), nil
+}
+
+// zlibSupercompressor uses the compress/zlib package.
+type zlibSupercompressor struct{}
+
+func (zlibSupercompressor) ID() uint32   { return IDZLIB }
+func (zlibSupercompressor) Name() string { return "zlib" }
+
+func (zlibSupercompressor) Compress(src []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := zlib.NewWriterLevel(buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	} else if _, err := w.Write(src); err != nil {
+		return nil, err
+	} else if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (zlibSupercompressor) Decompress(src []byte, decompressedLength int) ([]byte, error) {
+	if decompressedLength < 0 {
+		return nil, ErrBadArgument
+	}
+	r, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Read one byte more than expected, to detect too-long data.
+	dst := make([]byte, decompressedLength+1)
+	n, err := io.ReadFull(r, dst)
+	if (err != io.ErrUnexpectedEOF) && (err != nil) {
+		return nil, err
+	} else if n != decompressedLength {
+		return nil, ErrSizeMismatch
+	}
+	return dst[:n], nil
+}
+
+// zstdSupercompressor uses the github.com/klauspost/compress/zstd package.
+type zstdSupercompressor struct{}
+
+func (zstdSupercompressor) ID() uint32   { return IDZstandard }
+func (zstdSupercompressor) Name() string { return "zstd" }
+
+func (zstdSupercompressor) Compress(src []byte) ([]byte, error) {
+	w, err := zstd.NewWriter(nil,
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	return w.EncodeAll(src, nil), nil
+}
+
+func (zstdSupercompressor) Decompress(src []byte, decompressedLength int) ([]byte, error) {
+	if decompressedLength < 0 {
+		return nil, ErrBadArgument
+	}
+	r, err := zstd.NewReader(bytes.NewReader(src), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Read one byte more than expected, to detect too-long data.
+	dst := make([]byte, decompressedLength+1)
+	n, err := io.ReadFull(r, dst)
+	if (err != io.ErrUnexpectedEOF) && (err != nil) {
+		return nil, err
+	} else if n != decompressedLength {
+		return nil, ErrSizeMismatch
+	}
+	return dst[:n], nil
+}
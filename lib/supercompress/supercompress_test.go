@@ -0,0 +1,68 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package supercompress
+
+import (
+	"bytes"
+	"testing"
+)
+
+// reverse is a toy Supercompressor that reverses its input.
+type reverse struct{}
+
+func (reverse) ID() uint32   { return 0x1_0000 }
+func (reverse) Name() string { return "reverse" }
+
+func (reverse) Compress(src []byte) ([]byte, error) {
+	dst := bytes.Clone(src)
+	for i, j := 0, len(dst)-1; i < j; i, j = i+1, j-1 {
+		dst[i], dst[j] = dst[j], dst[i]
+	}
+	return dst, nil
+}
+
+func (r reverse) Decompress(src []byte, decompressedLength int) ([]byte, error) {
+	if len(src) != decompressedLength {
+		return nil, ErrSizeMismatch
+	}
+	return r.Compress(src)
+}
+
+func TestRoundTrip(tt *testing.T) {
+	if err := Register(reverse{}); err != nil {
+		tt.Fatalf("Register: %v", err)
+	} else if err := Register(reverse{}); err != ErrDuplicateID {
+		tt.Fatalf("Register again: got %v, want %v", err, ErrDuplicateID)
+	}
+
+	src := bytes.Repeat([]byte("etc2 payload "), 100)
+	for _, id := range []uint32{IDNone, IDZstandard, IDZLIB, 0x1_0000} {
+		s, err := Lookup(id)
+		if err != nil {
+			tt.Fatalf("id=%d: Lookup: %v", id, err)
+		}
+		compressed, err := s.Compress(src)
+		if err != nil {
+			tt.Fatalf("%s: Compress: %v", s.Name(), err)
+		}
+		got, err := s.Decompress(compressed, len(src))
+		if err != nil {
+			tt.Fatalf("%s: Decompress: %v", s.Name(), err)
+		} else if !bytes.Equal(got, src) {
+			tt.Fatalf("%s: round trip mismatch", s.Name())
+		}
+		if _, err := s.Decompress(compressed, len(src)-1); err != ErrSizeMismatch {
+			tt.Fatalf("%s: wrong length: got %v, want %v", s.Name(), err, ErrSizeMismatch)
+		}
+	}
+
+	if _, err := Lookup(IDBasisLZ); err != ErrUnsupportedID {
+		tt.Fatalf("Lookup(IDBasisLZ): got %v, want %v", err, ErrUnsupportedID)
+	}
+}
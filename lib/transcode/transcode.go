@@ -0,0 +1,242 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// ----------------
+
+// Package transcode converts ETC-compressed payloads to other GPU texture
+// formats, block by block, without a full decode and re-encode.
+//
+// This lets one encoded asset target both OpenGL ES (ETC) and desktop (BC,
+// also known as S3TC or DXT) GPUs, similar to what Basis Universal does.
+package transcode
+
+import (
+	"errors"
+)
+
+var (
+	ErrBadArgument    = errors.New("transcode: bad argument")
+	ErrNotAnETC1Block = errors.New("transcode: not an ETC1 block")
+)
+
+// ETC1SToBC1 converts a raw (container-less) ETC1S payload in src to BC1 (also
+// known as DXT1), writing the result to dst. Both payloads consist of 8-byte
+// blocks, in the same order, so dst and src must have the same length and
+// that length must be a multiple of 8.
+//
+// Any ETC1 payload is accepted, but ETC1S (whose two half-blocks share a base
+// color, so that all of a block's colors lie on one line) transcodes best. It
+// returns ErrNotAnETC1Block if a block uses an ETC2-only mode.
+func ETC1SToBC1(dst []byte, src []byte) error {
+	if (len(dst) != len(src)) || ((len(src) & 7) != 0) {
+		return ErrBadArgument
+	}
+	for ; len(src) > 0; dst, src = dst[8:], src[8:] {
+		code := uint64(0)
+		for _, x := range src[:8] {
+			code = (code << 8) | uint64(x)
+		}
+		bc1, ok := etc1ToBC1(code)
+		if !ok {
+			return ErrNotAnETC1Block
+		}
+		for i := range 8 {
+			dst[i] = uint8(bc1 >> (8 * i))
+		}
+	}
+	return nil
+}
+
+// etc1ToBC1 converts one ETC1 block code (read as a big-endian uint64) to a
+// BC1 block code (to be written as a little-endian uint64).
+//
+// Instead of decoding the 16 pixels, it works on the (at most 8) colors in the
+// ETC1 block's two 4-color palettes. It starts with the two furthest apart
+// colors that are actually used as the BC1 endpoints, maps each ETC1 palette
+// entry (and therefore each pixel's ETC1 index) to its nearest BC1 color and
+// then refines the endpoints.
+func etc1ToBC1(code uint64) (bc1 uint64, ok bool) {
+	base := [2][3]int32{}
+	if (code & 0x2_0000_0000) != 0 {
+		for c := range 3 {
+			shift := 0x3B - (8 * c)
+			b0 := int32(0x1F & (code >> shift))
+			b1 := b0 + etc1Diffs[7&(code>>(shift-3))]
+			if (b1 < 0) || (b1 > 0x1F) {
+				return 0, false
+			}
+			base[0][c] = (b0 << 3) | (b0 >> 2)
+			base[1][c] = (b1 << 3) | (b1 >> 2)
+		}
+	} else {
+		for c := range 3 {
+			shift := 0x3C - (8 * c)
+			b0 := int32(0x0F & (code >> shift))
+			b1 := int32(0x0F & (code >> (shift - 4)))
+			base[0][c] = (b0 << 4) | b0
+			base[1][c] = (b1 << 4) | b1
+		}
+	}
+	tables := [2]uint64{(code >> 0x25) & 7, (code >> 0x22) & 7}
+	flip := (code & 0x1_0000_0000) != 0
+
+	// palette[(4 * half) + index] is the ETC1 color for that half-block and
+	// 2-bit pixel index. used is a bit-set of which palette entries are used.
+	palette := [8][3]int32{}
+	for half := range 2 {
+		for index := range 4 {
+			m := etc1Modifiers[tables[half]][index]
+			for c := range 3 {
+				palette[(4*half)+index][c] = max(0, min(255, base[half][c]+m))
+			}
+		}
+	}
+	pixelEntries := [16]uint8{}
+	used := uint8(0)
+	for y := range 4 {
+		for x := range 4 {
+			half := x >> 1
+			if flip {
+				half = y >> 1
+			}
+			x4y := (x * 4) | y
+			index := ((code >> x4y) & 1) | ((code >> (x4y + 15)) & 2)
+			entry := uint8((4 * half) + int(index))
+			pixelEntries[(4*y)+x] = entry
+			used |= 1 << entry
+		}
+	}
+
+	// Pick the endpoints.
+	e0, e1, bestDist := 0, 0, int32(-1)
+	for i := range 8 {
+		if (used & (1 << i)) == 0 {
+			continue
+		}
+		for j := i; j < 8; j++ {
+			if (used & (1 << j)) == 0 {
+				continue
+			} else if d := distSquared(&palette[i], &palette[j]); bestDist < d {
+				e0, e1, bestDist = i, j, d
+			}
+		}
+	}
+	bc1, pixelError := makeBC1(&palette, &pixelEntries, used, palette[e0], palette[e1])
+
+	// Refine the endpoints with a least squares fit: find the a and b that
+	// minimize the sum, over all pixels p, of |((1-t)*a + t*b) - p|² where t
+	// is 0, 1, 1/3 or 2/3 depending on p's BC1 index.
+	aa, ab, bb := 0.0, 0.0, 0.0
+	ap, bp := [3]float64{}, [3]float64{}
+	for i, entry := range pixelEntries {
+		t := indexToT[(bc1>>(32+(2*i)))&3]
+		aa += (1 - t) * (1 - t)
+		ab += (1 - t) * t
+		bb += t * t
+		for c := range 3 {
+			ap[c] += (1 - t) * float64(palette[entry][c])
+			bp[c] += t * float64(palette[entry][c])
+		}
+	}
+	if det := (aa * bb) - (ab * ab); det != 0 {
+		a, b := [3]int32{}, [3]int32{}
+		for c := range 3 {
+			a[c] = int32(max(0, min(255, (((bb*ap[c])-(ab*bp[c]))/det)+0.5)))
+			b[c] = int32(max(0, min(255, (((aa*bp[c])-(ab*ap[c]))/det)+0.5)))
+		}
+		if bc1b, pixelErrorB := makeBC1(&palette, &pixelEntries, used, a, b); pixelError > pixelErrorB {
+			bc1 = bc1b
+		}
+	}
+	return bc1, true
+}
+
+// indexToT maps a BC1 index (in 4-color mode) to how far its color is from the
+// first endpoint towards the second.
+var indexToT = [4]float64{0, 1, 1.0 / 3, 2.0 / 3}
+
+// makeBC1 returns the BC1 block code with endpoints (approximately) ep0 and
+// ep1 that best matches the ETC1 pixels, along with its total squared error.
+func makeBC1(palette *[8][3]int32, pixelEntries *[16]uint8, used uint8, ep0 [3]int32, ep1 [3]int32) (bc1 uint64, pixelError int32) {
+	c0, c1 := toRGB565(&ep0), toRGB565(&ep1)
+	if c0 < c1 {
+		c0, c1 = c1, c0
+	}
+	bc1 = uint64(c0) | (uint64(c1) << 16)
+
+	// Build the BC1 palette and map each used ETC1 palette entry to its
+	// nearest BC1 index. If c0 == c1 then only index 0 (meaning c0) is used.
+	bcPalette := [4][3]int32{fromRGB565(c0), fromRGB565(c1)}
+	for c := range 3 {
+		bcPalette[2][c] = ((2 * bcPalette[0][c]) + bcPalette[1][c] + 1) / 3
+		bcPalette[3][c] = (bcPalette[0][c] + (2 * bcPalette[1][c]) + 1) / 3
+	}
+	numIndexes := 4
+	if c0 == c1 {
+		numIndexes = 1
+	}
+	entryToIndex, entryToError := [8]uint64{}, [8]int32{}
+	for i := range 8 {
+		if (used & (1 << i)) == 0 {
+			continue
+		}
+		bestIndex, bestDist := 0, int32(-1)
+		for j := range numIndexes {
+			if d := distSquared(&palette[i], &bcPalette[j]); (bestDist < 0) || (bestDist > d) {
+				bestIndex, bestDist = j, d
+			}
+		}
+		entryToIndex[i], entryToError[i] = uint64(bestIndex), bestDist
+	}
+
+	for i, entry := range pixelEntries {
+		bc1 |= entryToIndex[entry] << (32 + (2 * i))
+		pixelError += entryToError[entry]
+	}
+	return bc1, pixelError
+}
+
+func distSquared(a *[3]int32, b *[3]int32) int32 {
+	d0 := a[0] - b[0]
+	d1 := a[1] - b[1]
+	d2 := a[2] - b[2]
+	return (d0 * d0) + (d1 * d1) + (d2 * d2)
+}
+
+func toRGB565(rgb *[3]int32) uint16 {
+	r := ((rgb[0] * 31) + 127) / 255
+	g := ((rgb[1] * 63) + 127) / 255
+	b := ((rgb[2] * 31) + 127) / 255
+	return uint16((r << 11) | (g << 5) | b)
+}
+
+func fromRGB565(c uint16) [3]int32 {
+	r := int32(c>>11) & 0x1F
+	g := int32(c>>5) & 0x3F
+	b := int32(c>>0) & 0x1F
+	return [3]int32{
+		(r << 3) | (r >> 2),
+		(g << 2) | (g >> 4),
+		(b << 3) | (b >> 2),
+	}
+}
+
+var etc1Diffs = [8]int32{0, +1, +2, +3, -4, -3, -2, -1}
+
+// etc1Modifiers are the ETC1 intensity modifier tables, indexed by the 3-bit
+// table codeword and then the 2-bit pixel index.
+var etc1Modifiers = [8][4]int32{
+	{+2, +8, -2, -8},
+	{+5, +17, -5, -17},
+	{+9, +29, -9, -29},
+	{+13, +42, -13, -42},
+	{+18, +60, -18, -60},
+	{+24, +80, -24, -80},
+	{+33, +106, -33, -106},
+	{+47, +183, -47, -183},
+}
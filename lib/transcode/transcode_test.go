@@ -0,0 +1,210 @@
+// Copyright 2025 The Etc2 Authors.
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// https://www.apache.org/licenses/LICENSE-2.0>. This file may not be copied,
+// modified, or distributed except according to those terms.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transcode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/nigeltao/etc2/lib/etc2"
+	"github.com/nigeltao/etc2/lib/metrics"
+)
+
+// decodeBC1 decodes a BC1 payload, assuming 4-color mode.
+func decodeBC1(src []byte, width int, height int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, width, height))
+	for by := 0; by < height; by += 4 {
+		for bx := 0; bx < width; bx += 4 {
+			c0 := uint16(src[0]) | (uint16(src[1]) << 8)
+			c1 := uint16(src[2]) | (uint16(src[3]) << 8)
+			indexes := uint32(src[4]) | (uint32(src[5]) << 8) | (uint32(src[6]) << 16) | (uint32(src[7]) << 24)
+			src = src[8:]
+
+			palette := [4][3]int32{fromRGB565(c0), fromRGB565(c1)}
+			for c := range 3 {
+				palette[2][c] = ((2 * palette[0][c]) + palette[1][c] + 1) / 3
+				palette[3][c] = (palette[0][c] + (2 * palette[1][c]) + 1) / 3
+			}
+			for i := range 16 {
+				x, y := bx+(i&3), by+(i>>2)
+				if (x >= width) || (y >= height) {
+					continue
+				}
+				p := &palette[(indexes>>(2*i))&3]
+				m.SetRGBA(x, y, color.RGBA{uint8(p[0]), uint8(p[1]), uint8(p[2]), 0xFF})
+			}
+		}
+	}
+	return m
+}
+
+// encodeBC1Baseline encodes m to a BC1 payload, the slow way: for each block,
+// its endpoints are the two furthest apart pixels and each pixel takes its
+// nearest BC1 color. It is a baseline for what transcoding, without decoding
+// to pixels, should match or beat.
+func encodeBC1Baseline(m image.Image) []byte {
+	bounds := m.Bounds()
+	dst := []byte(nil)
+	for by := bounds.Min.Y; by < bounds.Max.Y; by += 4 {
+		for bx := bounds.Min.X; bx < bounds.Max.X; bx += 4 {
+			pixels := [16][3]int32{}
+			for i := range pixels {
+				x, y := min(bx+(i&3), bounds.Max.X-1), min(by+(i>>2), bounds.Max.Y-1)
+				r, g, b, _ := m.At(x, y).RGBA()
+				pixels[i] = [3]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8)}
+			}
+
+			e0, e1, bestDist := 0, 0, int32(-1)
+			for i := range pixels {
+				for j := i; j < len(pixels); j++ {
+					if d := distSquared(&pixels[i], &pixels[j]); bestDist < d {
+						e0, e1, bestDist = i, j, d
+					}
+				}
+			}
+			c0, c1 := toRGB565(&pixels[e0]), toRGB565(&pixels[e1])
+			if c0 < c1 {
+				c0, c1 = c1, c0
+			}
+			palette := [4][3]int32{fromRGB565(c0), fromRGB565(c1)}
+			for c := range 3 {
+				palette[2][c] = ((2 * palette[0][c]) + palette[1][c] + 1) / 3
+				palette[3][c] = (palette[0][c] + (2 * palette[1][c]) + 1) / 3
+			}
+			numIndexes := 4
+			if c0 == c1 {
+				numIndexes = 1
+			}
+
+			indexes := uint32(0)
+			for i := range pixels {
+				bestIndex, bestDist := 0, int32(-1)
+				for j := range numIndexes {
+					if d := distSquared(&pixels[i], &palette[j]); (bestDist < 0) || (bestDist > d) {
+						bestIndex, bestDist = j, d
+					}
+				}
+				indexes |= uint32(bestIndex) << (2 * i)
+			}
+			dst = append(dst,
+				uint8(c0>>0), uint8(c0>>8), uint8(c1>>0), uint8(c1>>8),
+				uint8(indexes>>0), uint8(indexes>>8), uint8(indexes>>16), uint8(indexes>>24))
+		}
+	}
+	return dst
+}
+
+func TestETC1SToBC1Blocks(tt *testing.T) {
+	testCases := []struct {
+		name    string
+		etc1s   [8]byte
+		wantBC1 [8]byte
+	}{{
+		// Differential mode, base color (16, 8, 4) (5 bits per channel),
+		// table 0, every pixel at index 0 (+2): the color (134, 68, 35).
+		// Both endpoints are that color in RGB565, (16, 17, 4), and every
+		// BC1 index is 0.
+		name:    "solid",
+		etc1s:   [8]byte{0x80, 0x40, 0x20, 0x02, 0x00, 0x00, 0x00, 0x00},
+		wantBC1: [8]byte{0x24, 0x82, 0x24, 0x82, 0x00, 0x00, 0x00, 0x00},
+	}, {
+		// Base gray 132, table 3. The left two columns are at index 3 (-42)
+		// and the right two at index 1 (+42): grays 90 and 174. The
+		// endpoints are those grays in RGB565, 0xAD75 and 0x5ACB, and each
+		// row's BC1 indexes are 1, 1, 0, 0.
+		name:    "two-tone",
+		etc1s:   [8]byte{0x80, 0x80, 0x80, 0x6E, 0x00, 0xFF, 0xFF, 0xFF},
+		wantBC1: [8]byte{0x75, 0xAD, 0xCB, 0x5A, 0x05, 0x05, 0x05, 0x05},
+	}, {
+		// Base gray 132, table 2. The columns are at indexes 3, 2, 0 and 1
+		// (-29, -9, +9 and +29): grays 103, 123, 141 and 161. The endpoints
+		// are the outer grays in RGB565, 0xA514 and 0x6B2D, and each row's
+		// BC1 indexes are 1, 3, 2, 0 (the 2/3 and 1/3 interpolants being the
+		// nearest to 123 and 141).
+		name:    "gradient",
+		etc1s:   [8]byte{0x80, 0x80, 0x80, 0x4A, 0x00, 0xFF, 0xF0, 0x0F},
+		wantBC1: [8]byte{0x14, 0xA5, 0x2D, 0x6B, 0x2D, 0x2D, 0x2D, 0x2D},
+	}}
+
+	for _, tc := range testCases {
+		got := [8]byte{}
+		if err := ETC1SToBC1(got[:], tc.etc1s[:]); err != nil {
+			tt.Fatalf("%s: ETC1SToBC1: %v", tc.name, err)
+		} else if got != tc.wantBC1 {
+			tt.Fatalf("%s: got % 02X, want % 02X", tc.name, got[:], tc.wantBC1[:])
+		}
+
+		// Decoding to pixels and re-encoding should give the same BC1 code.
+		decoded, err := etc2.DecodeRaw(tc.etc1s[:], etc2.FormatETC1S, 4, 4)
+		if err != nil {
+			tt.Fatalf("%s: etc2.DecodeRaw: %v", tc.name, err)
+		} else if baseline := encodeBC1Baseline(decoded); !bytes.Equal(baseline, got[:]) {
+			tt.Fatalf("%s: baseline: got % 02X, want % 02X", tc.name, baseline, got[:])
+		}
+	}
+}
+
+func TestETC1SToBC1(tt *testing.T) {
+	srcFile, err := os.Open("../../res/0-original-png/dice.80x60.png")
+	if err != nil {
+		tt.Fatalf("os.Open: %v", err)
+	}
+	defer srcFile.Close()
+	src, err := png.Decode(srcFile)
+	if err != nil {
+		tt.Fatalf("png.Decode: %v", err)
+	}
+
+	etc1s := &bytes.Buffer{}
+	if err := etc2.Encode(etc1s, src, etc2.FormatETC1S, nil); err != nil {
+		tt.Fatalf("etc2.Encode: %v", err)
+	}
+	bc1 := make([]byte, etc1s.Len())
+	if err := ETC1SToBC1(bc1, etc1s.Bytes()); err != nil {
+		tt.Fatalf("ETC1SToBC1: %v", err)
+	}
+
+	const width, height = 80, 60
+	want, err := etc2.DecodeRaw(etc1s.Bytes(), etc2.FormatETC1S, width, height)
+	if err != nil {
+		tt.Fatalf("etc2.DecodeRaw: %v", err)
+	}
+	got := decodeBC1(bc1, width, height)
+
+	// BC1 has fewer colors per block than ETC1S (4 versus up to 8) but the
+	// transcoded image should still be close to the ETC1S one.
+	mse, err := metrics.MSE(got, want)
+	if err != nil {
+		tt.Fatalf("metrics.MSE: %v", err)
+	}
+	if m := (mse[0] + mse[1] + mse[2]) / 3; m > 40 {
+		tt.Fatalf("mean squared error: got %.2f, want <= 40", m)
+	}
+
+	// Transcoding should be no worse than decoding the ETC1S payload and
+	// re-encoding it as BC1 with the same endpoint selection (but without
+	// the least squares refinement).
+	baseline := decodeBC1(encodeBC1Baseline(want), width, height)
+	baselineMSE, err := metrics.MSE(baseline, want)
+	if err != nil {
+		tt.Fatalf("metrics.MSE(baseline): %v", err)
+	}
+	m, bm := (mse[0]+mse[1]+mse[2])/3, (baselineMSE[0]+baselineMSE[1]+baselineMSE[2])/3
+	if m > bm {
+		tt.Fatalf("mean squared error: got %.2f, want <= %.2f (the baseline)", m, bm)
+	}
+
+	if err := ETC1SToBC1(bc1[:7], etc1s.Bytes()[:7]); err != ErrBadArgument {
+		tt.Fatalf("short payload: got %v, want %v", err, ErrBadArgument)
+	}
+}
@@ -16,7 +16,7 @@ import (
 	"os"
 	"strings"
 
-	"github.com/nigeltao/etc2/internal/nie"
+	"github.com/nigeltao/etc2/lib/nie"
 )
 
 const srcDirName = "../2-decoded-png"